@@ -66,9 +66,13 @@ if [ ! -f ".env" ]; then
 BUFFERED_EVENTS=""
 CLIENT_ADDRESS=""
 CLIENT_BACKUP_DIR=""
+CLIENT_DOWNLOAD_LIMIT=""
 CLIENT_EMAIL=""
+CLIENT_FILE_DOWNLOAD_LIMIT=""
+CLIENT_FILE_UPLOAD_LIMIT=""
 CLIENT_HOST=""
 CLIENT_ID=""
+CLIENT_IGNORE=""
 CLIENT_LOCAL_BACKUP=""
 CLIENT_LOG_DIR=""
 CLIENT_NAME=""
@@ -76,11 +80,18 @@ CLIENT_NEW_SERVICE=""
 CLIENT_PASSWORD=""
 CLIENT_PORT=""
 CLIENT_PROFILE_PIC=""
+CLIENT_PUSH_NEW_ITEMS=""
+CLIENT_RECYCLE_EXPIRY=""
 CLIENT_ROOT=""
 CLIENT_TESTING=""
+CLIENT_TRANSFER_RETRIES=""
+CLIENT_TRANSFER_WINDOWS=""
+CLIENT_TRANSFER_WORKERS=""
+CLIENT_UPLOAD_LIMIT=""
 CLIENT_USERNAME=""
 EVENT_BUFFER_SIZE=""
 JWT_SECRET=""
+MONITOR_BACKEND=""
 NEW_SERVICE=""
 SERVER_ADDR=""
 SERVER_ADMIN=""
@@ -91,6 +102,7 @@ SERVER_PORT=""
 SERVER_TIMEOUT_IDLE=""
 SERVER_TIMEOUT_READ=""
 SERVER_TIMEOUT_WRITE=""
+SERVER_TOMBSTONE_RETENTION=""
 SERVICE_ENV=""
 SERVICE_LOG_DIR=""
 SERVICE_ROOT=""
@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/sfs/pkg/client"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+/*
+Register this device with the server, and manage the user's registered devices.
+
+sfs client register-device [--name <name>]  // register this device. name defaults to the host name
+sfs client devices                          // list the user's registered devices
+sfs client devices --revoke <id>            // revoke a device's API key
+*/
+
+var (
+	registerDeviceCmd = &cobra.Command{
+		Use:   "register-device",
+		Short: "Register this device with the SFS server",
+		Long: `
+Register this device with the SFS server. The device gets its own API key, which the client
+sends with its requests instead of logging in, and changes made from this device are
+attributed to it. Use sfs client devices --revoke <id> to revoke a device's key.
+		`,
+		Run: runRegisterDeviceCmd,
+	}
+	devicesCmd = &cobra.Command{
+		Use:   "devices",
+		Short: "List or revoke the devices registered with the SFS server",
+		Run:   runDevicesCmd,
+	}
+)
+
+func init() {
+	flags := FlagPole{}
+	registerDeviceCmd.Flags().StringVar(&flags.name, "name", "", "Name of this device. defaults to the host name")
+	devicesCmd.Flags().StringVar(&flags.revoke, "revoke", "", "ID of a device to revoke")
+
+	viper.BindPFlag("name", registerDeviceCmd.Flags().Lookup("name"))
+	viper.BindPFlag("revoke", devicesCmd.Flags().Lookup("revoke"))
+
+	clientCmd.AddCommand(registerDeviceCmd)
+	clientCmd.AddCommand(devicesCmd)
+}
+
+func runRegisterDeviceCmd(cmd *cobra.Command, args []string) {
+	if localBackupEnabled() {
+		fmt.Print("local backup mode is enabled. devices can only be registered with the server.")
+		return
+	}
+	c, err := client.LoadClient(false)
+	if err != nil {
+		showerr(fmt.Errorf("failed to initialize service: %v", err))
+		return
+	}
+	name, _ := cmd.Flags().GetString("name")
+	device, err := c.RegisterDevice(name)
+	if err != nil {
+		showerr(err)
+		return
+	}
+	fmt.Printf("registered device %s (id=%s)\n", device.Name, device.ID)
+}
+
+func runDevicesCmd(cmd *cobra.Command, args []string) {
+	c, err := client.LoadClient(false)
+	if err != nil {
+		showerr(fmt.Errorf("failed to initialize service: %v", err))
+		return
+	}
+	if id, _ := cmd.Flags().GetString("revoke"); id != "" {
+		if err := c.RevokeDevice(id); err != nil {
+			showerr(err)
+			return
+		}
+		fmt.Printf("device (id=%s) revoked\n", id)
+		return
+	}
+	devices, err := c.ListDevices()
+	if err != nil {
+		showerr(err)
+		return
+	}
+	if len(devices) == 0 {
+		fmt.Print("no registered devices. use sfs client register-device to register this one.\n")
+		return
+	}
+	for _, d := range devices {
+		status := "active"
+		if d.Revoked {
+			status = "revoked"
+		}
+		current := ""
+		if c.Device != nil && c.Device.ID == d.ID {
+			current = " (this device)"
+		}
+		fmt.Printf("%s  %s%s\n    status: %s  last seen: %s  last synced revision: %d\n",
+			d.ID, d.Name, current, status, d.LastSeen.Local().Format("2006-01-02 15:04"), d.LastRevision)
+	}
+}
@@ -23,6 +23,9 @@ var (
 Use sfs discover -p <path> to discover all items under a given directory.
 
 Can be used to automatically discover and add all items under a given directory.
+Items matching .sfsignore files or the CLIENT_IGNORE setting are skipped.
+
+Use sfs discover -p <path> --dry-run to see what would be added without adding anything.
 		`,
 		Run: runDiscoverCmd,
 	}
@@ -31,8 +34,10 @@ Can be used to automatically discover and add all items under a given directory.
 func init() {
 	flags := FlagPole{}
 	discoverCmd.Flags().StringVarP(&flags.path, "path", "p", "", "Path to the directory to run discover on")
+	discoverCmd.Flags().BoolVar(&flags.dryRun, "dry-run", false, "Show what would be added without adding anything")
 
 	viper.BindPFlag("path", discoverCmd.Flags().Lookup("path"))
+	viper.BindPFlag("dry-run", discoverCmd.Flags().Lookup("dry-run"))
 
 	drvCmd.AddCommand(discoverCmd)
 }
@@ -48,6 +53,12 @@ func runDiscoverCmd(cmd *cobra.Command, args []string) {
 		showerr(err)
 		return
 	}
+	if dryRun, _ := cmd.Flags().GetBool("dry-run"); dryRun {
+		if err := c.DiscoverDryRun(path); err != nil {
+			showerr(err)
+		}
+		return
+	}
 	if _, err := c.Discover(path); err != nil {
 		showerr(err)
 	}
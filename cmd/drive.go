@@ -2,8 +2,11 @@ package cmd
 
 import (
 	"fmt"
+	"os"
+	"text/tabwriter"
 
 	"github.com/sfs/pkg/client"
+	svc "github.com/sfs/pkg/service"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -17,6 +20,7 @@ sfs drive --register
 sfs drive --refresh
 sfs drive --list-files
 sfs drive --list-dirs
+sfs drive --usage
 
 // add or remove files
 
@@ -37,10 +41,12 @@ func init() {
 	drvCmd.Flags().BoolVar(&flags.listFiles, "list-files", false, "list all local files managed by the sfs client service")
 	drvCmd.Flags().BoolVar(&flags.listDirs, "list-dirs", false, "list all local directories managed by the sfs client service")
 	drvCmd.Flags().BoolVar(&flags.remote, "remote", false, "list all files stored on the sfs server")
+	drvCmd.Flags().BoolVar(&flags.usage, "usage", false, "show the drive's quota and space used by each directory on the sfs server")
 
 	viper.BindPFlag("list-files", drvCmd.PersistentFlags().Lookup("list-files"))
 	viper.BindPFlag("list-dirs", drvCmd.PersistentFlags().Lookup("list-dirs"))
 	viper.BindPFlag("remote", drvCmd.Flags().Lookup("remote"))
+	viper.BindPFlag("usage", drvCmd.Flags().Lookup("usage"))
 
 	rootCmd.AddCommand(drvCmd)
 }
@@ -49,11 +55,13 @@ func getDrvflags(cmd *cobra.Command) FlagPole {
 	list_files, _ := cmd.Flags().GetBool("list-files")
 	list_dirs, _ := cmd.Flags().GetBool("list-dirs")
 	remote, _ := cmd.Flags().GetBool("remote")
+	usage, _ := cmd.Flags().GetBool("usage")
 
 	return FlagPole{
 		listFiles: list_files,
 		listDirs:  list_dirs,
 		remote:    remote,
+		usage:     usage,
 	}
 }
 
@@ -77,5 +85,37 @@ func runDrvCmd(cmd *cobra.Command, args []string) {
 		if err := c.ListRemoteFiles(); err != nil {
 			showerr(err)
 		}
+	case f.usage:
+		usage, err := c.GetDriveUsage()
+		if err != nil {
+			showerr(err)
+			return
+		}
+		showUsage(usage)
+	}
+}
+
+// print a drive's quota, followed by the space used by each directory
+func showUsage(usage *svc.DriveUsage) {
+	fmt.Printf("used %s of %s (%s free)\n\n", formatSize(usage.Used), formatSize(usage.Total), formatSize(usage.Free))
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SIZE\tFILES\tDIRECTORY")
+	for _, dir := range usage.Dirs {
+		fmt.Fprintf(w, "%s\t%d\t%s\n", formatSize(dir.Size), dir.Files, dir.Path)
+	}
+	w.Flush()
+}
+
+// human readable file size, ie. 1.5 GB
+func formatSize(size int64) string {
+	const unit = 1000
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
 	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "kMGTPE"[exp])
 }
@@ -16,6 +16,7 @@ type FlagPole struct {
 	register  bool // register a new drive with the sfs server
 	listFiles bool // list all files
 	listDirs  bool // list all directories
+	usage     bool // show the drive's quota and space usage by directory
 
 	// configs
 	get     string
@@ -29,10 +30,29 @@ type FlagPole struct {
 	newFile bool
 	newDir  bool
 
+	// discover and sync cmd flags
+	dryRun bool // show what would be discovered or synced without changing anything
+	json   bool // print output as JSON
+
 	// copy cmd flags
 	src  string
 	dest string
 
+	// restore cmd flags
+	drive    bool   // restore the whole drive
+	at       string // point in time to restore to
+	preview  bool   // show what would change without restoring anything
+	recycled string // id of a recycle bin item to restore
+
+	// device cmd flags
+	revoke string // id of a device to revoke
+
+	// share cmd flags
+	with        string // user id or user name to share an item with
+	write       bool   // share with write access
+	expires     string // when a share expires
+	removeShare string // id of a share to remove
+
 	// remove cmd
 	delete bool // true to delete. false to just stop monitoring the item.
 
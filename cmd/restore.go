@@ -0,0 +1,157 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sfs/pkg/client"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+/*
+Restore a drive to how it was at an earlier point in time,
+or restore files from the recycle bin
+*/
+
+var (
+	restoreCmd = &cobra.Command{
+		Use:   "restore",
+		Short: "Restore files from the recycle bin or the SFS server's version history",
+		Long: `
+Use sfs restore --recycled <id> to put a file from the recycle bin back where it was removed from.
+Use sfs restore --list to see what's in the recycle bin, along with each item's id.
+
+Use sfs restore --drive --at <time> to restore the whole drive to how it was at <time>.
+
+<time> can be an RFC3339 timestamp (2024-06-01T15:04:05Z), or a local date and time
+in the form "2024-06-01 15:04" or "2024-06-01".
+
+Files are restored into the drive's root directory by default, which also moves any
+files that didn't exist at <time> to the recycle bin. Use --dest <dir> to restore
+into a separate folder instead.
+
+Use --preview to see what would change without restoring anything.
+		`,
+		Run: runRestoreCmd,
+	}
+)
+
+func init() {
+	flags := FlagPole{}
+	restoreCmd.Flags().BoolVar(&flags.drive, "drive", false, "Restore the whole drive")
+	restoreCmd.Flags().StringVar(&flags.at, "at", "", "Point in time to restore to")
+	restoreCmd.Flags().StringVar(&flags.dest, "dest", "", "Folder to restore into. defaults to the drive's root directory")
+	restoreCmd.Flags().BoolVar(&flags.preview, "preview", false, "Show what would change without restoring anything")
+	restoreCmd.Flags().StringVar(&flags.recycled, "recycled", "", "ID of a recycle bin item to restore")
+	restoreCmd.Flags().BoolVarP(&flags.list, "list", "l", false, "List the items in the recycle bin")
+
+	viper.BindPFlag("drive", restoreCmd.Flags().Lookup("drive"))
+	viper.BindPFlag("at", restoreCmd.Flags().Lookup("at"))
+	viper.BindPFlag("dest", restoreCmd.Flags().Lookup("dest"))
+	viper.BindPFlag("preview", restoreCmd.Flags().Lookup("preview"))
+	viper.BindPFlag("recycled", restoreCmd.Flags().Lookup("recycled"))
+
+	rootCmd.AddCommand(restoreCmd)
+}
+
+// time formats accepted by --at. formats without a time zone use local time.
+var restoreTimeFormats = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+func parseRestoreTime(s string) (time.Time, error) {
+	for _, layout := range restoreTimeFormats {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time '%s'. use RFC3339, 'YYYY-MM-DD HH:MM', or 'YYYY-MM-DD'", s)
+}
+
+func runRestoreCmd(cmd *cobra.Command, args []string) {
+	if list, _ := cmd.Flags().GetBool("list"); list {
+		listRecycled()
+		return
+	}
+	if id, _ := cmd.Flags().GetString("recycled"); id != "" {
+		restoreRecycled(id)
+		return
+	}
+	if localBackupEnabled() {
+		fmt.Print("local backup mode is enabled. server version history is not available.")
+		return
+	}
+	if drive, _ := cmd.Flags().GetBool("drive"); !drive {
+		showerr(fmt.Errorf("nothing to restore. use --recycled <id> or --drive"))
+		return
+	}
+	atStr, _ := cmd.Flags().GetString("at")
+	if atStr == "" {
+		showerr(fmt.Errorf("no time specified. use --at <time>"))
+		return
+	}
+	at, err := parseRestoreTime(atStr)
+	if err != nil {
+		showerr(err)
+		return
+	}
+	if at.After(time.Now()) {
+		showerr(fmt.Errorf("can't restore to a time in the future"))
+		return
+	}
+
+	c, err := client.LoadClient(false)
+	if err != nil {
+		showerr(fmt.Errorf("failed to initialize service: %v", err))
+		return
+	}
+	dest, _ := cmd.Flags().GetString("dest")
+	plan, err := c.PlanRestore(at, dest)
+	if err != nil {
+		showerr(fmt.Errorf("failed to plan restore: %v", err))
+		return
+	}
+	fmt.Print(plan.Preview())
+	if preview, _ := cmd.Flags().GetBool("preview"); preview || plan.Empty() {
+		return
+	}
+	if err := c.Restore(plan); err != nil {
+		showerr(err)
+		return
+	}
+	fmt.Print("\nrestore complete\n")
+}
+
+func listRecycled() {
+	c, err := client.LoadClient(false)
+	if err != nil {
+		showerr(fmt.Errorf("failed to initialize service: %v", err))
+		return
+	}
+	items, err := c.GetRecycled()
+	if err != nil {
+		showerr(err)
+		return
+	}
+	if len(items) == 0 {
+		fmt.Print("recycle bin is empty\n")
+		return
+	}
+	for _, item := range items {
+		fmt.Printf("%s  %s  %s\n", item.ID, item.Deleted.Local().Format("2006-01-02 15:04"), item.OrigPath)
+	}
+}
+
+func restoreRecycled(id string) {
+	c, err := client.LoadClient(false)
+	if err != nil {
+		showerr(fmt.Errorf("failed to initialize service: %v", err))
+		return
+	}
+	item, err := c.RestoreRecycled(id)
+	if err != nil {
+		showerr(err)
+		return
+	}
+	fmt.Printf("restored %s to %s\n", item.Name, item.OrigPath)
+}
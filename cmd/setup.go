@@ -78,17 +78,28 @@ func setDefaults(newEnv map[string]string, root string) map[string]string {
 	newEnv["BUFFERED_EVENTS"] = "true"
 	newEnv["EVENT_BUFFER_SIZE"] = "2"
 	newEnv["JWT_SECRET"] = auth.GenSecret(64)
+	newEnv["MONITOR_BACKEND"] = "fsnotify"
 	newEnv["NEW_SERVICE"] = "true"
 	newEnv["CLIENT_ADDRESS"] = client.EndpointRoot + ":" + "9090"
 	newEnv["CLIENT_BACKUP_DIR"] = filepath.Join(root, "pkg", "client", "run", "backups")
+	newEnv["CLIENT_DOWNLOAD_LIMIT"] = "0"
+	newEnv["CLIENT_FILE_DOWNLOAD_LIMIT"] = "0"
+	newEnv["CLIENT_FILE_UPLOAD_LIMIT"] = "0"
 	newEnv["CLIENT_ROOT"] = filepath.Join(root, "pkg", "client", "run")
 	newEnv["CLIENT_ID"] = auth.NewUUID()
+	newEnv["CLIENT_IGNORE"] = ".git/;node_modules/;*.swp;*~;.DS_Store"
 	newEnv["CLIENT_NEW_SERVICE"] = "true"
 	newEnv["CLIENT_LOCAL_BACKUP"] = "false"
 	newEnv["CLIENT_LOG_DIR"] = filepath.Join(root, "pkg", "client", "logs")
 	newEnv["CLIENT_PASSWORD"] = auth.GenSecret(64)
 	newEnv["CLIENT_PORT"] = "9090"
+	newEnv["CLIENT_PUSH_NEW_ITEMS"] = "false"
+	newEnv["CLIENT_RECYCLE_EXPIRY"] = "30"
 	newEnv["CLIENT_TESTING"] = filepath.Join(root, "pkg", "client", "testing")
+	newEnv["CLIENT_TRANSFER_RETRIES"] = "3"
+	newEnv["CLIENT_TRANSFER_WINDOWS"] = ""
+	newEnv["CLIENT_TRANSFER_WORKERS"] = "4"
+	newEnv["CLIENT_UPLOAD_LIMIT"] = "0"
 	newEnv["SERVER_ADDR"] = client.EndpointRoot + ":" + "9191"
 	newEnv["SERVER_ADMIN"] = "admin"
 	newEnv["SERVER_ADMIN_KEY"] = auth.GenSecret(64)
@@ -97,6 +108,7 @@ func setDefaults(newEnv map[string]string, root string) map[string]string {
 	newEnv["SERVER_TIMEOUT_IDLE"] = "900s"
 	newEnv["SERVER_TIMEOUT_READ"] = "5s"
 	newEnv["SERVER_TIMEOUT_WRITE"] = "10s"
+	newEnv["SERVER_TOMBSTONE_RETENTION"] = "720h"
 	newEnv["SERVICE_ENV"] = filepath.Join(root, "pkg", "env", ".env")
 	newEnv["SERVICE_LOG_DIR"] = filepath.Join(root, "pkg", "service", "logs")
 	newEnv["SERVICE_ROOT"] = filepath.Join(root, "pkg", "server", "run")
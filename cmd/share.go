@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sfs/pkg/client"
+	svc "github.com/sfs/pkg/service"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+/*
+Share files and directories with other users, and manage the user's shares.
+
+sfs client share --path <path> --with <user> [--write] [--expires <RFC3339 time>]  // share an item
+sfs client shares                                                                // list the user's shares
+sfs client shares --remove-share <id>                                            // stop sharing an item
+*/
+
+var (
+	shareCmd = &cobra.Command{
+		Use:   "share",
+		Short: "Share a file or directory with another SFS user",
+		Long: `
+Share a file or directory with another SFS user. Items are shared as read-only unless --write
+is set. Shared items show up in the other user's "Shared with me" directory, and are synced
+along with the rest of their drive. Sharing an item with the same user again replaces the
+previous share.
+		`,
+		Run: runShareCmd,
+	}
+	sharesCmd = &cobra.Command{
+		Use:   "shares",
+		Short: "List or remove the items this user has shared",
+		Run:   runSharesCmd,
+	}
+)
+
+func init() {
+	flags := FlagPole{}
+	shareCmd.Flags().StringVar(&flags.path, "path", "", "Path to the file or directory to share")
+	shareCmd.Flags().StringVar(&flags.with, "with", "", "User ID or user name of the user to share with")
+	shareCmd.Flags().BoolVar(&flags.write, "write", false, "Let the user change the item")
+	shareCmd.Flags().StringVar(&flags.expires, "expires", "", "When the share expires (RFC3339). shares don't expire by default")
+	sharesCmd.Flags().StringVar(&flags.removeShare, "remove-share", "", "ID of a share to remove")
+
+	viper.BindPFlag("path", shareCmd.Flags().Lookup("path"))
+	viper.BindPFlag("with", shareCmd.Flags().Lookup("with"))
+	viper.BindPFlag("write", shareCmd.Flags().Lookup("write"))
+	viper.BindPFlag("expires", shareCmd.Flags().Lookup("expires"))
+	viper.BindPFlag("remove-share", sharesCmd.Flags().Lookup("remove-share"))
+
+	clientCmd.AddCommand(shareCmd)
+	clientCmd.AddCommand(sharesCmd)
+}
+
+func runShareCmd(cmd *cobra.Command, args []string) {
+	if localBackupEnabled() {
+		fmt.Print("local backup mode is enabled. items can only be shared through the server.")
+		return
+	}
+	path, _ := cmd.Flags().GetString("path")
+	with, _ := cmd.Flags().GetString("with")
+	if path == "" || with == "" {
+		showerr(fmt.Errorf("--path and --with are required"))
+		return
+	}
+	perm := svc.PermRead
+	if write, _ := cmd.Flags().GetBool("write"); write {
+		perm = svc.PermReadWrite
+	}
+	var expires time.Time
+	if exp, _ := cmd.Flags().GetString("expires"); exp != "" {
+		t, err := time.Parse(time.RFC3339, exp)
+		if err != nil {
+			showerr(fmt.Errorf("invalid expiration time: %v", err))
+			return
+		}
+		expires = t
+	}
+	c, err := client.LoadClient(false)
+	if err != nil {
+		showerr(fmt.Errorf("failed to initialize service: %v", err))
+		return
+	}
+	share, err := c.ShareItem(path, with, perm, expires)
+	if err != nil {
+		showerr(err)
+		return
+	}
+	fmt.Printf("shared %s with %s as %s (share id=%s)\n", path, with, share.Permission, share.ID)
+}
+
+func runSharesCmd(cmd *cobra.Command, args []string) {
+	c, err := client.LoadClient(false)
+	if err != nil {
+		showerr(fmt.Errorf("failed to initialize service: %v", err))
+		return
+	}
+	if id, _ := cmd.Flags().GetString("remove-share"); id != "" {
+		if err := c.RemoveShare(id); err != nil {
+			showerr(err)
+			return
+		}
+		fmt.Printf("share (id=%s) removed\n", id)
+		return
+	}
+	shares, err := c.ListShares()
+	if err != nil {
+		showerr(err)
+		return
+	}
+	if len(shares) == 0 {
+		fmt.Print("nothing shared yet. use sfs client share to share a file or directory.\n")
+		return
+	}
+	for _, s := range shares {
+		expires := "never"
+		if !s.Expires.IsZero() {
+			expires = s.Expires.Local().Format("2006-01-02 15:04")
+		}
+		status := ""
+		if s.Expired() {
+			status = " (expired)"
+		}
+		fmt.Printf("%s  %s %s%s\n    shared with: %s  permission: %s  expires: %s\n",
+			s.ID, s.ItemType, s.ItemID, status, s.GranteeID, s.Permission, expires)
+	}
+}
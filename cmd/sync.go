@@ -1,6 +1,13 @@
 package cmd
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"text/tabwriter"
+
 	"github.com/sfs/pkg/client"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -27,6 +34,12 @@ Examples:
 	// update the local filesystem and/or server with latest metadata and file objects. 
 
 	sfs drive sync --remote=true 		
+
+	// shows what a remote sync would push, pull, delete, and rename, and any
+	// conflicts, without changing anything. use --json for machine readable output.
+
+	sfs drive sync --dry-run
+	sfs drive sync --dry-run --json
 `,
 		Run: runSyncCmd,
 	}
@@ -37,9 +50,13 @@ func init() {
 
 	syncCmd.Flags().BoolVar(&flags.local, "local", false, "sync local objects")
 	syncCmd.Flags().BoolVar(&flags.remote, "remote", false, "sync remote objects")
+	syncCmd.Flags().BoolVar(&flags.dryRun, "dry-run", false, "show what a remote sync would do without syncing anything")
+	syncCmd.Flags().BoolVar(&flags.json, "json", false, "print the dry run plan as JSON")
 
 	viper.BindPFlag("local", syncCmd.Flags().Lookup("local"))
 	viper.BindPFlag("remote", syncCmd.Flags().Lookup("remote"))
+	viper.BindPFlag("dry-run", syncCmd.Flags().Lookup("dry-run"))
+	viper.BindPFlag("json", syncCmd.Flags().Lookup("json"))
 
 	drvCmd.AddCommand(syncCmd)
 }
@@ -47,9 +64,13 @@ func init() {
 func getFlags(cmd *cobra.Command) FlagPole {
 	local, _ := cmd.Flags().GetBool("local")
 	remote, _ := cmd.Flags().GetBool("remote")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	asJSON, _ := cmd.Flags().GetBool("json")
 	return FlagPole{
 		local:  local,
 		remote: remote,
+		dryRun: dryRun,
+		json:   asJSON,
 	}
 }
 
@@ -60,13 +81,80 @@ func runSyncCmd(cmd *cobra.Command, args []string) {
 	}
 	f := getFlags(cmd)
 	switch {
+	case f.dryRun:
+		plan, err := c.PlanSync()
+		if err != nil {
+			showerr(err)
+			return
+		}
+		if f.json {
+			data, err := plan.ToJSON()
+			if err != nil {
+				showerr(err)
+				return
+			}
+			fmt.Println(string(data))
+			return
+		}
+		showPlan(c, plan)
 	case f.local:
 		if err := c.LocalSync(); err != nil {
 			showerr(err)
 		}
 	case f.remote:
-		if err := c.ServerSync(); err != nil {
+		// ctrl+c stops any transfers that haven't started yet
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		result, err := c.ServerSync(ctx)
+		if err != nil {
 			showerr(err)
+			return
+		}
+		fmt.Printf("pushed: %d | pulled: %d | deleted: %d | conflicts: %d\n", len(result.Pushed), len(result.Pulled), len(result.Deleted), len(result.Conflicts))
+		fmt.Printf("transfers: %s\n", result.Transfers)
+		for _, failure := range result.Transfers.Failed {
+			fmt.Printf("failed: '%s' after %d attempt(s): %s\n", failure.Name, failure.Attempts, failure.Error)
+		}
+		for _, conflict := range result.Conflicts {
+			fmt.Printf("conflict: '%s' was modified on both this device and the server. local changes saved to '%s'\n",
+				conflict.Name, conflict.ConflictPath,
+			)
+		}
+	}
+}
+
+// print each item in a sync plan, followed by a summary
+func showPlan(c *client.Client, plan *client.SyncPlan) {
+	if plan.Empty() {
+		fmt.Printf("nothing to sync. client is at revision %d of %d\n", plan.Revision, plan.Target)
+		return
+	}
+	// paths are shown relative to the drive's root
+	rel := func(path string) string {
+		if r, err := filepath.Rel(c.Drive.Root.ClientPath, path); err == nil {
+			return r
+		}
+		return path
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "OP\tSIZE\tPATH")
+	for _, item := range plan.Items {
+		path := rel(item.Path)
+		switch {
+		case item.Dir:
+			path += string(filepath.Separator)
+		case item.Op == client.OpRename:
+			path += " -> " + rel(item.NewPath)
 		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", item.Op, formatSize(item.Size), path)
 	}
+	w.Flush()
+	fmt.Printf(
+		"\n%d to push (%s) | %d to pull (%s) | %d conflicts (%s) | %d to delete | %d to rename\n",
+		plan.Count(client.OpPush), formatSize(plan.Size(client.OpPush)),
+		plan.Count(client.OpPull), formatSize(plan.Size(client.OpPull)),
+		plan.Count(client.OpConflict), formatSize(plan.Size(client.OpConflict)),
+		plan.Count(client.OpDelete), plan.Count(client.OpRename),
+	)
 }
@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+/*
+registered devices.
+
+each machine a user syncs from can be registered with the server, which gives
+it an API key to send with its requests instead of logging in. keys can be
+revoked without affecting the user's other devices. only the hash of a
+device's key is kept by the server.
+*/
+
+// header used for sending a device's API key with a request
+const DeviceKeyHeader = "X-Sfs-Device-Key"
+
+type Device struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	OwnerID string `json:"owner_id"`
+
+	// the device's API key. only set when the device is first registered,
+	// since the server only keeps its hash.
+	Key     string `json:"key,omitempty"`
+	KeyHash string `json:"-"`
+
+	Created      time.Time `json:"created"`
+	LastSeen     time.Time `json:"last_seen"`
+	LastRevision int64     `json:"last_revision"` // latest drive revision the device has synced
+	Revoked      bool      `json:"revoked"`
+}
+
+// create a new device and API key for a user
+func NewDevice(name string, ownerID string) (*Device, error) {
+	if name == "" {
+		return nil, fmt.Errorf("no device name provided")
+	}
+	key, err := newSecretToken()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	return &Device{
+		ID:       NewUUID(),
+		Name:     name,
+		OwnerID:  ownerID,
+		Key:      key,
+		KeyHash:  HashToken(key),
+		Created:  now,
+		LastSeen: now,
+	}, nil
+}
+
+func (d *Device) ToJSON() ([]byte, error) {
+	return json.MarshalIndent(d, "", "  ")
+}
+
+func UnmarshalDevice(data []byte) (*Device, error) {
+	d := new(Device)
+	if err := json.Unmarshal(data, d); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal device: %v", err)
+	}
+	return d, nil
+}
@@ -10,10 +10,18 @@ import (
 	"github.com/dgrijalva/jwt-go" // TODO: replace -- this has a security problem
 )
 
+// header used for tokens carrying item metadata (files, directories, etc.)
+// sent with a request. the Authorization header is used for user access tokens.
+const PayloadHeader = "X-Sfs-Payload"
+
+// how long payload tokens are valid for by default
+const PayloadExpiry = time.Hour
+
 // json web token
 type Token struct {
-	Jwt    string `json:"-"`
-	Secret []byte `json:"-"`
+	Jwt    string        `json:"-"`
+	Secret []byte        `json:"-"`
+	Expiry time.Duration `json:"-"` // how long tokens made with Create are valid for
 }
 
 func NewT() *Token {
@@ -23,6 +31,7 @@ func NewT() *Token {
 	}
 	return &Token{
 		Secret: s,
+		Expiry: PayloadExpiry,
 	}
 }
 
@@ -36,43 +45,55 @@ func (t *Token) Extract(rawReqToken string) (string, error) {
 	return reqToken, nil
 }
 
-// verify jwt token and attempt to retrieve the request payload.
-//
-// use the return value to compare against the db and whether
-// they're an actual user
-func (t *Token) Verify(tokenString string) (string, error) {
+// parse and verify a token's signature and expiration time, and return its claims.
+// tokens without an expiration time are rejected.
+func (t *Token) parse(tokenString string) (jwt.MapClaims, error) {
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
 		return t.Secret, nil
 	})
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	if !token.Valid {
-		return "", fmt.Errorf("invalid token")
+		return nil, fmt.Errorf("invalid token")
 	}
-	// retrieve claims
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok {
-		return "", fmt.Errorf("failed to parse jwt claims")
+		return nil, fmt.Errorf("failed to parse jwt claims")
+	}
+	// jwt.Parse only checks exp if it's set, so check it ourselves too
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, fmt.Errorf("token is expired")
+	}
+	return claims, nil
+}
+
+// verify jwt token and attempt to retrieve the request payload.
+//
+// use the return value to compare against the db and whether
+// they're an actual user
+func (t *Token) Verify(tokenString string) (string, error) {
+	claims, err := t.parse(tokenString)
+	if err != nil {
+		return "", err
 	}
 	// retrieve the payload as a string
-	data := claims["sub"].(string)
+	data, _ := claims["sub"].(string)
 	if data == "" {
 		return "", fmt.Errorf("no payload found in token claims")
 	}
 	return data, nil
 }
 
-// validate a request token from a given http request
+// validate the payload token from a given http request
 func (t *Token) Validate(r *http.Request) (string, error) {
-	rawToken := r.Header.Get("Authorization")
-	if rawToken == "" {
+	token := r.Header.Get(PayloadHeader)
+	if token == "" {
 		return "", fmt.Errorf("no token provided")
 	}
-	token, err := t.Extract(rawToken)
-	if err != nil {
-		return "", fmt.Errorf("failed to extract token: %v", err)
-	}
 	itemInfo, err := t.Verify(token)
 	if err != nil {
 		return "", err
@@ -80,16 +101,19 @@ func (t *Token) Validate(r *http.Request) (string, error) {
 	return itemInfo, nil
 }
 
-// create a new token using a given payload string
+// create a new token using a given payload string.
+// the token expires after t.Expiry.
 func (t *Token) Create(payload string) (string, error) {
-	token := jwt.New(jwt.SigningMethodHS256)
-	claims := token.Claims.(jwt.MapClaims)
-	// add the payload to the claims. payloads usually have
-	// the data associated with the request
-	claims["sub"] = payload
-	// TODO: token expires in 1 hour by default.
-	// expiration times should vary depending on the request.
-	claims["exp"] = time.Now().Add(time.Hour).UTC()
+	expiry := t.Expiry
+	if expiry == 0 {
+		expiry = PayloadExpiry
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		// add the payload to the claims. payloads usually have
+		// the data associated with the request
+		"sub": payload,
+		"exp": time.Now().Add(expiry).Unix(),
+	})
 	tokenString, err := token.SignedString(t.Secret)
 	if err != nil {
 		return "", err
@@ -2,9 +2,12 @@ package auth
 
 import (
 	"testing"
+	"time"
 
 	"github.com/sfs/pkg/env"
 
+	"github.com/dgrijalva/jwt-go"
+
 	"github.com/alecthomas/assert/v2"
 )
 
@@ -76,3 +79,148 @@ func TestSecretGeneration(t *testing.T) {
 	assert.NotEqual(t, testSecret, "")
 	assert.Equal(t, len(testSecret), 64)
 }
+
+func TestSessionTokens(t *testing.T) {
+	env.SetEnv(false)
+
+	tok := NewSessionT([]byte(GenSecret(64)))
+	userID := NewUUID()
+	session, refresh, err := tok.NewSession(userID)
+	if err != nil {
+		t.Fatalf("create session failed: %v", err)
+	}
+	assert.Equal(t, userID, session.UserID)
+	assert.Equal(t, refresh.ID, session.ID)
+	assert.False(t, session.Expired())
+	assert.True(t, session.CanRefresh())
+	assert.True(t, refresh.Valid())
+
+	// only the refresh token's hash is kept
+	assert.NotEqual(t, session.RefreshToken, refresh.Hash)
+	assert.Equal(t, HashToken(session.RefreshToken), refresh.Hash)
+
+	id, sid, err := tok.VerifyAccess(session.Token)
+	if err != nil {
+		t.Fatalf("access token verification failed: %v", err)
+	}
+	assert.Equal(t, userID, id)
+	assert.Equal(t, session.ID, sid)
+
+	// renewing a session rotates the refresh token
+	renewed, err := tok.RenewSession(refresh)
+	if err != nil {
+		t.Fatalf("renew session failed: %v", err)
+	}
+	assert.Equal(t, session.ID, renewed.ID)
+	assert.NotEqual(t, session.RefreshToken, renewed.RefreshToken)
+	assert.Equal(t, HashToken(renewed.RefreshToken), refresh.Hash)
+
+	// payload tokens can't be used as access tokens
+	payload, err := tok.Create(userID)
+	if err != nil {
+		t.Fatalf("create token failed: %v", err)
+	}
+	_, _, err = tok.VerifyAccess(payload)
+	assert.Error(t, err)
+
+	// access tokens signed with the payload secret clients have are rejected
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": userID,
+		"sid": session.ID,
+		"typ": "access",
+		"exp": time.Now().Add(AccessExpiry).Unix(),
+	})
+	forgedToken, err := forged.SignedString(NewT().Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = tok.VerifyAccess(forgedToken)
+	assert.Error(t, err)
+	_, _, err = NewT().VerifyAccess(session.Token)
+	assert.Error(t, err)
+
+	// expired access tokens are rejected
+	expired := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": userID,
+		"sid": session.ID,
+		"typ": "access",
+		"exp": time.Now().Add(-time.Minute).Unix(),
+	})
+	expiredToken, err := expired.SignedString(tok.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = tok.VerifyAccess(expiredToken)
+	assert.Error(t, err)
+
+	// and so are tokens without an expiration time
+	noExp := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": userID,
+	})
+	noExpToken, err := noExp.SignedString(tok.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tok.Verify(noExpToken)
+	assert.Error(t, err)
+}
+
+func TestExpiredPayloadToken(t *testing.T) {
+	env.SetEnv(false)
+
+	tok := NewT()
+	tok.Expiry = -time.Minute
+	tokenString, err := tok.Create(NewUUID())
+	if err != nil {
+		t.Fatalf("create token failed: %v", err)
+	}
+	_, err = tok.Verify(tokenString)
+	assert.Error(t, err)
+}
+
+func TestPasswords(t *testing.T) {
+	user := NewUser("bill buttlicker", "billBB", "bill@bill.com", "/tmp", false)
+	assert.Equal(t, "", user.Password)
+	assert.False(t, user.CheckPassword(""))
+
+	assert.Error(t, user.SetPassword(""))
+	if err := user.SetPassword("hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	assert.NotEqual(t, "hunter2", user.Password)
+	assert.True(t, user.CheckPassword("hunter2"))
+	assert.False(t, user.CheckPassword("hunter3"))
+}
+
+func TestRoles(t *testing.T) {
+	user := NewUser("bill buttlicker", "billBB", "bill@bill.com", "/tmp", false)
+	assert.Equal(t, RoleUser, user.GetRole())
+	assert.True(t, user.CanWrite())
+	assert.False(t, user.IsAdmin())
+
+	if err := user.SetRole(RoleReadOnly); err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, user.CanWrite())
+	assert.False(t, user.IsAdmin())
+
+	if err := user.SetRole(RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, user.Admin)
+	assert.True(t, user.IsAdmin())
+	assert.Error(t, user.SetRole(Role("superuser")))
+	assert.Equal(t, RoleAdmin, user.GetRole())
+
+	// users saved before roles were added only have the admin flag
+	old := &User{Admin: true}
+	assert.Equal(t, RoleAdmin, old.GetRole())
+	old.Admin = false
+	assert.Equal(t, RoleUser, old.GetRole())
+
+	_, err := ParseRole("nope")
+	assert.Error(t, err)
+	role, err := ParseRole("read-only")
+	assert.NoError(t, err)
+	assert.Equal(t, RoleReadOnly, role)
+}
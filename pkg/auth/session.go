@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+/*
+user sessions.
+
+logging in starts a session, which gives the user a short-lived access token
+and a long-lived refresh token. access tokens are JWTs sent in the Authorization
+header of each request. refresh tokens are random strings that are exchanged for
+a new pair of tokens once the access token expires. refresh tokens are rotated
+each time they're used, and only their hashes are kept by the server.
+
+revoking a session (ie. logging out) invalidates both of its tokens.
+
+access tokens are signed with a secret only the server has. clients have the
+secret used for payload tokens (JWT_SECRET), so access tokens signed with it
+could be forged by any client.
+*/
+
+const (
+	AccessExpiry  = time.Minute * 15    // how long access tokens are valid for
+	RefreshExpiry = time.Hour * 24 * 30 // how long refresh tokens are valid for
+)
+
+// tokens sent to a user when they log in or refresh their session
+type Session struct {
+	ID             string    `json:"id"`
+	UserID         string    `json:"user_id"`
+	Token          string    `json:"token"` // access token
+	Expires        time.Time `json:"expires"`
+	RefreshToken   string    `json:"refresh_token"`
+	RefreshExpires time.Time `json:"refresh_expires"`
+}
+
+func (s *Session) ToJSON() ([]byte, error) {
+	return json.MarshalIndent(s, "", "  ")
+}
+
+func UnmarshalSession(data []byte) (*Session, error) {
+	s := new(Session)
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal session: %v", err)
+	}
+	return s, nil
+}
+
+// whether the session's access token has expired (or is about to)
+func (s *Session) Expired() bool {
+	return time.Now().Add(time.Minute).After(s.Expires)
+}
+
+// whether the session's refresh token can still be used
+func (s *Session) CanRefresh() bool {
+	return s.RefreshToken != "" && time.Now().Before(s.RefreshExpires)
+}
+
+// the server's record of a session. only the hash of the
+// current refresh token is kept.
+type Refresh struct {
+	ID      string    `json:"id"` // session ID
+	UserID  string    `json:"user_id"`
+	Hash    string    `json:"-"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+	Revoked bool      `json:"revoked"`
+}
+
+// whether the session's refresh token can still be used
+func (r *Refresh) Valid() bool {
+	return !r.Revoked && time.Now().Before(r.Expires)
+}
+
+// hash a refresh token for storage
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newSecretToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// create a token for signing and verifying access tokens. secret must
+// only be known to the server, and must not be the payload token secret.
+func NewSessionT(secret []byte) *Token {
+	return &Token{
+		Secret: secret,
+		Expiry: AccessExpiry,
+	}
+}
+
+// start a new session for a user. returns the tokens to send to the
+// user, and the record of the session for the server to keep.
+func (t *Token) NewSession(userID string) (*Session, *Refresh, error) {
+	r := &Refresh{
+		ID:      NewUUID(),
+		UserID:  userID,
+		Created: time.Now().UTC(),
+	}
+	s, err := t.RenewSession(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s, r, nil
+}
+
+// issue a new access token and refresh token for an existing session.
+// the session's refresh token hash and expiration time are updated.
+func (t *Token) RenewSession(r *Refresh) (*Session, error) {
+	refreshToken, err := newSecretToken()
+	if err != nil {
+		return nil, err
+	}
+	expires := time.Now().Add(AccessExpiry).UTC()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": r.UserID,
+		"sid": r.ID,
+		"typ": "access",
+		"iat": time.Now().Unix(),
+		"exp": expires.Unix(),
+	})
+	accessToken, err := token.SignedString(t.Secret)
+	if err != nil {
+		return nil, err
+	}
+	r.Hash = HashToken(refreshToken)
+	r.Expires = time.Now().Add(RefreshExpiry).UTC()
+	return &Session{
+		ID:             r.ID,
+		UserID:         r.UserID,
+		Token:          accessToken,
+		Expires:        expires,
+		RefreshToken:   refreshToken,
+		RefreshExpires: r.Expires,
+	}, nil
+}
+
+// verify an access token and return the IDs of the user
+// and session it belongs to.
+func (t *Token) VerifyAccess(tokenString string) (string, string, error) {
+	claims, err := t.parse(tokenString)
+	if err != nil {
+		return "", "", err
+	}
+	if typ, _ := claims["typ"].(string); typ != "access" {
+		return "", "", fmt.Errorf("not an access token")
+	}
+	userID, _ := claims["sub"].(string)
+	sessionID, _ := claims["sid"].(string)
+	if userID == "" || sessionID == "" {
+		return "", "", fmt.Errorf("no user or session ID found in token claims")
+	}
+	return userID, sessionID, nil
+}
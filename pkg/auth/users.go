@@ -16,7 +16,9 @@ type User struct {
 	Email     string    `json:"email"`
 	LastLogin time.Time `json:"last_login"`
 
-	// used for maintenance roles
+	// what the user is allowed to do. see Role.
+	// Admin is kept in sync with Role for older code and databases.
+	Role  Role `json:"role"`
 	Admin bool `json:"admin"`
 
 	// sfs/users/this user
@@ -36,6 +38,24 @@ type User struct {
 	DrvRoot string `json:"root"`
 }
 
+// user roles
+type Role string
+
+const (
+	RoleAdmin    Role = "admin"     // can access everything, and manage other users
+	RoleUser     Role = "user"      // can access and change their own files
+	RoleReadOnly Role = "read-only" // can view and download their own files, but not change them
+)
+
+func ParseRole(role string) (Role, error) {
+	switch r := Role(role); r {
+	case RoleAdmin, RoleUser, RoleReadOnly:
+		return r, nil
+	default:
+		return "", fmt.Errorf("invalid role '%s'. must be one of: admin, user, read-only", role)
+	}
+}
+
 func valid(name, userName, email, svcRoot string) bool {
 	if name == "" || userName == "" || email == "" || svcRoot == "" {
 		return false
@@ -47,13 +67,18 @@ func NewUser(name string, userName string, email string, svcRoot string, isAdmin
 	if !valid(name, userName, email, svcRoot) {
 		log.Fatalf("all new user params must be provided")
 	}
+	role := RoleUser
+	if isAdmin {
+		role = RoleAdmin
+	}
 	return &User{
 		ID:        NewUUID(),
 		Name:      name,
 		UserName:  userName,
-		Password:  "default",
+		Password:  "", // set with SetPassword
 		Email:     email,
 		LastLogin: time.Now().UTC(),
+		Role:      role,
 		Admin:     isAdmin,
 		SvcRoot:   svcRoot,
 		SfPath:    "", // set the first time the state is saved
@@ -78,3 +103,75 @@ func UnmarshalUserStr(userInfo string) (*User, error) {
 	}
 	return newUser, nil
 }
+
+// get the user's role. users from before roles were added
+// are either admins or regular users.
+func (u *User) GetRole() Role {
+	if u.Role != "" {
+		return u.Role
+	}
+	if u.Admin {
+		return RoleAdmin
+	}
+	return RoleUser
+}
+
+func (u *User) SetRole(role Role) error {
+	if _, err := ParseRole(string(role)); err != nil {
+		return err
+	}
+	u.Role = role
+	u.Admin = role == RoleAdmin
+	return nil
+}
+
+func (u *User) IsAdmin() bool { return u.GetRole() == RoleAdmin }
+
+// whether the user can add, change, or remove files
+func (u *User) CanWrite() bool { return u.GetRole() != RoleReadOnly }
+
+// hash and set the user's password
+func (u *User) SetPassword(password string) error {
+	if password == "" {
+		return fmt.Errorf("no password provided")
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %v", err)
+	}
+	u.Password = hash
+	return nil
+}
+
+// check a plain text password against the user's hashed password
+func (u *User) CheckPassword(password string) bool {
+	if u.Password == "" || password == "" {
+		return false
+	}
+	return CheckPasswordHash(password, u.Password)
+}
+
+// credentials sent when logging in or registering a new user.
+// users can log in with either their user ID or user name.
+type Credentials struct {
+	UserID   string `json:"user_id,omitempty"`
+	UserName string `json:"username,omitempty"`
+	Password string `json:"password"`
+
+	// only used when changing passwords
+	NewPassword string `json:"new_password,omitempty"`
+}
+
+// user details sent by admins when adding or updating users.
+// empty fields are left as they are when updating a user.
+type UserInfo struct {
+	Name     string `json:"name,omitempty"`
+	UserName string `json:"username,omitempty"`
+	Email    string `json:"email,omitempty"`
+	Password string `json:"password,omitempty"`
+	Role     string `json:"role,omitempty"`
+}
+
+func (c *Credentials) ToJSON() ([]byte, error) {
+	return json.Marshal(c)
+}
@@ -0,0 +1,252 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/sfs/pkg/auth"
+)
+
+/*
+user sessions with the server.
+
+the client logs in with the user's ID and password the first time it sends
+a request to the server, then attaches the session's access token to every
+request after that. access tokens are short-lived, so they're renewed with
+the session's refresh token when they expire, or when the server rejects them.
+the client logs in again if the refresh token has expired or was revoked.
+
+registered devices send their API key instead, and don't need a session.
+*/
+
+// log in to the server and start a new session
+func (c *Client) Login() error {
+	c.sessMu.Lock()
+	defer c.sessMu.Unlock()
+	return c.login()
+}
+
+func (c *Client) login() error {
+	if c.User == nil {
+		return fmt.Errorf("no user found")
+	}
+	req, err := c.LoginRequest(c.User)
+	if err != nil {
+		return err
+	}
+	session, err := c.sessionRequest(req)
+	if err != nil {
+		return fmt.Errorf("failed to log in: %v", err)
+	}
+	c.session = session
+	c.log.Info(fmt.Sprintf("logged in. session expires at %s", session.RefreshExpires.Local().Format("2006-01-02 15:04")))
+	return nil
+}
+
+// get a new access token using the current session's refresh token
+func (c *Client) refresh() error {
+	req, err := c.RefreshRequest(c.session)
+	if err != nil {
+		return err
+	}
+	session, err := c.sessionRequest(req)
+	if err != nil {
+		return fmt.Errorf("failed to refresh session: %v", err)
+	}
+	c.session = session
+	return nil
+}
+
+// renew the current session, logging in again if it can't be refreshed
+func (c *Client) renew() error {
+	if c.session != nil && c.session.CanRefresh() {
+		err := c.refresh()
+		if err == nil {
+			return nil
+		}
+		c.log.Warn(err.Error())
+	}
+	return c.login()
+}
+
+// send a login or refresh request and decode the session from the response
+func (c *Client) sessionRequest(req *http.Request) (*auth.Session, error) {
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		c.dump(resp)
+		return nil, fmt.Errorf("server status: %v", resp.Status)
+	}
+	session := new(auth.Session)
+	if err := json.NewDecoder(resp.Body).Decode(session); err != nil {
+		return nil, fmt.Errorf("failed to decode session: %v", err)
+	}
+	return session, nil
+}
+
+// get the current access token, logging in first if there isn't
+// a session yet, or renewing the session if the token has expired.
+func (c *Client) sessionToken() (string, error) {
+	c.sessMu.Lock()
+	defer c.sessMu.Unlock()
+	if c.session == nil {
+		if err := c.login(); err != nil {
+			return "", err
+		}
+	} else if c.session.Expired() {
+		if err := c.renew(); err != nil {
+			return "", err
+		}
+	}
+	return c.session.Token, nil
+}
+
+// renew the session after the server rejected its access token. if the
+// session was already renewed by another request, then its token is used instead.
+func (c *Client) renewSessionToken(rejected string) (string, error) {
+	c.sessMu.Lock()
+	defer c.sessMu.Unlock()
+	if c.session == nil || c.session.Token == rejected {
+		if err := c.renew(); err != nil {
+			return "", err
+		}
+	}
+	return c.session.Token, nil
+}
+
+// end the current session. the session's tokens can't be used
+// after this, and the next request will log in again.
+func (c *Client) Logout() error {
+	c.sessMu.Lock()
+	defer c.sessMu.Unlock()
+	if c.session == nil {
+		return nil
+	}
+	req, err := c.LogoutRequest(c.session)
+	if err != nil {
+		return err
+	}
+	c.session = nil
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to log out: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusUnauthorized {
+		c.dump(resp)
+		return fmt.Errorf("failed to log out. server status: %v", resp.Status)
+	}
+	return nil
+}
+
+// change the user's password on the server. the server revokes all of the
+// user's sessions, including ones on other devices, and starts a new one for us.
+func (c *Client) changeServerPassword(oldPw, newPw string) error {
+	req, err := c.PasswordRequest(oldPw, newPw)
+	if err != nil {
+		return err
+	}
+	session, err := c.sessionRequest(req)
+	if err != nil {
+		return fmt.Errorf("failed to change password: %v", err)
+	}
+	c.sessMu.Lock()
+	c.session = session
+	c.sessMu.Unlock()
+	return nil
+}
+
+// attach session tokens to server requests made with
+// the client's http client and transfer component.
+func (c *Client) setAuth() {
+	if c.Client != nil {
+		c.Client.Transport = &authTransport{c: c, base: c.Client.Transport}
+	}
+	if c.Transfer != nil {
+		c.Transfer.Client.Transport = &authTransport{c: c, base: c.Transfer.Client.Transport}
+	}
+}
+
+// http.RoundTripper that adds the client's session token to requests
+type authTransport struct {
+	c    *Client
+	base http.RoundTripper
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if req.Header.Get("Authorization") != "" || !needsSession(req.URL.Path) || !t.c.isServer(req.URL) {
+		return base.RoundTrip(req)
+	}
+	// registered devices use their API key
+	if key := t.c.deviceKey(); key != "" {
+		keyReq := req.Clone(req.Context())
+		keyReq.Header.Set(auth.DeviceKeyHeader, key)
+		resp, err := base.RoundTrip(keyReq)
+		if err == nil && resp.StatusCode == http.StatusUnauthorized {
+			t.c.log.Warn("device key was rejected by the server. this device may have been revoked")
+		}
+		return resp, err
+	}
+	token, err := t.c.sessionToken()
+	if err != nil {
+		return nil, err
+	}
+	// round trippers shouldn't modify the original request
+	authReq := req.Clone(req.Context())
+	authReq.Header.Set("Authorization", "Bearer "+token)
+	resp, err := base.RoundTrip(authReq)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	// the access token was rejected (expired, or the session was revoked),
+	// so renew the session and try again, as long as the body can be sent again.
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return resp, nil
+	}
+	token, err = t.c.renewSessionToken(token)
+	if err != nil {
+		t.c.log.Warn(err.Error())
+		return resp, nil
+	}
+	resp.Body.Close()
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		retry.Body = body
+	}
+	retry.Header.Set("Authorization", "Bearer "+token)
+	return base.RoundTrip(retry)
+}
+
+// whether a URL points at the client's server. session tokens and device
+// keys are only ever sent there, never to wherever else a URL might lead.
+func (c *Client) isServer(u *url.URL) bool {
+	server, err := url.Parse(c.Endpoints["login"])
+	if err != nil || server.Host == "" {
+		return false
+	}
+	return u.Scheme == server.Scheme && u.Host == server.Host
+}
+
+// whether a request to this path needs an access token.
+// logging in, refreshing sessions, and registering new users don't.
+func needsSession(path string) bool {
+	return strings.HasPrefix(path, "/v1/") &&
+		!strings.HasPrefix(path, "/v1/auth/login") &&
+		!strings.HasPrefix(path, "/v1/auth/refresh") &&
+		!strings.HasPrefix(path, "/v1/users/new")
+}
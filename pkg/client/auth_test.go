@@ -0,0 +1,214 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sfs/pkg/auth"
+	"github.com/sfs/pkg/logger"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestNeedsSession(t *testing.T) {
+	assert.True(t, needsSession("/v1/files/some-file-id"))
+	assert.True(t, needsSession("/v1/sync/some-drive-id/changes"))
+	assert.True(t, needsSession("/v1/auth/logout"))
+	assert.True(t, needsSession("/v1/auth/password"))
+	assert.False(t, needsSession("/v1/auth/login"))
+	assert.False(t, needsSession("/v1/auth/refresh"))
+	assert.False(t, needsSession("/v1/users/new"))
+	assert.False(t, needsSession("/ping"))
+}
+
+func TestSessionRefresh(t *testing.T) {
+	// fake server that only accepts the most recently issued access token
+	var mu sync.Mutex
+	var current string
+	var logins, refreshes int
+	issue := func(w http.ResponseWriter) {
+		current = fmt.Sprintf("access-%d-%d", logins, refreshes)
+		json.NewEncoder(w).Encode(&auth.Session{
+			Token:          current,
+			Expires:        time.Now().Add(auth.AccessExpiry),
+			RefreshToken:   "refresh-token",
+			RefreshExpires: time.Now().Add(auth.RefreshExpiry),
+		})
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/v1/auth/login":
+			logins++
+			issue(w)
+		case "/v1/auth/refresh":
+			refreshes++
+			issue(w)
+		default:
+			if r.Header.Get("Authorization") != "Bearer "+current {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			body, _ := io.ReadAll(r.Body)
+			w.Write(body)
+		}
+	}))
+	defer srv.Close()
+
+	c := &Client{
+		User:   auth.NewUser("bill buttlicker", "billBB", "bill@bill.com", "/tmp", false),
+		log:    logger.NewLogger("Client", "None"),
+		Client: &http.Client{},
+		Endpoints: map[string]string{
+			"login":   srv.URL + "/v1/auth/login",
+			"refresh": srv.URL + "/v1/auth/refresh",
+		},
+	}
+	c.setAuth()
+
+	// first request logs in
+	resp, err := c.Client.Post(srv.URL+"/v1/files/new", "text/plain", bytes.NewReader([]byte("hi")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 1, logins)
+
+	// the server rejects the token, so the session is refreshed and the request is sent again
+	mu.Lock()
+	current = "something-else"
+	mu.Unlock()
+	resp, err = c.Client.Post(srv.URL+"/v1/files/new", "text/plain", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "hello", string(body))
+	assert.Equal(t, 1, logins)
+	assert.Equal(t, 1, refreshes)
+
+	// expired access tokens are refreshed before sending
+	c.session.Expires = time.Now().Add(-time.Minute)
+	resp, err = c.Client.Get(srv.URL + "/v1/runtime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 2, refreshes)
+
+	// expired refresh tokens mean logging in again
+	c.session.Expires = time.Now().Add(-time.Minute)
+	c.session.RefreshExpires = time.Now().Add(-time.Minute)
+	resp, err = c.Client.Get(srv.URL + "/v1/runtime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 2, logins)
+	assert.Equal(t, 2, refreshes)
+}
+
+func TestDeviceKey(t *testing.T) {
+	// fake server that only accepts the device's key, and never issues sessions
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(auth.DeviceKeyHeader) != "some-key" || r.Header.Get("Authorization") != "" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+
+	c := &Client{
+		User:   auth.NewUser("bill buttlicker", "billBB", "bill@bill.com", "/tmp", false),
+		log:    logger.NewLogger("Client", "None"),
+		Client: &http.Client{},
+		Device: &auth.Device{ID: auth.NewUUID(), Name: "laptop", Key: "some-key"},
+		Endpoints: map[string]string{
+			"login": srv.URL + "/v1/auth/login",
+		},
+	}
+	c.setAuth()
+
+	resp, err := c.Client.Get(srv.URL + "/v1/runtime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, nil, c.session)
+
+	// revoked devices fall back to sessions
+	c.Device.Revoked = true
+	_, err = c.Client.Get(srv.URL + "/v1/runtime")
+	assert.Error(t, err)
+}
+
+func TestCredentialsOnlyGoToServer(t *testing.T) {
+	// fake server that accepts any session or device key
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/auth/login" {
+			json.NewEncoder(w).Encode(&auth.Session{
+				Token:          "access-token",
+				Expires:        time.Now().Add(auth.AccessExpiry),
+				RefreshToken:   "refresh-token",
+				RefreshExpires: time.Now().Add(auth.RefreshExpiry),
+			})
+		}
+	}))
+	defer srv.Close()
+	// some other host with the same paths, that should never see any credentials
+	var leaked []string
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, h := range []string{"Authorization", auth.DeviceKeyHeader} {
+			if v := r.Header.Get(h); v != "" {
+				leaked = append(leaked, h)
+			}
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer other.Close()
+
+	c := &Client{
+		User:   auth.NewUser("bill buttlicker", "billBB", "bill@bill.com", "/tmp", false),
+		log:    logger.NewLogger("Client", "None"),
+		Client: &http.Client{},
+		Endpoints: map[string]string{
+			"login": srv.URL + "/v1/auth/login",
+		},
+	}
+	c.setAuth()
+	for _, device := range []*auth.Device{nil, {ID: auth.NewUUID(), Name: "laptop", Key: "some-key"}} {
+		c.Device = device
+		resp, err := c.Client.Get(other.URL + "/v1/files/some-file-id")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	}
+	assert.Equal(t, 0, len(leaked))
+	assert.Equal(t, nil, c.session)
+
+	// same host, different scheme
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, c.isServer(u))
+	u.Scheme = "https"
+	assert.False(t, c.isServer(u))
+}
@@ -8,6 +8,7 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"sync"
 	"syscall"
 	"time"
 
@@ -36,9 +37,22 @@ type Client struct {
 	// Token creator for server requests
 	Tok *auth.Token `json:"token"`
 
+	// current session with the server. see auth.go
+	sessMu  sync.Mutex
+	session *auth.Session
+
+	// this machine's registration with the server, if it's been registered.
+	// its API key is sent with server requests instead of session tokens.
+	// see devices.go
+	Device *auth.Device `json:"device,omitempty"`
+
 	// Path to the local backup directory
 	LocalBackupDir string `json:"backup_dir"`
 
+	// Latest server revision of the drive this client has synced to.
+	// used to ask the server for only the changes made since the last sync.
+	Revision int64 `json:"revision"`
+
 	// Server api endpoints.
 	//
 	// file objects have their own API field, this is for storing
@@ -63,9 +77,21 @@ type Client struct {
 	// key == item path, value == event handler function
 	Handlers map[string]Handler `json:"-"`
 
+	// recently removed and monitored files, used for
+	// detecting renamed or moved files.
+	moves *moveTracker
+
+	// ignore rules from .sfsignore files and the global
+	// patterns in the client config.
+	ignore *svc.Ignore
+
 	// File transfer component. Handles file uploads and downloads.
 	Transfer *transfer.Transfer `json:"-"`
 
+	// timer for a push waiting on the next transfer window, if any.
+	pushMu    sync.Mutex
+	pushTimer *time.Timer
+
 	// HTTP client. Used for calls to the server.
 	Client *http.Client `json:"-"`
 }
@@ -107,6 +133,12 @@ func (c *Client) ShutDown() {
 	// shutdown client side services
 	c.StopMonitoring()
 	c.StopHandlers()
+	c.stopDeferredPush()
+
+	// end our session with the server
+	if err := c.Logout(); err != nil {
+		c.log.Error(err.Error())
+	}
 
 	// close DB
 	if err := c.Db.Close(); err != nil {
@@ -8,31 +8,43 @@ import (
 	"strings"
 
 	"github.com/sfs/pkg/configs"
+	svc "github.com/sfs/pkg/service"
+	"github.com/sfs/pkg/transfer"
 
 	"github.com/joeshaw/envdecode"
 )
 
 type Conf struct {
-	IsAdmin         bool   `env:"ADMIN_MODE"`                  // whether the service should be run in admin mode or not
-	BufferedEvents  bool   `env:"BUFFERED_EVENTS,required"`    // whether events should be buffered (i.e. have a delay between sync events)
-	EventBufferSize int    `env:"EVENT_BUFFER_SIZE,required"`  // size of events buffer
-	User            string `env:"CLIENT_NAME,required"`        // users name
-	UserAlias       string `env:"CLIENT_USERNAME,required"`    // users alias (username)
-	ID              string `env:"CLIENT_ID,required"`          // this is generated at creation time. won't be in the initial .env file
-	Email           string `env:"CLIENT_EMAIL,required"`       // users email
-	ProfilePic      string `env:"CLIENT_PROFILE_PIC,required"` // path to users profile picture
-	Root            string `env:"CLIENT_ROOT,required"`        // client service root (ie. ../sfs/client/run/)
-	TestRoot        string `env:"CLIENT_TESTING,required"`     // testing root directory
-	ClientPort      int    `env:"CLIENT_PORT,required"`        // client port
-	Addr            string `env:"CLIENT_ADDRESS,required"`     // address for http client
-	NewService      bool   `env:"CLIENT_NEW_SERVICE,required"` // whether we need to initialize a new client service instance.
-	LogDir          string `env:"CLIENT_LOG_DIR,required"`     // location of log directory
-	ServerSync      bool   `env:"CLIENT_SERVER_SYNC,required"` // whether we're syncing with the server in addition to creating local backups.
-	BackupDir       string `env:"CLIENT_BACKUP_DIR,required"`  // location of backup directory
-	ServerAddr      string `env:"SERVER_ADDR,required"`        // server address
-	Host            string `env:"SERVER_HOST,required"`        // client host
-	Port            int    `env:"SERVER_PORT,required"`        // server port
-	EnvFile         string `env:"SERVICE_ENV,required"`        // absoloute path to the dedicated .env file
+	IsAdmin           bool     `env:"ADMIN_MODE"`                                                   // whether the service should be run in admin mode or not
+	BufferedEvents    bool     `env:"BUFFERED_EVENTS,required"`                                     // whether events should be buffered (i.e. have a delay between sync events)
+	EventBufferSize   int      `env:"EVENT_BUFFER_SIZE,required"`                                   // size of events buffer
+	User              string   `env:"CLIENT_NAME,required"`                                         // users name
+	UserAlias         string   `env:"CLIENT_USERNAME,required"`                                     // users alias (username)
+	ID                string   `env:"CLIENT_ID,required"`                                           // this is generated at creation time. won't be in the initial .env file
+	Email             string   `env:"CLIENT_EMAIL,required"`                                        // users email
+	ProfilePic        string   `env:"CLIENT_PROFILE_PIC,required"`                                  // path to users profile picture
+	Root              string   `env:"CLIENT_ROOT,required"`                                         // client service root (ie. ../sfs/client/run/)
+	TestRoot          string   `env:"CLIENT_TESTING,required"`                                      // testing root directory
+	ClientPort        int      `env:"CLIENT_PORT,required"`                                         // client port
+	Addr              string   `env:"CLIENT_ADDRESS,required"`                                      // address for http client
+	NewService        bool     `env:"CLIENT_NEW_SERVICE,required"`                                  // whether we need to initialize a new client service instance.
+	LogDir            string   `env:"CLIENT_LOG_DIR,required"`                                      // location of log directory
+	ServerSync        bool     `env:"CLIENT_SERVER_SYNC,required"`                                  // whether we're syncing with the server in addition to creating local backups.
+	PushNewItems      bool     `env:"CLIENT_PUSH_NEW_ITEMS,default=false"`                          // whether new items found in monitored directories are pushed to the server right away.
+	Ignore            []string `env:"CLIENT_IGNORE,default=.git/;node_modules/;*.swp;*~;.DS_Store"` // global ignore patterns (gitignore syntax, separated by ';'). applied in addition to any .sfsignore files.
+	RecycleExpiry     int      `env:"CLIENT_RECYCLE_EXPIRY,default=30"`                             // days items are kept in the recycle bin before they're removed. 0 keeps them forever.
+	Workers           int      `env:"CLIENT_TRANSFER_WORKERS,default=4"`                            // number of files transferred to or from the server at once
+	Retries           int      `env:"CLIENT_TRANSFER_RETRIES,default=3"`                            // number of times a failed transfer is retried
+	UploadLimit       int      `env:"CLIENT_UPLOAD_LIMIT,default=0"`                                // upload limit shared by all transfers, in KB/s. 0 for no limit.
+	DownloadLimit     int      `env:"CLIENT_DOWNLOAD_LIMIT,default=0"`                              // download limit shared by all transfers, in KB/s. 0 for no limit.
+	FileUploadLimit   int      `env:"CLIENT_FILE_UPLOAD_LIMIT,default=0"`                           // upload limit for each file, in KB/s. 0 for no limit.
+	FileDownloadLimit int      `env:"CLIENT_FILE_DOWNLOAD_LIMIT,default=0"`                         // download limit for each file, in KB/s. 0 for no limit.
+	TransferWindows   string   `env:"CLIENT_TRANSFER_WINDOWS"`                                      // times of day when transfers run without limits (ie. 01:00-06:00, separated by ';'). pushes from file events wait for a window.
+	BackupDir         string   `env:"CLIENT_BACKUP_DIR,required"`                                   // location of backup directory
+	ServerAddr        string   `env:"SERVER_ADDR,required"`                                         // server address
+	Host              string   `env:"SERVER_HOST,required"`                                         // client host
+	Port              int      `env:"SERVER_PORT,required"`                                         // server port
+	EnvFile           string   `env:"SERVICE_ENV,required"`                                         // absoloute path to the dedicated .env file
 }
 
 func GetClientConfigs() *Conf {
@@ -71,6 +83,21 @@ func (c *Client) UpdateConfigSetting(setting, value string) error {
 		return c.EnableServerSync(value)
 	case configs.CLIENT_PROFILE_PIC:
 		return c.updateClientIcon(value)
+	case configs.CLIENT_PUSH_NEW_ITEMS:
+		return c.updatePushNewItems(value)
+	case configs.CLIENT_IGNORE:
+		return c.updateIgnore(value)
+	case configs.CLIENT_RECYCLE_EXPIRY:
+		return c.updateRecycleExpiry(value)
+	case configs.CLIENT_TRANSFER_WORKERS:
+		return c.updateTransferWorkers(value)
+	case configs.CLIENT_TRANSFER_RETRIES:
+		return c.updateTransferRetries(value)
+	case configs.CLIENT_UPLOAD_LIMIT, configs.CLIENT_DOWNLOAD_LIMIT,
+		configs.CLIENT_FILE_UPLOAD_LIMIT, configs.CLIENT_FILE_DOWNLOAD_LIMIT:
+		return c.updateTransferLimit(setting, value)
+	case configs.CLIENT_TRANSFER_WINDOWS:
+		return c.updateTransferWindows(value)
 	case configs.CLIENT_NOTIFICATIONS:
 		fmt.Print("no implemented yet") // TODO:
 	case configs.CLIENT_NEW_SERVICE:
@@ -256,10 +283,17 @@ func (c *Client) updateUserPassword(oldPw, newPw string) error {
 	if oldPw != user.Password && oldPw != c.User.Password {
 		return fmt.Errorf("incorrect password. password not updated")
 	}
+	// update the server's copy first, so we don't lose the
+	// password we use to log in if the server rejects it.
+	if c.Conf.ServerSync {
+		if err := c.changeServerPassword(oldPw, newPw); err != nil {
+			return err
+		}
+	}
 	user.Password = newPw
 	c.User.Password = newPw
-	// TODO: hashing of user passwords should occur before saving to DB.
-	// dont save them as plaintext! or not save the PW at all?
+	// the client keeps the plain text password since it's used to log in
+	// to the server, which only keeps a hash of it.
 	if err := c.Db.UpdateUser(user); err != nil {
 		return err
 	}
@@ -291,6 +325,179 @@ func (c *Client) updateClientPort(pvalue string) error {
 	return nil
 }
 
+// whether new files and directories found in monitored directories
+// should be pushed to the server as soon as they're registered.
+func (c *Client) updatePushNewItems(value string) error {
+	push, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+	if push == c.Conf.PushNewItems {
+		return nil
+	}
+	c.Conf.PushNewItems = push
+	if err := svcCfgs.Set(configs.CLIENT_PUSH_NEW_ITEMS, value); err != nil {
+		return err
+	}
+	if err := c.SaveState(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// (re)load ignore rules for the client's root directory.
+// clients saved before CLIENT_IGNORE was added use the patterns from the .env file.
+func (c *Client) setIgnore() {
+	if c.Conf.Ignore == nil {
+		c.Conf.Ignore = cCfgs.Ignore
+	}
+	c.ignore = svc.NewIgnore(c.Root, c.Conf.Ignore)
+	if c.Monitor != nil {
+		c.Monitor.SetIgnore(c.ignore)
+	}
+}
+
+// update the global ignore patterns. patterns are separated by ';'.
+func (c *Client) updateIgnore(value string) error {
+	patterns := make([]string, 0)
+	for _, p := range strings.Split(value, ";") {
+		if p = strings.TrimSpace(p); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	c.Conf.Ignore = patterns
+	c.setIgnore()
+	if err := svcCfgs.Set(configs.CLIENT_IGNORE, strings.Join(patterns, ";")); err != nil {
+		return err
+	}
+	if err := c.SaveState(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *Client) updateRecycleExpiry(value string) error {
+	days, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	if days < 0 {
+		return fmt.Errorf("recycle bin expiry can't be negative")
+	}
+	c.Conf.RecycleExpiry = days
+	if err := svcCfgs.Set(configs.CLIENT_RECYCLE_EXPIRY, value); err != nil {
+		return err
+	}
+	if err := c.SaveState(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *Client) updateTransferWorkers(value string) error {
+	workers, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	if workers < 1 {
+		return fmt.Errorf("at least one transfer worker is needed")
+	}
+	c.Conf.Workers = workers
+	if err := svcCfgs.Set(configs.CLIENT_TRANSFER_WORKERS, value); err != nil {
+		return err
+	}
+	if err := c.SaveState(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *Client) updateTransferRetries(value string) error {
+	retries, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	if retries < 0 {
+		return fmt.Errorf("transfer retries can't be negative")
+	}
+	c.Conf.Retries = retries
+	if err := svcCfgs.Set(configs.CLIENT_TRANSFER_RETRIES, value); err != nil {
+		return err
+	}
+	if err := c.SaveState(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// apply rate limits and transfer windows to the client's transfer component.
+// clients saved before these settings were added use the values from the .env file.
+func (c *Client) setTransfer() {
+	if c.Conf.Workers == 0 {
+		c.Conf.Workers = cCfgs.Workers
+		c.Conf.Retries = cCfgs.Retries
+	}
+	if c.Transfer == nil {
+		return
+	}
+	c.Transfer.SetLimits(transfer.Limits{
+		Upload:       int64(c.Conf.UploadLimit) * 1000,
+		Download:     int64(c.Conf.DownloadLimit) * 1000,
+		FileUpload:   int64(c.Conf.FileUploadLimit) * 1000,
+		FileDownload: int64(c.Conf.FileDownloadLimit) * 1000,
+	})
+	windows, err := transfer.ParseWindows(c.Conf.TransferWindows)
+	if err != nil {
+		c.log.Error(fmt.Sprintf("failed to parse transfer windows: %v", err))
+	}
+	c.Transfer.SetWindows(windows)
+}
+
+// update one of the upload or download limits. limits are in KB/s.
+func (c *Client) updateTransferLimit(setting string, value string) error {
+	limit, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	if limit < 0 {
+		return fmt.Errorf("transfer limits can't be negative")
+	}
+	switch setting {
+	case configs.CLIENT_UPLOAD_LIMIT:
+		c.Conf.UploadLimit = limit
+	case configs.CLIENT_DOWNLOAD_LIMIT:
+		c.Conf.DownloadLimit = limit
+	case configs.CLIENT_FILE_UPLOAD_LIMIT:
+		c.Conf.FileUploadLimit = limit
+	case configs.CLIENT_FILE_DOWNLOAD_LIMIT:
+		c.Conf.FileDownloadLimit = limit
+	}
+	c.setTransfer()
+	if err := svcCfgs.Set(setting, value); err != nil {
+		return err
+	}
+	if err := c.SaveState(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// update the transfer windows. windows are separated by ';', ie. "01:00-06:00;22:00-23:30"
+func (c *Client) updateTransferWindows(value string) error {
+	if _, err := transfer.ParseWindows(value); err != nil {
+		return err
+	}
+	c.Conf.TransferWindows = value
+	c.setTransfer()
+	if err := svcCfgs.Set(configs.CLIENT_TRANSFER_WINDOWS, value); err != nil {
+		return err
+	}
+	if err := c.SaveState(); err != nil {
+		return err
+	}
+	return nil
+}
+
 func (c *Client) updateEventBufferSize(sizestr string) error {
 	size, err := strconv.Atoi(sizestr)
 	if err != nil {
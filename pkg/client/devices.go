@@ -0,0 +1,117 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/sfs/pkg/auth"
+)
+
+/*
+device registration.
+
+registering a client with the server gives it an API key that's sent with
+every request in place of a session token, and lets the server attribute
+changes to this device. keys can be revoked from any of the user's devices.
+*/
+
+// the API key of this device, if it's been registered
+func (c *Client) deviceKey() string {
+	c.sessMu.Lock()
+	defer c.sessMu.Unlock()
+	if c.Device == nil || c.Device.Revoked {
+		return ""
+	}
+	return c.Device.Key
+}
+
+// register this device with the server. name defaults to the device's host name.
+func (c *Client) RegisterDevice(name string) (*auth.Device, error) {
+	if name == "" {
+		name = deviceName()
+	}
+	// log in with the user's password, since the device doesn't have a key yet
+	if err := c.Login(); err != nil {
+		return nil, err
+	}
+	c.sessMu.Lock()
+	token := c.session.Token
+	c.sessMu.Unlock()
+
+	req, err := c.RegisterDeviceRequest(name, token)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to register device: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		c.dump(resp)
+		return nil, fmt.Errorf("failed to register device. server status: %v", resp.Status)
+	}
+	device := new(auth.Device)
+	if err := json.NewDecoder(resp.Body).Decode(device); err != nil {
+		return nil, fmt.Errorf("failed to decode device: %v", err)
+	}
+	c.sessMu.Lock()
+	c.Device = device
+	c.sessMu.Unlock()
+	if err := c.SaveState(); err != nil {
+		return nil, err
+	}
+	c.log.Info(fmt.Sprintf("registered device %s (id=%s)", device.Name, device.ID))
+	return device, nil
+}
+
+// list the user's registered devices
+func (c *Client) ListDevices() ([]*auth.Device, error) {
+	req, err := c.DevicesRequest()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get devices: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		c.dump(resp)
+		return nil, fmt.Errorf("failed to get devices. server status: %v", resp.Status)
+	}
+	devices := make([]*auth.Device, 0)
+	if err := json.NewDecoder(resp.Body).Decode(&devices); err != nil {
+		return nil, fmt.Errorf("failed to decode devices: %v", err)
+	}
+	return devices, nil
+}
+
+// revoke a registered device. revoking this device means the
+// client goes back to logging in with the user's password.
+func (c *Client) RevokeDevice(deviceID string) error {
+	req, err := c.RevokeDeviceRequest(deviceID)
+	if err != nil {
+		return err
+	}
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to revoke device: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		c.dump(resp)
+		return fmt.Errorf("failed to revoke device. server status: %v", resp.Status)
+	}
+	c.sessMu.Lock()
+	revoked := c.Device != nil && c.Device.ID == deviceID
+	if revoked {
+		c.Device = nil
+	}
+	c.sessMu.Unlock()
+	if revoked {
+		return c.SaveState()
+	}
+	return nil
+}
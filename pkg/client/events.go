@@ -2,6 +2,7 @@ package client
 
 import (
 	"fmt"
+	"net/http"
 	"os"
 	"path/filepath"
 	"time"
@@ -9,6 +10,7 @@ import (
 	"github.com/sfs/pkg/logger"
 	"github.com/sfs/pkg/monitor"
 	svc "github.com/sfs/pkg/service"
+	"github.com/sfs/pkg/transfer"
 )
 
 // ---- file monitoring operations
@@ -24,17 +26,16 @@ func (c *Client) StartMonitor() error {
 			return err
 		}
 	}
-	// NOTE: monitoring directories is currently not supported.
-	// leaving this for future implementation iterations.
-	// dirs, err := c.Db.GetUsersDirectories(c.UserID)
-	// if err != nil {
-	// 	return err
-	// }
-	// for _, d := range dirs {
-	// 	if err := c.WatchItem(d.Path); err != nil {
-	// 		return err
-	// 	}
-	// }
+	// directories are monitored for new and removed items
+	dirs, err := c.Db.GetUsersDirectories(c.UserID)
+	if err != nil {
+		return err
+	}
+	for _, d := range dirs {
+		if err := c.WatchItem(d.ClientPath); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -58,6 +59,10 @@ func (c *Client) WatchItem(path string) error {
 	if err := c.Monitor.Watch(path); err != nil {
 		return err
 	}
+	// keep track of file info so renamed or moved files can be matched later
+	if info, err := os.Stat(path); err == nil && !info.IsDir() {
+		c.moves.track(path, info)
+	}
 	if err := c.NewHandler(path); err != nil {
 		return err
 	}
@@ -85,15 +90,11 @@ func (c *Client) setupHandler(itemPath string) (chan monitor.Event, string, erro
 	// item id is used in event objects
 	var id string
 	if thing.IsDir() {
-		// itemID, err := c.Db.GetDirIDFromPath(itemPath)
-		// if err != nil {
-		// 	return nil, "", err
-		// }
-		// if itemID == "" {
-		// 	return nil, "", fmt.Errorf("no id found for directory '%s'", itemPath)
-		// }
-		// id = itemID
-		return nil, "", fmt.Errorf("directory monitoring is not supported")
+		itemID, err := c.GetDirIDFromPath(itemPath)
+		if err != nil {
+			return nil, "", err
+		}
+		id = itemID
 	} else {
 		itemID, err := c.Db.GetFileIDFromPath(itemPath)
 		if err != nil {
@@ -139,19 +140,17 @@ func (c *Client) StartHandlers() error {
 			return err
 		}
 	}
-	// NOTE: directory monitoring is not supported, but may be
-	// in future versions, so this code will stay here for now.
 	// start directory handlers
-	// dirs, err := c.Db.GetUsersDirectories(c.UserID)
-	// if err != nil {
-	// 	return nil
-	// }
-	// c.log.Info(fmt.Sprintf("starting %d directory handler(s)...", len(dirs)))
-	// for _, d := range dirs {
-	// 	if err := c.StartHandler(d.Path); err != nil {
-	// 		return err
-	// 	}
-	// }
+	dirs, err := c.Db.GetUsersDirectories(c.UserID)
+	if err != nil {
+		return err
+	}
+	c.log.Info(fmt.Sprintf("starting %d directory handler(s)...", len(dirs)))
+	for _, d := range dirs {
+		if err := c.StartHandler(d.ClientPath); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -180,23 +179,15 @@ func (c *Client) BuildHandlers() error {
 			return err
 		}
 	}
-	// NOTE: monitoring directories is not supported, but may be in the future.
-	// os.ReadDir() was using a lot of resources.
-	// dirs, err := c.Db.GetUsersDirectories(c.UserID)
-	// if err != nil {
-	// 	return err
-	// }
-	// for _, dir := range dirs {
-	// 	if _, exists := c.Handlers[dir.Path]; !exists {
-	// 		if err := c.NewEHandler(dir.Path); err != nil {
-	// 			return err
-	// 		}
-	// 	}
-	// }
-	// // watch root
-	// if err := c.WatchItem(c.Drive.Root.Path); err != nil {
-	// 	return err
-	// }
+	dirs, err := c.Db.GetUsersDirectories(c.UserID)
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		if err := c.NewHandler(dir.ClientPath); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -215,44 +206,31 @@ func (c *Client) handler(itemPath string) error {
 	// main listening loop for events
 	for {
 		select {
-		case evt := <-evtChan:
+		case evt, ok := <-evtChan:
+			if !ok {
+				c.log.Log(logger.INFO, fmt.Sprintf("handler for item (id=%s) stopping. item is no longer monitored", itemID))
+				return nil
+			}
 			switch evt.Etype {
-			// NOTE: this is no longer supported, but may be used in the future versions.
-			//
-			// new files or directories were added to a monitored directory
-			// case monitor.Add:
-			// for _, eitem := range evt.Items {
-			// 	// if this is a known item and it has just changed locations,
-			// 	// then we just need to update the metadata, otherwise
-			// 	// create a new object and register
-			// 	if c.KnownItem(eitem.Path()) {
-			// 		if err := c.UpdateItem(eitem); err != nil {
-			// 			c.log.Error(fmt.Sprintf("failed to update item %s: %v", eitem.Path(), err))
-			// 		}
-			// 	}
-			// 	// otherwise create new object and register
-			// 	if eitem.IsDir() {
-			// 		newDir := svc.NewDirectory(eitem.Name(), c.UserID, c.DriveID, eitem.Path())
-			// 		if err := c.AddDirWithID(itemID, newDir); err != nil {
-			// 			c.log.Error(fmt.Sprintf("failed to add new directory: %v", err))
-			// 		}
-			// 	} else {
-			// 		newFile := svc.NewFile(eitem.Name(), c.DriveID, c.UserID, eitem.Path())
-			// 		if err := c.AddFileWithDirID(itemID, newFile); err != nil {
-			// 			c.log.Error(fmt.Sprintf("failed to add new file: %v", err))
-			// 		}
-			// 	}
-			// }
-			// NOTE: these new items may not be pushed to the server
-			// since they will be added with their initial last sync times.
-			// they will be added to the server after some modifications are detected,
-			// and if auto sync is enabled.
-			// evtBuf.AddEvent(evt)
-			// case monitor.Remove:
-			//
-			// TODO: handle for cases when items are removed from a directory
-			// and possibly moved to another location.
-			//
+			// new files or directories were added to a monitored directory.
+			// these are registered right away rather than going through the
+			// events buffer, and are only pushed to the server if CLIENT_PUSH_NEW_ITEMS
+			// is enabled. otherwise they're pushed during the next sync.
+			case monitor.Add:
+				for _, eitem := range evt.Items {
+					if err := c.addNewItem(eitem.Path(), eitem.IsDir()); err != nil {
+						c.log.Error(fmt.Sprintf("failed to add new item '%s': %v", eitem.Name(), err))
+					}
+				}
+				continue
+			// files or directories were removed from a monitored directory
+			case monitor.Remove:
+				for _, eitem := range evt.Items {
+					if err := c.removeOldItem(eitem.Path()); err != nil {
+						c.log.Error(fmt.Sprintf("failed to remove item '%s': %v", eitem.Name(), err))
+					}
+				}
+				continue
 			// item name change
 			case monitor.Name:
 				if err := c.apply(evt.Path, "name"); err != nil {
@@ -311,7 +289,7 @@ func (c *Client) handler(itemPath string) error {
 				// push meta-data changes and backup copies of file(s)
 				// to remote server, if applicable.
 				if c.SvrSync() {
-					if err := c.PushAll(); err != nil {
+					if err := c.pushChanges(); err != nil {
 						return err
 					}
 				}
@@ -325,6 +303,154 @@ func (c *Client) handler(itemPath string) error {
 	}
 }
 
+// push changes found by an event handler. if transfer windows are
+// configured and none are open, the push waits for the next window instead.
+func (c *Client) pushChanges() error {
+	now := time.Now()
+	next := transfer.NextWindow(c.Transfer.Windows(), now)
+	if next.IsZero() || !next.After(now) {
+		_, err := c.PushAll()
+		return err
+	}
+	c.deferPush(next)
+	return nil
+}
+
+// schedule a push for when the next transfer window opens.
+// only one push is scheduled at a time, since it pushes everything
+// that's changed by then.
+func (c *Client) deferPush(at time.Time) {
+	c.pushMu.Lock()
+	defer c.pushMu.Unlock()
+	if c.pushTimer != nil {
+		return
+	}
+	c.log.Info(fmt.Sprintf("outside of transfer windows. pushing changes at %s", at.Format("15:04")))
+	c.pushTimer = time.AfterFunc(time.Until(at), func() {
+		c.pushMu.Lock()
+		c.pushTimer = nil
+		c.pushMu.Unlock()
+
+		c.Drive.SyncIndex = svc.BuildToUpdate(c.Drive.GetFiles(), c.Drive.GetDirs(), c.Drive.SyncIndex)
+		if _, err := c.PushAll(); err != nil {
+			c.log.Error(fmt.Sprintf("failed to push changes: %v", err))
+		}
+	})
+}
+
+// cancel a push waiting on a transfer window
+func (c *Client) stopDeferredPush() {
+	c.pushMu.Lock()
+	defer c.pushMu.Unlock()
+	if c.pushTimer != nil {
+		c.pushTimer.Stop()
+		c.pushTimer = nil
+	}
+}
+
+// whether new items found in monitored directories
+// should be pushed to the server right away.
+func (c *Client) pushNewItems() bool { return c.SvrSync() && c.Conf.PushNewItems }
+
+// register a file or directory that was created in a monitored directory.
+// new directories are walked so anything that was already inside of them
+// (i.e. they were moved here) is registered as well.
+func (c *Client) addNewItem(itemPath string, isDir bool) error {
+	if c.ignore.Ignored(itemPath, isDir) {
+		return nil
+	}
+	if !isDir {
+		if moved, err := c.detectMove(itemPath); err != nil {
+			return err
+		} else if moved {
+			return nil
+		}
+		if err := c.AddFile(itemPath); err != nil {
+			return err
+		}
+		if c.pushNewItems() {
+			file, err := c.GetFileByPath(itemPath)
+			if err != nil {
+				return err
+			}
+			if err := c.PushFile(file); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	// AddDir will push the directory's meta-data to the
+	// server if server sync is enabled.
+	if err := c.AddDir(itemPath); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(itemPath)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := c.addNewItem(filepath.Join(itemPath, entry.Name()), entry.IsDir()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unregister a file or directory that was removed from a monitored directory.
+// the physical item is already gone, so nothing is moved to the recycle bin.
+// files are unregistered after MOVE_WINDOW, unless they were moved.
+// will be a no-op if the item wasn't registered.
+func (c *Client) removeOldItem(itemPath string) error {
+	file, err := c.Db.GetFileByPath(itemPath)
+	if err != nil {
+		return err
+	}
+	if file != nil {
+		// this might be a move, so wait a bit before unregistering
+		c.moves.remove(file)
+		time.AfterFunc(MOVE_WINDOW, func() { c.finishRemove(file.ID) })
+		return nil
+	}
+	d, err := c.Db.GetDirectoryByPath(itemPath)
+	if err != nil {
+		return err
+	}
+	if d == nil {
+		return nil
+	}
+	// use the drive's copy of the directory since it has its files
+	// and subdirectories attached to it.
+	dir := c.Drive.GetDir(d.ID)
+	if dir == nil {
+		dir = d
+	}
+	for _, f := range dir.GetFiles() {
+		c.Monitor.StopWatching(f.ClientPath)
+	}
+	for _, sd := range dir.GetSubDirs() {
+		c.Monitor.StopWatching(sd.ClientPath)
+	}
+	c.Monitor.StopWatching(dir.ClientPath)
+	if err := c.RemoveDir(dir); err != nil {
+		return err
+	}
+	if c.SvrSync() {
+		req, err := c.DeleteDirectoryRequest(dir)
+		if err != nil {
+			return err
+		}
+		resp, err := c.Client.Do(req)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			c.dump(resp)
+		}
+	}
+	c.log.Info(fmt.Sprintf("directory '%s' was removed", dir.Name))
+	return nil
+}
+
 // apply the given action to the given item and reset sync mechanisms
 func (c *Client) apply(itemPath string, action string) error {
 	item, err := os.Stat(itemPath)
@@ -2,10 +2,15 @@ package client
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/sfs/pkg/env"
+	svc "github.com/sfs/pkg/service"
+
+	"github.com/alecthomas/assert/v2"
 )
 
 func TestStartHandler(t *testing.T) {
@@ -72,3 +77,37 @@ func TestStartHandler(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestMoveTracker(t *testing.T) {
+	env.SetEnv(false)
+
+	tmp := t.TempDir()
+	oldPath := filepath.Join(tmp, "old.txt")
+	newPath := filepath.Join(tmp, "new.txt")
+	if err := os.WriteFile(oldPath, []byte("some data"), svc.PERMS); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(oldPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	moves := newMoveTracker()
+	moves.track(oldPath, info)
+
+	// renamed files should be matched by inode
+	if err := os.Rename(oldPath, newPath); err != nil {
+		t.Fatal(err)
+	}
+	newInfo, err := os.Stat(newPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, oldPath, moves.matchInode(newPath, newInfo))
+
+	// removed files should be matched by checksum, and only claimed once
+	file := &svc.File{ID: "file-id", Name: "old.txt", CheckSum: "checksum"}
+	moves.remove(file)
+	assert.Equal(t, (*svc.File)(nil), moves.matchChecksum("other-checksum"))
+	assert.Equal(t, file, moves.matchChecksum("checksum"))
+	assert.Equal(t, (*svc.File)(nil), moves.take(file.ID))
+}
@@ -9,6 +9,7 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 
 	cfgs "github.com/sfs/pkg/configs"
 	"github.com/sfs/pkg/server"
@@ -116,6 +117,30 @@ func (c *Client) EmptyRecycleBinHandler(w http.ResponseWriter, r *http.Request)
 	c.successMsg(w, "success")
 }
 
+// restore an item from the recycle bin. the item's ID is sent as the request body.
+func (c *Client) RestoreRecycledHandler(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	_, err := io.Copy(&buf, r.Body)
+	if err != nil {
+		c.error(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	id := buf.String()
+	if id == "" {
+		c.error(w, r, "no item ID provided", http.StatusBadRequest)
+		return
+	}
+	if _, err := c.RestoreRecycled(id); err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			c.error(w, r, err.Error(), http.StatusNotFound)
+		} else {
+			c.error(w, r, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	c.successMsg(w, "success")
+}
+
 // update config setting
 func (c *Client) updateSetting(w http.ResponseWriter, setting string, value interface{}) {
 	var v string
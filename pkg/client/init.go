@@ -72,7 +72,7 @@ func SetupClient(svcRoot string) (*Client, error) {
 
 	// make each database
 	setupLog.Info("creating databases...")
-	if err := db.InitServerDBs(svcPaths[0]); err != nil {
+	if err := db.InitClientDBs(svcPaths[0]); err != nil {
 		return nil, err
 	}
 
@@ -129,6 +129,15 @@ func newUser() (*auth.User, error) {
 		initLog.Error("failed to set user ID as an env variable: " + err.Error())
 		return nil, err
 	}
+	// the password is used to log in to the server
+	password, err := svcCfgs.Get(configs.CLIENT_PASSWORD)
+	if err != nil || password == "" {
+		password = auth.GenSecret(64)
+		if err := svcCfgs.Set(configs.CLIENT_PASSWORD, password); err != nil {
+			return nil, err
+		}
+	}
+	newUser.Password = password
 	return newUser, nil
 }
 
@@ -195,12 +204,22 @@ func LoadClient(persist bool) (*Client, error) {
 	// initialize DB connection
 	client.Db = db.NewQuery(client.Db.DBPath, true)
 
+	// create any databases added since this client was set up
+	if err := db.UpdateClientDBs(client.Db.DBPath); err != nil {
+		initLog.Log(logger.ERROR, fmt.Sprintf("failed to update databases: %v", err))
+		return nil, fmt.Errorf("failed to update databases: %v", err)
+	}
+
 	// load user info
 	if err := client.LoadUser(); err != nil {
 		initLog.Log(logger.ERROR, fmt.Sprintf("failed to load user: %v", err))
 		return nil, fmt.Errorf("failed to load user: %v", err)
 	}
 
+	// load ignore rules before the drive, since
+	// they're used when building the sync index.
+	client.setIgnore()
+
 	// load drive with users sfs directory tree populated.
 	// also refreshes (or generates) drive sync index.
 	if err := client.LoadDrive(); err != nil {
@@ -219,13 +238,24 @@ func LoadClient(persist bool) (*Client, error) {
 
 	// add transfer component
 	client.Transfer = transfer.NewTransfer()
+	client.setTransfer()
+
+	// send session tokens with server requests
+	client.setAuth()
 
 	// add monitoring component
 	client.Monitor = monitor.NewMonitor(client.Root)
+	client.Monitor.SetIgnore(client.ignore)
 
 	// initialize event map
 	client.InitHandlerMap()
 
+	// rename and move detection
+	client.moves = newMoveTracker()
+
+	// clear out anything that's been in the recycle bin for too long
+	client.ExpireRecycled()
+
 	// load and start persistent services only when necessary.
 	// persist should only be set to true when followed by a
 	// call to client.Start(), otherwise none of the monitoring
@@ -285,6 +315,7 @@ func (c *Client) setEndpoints() {
 	EndpointRootWithPort := fmt.Sprint(EndpointRoot, ":", c.Conf.Port)
 	// general purpose endpoints.
 	// files and directories have their endpoints defined in their respective structures.
+	c.Endpoints["files"] = EndpointRootWithPort + "/v1/files/" // NOTE: this will need to be concatenated with a file ID
 	c.Endpoints["all files"] = EndpointRootWithPort + "/v1/files/i/all/" + c.UserID
 	c.Endpoints["new file"] = EndpointRootWithPort + "/v1/files/new"
 	c.Endpoints["file info"] = EndpointRootWithPort + "/v1/files/i/" // NOTE: this will need to be concatenated with a file ID
@@ -293,12 +324,21 @@ func (c *Client) setEndpoints() {
 	c.Endpoints["new dir"] = EndpointRootWithPort + "/v1/dirs/new"
 	c.Endpoints["drive"] = EndpointRootWithPort + "/v1/drive/" + c.DriveID
 	c.Endpoints["new drive"] = EndpointRootWithPort + "/v1/drive/new"
+	c.Endpoints["snapshot"] = EndpointRootWithPort + "/v1/drive/" + c.DriveID + "/snapshot"
+	c.Endpoints["usage"] = EndpointRootWithPort + "/v1/drive/" + c.DriveID + "/usage"
 	c.Endpoints["sync"] = EndpointRootWithPort + "/v1/sync/" + c.DriveID
 	c.Endpoints["get index"] = EndpointRootWithPort + "/v1/sync/" + c.DriveID
 	c.Endpoints["gen index"] = EndpointRootWithPort + "/v1/sync/" + c.DriveID + "/index"
 	c.Endpoints["gen updates"] = EndpointRootWithPort + "/v1/sync/" + c.DriveID + "/update"
+	c.Endpoints["changes"] = EndpointRootWithPort + "/v1/sync/" + c.DriveID + "/changes"
 	c.Endpoints["user"] = EndpointRootWithPort + "/v1/users/" + c.UserID
 	c.Endpoints["new user"] = EndpointRootWithPort + "/v1/users/new"
+	c.Endpoints["login"] = EndpointRootWithPort + "/v1/auth/login"
+	c.Endpoints["refresh"] = EndpointRootWithPort + "/v1/auth/refresh"
+	c.Endpoints["logout"] = EndpointRootWithPort + "/v1/auth/logout"
+	c.Endpoints["password"] = EndpointRootWithPort + "/v1/auth/password"
+	c.Endpoints["devices"] = EndpointRootWithPort + "/v1/devices"
+	c.Endpoints["shares"] = EndpointRootWithPort + "/v1/shares"
 	c.Endpoints["all users"] = EndpointRootWithPort + "/v1/users/all"
 	c.Endpoints["runtime"] = EndpointRootWithPort + "/v1/runtime"
 }
@@ -338,6 +378,7 @@ func NewClient(user *auth.User) (*Client, error) {
 		log:            logger.NewLogger("Client", user.ID),
 		Tok:            auth.NewT(),
 		Handlers:       make(map[string]Handler),
+		moves:          newMoveTracker(),
 		Transfer:       transfer.NewTransfer(),
 		Client:         newHttpClient(),
 	}
@@ -359,6 +400,15 @@ func NewClient(user *auth.User) (*Client, error) {
 	// add token component
 	client.Tok = auth.NewT()
 
+	// load ignore rules
+	client.setIgnore()
+
+	// apply transfer limits and windows
+	client.setTransfer()
+
+	// send session tokens with server requests
+	client.setAuth()
+
 	// initialize local sync index
 	client.BuildSyncIndex()
 
@@ -0,0 +1,167 @@
+package client
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	svc "github.com/sfs/pkg/service"
+)
+
+/*
+rename and move detection.
+
+when a file is renamed or moved, the monitor sees it being removed from one
+directory and added to another (or the same one, for renames), and the order
+those two events arrive in isn't guaranteed. rather than unregistering the old
+file and registering the new one under a new ID, new files are matched against
+known files, first by inode, then by checksum against files removed within
+MOVE_WINDOW, and the existing file is updated in place.
+*/
+
+// how long removed files are held onto before they're unregistered,
+// giving any matching new files a chance to show up.
+const MOVE_WINDOW = time.Second * 10
+
+// a file that was removed from a monitored directory
+// and hasn't been unregistered yet.
+type removedFile struct {
+	file    *svc.File
+	removed time.Time
+}
+
+type moveTracker struct {
+	mu sync.Mutex
+
+	// last known file info for each monitored file.
+	// used to match new files to known files by inode.
+	//
+	// key = file path, value = file info
+	stats map[string]os.FileInfo
+
+	// files removed within the last MOVE_WINDOW.
+	//
+	// key = file ID, value = removed file
+	removed map[string]*removedFile
+}
+
+func newMoveTracker() *moveTracker {
+	return &moveTracker{
+		stats:   make(map[string]os.FileInfo),
+		removed: make(map[string]*removedFile),
+	}
+}
+
+// record the file info for a monitored file
+func (m *moveTracker) track(filePath string, info os.FileInfo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stats[filePath] = info
+}
+
+func (m *moveTracker) untrack(filePath string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.stats, filePath)
+}
+
+// find the path of a known file that is the same underlying file
+// as the given one, and no longer exists at its old location.
+// returns an empty string if there's no match.
+func (m *moveTracker) matchInode(filePath string, info os.FileInfo) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for oldPath, oldInfo := range m.stats {
+		if oldPath == filePath || !os.SameFile(oldInfo, info) {
+			continue
+		}
+		if _, err := os.Stat(oldPath); os.IsNotExist(err) {
+			return oldPath
+		}
+	}
+	return ""
+}
+
+// hold onto a removed file until take() is called for it, or the window expires.
+func (m *moveTracker) remove(file *svc.File) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.removed[file.ID] = &removedFile{file: file, removed: time.Now().UTC()}
+}
+
+// claim a removed file. returns nil if the file was already claimed.
+func (m *moveTracker) take(fileID string) *svc.File {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if rf, ok := m.removed[fileID]; ok {
+		delete(m.removed, fileID)
+		return rf.file
+	}
+	return nil
+}
+
+// claim the first file removed within the window with a matching checksum.
+// returns nil if none were found.
+func (m *moveTracker) matchChecksum(checksum string) *svc.File {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, rf := range m.removed {
+		if time.Since(rf.removed) <= MOVE_WINDOW && rf.file.CheckSum != "" && rf.file.CheckSum == checksum {
+			delete(m.removed, id)
+			return rf.file
+		}
+	}
+	return nil
+}
+
+// see if a new file is actually a known file that was renamed or moved.
+// if so, the known file is moved to filePath and true is returned.
+func (c *Client) detectMove(filePath string) (bool, error) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return false, err
+	}
+	var file *svc.File
+	if oldPath := c.moves.matchInode(filePath, info); oldPath != "" {
+		f, err := c.Db.GetFileByPath(oldPath)
+		if err != nil {
+			return false, err
+		}
+		if f != nil {
+			c.moves.take(f.ID) // in case the removal was already seen
+			file = f
+		}
+	}
+	if file == nil {
+		cs, err := svc.CalculateChecksum(filePath)
+		if err != nil {
+			return false, err
+		}
+		file = c.moves.matchChecksum(cs)
+	}
+	if file == nil {
+		return false, nil
+	}
+	if err := c.MoveFile(file, filePath); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// unregister a removed file once the move window has passed, unless it was
+// matched to a new file in the meantime.
+func (c *Client) finishRemove(fileID string) {
+	file := c.moves.take(fileID)
+	if file == nil {
+		return
+	}
+	if err := c.unregisterFile(file); err != nil {
+		c.log.Error(fmt.Sprintf("failed to unregister '%s': %v", file.Name, err))
+		return
+	}
+	if c.SvrSync() {
+		c.removeFromServer(file)
+	}
+	c.log.Info(fmt.Sprintf("file '%s' was removed", file.Name))
+}
@@ -0,0 +1,493 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	svc "github.com/sfs/pkg/service"
+	"github.com/sfs/pkg/transfer"
+)
+
+/*
+sync plans.
+
+before anything is transferred, the server's changes since the client's last
+synced revision are compared against the local drive to build a sync plan:
+every file that will be pushed, pulled, deleted, or renamed, along with any
+conflicts. files shared with the user are planned separately (see shares.go).
+ServerSync() builds a plan and then runs it. PlanSync() only builds
+one, so a dry run (sfs drive sync --dry-run) shows exactly what a sync would do.
+
+building a plan doesn't change anything locally or on the server.
+*/
+
+// a single change in a sync plan
+type SyncItem struct {
+	Op      SyncOp `json:"op"`
+	ID      string `json:"id"` // file or directory id
+	Name    string `json:"name"`
+	Path    string `json:"path"`               // local path
+	NewPath string `json:"new_path,omitempty"` // where a renamed file will be moved to
+	Size    int64  `json:"size"`               // the server's size for pulls and conflicts, otherwise the local size
+	Dir     bool   `json:"dir,omitempty"`      // whether this is a new directory from the server
+
+	file   *svc.File      // local file. nil for new items from the server
+	server *svc.File      // server's metadata for the file, if known
+	dir    *svc.Directory // server's metadata for a new directory
+	ts     *svc.Tombstone // set for deletions
+}
+
+// everything a sync with the server will do
+type SyncPlan struct {
+	Revision int64       `json:"revision"` // revision the client last synced to
+	Target   int64       `json:"target"`   // latest revision on the server
+	Items    []*SyncItem `json:"items"`
+
+	changes *svc.SyncIndex
+	synced  []*svc.File // files both sides already agree on. only their sync state is updated.
+}
+
+func (p *SyncPlan) items(op SyncOp) []*SyncItem {
+	items := make([]*SyncItem, 0)
+	for _, item := range p.Items {
+		if item.Op == op {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// number of items with the given operation
+func (p *SyncPlan) Count(op SyncOp) int {
+	return len(p.items(op))
+}
+
+// total size of the items with the given operation
+func (p *SyncPlan) Size(op SyncOp) int64 {
+	var size int64
+	for _, item := range p.items(op) {
+		size += item.Size
+	}
+	return size
+}
+
+// whether running the plan would change anything
+func (p *SyncPlan) Empty() bool {
+	return len(p.Items) == 0
+}
+
+func (p *SyncPlan) ToJSON() ([]byte, error) {
+	return json.MarshalIndent(p, "", "  ")
+}
+
+// ------- planning ---------------------------------------------
+
+// build a plan for syncing with the server without running it.
+func (c *Client) PlanSync() (*SyncPlan, error) {
+	changes, err := c.GetServerChanges(c.Revision)
+	if err != nil {
+		return nil, err
+	}
+	// the server's metadata is only needed if something changed. new clients
+	// start at revision 0, so they'll pick up everything on the server.
+	var svrFiles []*svc.File
+	var svrDirs []*svc.Directory
+	if c.Revision == 0 || len(changes.Revisions) > 0 {
+		if svrDirs, err = c.getServerDirs(); err != nil {
+			return nil, err
+		}
+		if svrFiles, err = c.getServerFiles(); err != nil {
+			return nil, err
+		}
+	}
+	plan, err := c.planSync(changes, svrFiles, svrDirs)
+	if err != nil {
+		return nil, err
+	}
+	// files other users shared with this one
+	shared, err := c.getSharedFiles()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.planShared(plan, shared); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(plan.Items, func(i, j int) bool { return plan.Items[i].Path < plan.Items[j].Path })
+	return plan, nil
+}
+
+// compare the server's changes and metadata against the local drive.
+//
+// files that were only modified on one side since the last sync are pushed or
+// pulled, and files modified on both sides are conflicts. files deleted on the
+// server are deleted locally, and files moved or renamed on the server are
+// renamed locally. directories and files that only exist on the server are pulled.
+//
+// NOTE: files the client has that the server doesn't know about
+// are not handled here. those are registered via AddFile().
+func (c *Client) planSync(changes *svc.SyncIndex, svrFiles []*svc.File, svrDirs []*svc.Directory) (*SyncPlan, error) {
+	plan := &SyncPlan{
+		Revision: c.Revision,
+		Target:   changes.Revision,
+		Items:    make([]*SyncItem, 0),
+		changes:  changes,
+	}
+
+	// anything deleted on the server that the client still has
+	for _, ts := range changes.Tombstones {
+		file, err := c.findFile(ts.FileID)
+		if err != nil {
+			return nil, err
+		}
+		if file == nil {
+			continue
+		}
+		plan.Items = append(plan.Items, &SyncItem{
+			Op:   OpDelete,
+			ID:   file.ID,
+			Name: file.Name,
+			Path: file.ClientPath,
+			Size: localSize(file),
+			file: file,
+			ts:   ts,
+		})
+	}
+
+	// directories and files the client has never seen. these are placed under
+	// the client's drive root using their path relative to the server's drive root.
+	if err := c.planNewItems(plan, svrFiles, svrDirs); err != nil {
+		return nil, err
+	}
+
+	// changes to files both sides know about
+	svrFileMap := make(map[string]*svc.File, len(svrFiles))
+	for _, sf := range svrFiles {
+		svrFileMap[sf.ID] = sf
+	}
+	if c.Drive.SyncIndex != nil {
+		for id := range c.Drive.SyncIndex.LastSync {
+			if _, deleted := changes.Tombstones[id]; deleted {
+				continue
+			}
+			file, err := c.GetFileByID(id)
+			if err != nil {
+				return nil, err
+			}
+			if file == nil || !file.Registered {
+				continue
+			}
+			if err := c.planFile(plan, file, svrFileMap[id]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// parent directories sort before their contents
+	sort.SliceStable(plan.Items, func(i, j int) bool { return plan.Items[i].Path < plan.Items[j].Path })
+	return plan, nil
+}
+
+func (c *Client) planNewItems(plan *SyncPlan, svrFiles []*svc.File, svrDirs []*svc.Directory) error {
+	dirs := make(map[string]*svc.Directory, len(svrDirs))
+	for _, dir := range svrDirs {
+		dirs[dir.ID] = dir
+	}
+	root := c.Drive.Root.ClientPath
+
+	for _, sd := range svrDirs {
+		rel, err := relDirPath(sd.ID, dirs)
+		if err != nil {
+			c.log.Warn(fmt.Sprintf("skipping directory (id=%s): %v", sd.ID, err))
+			continue
+		}
+		if rel == "" {
+			continue
+		}
+		path, err := localPath(root, rel)
+		if err != nil {
+			c.log.Warn(fmt.Sprintf("skipping directory (id=%s): %v", sd.ID, err))
+			continue
+		}
+		if d, err := c.Db.GetDirectoryByID(sd.ID); err != nil {
+			return err
+		} else if d != nil {
+			continue
+		}
+		plan.Items = append(plan.Items, &SyncItem{
+			Op:   OpPull,
+			ID:   sd.ID,
+			Name: sd.Name,
+			Path: path,
+			Dir:  true,
+			dir:  sd,
+		})
+	}
+	for _, sf := range svrFiles {
+		if f, err := c.Db.GetFileByID(sf.ID); err != nil {
+			return err
+		} else if f != nil {
+			continue
+		}
+		rel, err := relDirPath(sf.DirID, dirs)
+		if err != nil {
+			c.log.Warn(fmt.Sprintf("skipping file (id=%s): %v", sf.ID, err))
+			continue
+		}
+		if !safeName(sf.Name) {
+			c.log.Warn(fmt.Sprintf("skipping file (id=%s): unsafe file name from server: '%s'", sf.ID, sf.Name))
+			continue
+		}
+		path, err := localPath(root, filepath.Join(rel, sf.Name))
+		if err != nil {
+			c.log.Warn(fmt.Sprintf("skipping file (id=%s): %v", sf.ID, err))
+			continue
+		}
+		plan.Items = append(plan.Items, &SyncItem{
+			Op:     OpPull,
+			ID:     sf.ID,
+			Name:   sf.Name,
+			Path:   path,
+			Size:   sf.Size,
+			server: sf,
+		})
+	}
+	return nil
+}
+
+// decide what to do with a file both the client and the server know about.
+// sf is the server's metadata for the file, or nil if it wasn't retrieved.
+//
+// the state recorded during the last sync is used as the common base: if only
+// one side changed since then, that side wins. if both changed, then it's a conflict.
+func (c *Client) planFile(plan *SyncPlan, file *svc.File, sf *svc.File) error {
+	localCs, err := svc.CalculateChecksum(file.ClientPath)
+	if err != nil {
+		return fmt.Errorf("failed to calculate checksum for %s: %v", file.Name, err)
+	}
+	base, err := c.Db.GetSyncState(file.ID)
+	if err != nil {
+		return err
+	}
+	svrRev, changed := plan.changes.Revisions[file.ID]
+
+	if sf != nil && base != nil && changed && svrRev > base.Revision {
+		if newPath := c.serverPath(file, sf); newPath != file.ClientPath && !FileExists(newPath) {
+			plan.Items = append(plan.Items, &SyncItem{
+				Op:      OpRename,
+				ID:      file.ID,
+				Name:    file.Name,
+				Path:    file.ClientPath,
+				NewPath: newPath,
+				Size:    localSize(file),
+				file:    file,
+				server:  sf,
+			})
+		}
+	}
+
+	item := &SyncItem{ID: file.ID, Name: file.Name, Path: file.ClientPath, file: file, server: sf}
+	item.Op = syncOp(localCs, plan.changes.CheckSums[file.ID], svrRev, changed, base)
+	switch item.Op {
+	case OpNone:
+		if changed && (base == nil || base.Revision != svrRev) {
+			plan.synced = append(plan.synced, file)
+		}
+		return nil
+	case OpPush:
+		item.Size = localSize(file)
+	case OpPull, OpConflict:
+		item.Size = file.Size
+		if sf != nil {
+			item.Size = sf.Size
+		}
+	}
+	plan.Items = append(plan.Items, item)
+	return nil
+}
+
+// where a known file should be locally, given the server's name and directory for it.
+//
+// files only follow moves into directories the client knows about. files whose
+// directories aren't registered with the server are kept in the server's root
+// directory, so a file in the server's root may not have been moved at all.
+// files whose server names aren't safe to use locally stay where they are.
+func (c *Client) serverPath(file *svc.File, sf *svc.File) string {
+	if !safeName(sf.Name) {
+		return file.ClientPath
+	}
+	dirPath := filepath.Dir(file.ClientPath)
+	if sf.DirID != file.DirID && sf.DirID != c.Drive.RootID {
+		if dir := c.Drive.GetDir(sf.DirID); dir != nil {
+			dirPath = dir.ClientPath
+		}
+	}
+	return filepath.Join(dirPath, sf.Name)
+}
+
+// find a local file by id. returns nil if the client has no record of it.
+func (c *Client) findFile(fileID string) (*svc.File, error) {
+	if file := c.Drive.GetFile(fileID); file != nil {
+		return file, nil
+	}
+	return c.Db.GetFileByID(fileID)
+}
+
+// current size of a local file, or its last recorded size if it can't be read
+func localSize(file *svc.File) int64 {
+	if info, err := os.Stat(file.ClientPath); err == nil {
+		return info.Size()
+	}
+	return file.Size
+}
+
+// ------- running plans ----------------------------------------
+
+// run a sync plan. pushes and pulls are run by the client's transfer
+// workers, and cancelling ctx skips any that haven't started yet.
+//
+// conflicts have their local changes saved to a conflict copy before the
+// server's version is pulled, and are reported in the sync result. deleted
+// files are moved to the recycle bin.
+//
+// the client's revision is only advanced if every item succeeded,
+// so anything that failed will be picked up again on the next sync.
+func (c *Client) Sync(ctx context.Context, plan *SyncPlan) (*SyncResult, error) {
+	var result = &SyncResult{Transfers: transfer.NewReport()}
+	var localIndex = c.Drive.SyncIndex
+
+	// make server revisions available to markSynced() for this sync
+	if localIndex.Revisions == nil {
+		localIndex.Revisions = make(map[string]int64, len(plan.changes.Revisions))
+	}
+	for id, rev := range plan.changes.Revisions {
+		localIndex.Revisions[id] = rev
+	}
+	for _, file := range plan.synced {
+		if err := c.markSynced(file); err != nil {
+			c.log.Warn(fmt.Sprintf("failed to update sync state for %s: %v", file.Name, err))
+		}
+	}
+	if plan.Empty() {
+		c.log.Info("no files to push or pull. exiting...")
+		if err := c.setRevision(plan.Target); err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+
+	// remove anything that was deleted on the server
+	var failed int
+	for _, item := range plan.items(OpDelete) {
+		deleted, err := c.applyTombstone(item.ts)
+		if err != nil {
+			c.log.Error(fmt.Sprintf("failed to remove deleted file (id=%s): %v", item.ID, err))
+			failed++
+			continue
+		}
+		if deleted {
+			result.Deleted = append(result.Deleted, item.ID)
+		}
+	}
+
+	// follow any moves and renames made on the server
+	for _, item := range plan.items(OpRename) {
+		if err := c.applyRename(item); err != nil {
+			c.log.Error(fmt.Sprintf("failed to move %s to %s: %v", item.Path, item.NewPath, err))
+			failed++
+		}
+	}
+
+	// save local changes for any conflicts, then pull the server's version
+	var pull []*svc.File
+	for _, item := range plan.items(OpConflict) {
+		conflict, err := c.resolveConflict(item.file)
+		if err != nil {
+			c.log.Error(fmt.Sprintf("failed to resolve conflict: %v", err))
+			failed++
+			continue
+		}
+		result.Conflicts = append(result.Conflicts, conflict)
+		pull = append(pull, item.file)
+	}
+
+	// create anything that only exists on the server. items are sorted
+	// by path, so directories are created before their contents.
+	var push []*svc.File
+	for _, item := range plan.items(OpPull) {
+		switch {
+		case item.Dir:
+			if err := c.addServerDir(item.dir, item.Path); err != nil {
+				c.log.Error(fmt.Sprintf("failed to pull new directory %s: %v", item.Name, err))
+				failed++
+			}
+		case item.file == nil:
+			if err := c.addServerFile(item.server, item.Path); err != nil {
+				c.log.Error(fmt.Sprintf("failed to pull new file %s: %v", item.Name, err))
+				failed++
+				continue
+			}
+			result.Pulled = append(result.Pulled, item.ID)
+		default:
+			pull = append(pull, item.file)
+		}
+	}
+	for _, item := range plan.items(OpPush) {
+		push = append(push, item.file)
+	}
+
+	// pull latest versions of files from the server
+	c.log.Info(fmt.Sprintf("pulling %d files from the server...", len(pull)))
+	pulled := c.executor().Run(ctx, svc.BuildFileQ(pull), func(ctx context.Context, file *svc.File) error {
+		return c.PullFile(file)
+	})
+	result.Pulled = append(result.Pulled, pulled.Succeeded...)
+	result.Transfers.Merge(pulled)
+
+	// push latest versions of files to the server
+	c.log.Info(fmt.Sprintf("pushing %d files to the server...", len(push)))
+	pushed := c.executor().Run(ctx, svc.BuildFileQ(push), func(ctx context.Context, file *svc.File) error {
+		return c.PushFile(file)
+	})
+	result.Pushed = append(result.Pushed, pushed.Succeeded...)
+	result.Transfers.Merge(pushed)
+
+	// anything skipped still needs syncing
+	failed += len(result.Transfers.Failed) + len(result.Transfers.Skipped)
+
+	// reset local sync mechanisms
+	c.reset()
+	if err := c.SaveState(); err != nil {
+		c.log.Error(fmt.Sprintf("failed to save state file: %v", err))
+	}
+
+	if failed > 0 {
+		c.log.Warn(fmt.Sprintf("%d sync operations failed. staying at revision %d", failed, c.Revision))
+		return result, nil
+	}
+	if err := c.setRevision(plan.Target); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// move a local file to where the server has it. the server already
+// has the file at its new location, so nothing is sent to the server.
+func (c *Client) applyRename(item *SyncItem) error {
+	if err := os.MkdirAll(filepath.Dir(item.NewPath), svc.PERMS); err != nil {
+		return err
+	}
+	// stop watching first so the monitor doesn't see this as a removal
+	c.Monitor.StopWatching(item.Path)
+	if err := os.Rename(item.Path, item.NewPath); err != nil {
+		if werr := c.WatchItem(item.Path); werr != nil {
+			c.log.Error(werr.Error())
+		}
+		return err
+	}
+	_, err := c.moveLocal(item.file, item.NewPath)
+	return err
+}
@@ -2,8 +2,10 @@ package client
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strings"
 
 	"github.com/sfs/pkg/auth"
@@ -139,11 +141,140 @@ func (c *Client) NewRuntimeRequest() (*http.Request, error) {
 	return req, nil
 }
 
+// log in with the user's ID and password
+func (c *Client) LoginRequest(user *auth.User) (*http.Request, error) {
+	creds := &auth.Credentials{UserID: user.ID, Password: user.Password}
+	data, err := creds.ToJSON()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, c.Endpoints["login"], bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	return req, nil
+}
+
+// exchange the session's refresh token for a new session
+func (c *Client) RefreshRequest(session *auth.Session) (*http.Request, error) {
+	data, err := json.Marshal(map[string]string{"refresh_token": session.RefreshToken})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, c.Endpoints["refresh"], bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	return req, nil
+}
+
+// end a session. the session's access token is set here
+// so the request doesn't start a new session first.
+func (c *Client) LogoutRequest(session *auth.Session) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodPost, c.Endpoints["logout"], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+session.Token)
+	return req, nil
+}
+
+// change the user's password
+func (c *Client) PasswordRequest(oldPw, newPw string) (*http.Request, error) {
+	creds := &auth.Credentials{Password: oldPw, NewPassword: newPw}
+	data, err := creds.ToJSON()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPut, c.Endpoints["password"], bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	return req, nil
+}
+
+// register this device with the server. the current session's access token
+// is set here, since the device doesn't have an API key yet.
+func (c *Client) RegisterDeviceRequest(name string, token string) (*http.Request, error) {
+	endpoint := c.Endpoints["devices"] + "/new?name=" + url.QueryEscape(name)
+	req, err := http.NewRequest(http.MethodPost, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req, nil
+}
+
+// list the user's registered devices
+func (c *Client) DevicesRequest() (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodGet, c.Endpoints["devices"], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	return req, nil
+}
+
+// revoke a registered device
+func (c *Client) RevokeDeviceRequest(deviceID string) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodDelete, c.Endpoints["devices"]+"/"+deviceID, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	return req, nil
+}
+
+// share a file or directory with another user
+func (c *Client) ShareRequest(share *svc.Share) (*http.Request, error) {
+	data, err := share.ToJSON()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, c.Endpoints["shares"]+"/new", bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+// list the shares the user has created
+func (c *Client) SharesRequest() (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodGet, c.Endpoints["shares"], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	return req, nil
+}
+
+// list every file shared with the user
+func (c *Client) SharedFilesRequest() (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodGet, c.Endpoints["shares"]+"/with-me/files", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	return req, nil
+}
+
+// stop sharing an item, or remove it from "Shared with me"
+func (c *Client) RemoveShareRequest(shareID string) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodDelete, c.Endpoints["shares"]+"/"+shareID, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	return req, nil
+}
+
 // ------ new item requests ----------------------------------------------
 
+// register a new user. the password is sent in the request body
+// rather than the token, since the server keeps its own hash of it.
 func (c *Client) NewUserRequest(newUser *auth.User) (*http.Request, error) {
-	var buf bytes.Buffer
-	req, err := http.NewRequest(http.MethodPost, c.Endpoints["new user"], &buf)
+	creds := &auth.Credentials{UserName: newUser.UserName, Password: newUser.Password}
+	data, err := creds.ToJSON()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, c.Endpoints["new user"], bytes.NewReader(data))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
@@ -151,7 +282,7 @@ func (c *Client) NewUserRequest(newUser *auth.User) (*http.Request, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request token: %v", err)
 	}
-	req.Header.Set("Authorization", "Bearer "+reqToken)
+	req.Header.Set(auth.PayloadHeader, reqToken)
 	return req, nil
 }
 
@@ -165,7 +296,7 @@ func (c *Client) NewFileRequest(newFile *svc.File) (*http.Request, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request token: %v", err)
 	}
-	req.Header.Set("Authorization", "Bearer "+reqToken)
+	req.Header.Set(auth.PayloadHeader, reqToken)
 	return req, nil
 }
 
@@ -179,7 +310,7 @@ func (c *Client) NewDirectoryRequest(newDir *svc.Directory) (*http.Request, erro
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request token: %v", err)
 	}
-	req.Header.Set("Authorization", "Bearer "+reqToken)
+	req.Header.Set(auth.PayloadHeader, reqToken)
 	return req, nil
 }
 
@@ -193,7 +324,7 @@ func (c *Client) NewDriveRequest(newDrv *svc.Drive) (*http.Request, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request token: %v", err)
 	}
-	req.Header.Set("Authorization", "Bearer "+reqToken)
+	req.Header.Set(auth.PayloadHeader, reqToken)
 	return req, nil
 }
 
@@ -220,7 +351,7 @@ func (c *Client) GetFileRequest(file *svc.File) (*http.Request, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request token: %v", err)
 	}
-	req.Header.Set("Authorization", "Bearer "+reqToken)
+	req.Header.Set(auth.PayloadHeader, reqToken)
 	return req, nil
 }
 
@@ -234,7 +365,7 @@ func (c *Client) GetFileInfoRequest(file *svc.File) (*http.Request, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request token: %v", err)
 	}
-	req.Header.Set("Authorization", "Bearer "+reqToken)
+	req.Header.Set(auth.PayloadHeader, reqToken)
 	return req, nil
 }
 
@@ -248,7 +379,21 @@ func (c *Client) GetAllFilesRequest(user *auth.User) (*http.Request, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request token: %v", err)
 	}
-	req.Header.Set("Authorization", "Bearer "+reqToken)
+	req.Header.Set(auth.PayloadHeader, reqToken)
+	return req, nil
+}
+
+func (c *Client) GetAllDirsRequest(user *auth.User) (*http.Request, error) {
+	var buf bytes.Buffer
+	req, err := http.NewRequest(http.MethodGet, c.Endpoints["all dirs"], &buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	reqToken, err := c.encodeUser(user)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request token: %v", err)
+	}
+	req.Header.Set(auth.PayloadHeader, reqToken)
 	return req, nil
 }
 
@@ -262,7 +407,7 @@ func (c *Client) GetDirRequest(dir *svc.Directory) (*http.Request, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request token: %v", err)
 	}
-	req.Header.Set("Authorization", "Bearer "+reqToken)
+	req.Header.Set(auth.PayloadHeader, reqToken)
 	return req, nil
 }
 
@@ -276,7 +421,7 @@ func (c *Client) GetDirInfoRequest(dir *svc.Directory) (*http.Request, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request token: %v", err)
 	}
-	req.Header.Set("Authorization", "Bearer "+reqToken)
+	req.Header.Set(auth.PayloadHeader, reqToken)
 	return req, nil
 }
 
@@ -290,7 +435,7 @@ func (c *Client) GetDriveRequest(drv *svc.Drive) (*http.Request, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request token: %v", err)
 	}
-	req.Header.Set("Authorization", "Bearer "+reqToken)
+	req.Header.Set(auth.PayloadHeader, reqToken)
 	return req, nil
 }
 
@@ -304,7 +449,7 @@ func (c *Client) GetUserRequest(user *auth.User) (*http.Request, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request token: %v", err)
 	}
-	req.Header.Set("Authorization", "Bearer "+reqToken)
+	req.Header.Set(auth.PayloadHeader, reqToken)
 	return req, nil
 }
 
@@ -320,7 +465,7 @@ func (c *Client) UpdateFileRequest(file *svc.File) (*http.Request, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request token: %v", err)
 	}
-	req.Header.Set("Authorization", "Bearer "+reqToken)
+	req.Header.Set(auth.PayloadHeader, reqToken)
 	return req, nil
 }
 
@@ -334,7 +479,7 @@ func (c *Client) UpdateDirectoryRequest(dir *svc.Directory) (*http.Request, erro
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request token: %v", err)
 	}
-	req.Header.Set("Authorization", "Bearer "+reqToken)
+	req.Header.Set(auth.PayloadHeader, reqToken)
 	return req, nil
 }
 
@@ -348,7 +493,7 @@ func (c *Client) UpdateDriveRequest(drv *svc.Drive) (*http.Request, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request token: %v", err)
 	}
-	req.Header.Set("Authorization", "Bearer "+reqToken)
+	req.Header.Set(auth.PayloadHeader, reqToken)
 	return req, nil
 }
 
@@ -362,7 +507,7 @@ func (c *Client) UpdateUserRequest(user *auth.User) (*http.Request, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request token: %v", err)
 	}
-	req.Header.Set("Authorization", "Bearer "+reqToken)
+	req.Header.Set(auth.PayloadHeader, reqToken)
 	return req, nil
 }
 
@@ -370,7 +515,26 @@ func (c *Client) UpdateUserRequest(user *auth.User) (*http.Request, error) {
 
 func (c *Client) DeleteFileRequest(file *svc.File) (*http.Request, error) {
 	var buf bytes.Buffer
-	req, err := http.NewRequest(http.MethodDelete, file.Endpoint, &buf)
+	// let the server know which device this deletion came from
+	endpoint := file.Endpoint + "?device=" + url.QueryEscape(deviceName())
+	req, err := http.NewRequest(http.MethodDelete, endpoint, &buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	reqToken, err := c.encodeFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request token: %v", err)
+	}
+	req.Header.Set(auth.PayloadHeader, reqToken)
+	return req, nil
+}
+
+// move and/or rename a file on the server. the file's current
+// DirID and Name are used as the destination.
+func (c *Client) MoveFileRequest(file *svc.File) (*http.Request, error) {
+	var buf bytes.Buffer
+	endpoint := file.Endpoint + "/move?dir=" + url.QueryEscape(file.DirID) + "&name=" + url.QueryEscape(file.Name)
+	req, err := http.NewRequest(http.MethodPut, endpoint, &buf)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
@@ -378,13 +542,14 @@ func (c *Client) DeleteFileRequest(file *svc.File) (*http.Request, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request token: %v", err)
 	}
-	req.Header.Set("Authorization", "Bearer "+reqToken)
+	req.Header.Set(auth.PayloadHeader, reqToken)
 	return req, nil
 }
 
 func (c *Client) DeleteDirectoryRequest(dir *svc.Directory) (*http.Request, error) {
 	var buf bytes.Buffer
-	req, err := http.NewRequest(http.MethodDelete, dir.Endpoint, &buf)
+	endpoint := dir.Endpoint + "?device=" + url.QueryEscape(deviceName())
+	req, err := http.NewRequest(http.MethodDelete, endpoint, &buf)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
@@ -392,7 +557,7 @@ func (c *Client) DeleteDirectoryRequest(dir *svc.Directory) (*http.Request, erro
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request token: %v", err)
 	}
-	req.Header.Set("Authorization", "Bearer "+reqToken)
+	req.Header.Set(auth.PayloadHeader, reqToken)
 	return req, nil
 }
 
@@ -406,7 +571,7 @@ func (c *Client) DeleteDriveRequest(drv *svc.Drive) (*http.Request, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request token: %v", err)
 	}
-	req.Header.Set("Authorization", "Bearer "+reqToken)
+	req.Header.Set(auth.PayloadHeader, reqToken)
 	return req, nil
 }
 
@@ -420,7 +585,7 @@ func (c *Client) DeleteUserRequest(user *auth.User) (*http.Request, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request token: %v", err)
 	}
-	req.Header.Set("Authorization", "Bearer "+reqToken)
+	req.Header.Set(auth.PayloadHeader, reqToken)
 	return req, nil
 }
 
@@ -0,0 +1,258 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	svc "github.com/sfs/pkg/service"
+)
+
+/*
+point-in-time restores.
+
+the server rebuilds the drive's file tree as it was at a given time from its
+version history and tombstones (see GET /v1/drive/{driveID}/snapshot), and the
+client compares it against a target folder to build a restore plan. the plan
+can be previewed before it's applied.
+
+restores can target the client's drive root or a separate folder. restoring
+into a separate folder only ever adds or overwrites files. restoring into the
+drive root also removes any files that didn't exist at the given time (they're
+moved to the recycle bin like any other removal), and pushes the restored
+contents to the server as new changes, so nothing on the server is lost.
+*/
+
+type RestoreOp string
+
+const (
+	RestoreAdd       RestoreOp = "add"       // file doesn't exist in the target
+	RestoreUpdate    RestoreOp = "update"    // file exists in the target with different contents
+	RestoreRemove    RestoreOp = "remove"    // file exists in the target but not in the snapshot
+	RestoreUnchanged RestoreOp = "unchanged" // file in the target already matches the snapshot
+)
+
+// a single change to make to the restore target
+type RestoreItem struct {
+	Op   RestoreOp         `json:"op"`
+	Path string            `json:"path"`           // local path in the restore target
+	File *svc.SnapshotFile `json:"file,omitempty"` // nil for removals
+}
+
+// everything needed to restore a drive to a point in time
+type RestorePlan struct {
+	DriveID string         `json:"drive_id"`
+	At      time.Time      `json:"at"`
+	Target  string         `json:"target"`
+	Live    bool           `json:"live"` // whether the target is the drive's root directory
+	Items   []*RestoreItem `json:"items"`
+}
+
+func (p *RestorePlan) count(op RestoreOp) int {
+	var n int
+	for _, item := range p.Items {
+		if item.Op == op {
+			n++
+		}
+	}
+	return n
+}
+
+// whether applying the plan would change anything
+func (p *RestorePlan) Empty() bool {
+	return p.count(RestoreUnchanged) == len(p.Items)
+}
+
+// a listing of what the plan would change, one file per line
+// followed by a summary
+func (p *RestorePlan) Preview() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("restoring drive to %s in %s\n\n", p.At.Local().Format(time.RFC1123), p.Target))
+	for _, item := range p.Items {
+		switch item.Op {
+		case RestoreAdd:
+			sb.WriteString(fmt.Sprintf("  + %s\n", item.Path))
+		case RestoreUpdate:
+			sb.WriteString(fmt.Sprintf("  ~ %s\n", item.Path))
+		case RestoreRemove:
+			sb.WriteString(fmt.Sprintf("  - %s\n", item.Path))
+		}
+	}
+	sb.WriteString(fmt.Sprintf(
+		"\n%d to add, %d to update, %d to remove, %d unchanged\n",
+		p.count(RestoreAdd), p.count(RestoreUpdate), p.count(RestoreRemove), p.count(RestoreUnchanged),
+	))
+	return sb.String()
+}
+
+func (p *RestorePlan) ToJSON() ([]byte, error) {
+	return json.MarshalIndent(p, "", "  ")
+}
+
+// retrieve the server's record of the drive's files as they were at the given time
+func (c *Client) GetDriveSnapshot(at time.Time) (*svc.Snapshot, error) {
+	endpoint := c.Endpoints["snapshot"] + "?at=" + url.QueryEscape(at.UTC().Format(time.RFC3339))
+	req, err := c.GetInfoRequest(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	var snapshot *svc.Snapshot
+	if err := c.decodeItems(req, func(dec *json.Decoder) error {
+		snapshot = new(svc.Snapshot)
+		return dec.Decode(snapshot)
+	}); err != nil {
+		return nil, fmt.Errorf("failed to get drive snapshot: %v", err)
+	}
+	if snapshot == nil {
+		return nil, fmt.Errorf("drive (id=%s) not found on the server", c.DriveID)
+	}
+	return snapshot, nil
+}
+
+// compare the drive as it was at the given time against the target directory.
+// if target is empty, the drive's root directory is used.
+func (c *Client) PlanRestore(at time.Time, target string) (*RestorePlan, error) {
+	snapshot, err := c.GetDriveSnapshot(at)
+	if err != nil {
+		return nil, err
+	}
+	live := target == "" || filepath.Clean(target) == filepath.Clean(c.Drive.Root.ClientPath)
+	if live {
+		target = c.Drive.Root.ClientPath
+	}
+	return c.planRestore(snapshot, target, live)
+}
+
+func (c *Client) planRestore(snapshot *svc.Snapshot, target string, live bool) (*RestorePlan, error) {
+	plan := &RestorePlan{
+		DriveID: snapshot.DriveID,
+		At:      snapshot.At,
+		Target:  target,
+		Live:    live,
+		Items:   make([]*RestoreItem, 0, len(snapshot.Files)),
+	}
+	paths := make(map[string]bool, len(snapshot.Files))
+	for _, sf := range snapshot.Files {
+		path := filepath.Join(target, sf.Path)
+		paths[path] = true
+
+		item := &RestoreItem{Op: RestoreAdd, Path: path, File: sf}
+		if _, err := os.Stat(path); err == nil {
+			cs, err := svc.CalculateChecksum(path)
+			if err != nil {
+				return nil, err
+			}
+			item.Op = RestoreUpdate
+			if cs == sf.CheckSum {
+				item.Op = RestoreUnchanged
+			}
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+		plan.Items = append(plan.Items, item)
+	}
+	// only files the drive is tracking are removed, and only from the live root.
+	if live {
+		for _, file := range c.Drive.GetFiles() {
+			if !paths[file.ClientPath] {
+				plan.Items = append(plan.Items, &RestoreItem{Op: RestoreRemove, Path: file.ClientPath})
+			}
+		}
+	}
+	sort.Slice(plan.Items, func(i, j int) bool { return plan.Items[i].Path < plan.Items[j].Path })
+	return plan, nil
+}
+
+// apply a restore plan. every item is attempted, and any failures are
+// reported together once the rest of the plan has been applied.
+func (c *Client) Restore(plan *RestorePlan) error {
+	var failed []string
+	for _, item := range plan.Items {
+		var err error
+		switch item.Op {
+		case RestoreAdd, RestoreUpdate:
+			err = c.restoreFile(plan, item)
+		case RestoreRemove:
+			err = c.restoreRemove(item)
+		}
+		if err != nil {
+			c.log.Error(fmt.Sprintf("failed to restore %s: %v", item.Path, err))
+			failed = append(failed, item.Path)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to restore %d file(s): %s", len(failed), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// server endpoint for a snapshot file's contents
+func (c *Client) snapshotFileEndpoint(sf *svc.SnapshotFile) string {
+	endpoint := c.Endpoints["files"] + sf.FileID
+	if sf.Version > 0 {
+		endpoint += fmt.Sprintf("/versions/%d", sf.Version)
+	}
+	return endpoint
+}
+
+// download a file's contents as they were in the snapshot. when restoring the
+// live root, new files are registered, and changes to known files are pushed
+// to the server.
+func (c *Client) restoreFile(plan *RestorePlan, item *RestoreItem) error {
+	if err := os.MkdirAll(filepath.Dir(item.Path), svc.PERMS); err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+	if err := c.Transfer.Download(item.Path, c.snapshotFileEndpoint(item.File), item.File.CheckSum); err != nil {
+		return err
+	}
+	if !plan.Live {
+		return nil
+	}
+	file, err := c.Db.GetFileByPath(item.Path)
+	if err != nil {
+		return err
+	}
+	if file == nil {
+		return c.AddFile(item.Path)
+	}
+	if f := c.Drive.GetFile(file.ID); f != nil {
+		file = f
+	}
+	if err := file.UpdateChecksum(); err != nil {
+		return err
+	}
+	file.Size = file.GetSize()
+	if err := c.UpdateFile(file); err != nil {
+		return err
+	}
+	if c.SvrSync() {
+		return c.PushFile(file)
+	}
+	return nil
+}
+
+// move a file that didn't exist at the time being restored to the recycle bin
+func (c *Client) restoreRemove(item *RestoreItem) error {
+	file, err := c.Db.GetFileByPath(item.Path)
+	if err != nil {
+		return err
+	}
+	if file == nil {
+		return nil
+	}
+	if f := c.Drive.GetFile(file.ID); f != nil {
+		file = f
+	}
+	if err := c.RemoveFile(file); err != nil {
+		return err
+	}
+	if err := os.Remove(item.Path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove %s: %v", file.Name, err)
+	}
+	return nil
+}
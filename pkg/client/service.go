@@ -6,12 +6,14 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/sfs/pkg/logger"
 	svc "github.com/sfs/pkg/service"
@@ -297,6 +299,25 @@ func (c *Client) ListRemoteFiles() error {
 	return nil
 }
 
+// get the drive's quota and space usage on the server, broken down by directory
+func (c *Client) GetDriveUsage() (*svc.DriveUsage, error) {
+	req, err := c.GetInfoRequest(c.Endpoints["usage"])
+	if err != nil {
+		return nil, err
+	}
+	var usage *svc.DriveUsage
+	if err := c.decodeItems(req, func(dec *json.Decoder) error {
+		usage = new(svc.DriveUsage)
+		return dec.Decode(usage)
+	}); err != nil {
+		return nil, fmt.Errorf("failed to get drive usage: %v", err)
+	}
+	if usage == nil {
+		return nil, fmt.Errorf("drive (id=%s) not found on the server", c.DriveID)
+	}
+	return usage, nil
+}
+
 // retrieve a local file using its ID. returns nil if the file is not found.
 func (c *Client) GetFileByID(fileID string) (*svc.File, error) {
 	file := c.Drive.GetFile(fileID)
@@ -359,6 +380,10 @@ in the SFS server or locally to a designated directory, depending
 on the service configurations.
 */
 func (c *Client) AddFile(filePath string) error {
+	if c.ignore.Ignored(filePath, false) {
+		c.log.Info(fmt.Sprintf("'%s' is ignored", filepath.Base(filePath)))
+		return nil
+	}
 	file, err := c.Db.GetFileByPath(filePath)
 	if err != nil {
 		return err
@@ -499,6 +524,64 @@ func (c *Client) UpdateFile(updatedFile *svc.File) error {
 	return nil
 }
 
+// move (and/or rename) a registered file to newPath, which is where the
+// physical file is now located. the file keeps its ID, and the server's
+// copy is moved rather than being uploaded again.
+func (c *Client) MoveFile(file *svc.File, newPath string) error {
+	oldPath := file.ClientPath
+	file, err := c.moveLocal(file, newPath)
+	if err != nil {
+		return err
+	}
+	if c.SvrSync() && file.Registered {
+		req, err := c.MoveFileRequest(file)
+		if err != nil {
+			return err
+		}
+		resp, err := c.Client.Do(req)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			c.dump(resp)
+			c.log.Warn(fmt.Sprintf("failed to move '%s' on the server. server response code: %v", file.Name, resp.Status))
+		}
+	}
+	c.log.Info(fmt.Sprintf("'%s' moved to %s", filepath.Base(oldPath), newPath))
+	return nil
+}
+
+// update the client's records for a file that's been moved to newPath,
+// without telling the server. returns the drive's copy of the file.
+func (c *Client) moveLocal(file *svc.File, newPath string) (*svc.File, error) {
+	// use the drive's copy of the file if we have one
+	if f := c.Drive.GetFile(file.ID); f != nil {
+		file = f
+	}
+	oldPath := file.ClientPath
+	destDirID := c.Drive.RootID
+	if dir, err := c.GetDirByPath(filepath.Dir(newPath)); err == nil {
+		destDirID = dir.ID
+	}
+
+	c.Monitor.StopWatching(oldPath)
+	c.moves.untrack(oldPath)
+
+	file.Rename(filepath.Base(newPath))
+	file.ClientPath = newPath
+	file.Path = newPath
+	if err := c.Drive.MoveFile(destDirID, file); err != nil {
+		return nil, fmt.Errorf("failed to move file (id=%s): %v", file.ID, err)
+	}
+	if err := c.Db.UpdateFile(file); err != nil {
+		return nil, fmt.Errorf("failed to update file (id=%s) in database: %v", file.ID, err)
+	}
+	if err := c.WatchItem(newPath); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
 // remove a file.
 // removes the file from the server if local backup is disabled.
 func (c *Client) RemoveFile(file *svc.File) error {
@@ -506,14 +589,59 @@ func (c *Client) RemoveFile(file *svc.File) error {
 		return fmt.Errorf("file '%s' not registered", file.Name)
 	}
 
-	// stop monitoring the file
-	c.Monitor.StopWatching(file.ClientPath)
+	if err := c.recycleFile(file); err != nil {
+		return err
+	}
+	c.log.Info(fmt.Sprintf("%s was moved to the recycle bin", file.Name))
+
+	// remove from backup server if necessary
+	if c.SvrSync() {
+		c.removeFromServer(file)
+	}
+	return nil
+}
 
+// remove a file from the backup server. failures are only logged.
+func (c *Client) removeFromServer(file *svc.File) {
+	req, err := c.DeleteFileRequest(file)
+	if err != nil {
+		c.log.Error("failed to create request: " + err.Error())
+		return
+	}
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		c.log.Error("failed to execute HTTP request: " + err.Error())
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		c.dump(resp)
+	} else {
+		c.log.Info(fmt.Sprintf("file '%s' removed from backup server", file.Name))
+	}
+}
+
+// copy a file to the recycle bin and remove it from the service.
+// does not remove the *original* physical file.
+func (c *Client) recycleFile(file *svc.File) error {
 	// move the file to the SFS recycle bin to help with recovery in case
-	// of an accidental deletion.
-	if err := file.Copy(filepath.Join(c.RecycleBin, file.Name)); err != nil {
+	// of an accidental deletion. each copy gets a unique name so files
+	// with the same name don't overwrite each other.
+	item := svc.NewRecycledItem(file, c.RecycleBin)
+	if err := file.Copy(item.Path); err != nil {
 		return fmt.Errorf("failed to copy file to recyle directory: %v", err)
 	}
+	if err := c.Db.AddRecycled(item); err != nil {
+		os.Remove(item.Path)
+		return err
+	}
+	return c.unregisterFile(file)
+}
+
+// stop monitoring a file and remove its meta-data from the service.
+// does not touch the physical file.
+func (c *Client) unregisterFile(file *svc.File) error {
+	c.Monitor.StopWatching(file.ClientPath)
+	c.moves.untrack(file.ClientPath)
 
 	// TODO: need to example how file.BackupPath is set if the file is
 	// placed in the sfs root. sfs is looking for ../username/backups/root/filename.txt
@@ -532,25 +660,8 @@ func (c *Client) RemoveFile(file *svc.File) error {
 	if err := c.Db.RemoveFile(file.ID); err != nil {
 		return err
 	}
-	c.log.Info(fmt.Sprintf("%s was moved to the recycle bin", file.Name))
-
-	// remove from backup server if necessary
-	if c.SvrSync() {
-		req, err := c.DeleteFileRequest(file)
-		if err != nil {
-			c.log.Error("failed to create request: " + err.Error())
-			return nil
-		}
-		resp, err := c.Client.Do(req)
-		if err != nil {
-			c.log.Error("failed to execute HTTP request: " + err.Error())
-			return nil
-		}
-		if resp.StatusCode != http.StatusOK {
-			c.dump(resp)
-		} else {
-			c.log.Info(fmt.Sprintf("file '%s' removed from backup server", file.Name))
-		}
+	if err := c.Db.RemoveSyncState(file.ID); err != nil {
+		c.log.Warn(fmt.Sprintf("failed to remove sync state for %s: %v", file.Name, err))
 	}
 	return nil
 }
@@ -658,6 +769,10 @@ func (c *Client) getDirServerPath(dir *svc.Directory) (string, error) {
 // is already known, it will be added to there, otherwise will automatically
 // be placed under root.
 func (c *Client) AddDir(dirPath string) error {
+	if c.ignore.Ignored(dirPath, true) {
+		c.log.Info(fmt.Sprintf("'%s' is ignored", filepath.Base(dirPath)))
+		return nil
+	}
 	dir, err := c.Db.GetDirectoryByPath(dirPath)
 	if err != nil {
 		return err
@@ -691,11 +806,9 @@ func (c *Client) AddDir(dirPath string) error {
 	if err := c.Db.AddDir(newDir); err != nil {
 		return err
 	}
-	// NOTE: directory monitoring is not currently supported.
-	// keeping this for future implementation iterations.
-	// if err := c.WatchItem(dirPath); err != nil {
-	// 	return err
-	// }
+	if err := c.WatchItem(dirPath); err != nil {
+		return err
+	}
 	// push metadata to server if localBackup is disabled
 	if c.SvrSync() {
 		req, err := c.NewDirectoryRequest(newDir)
@@ -871,10 +984,83 @@ func (c *Client) EmptyRecycleBin() error {
 			c.log.Error(err.Error())
 		}
 	}
+	items, err := c.Db.GetAllRecycled()
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if err := c.Db.RemoveRecycled(item.ID); err != nil {
+			c.log.Error(err.Error())
+		}
+	}
 	c.log.Info(fmt.Sprintf("client recycle bin emptied. %d files deleted", len(entries)))
 	return nil
 }
 
+// get everything in the recycle bin, most recently deleted first.
+func (c *Client) GetRecycled() ([]*svc.RecycledItem, error) {
+	return c.Db.GetAllRecycled()
+}
+
+// put a file from the recycle bin back where it was removed from, and add it
+// to the service as a new file. fails if something else is already there.
+func (c *Client) RestoreRecycled(id string) (*svc.RecycledItem, error) {
+	item, err := c.Db.GetRecycled(id)
+	if err != nil {
+		return nil, err
+	}
+	if item == nil {
+		return nil, fmt.Errorf("recycled item (id=%s) not found", id)
+	}
+	if _, err := os.Stat(item.OrigPath); err == nil {
+		return nil, fmt.Errorf("'%s' already exists", item.OrigPath)
+	}
+	if err := os.MkdirAll(filepath.Dir(item.OrigPath), svc.PERMS); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %v", err)
+	}
+	if err := os.Rename(item.Path, item.OrigPath); err != nil {
+		return nil, fmt.Errorf("failed to restore %s: %v", item.Name, err)
+	}
+	if err := c.Db.RemoveRecycled(item.ID); err != nil {
+		return nil, err
+	}
+	c.log.Info(fmt.Sprintf("%s was restored from the recycle bin to %s", item.Name, item.OrigPath))
+
+	if err := c.AddFile(item.OrigPath); err != nil {
+		return item, fmt.Errorf("restored %s, but failed to add it to the service: %v", item.Name, err)
+	}
+	return item, nil
+}
+
+// permanently remove anything that's been in the recycle bin
+// longer than CLIENT_RECYCLE_EXPIRY days. failures are only logged.
+func (c *Client) ExpireRecycled() {
+	items, err := c.Db.GetAllRecycled()
+	if err != nil {
+		c.log.Error(fmt.Sprintf("failed to get recycled items: %v", err))
+		return
+	}
+	var removed int
+	now := time.Now().UTC()
+	for _, item := range items {
+		if !item.Expired(c.Conf.RecycleExpiry, now) {
+			continue
+		}
+		if err := os.Remove(item.Path); err != nil && !os.IsNotExist(err) {
+			c.log.Error(fmt.Sprintf("failed to remove %s from the recycle bin: %v", item.Name, err))
+			continue
+		}
+		if err := c.Db.RemoveRecycled(item.ID); err != nil {
+			c.log.Error(err.Error())
+			continue
+		}
+		removed++
+	}
+	if removed > 0 {
+		c.log.Info(fmt.Sprintf("removed %d expired item(s) from the recycle bin", removed))
+	}
+}
+
 // ----- drive --------------------------------
 
 // add a new drive to the client. mainly used for testing
@@ -931,6 +1117,10 @@ func (c *Client) LoadDrive() error {
 	if err := c.Drive.Root.AddSubDirs(dirs); err != nil {
 		return err
 	}
+	// directories discovered outside of the root use their own .sfsignore files
+	for _, dir := range dirs {
+		c.ignore.AddRoot(dir.ClientPath)
+	}
 
 	// add all other distributed files monitored by sfs
 	files, err := c.Db.GetUsersFiles(c.UserID)
@@ -1060,12 +1250,18 @@ func (c *Client) Discover(dirPath string) (*svc.Directory, error) {
 		return dir, nil
 	}
 
+	// .sfsignore files anywhere in the new directory apply to its contents
+	c.ignore.AddRoot(dirPath)
+	if c.ignore.Ignored(dirPath, true) {
+		return nil, fmt.Errorf("'%s' is ignored", filepath.Base(dirPath))
+	}
+
 	// create a new directory object and traverse
 	c.log.Info(fmt.Sprintf("traversing %s...", dirPath))
 	newDir := svc.NewDirectory(filepath.Base(dirPath), c.UserID, c.DriveID, dirPath)
 	newDir.Parent = c.Drive.Root
 	newDir.BackupPath = filepath.Join(c.Conf.BackupDir, newDir.Name)
-	newDir.Walk()
+	newDir.WalkIgnore(c.ignore)
 
 	// add newly discovered files and directories to the service
 	files := newDir.GetFiles()
@@ -1085,7 +1281,7 @@ func (c *Client) Discover(dirPath string) (*svc.Directory, error) {
 		}
 	}
 
-	// add directories to the database. not monitored (for now)
+	// add directories to the database and monitor them for new items
 	dirs := newDir.GetSubDirs()
 	c.log.Info(fmt.Sprintf("adding %d directories...", len(dirs)))
 
@@ -1093,9 +1289,9 @@ func (c *Client) Discover(dirPath string) (*svc.Directory, error) {
 		return nil, err
 	}
 	for _, subDir := range dirs {
-		// if err := c.WatchItem(subDir.ClientPath); err != nil {
-		// 	return err
-		// }
+		if err := c.WatchItem(subDir.ClientPath); err != nil {
+			return nil, err
+		}
 		if c.SvrSync() {
 			if err := c.RegisterDirectory(subDir); err != nil {
 				return nil, err
@@ -1103,14 +1299,14 @@ func (c *Client) Discover(dirPath string) (*svc.Directory, error) {
 		}
 	}
 
-	// add new directory itself. not monitored (for now)
+	// add new directory itself
 	c.log.Info(fmt.Sprintf("adding %s...", filepath.Base(dirPath)))
 	if err := c.Db.AddDir(newDir); err != nil {
 		return nil, fmt.Errorf("failed to add root to database: %v", err)
 	}
-	// if err := c.WatchItem(newDir.Path); err != nil {
-	// 	return err
-	// }
+	if err := c.WatchItem(newDir.ClientPath); err != nil {
+		return nil, err
+	}
 	if err := c.Drive.AddSubDir(c.Drive.RootID, newDir); err != nil {
 		return nil, fmt.Errorf("failed to add root to drive instance: %v", err)
 	}
@@ -1125,6 +1321,56 @@ func (c *Client) Discover(dirPath string) (*svc.Directory, error) {
 	return newDir, nil
 }
 
+// find all the items under a directory that Discover() would add, and the
+// ones it would skip because of ignore rules. ignored directories aren't
+// descended into, so their contents aren't listed.
+func (c *Client) discoverPreview(dirPath string) ([]string, []string, error) {
+	if !c.isDirPath(dirPath) {
+		return nil, nil, fmt.Errorf("path is not a directory: %s", dirPath)
+	}
+	c.ignore.AddRoot(dirPath)
+
+	included := make([]string, 0)
+	ignored := make([]string, 0)
+	err := filepath.WalkDir(dirPath, func(itemPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if c.ignore.Ignored(itemPath, d.IsDir()) {
+			ignored = append(ignored, itemPath)
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		included = append(included, itemPath)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return included, ignored, nil
+}
+
+// show what Discover() would add for a given directory
+// without registering anything.
+func (c *Client) DiscoverDryRun(dirPath string) error {
+	included, ignored, err := c.discoverPreview(dirPath)
+	if err != nil {
+		return err
+	}
+	var output string
+	for _, item := range included {
+		output += fmt.Sprintf("+ %s\n", item)
+	}
+	for _, item := range ignored {
+		output += fmt.Sprintf("- %s (ignored)\n", item)
+	}
+	output += fmt.Sprintf("\n%d item(s) would be added. %d item(s) ignored.\n", len(included), len(ignored))
+	fmt.Print(output)
+	return nil
+}
+
 // make sure all items registered on the client side are also registered
 // on the client side. if not, register them with the server.
 func (c *Client) RegisterItems() error {
@@ -0,0 +1,226 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	svc "github.com/sfs/pkg/service"
+)
+
+/*
+shared files and directories.
+
+items other users share with this user are synced into a "Shared with me"
+directory under the drive's root. the owner's changes don't show up in this
+user's drive revisions, so shared files are compared against their last synced
+checksums instead. local changes to files shared as read-only are never sent to
+the server. they're kept locally until the owner changes the file, at which
+point they're saved to a conflict copy and the owner's version is pulled.
+*/
+
+// share a local file or directory with another user.
+// grantee can be a user ID or user name. expires can be zero.
+func (c *Client) ShareItem(path string, grantee string, perm svc.Permission, expires time.Time) (*svc.Share, error) {
+	var itemID string
+	if file, err := c.Db.GetFileByPath(path); err != nil {
+		return nil, err
+	} else if file != nil {
+		itemID = file.ID
+	} else if dir, err := c.Db.GetDirectoryByPath(path); err != nil {
+		return nil, err
+	} else if dir != nil {
+		itemID = dir.ID
+	} else {
+		return nil, fmt.Errorf("'%s' is not monitored by SFS", path)
+	}
+
+	req, err := c.ShareRequest(&svc.Share{ItemID: itemID, GranteeID: grantee, Permission: perm, Expires: expires})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to share item: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		c.dump(resp)
+		return nil, fmt.Errorf("failed to share item. server status: %v", resp.Status)
+	}
+	share := new(svc.Share)
+	if err := json.NewDecoder(resp.Body).Decode(share); err != nil {
+		return nil, fmt.Errorf("failed to decode share: %v", err)
+	}
+	c.log.Info(fmt.Sprintf("shared %s with %s (%s)", filepath.Base(path), grantee, perm))
+	return share, nil
+}
+
+// list the shares the user has created
+func (c *Client) ListShares() ([]*svc.Share, error) {
+	req, err := c.SharesRequest()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get shares: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		c.dump(resp)
+		return nil, fmt.Errorf("failed to get shares. server status: %v", resp.Status)
+	}
+	shares := make([]*svc.Share, 0)
+	if err := json.NewDecoder(resp.Body).Decode(&shares); err != nil {
+		return nil, fmt.Errorf("failed to decode shares: %v", err)
+	}
+	return shares, nil
+}
+
+// stop sharing an item, or remove an item shared with this user
+func (c *Client) RemoveShare(shareID string) error {
+	req, err := c.RemoveShareRequest(shareID)
+	if err != nil {
+		return err
+	}
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to remove share: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		c.dump(resp)
+		return fmt.Errorf("failed to remove share. server status: %v", resp.Status)
+	}
+	return nil
+}
+
+// retrieve every file shared with this user
+func (c *Client) getSharedFiles() ([]*svc.SharedFile, error) {
+	req, err := c.SharedFilesRequest()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get shared files: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		c.dump(resp)
+		return nil, fmt.Errorf("failed to get shared files. server status: %v", resp.Status)
+	}
+	shared := make([]*svc.SharedFile, 0)
+	if err := json.NewDecoder(resp.Body).Decode(&shared); err != nil {
+		return nil, fmt.Errorf("failed to decode shared files: %v", err)
+	}
+	return shared, nil
+}
+
+// local directory shared items are synced to
+func (c *Client) sharedRoot() string {
+	return filepath.Join(c.Drive.Root.ClientPath, svc.SharedDirName)
+}
+
+// where a shared file should be locally. returns an error if the
+// server's path for it isn't safe to use.
+func (c *Client) sharedPath(sf *svc.SharedFile) (string, error) {
+	if !safePath(sf.Path) {
+		return "", fmt.Errorf("unsafe path from server: '%s'", sf.Path)
+	}
+	return localPath(c.sharedRoot(), sf.Path)
+}
+
+// add shared files to a sync plan. any items already planned for shared
+// files are replaced, since they were planned without the owner's changes.
+func (c *Client) planShared(plan *SyncPlan, shared []*svc.SharedFile) error {
+	if len(shared) == 0 {
+		return nil
+	}
+	sharedIDs := make(map[string]bool, len(shared))
+	for _, sf := range shared {
+		sharedIDs[sf.File.ID] = true
+	}
+	items := make([]*SyncItem, 0, len(plan.Items))
+	for _, item := range plan.Items {
+		if !sharedIDs[item.ID] {
+			items = append(items, item)
+		}
+	}
+	plan.Items = items
+
+	for _, sf := range shared {
+		file, err := c.Db.GetFileByID(sf.File.ID)
+		if err != nil {
+			return err
+		}
+		if file == nil {
+			path, err := c.sharedPath(sf)
+			if err != nil {
+				c.log.Warn(fmt.Sprintf("skipping shared file (id=%s): %v", sf.File.ID, err))
+				continue
+			}
+			plan.Items = append(plan.Items, &SyncItem{
+				Op:     OpPull,
+				ID:     sf.File.ID,
+				Name:   sf.File.Name,
+				Path:   path,
+				Size:   sf.File.Size,
+				server: sf.File,
+			})
+			continue
+		}
+		localCs, err := svc.CalculateChecksum(file.ClientPath)
+		if err != nil {
+			return fmt.Errorf("failed to calculate checksum for %s: %v", file.Name, err)
+		}
+		base, err := c.Db.GetSyncState(file.ID)
+		if err != nil {
+			return err
+		}
+		item := &SyncItem{ID: file.ID, Name: file.Name, Path: file.ClientPath, file: file, server: sf.File}
+		item.Op = sharedOp(localCs, sf.File.CheckSum, base, sf.Permission == svc.PermReadWrite)
+		switch item.Op {
+		case OpNone:
+			if localCs != sf.File.CheckSum {
+				c.log.Warn(fmt.Sprintf("%s is shared as read-only. local changes won't be sent to the server", file.Name))
+			} else if base == nil || base.CheckSum != localCs {
+				plan.synced = append(plan.synced, file)
+			}
+			continue
+		case OpPush:
+			item.Size = localSize(file)
+		case OpPull, OpConflict:
+			item.Size = sf.File.Size
+		}
+		plan.Items = append(plan.Items, item)
+	}
+	return nil
+}
+
+// determine the sync operation for a shared file given its local checksum,
+// the server's checksum, and its last synced state (if any). local changes
+// are only pushed if the file is shared with write access.
+func sharedOp(localCs string, svrCs string, base *svc.SyncState, canWrite bool) SyncOp {
+	if localCs == svrCs {
+		return OpNone
+	}
+	if base == nil {
+		return OpConflict
+	}
+	svrChanged := svrCs != base.CheckSum
+	localChanged := localCs != base.CheckSum
+	switch {
+	case svrChanged && localChanged:
+		return OpConflict
+	case svrChanged:
+		return OpPull
+	case localChanged && canWrite:
+		return OpPush
+	default:
+		return OpNone
+	}
+}
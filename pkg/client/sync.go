@@ -7,15 +7,22 @@ May need to build SyncIndex.ToUpdate map, or transfer individual files or direct
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/sfs/pkg/logger"
 	svc "github.com/sfs/pkg/service"
+	"github.com/sfs/pkg/transfer"
 )
 
 // whether the client is automatically syncing with the server
@@ -67,158 +74,241 @@ func (c *Client) BuildSyncIndex() {
 	// }
 
 	// NOTE: the dir arg is set to nil until dir monitoring is supported
-	c.Drive.SyncIndex = svc.BuildSyncIndex(files, nil, c.Drive.SyncIndex)
+	c.Drive.SyncIndex = svc.BuildSyncIndex(files, nil, c.Drive.SyncIndex, c.ignore)
 	c.log.Log(logger.INFO, fmt.Sprintf("%d files have been indexed", len(files)))
 }
 
-type SyncItems struct {
-	pull []*svc.File
-	push []*svc.File
+// sync operation to perform on a file
+type SyncOp string
+
+const (
+	OpNone     SyncOp = "none"
+	OpPush     SyncOp = "push"
+	OpPull     SyncOp = "pull"
+	OpConflict SyncOp = "conflict"
+	OpDelete   SyncOp = "delete"
+	OpRename   SyncOp = "rename"
+)
+
+// a file that was modified on both the client and the server since the last sync.
+// the server's version keeps the original name, and the local changes are
+// moved to a new conflict copy.
+type SyncConflict struct {
+	FileID       string    `json:"file_id"`       // id of the original file
+	Name         string    `json:"name"`          // name of the original file
+	ConflictID   string    `json:"conflict_id"`   // id of the conflict copy
+	ConflictPath string    `json:"conflict_path"` // path to the conflict copy
+	Device       string    `json:"device"`        // device the local changes were made on
+	Time         time.Time `json:"time"`          // when the conflict was detected
 }
 
-// sync items between the client and the server.
-//
-// NOTE: this assumes that both the client and the server have
-// a record of the objects to sync. if the server has a file the client doesn't
-// know about, then this doesn't handle it, and vice-versa
-func (c *Client) ServerSync() error {
-	svrIdx, err := c.GetServerIdx(true) // get latest server sync index
+// results of a sync operation with the server
+type SyncResult struct {
+	Pushed    []string        `json:"pushed"`    // ids of files pushed to the server
+	Pulled    []string        `json:"pulled"`    // ids of files pulled from the server
+	Deleted   []string        `json:"deleted"`   // ids of files deleted on the server and moved to the recycle bin
+	Conflicts []*SyncConflict `json:"conflicts"` // files modified on both the client and server
+
+	// results of the pushes and pulls
+	Transfers *transfer.Report `json:"transfers"`
+}
+
+func (r *SyncResult) ToJSON() ([]byte, error) {
+	data, err := json.MarshalIndent(r, "", "  ")
 	if err != nil {
-		return err
+		return nil, err
 	}
-	var syncItems = new(SyncItems)
-	var localIndex = c.Drive.SyncIndex
-
-	// figure out which items to push and pull
-	for id, svrLastSync := range svrIdx.LastSync {
-		if localIndex.HasItem(id) {
-			if svrLastSync.After(localIndex.LastSync[id]) {
-				file, err := c.GetFileByID(id)
-				if err != nil {
-					return err
-				}
-				syncItems.pull = append(syncItems.pull, file)
-			} else if localIndex.LastSync[id].After(svrIdx.LastSync[id]) {
-				file, err := c.GetFileByID(id)
-				if err != nil {
-					return err
-				}
-				syncItems.push = append(syncItems.push, file)
-			}
+	return data, nil
+}
+
+// record the current checksum of a local file as the last
+// version both the client and the server agreed on, along with the
+// server revision it corresponds to, if known.
+func (c *Client) markSynced(file *svc.File) error {
+	cs, err := svc.CalculateChecksum(file.ClientPath)
+	if err != nil {
+		return fmt.Errorf("failed to calculate checksum: %v", err)
+	}
+	file.CheckSum = cs
+	var rev int64
+	var ok bool
+	if c.Drive.SyncIndex != nil {
+		rev, ok = c.Drive.SyncIndex.Revisions[file.ID]
+	}
+	if !ok {
+		base, err := c.Db.GetSyncState(file.ID)
+		if err != nil {
+			return err
+		}
+		if base != nil {
+			rev = base.Revision
 		}
 	}
-	if len(syncItems.pull) == 0 && len(syncItems.push) == 0 {
-		c.log.Info("no sync operation necessary. exiting...")
-		return nil
+	return c.Db.SetSyncState(svc.NewSyncState(file, rev))
+}
+
+// determine the sync operation for a file given its local checksum, the
+// server's latest change to it (if any), and its last synced state (if any).
+//
+// revisions are used to tell whether the server's copy changed since the last
+// sync, rather than timestamps, so clock skew between devices doesn't matter.
+func syncOp(localCs string, svrCs string, svrRev int64, changed bool, base *svc.SyncState) SyncOp {
+	if changed && localCs == svrCs {
+		return OpNone
+	}
+	if base == nil {
+		// never synced, so there's nothing to tell us which side is newer.
+		// if the server has a different version, keep both.
+		if changed {
+			return OpConflict
+		}
+		return OpPush
 	}
+	// changes that match the last synced checksum are our own pushes
+	svrChanged := changed && svrRev > base.Revision && svrCs != base.CheckSum
+	localChanged := localCs != base.CheckSum
+	switch {
+	case svrChanged && localChanged:
+		return OpConflict
+	case svrChanged:
+		return OpPull
+	case localChanged:
+		return OpPush
+	default:
+		return OpNone
+	}
+}
 
-	// pull latest versions of files from the server
-	var wg sync.WaitGroup
-	c.log.Info(fmt.Sprintf("pulling %d files from the server...", len(syncItems.pull)))
-	for _, file := range syncItems.pull {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			if err := c.PullFile(file); err != nil {
-				c.log.Error(fmt.Sprintf("failed to pull file: %v", err))
-			}
-		}()
+// keep the local version of a conflicting file by copying it to
+// "name (conflict from <device> <date>).ext" and adding the copy to the
+// service as a new file. the original file can then be safely overwritten
+// by the server's version.
+func (c *Client) resolveConflict(file *svc.File) (*SyncConflict, error) {
+	var (
+		now    = time.Now().UTC()
+		device = deviceName()
+		path   = conflictPath(file.ClientPath, device, now)
+	)
+	if err := file.Copy(path); err != nil {
+		return nil, fmt.Errorf("failed to create conflict copy of %s: %v", file.Name, err)
 	}
-	wg.Wait()
+	if err := c.AddFile(path); err != nil {
+		return nil, fmt.Errorf("failed to add conflict copy of %s: %v", file.Name, err)
+	}
+	cf, err := c.GetFileByPath(path)
+	if err != nil {
+		return nil, err
+	}
+	if cf.Registered {
+		if err := c.PushFile(cf); err != nil {
+			c.log.Warn(fmt.Sprintf("failed to push conflict copy %s: %v", cf.Name, err))
+		}
+	}
+	c.log.Warn(fmt.Sprintf("conflict detected for %s. local changes saved to %s", file.Name, cf.Name))
+	return &SyncConflict{
+		FileID:       file.ID,
+		Name:         file.Name,
+		ConflictID:   cf.ID,
+		ConflictPath: path,
+		Device:       device,
+		Time:         now,
+	}, nil
+}
 
-	// push latest versions of files to the server
-	c.log.Info(fmt.Sprintf("pushing %d files to the server...", len(syncItems.push)))
-	for _, file := range syncItems.push {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			if err := c.PushFile(file); err != nil {
-				c.log.Error("failed to push file: " + err.Error())
-			}
-		}()
+// handle a file that was deleted on the server by moving the local
+// copy to the recycle bin. returns false if the file isn't known locally.
+func (c *Client) applyTombstone(ts *svc.Tombstone) (bool, error) {
+	file, err := c.findFile(ts.FileID)
+	if err != nil || file == nil {
+		return false, err
 	}
-	wg.Wait()
+	base, err := c.Db.GetSyncState(file.ID)
+	if err != nil {
+		return false, err
+	}
+	if base != nil {
+		if cs, err := svc.CalculateChecksum(file.ClientPath); err == nil && cs != base.CheckSum {
+			c.log.Warn(fmt.Sprintf("%s was modified locally but deleted on the server. local changes will be kept in the recycle bin", file.Name))
+		}
+	}
+	if err := c.recycleFile(file); err != nil {
+		return false, err
+	}
+	if err := os.Remove(file.ClientPath); err != nil && !os.IsNotExist(err) {
+		return false, fmt.Errorf("failed to remove %s: %v", file.Name, err)
+	}
+	c.log.Info(fmt.Sprintf("%s was deleted on the server by %s. moved to the recycle bin", file.Name, ts.Device))
+	return true, nil
+}
 
-	// reset local sync mechanisms
-	c.reset()
+// sync items between the client and the server.
+//
+// the client asks the server for every change made after the last revision it
+// synced to, builds a sync plan from them (see plan.go), then runs it.
+// use PlanSync() to see what a sync would do without running it.
+//
+// cancelling ctx skips any transfers that haven't started yet.
+func (c *Client) ServerSync(ctx context.Context) (*SyncResult, error) {
+	plan, err := c.PlanSync()
+	if err != nil {
+		return nil, err
+	}
+	return c.Sync(ctx, plan)
+}
 
-	return nil
+// record the latest server revision the client has synced to
+func (c *Client) setRevision(rev int64) error {
+	if rev <= c.Revision {
+		return nil
+	}
+	c.Revision = rev
+	return c.SaveState()
+}
+
+// worker pool for transfers to and from the server
+func (c *Client) executor() *transfer.Executor {
+	return transfer.NewExecutor(c.Conf.Workers, c.Conf.Retries)
 }
 
 // take a given sync index, build a queue of files to be pushed to the
-// server, then upload each in their own goroutines one batch at a time.
+// server, then upload them with the client's transfer workers.
 // each file is assumed to be already registered with the server, otherwise
 // this will receive a 404 response and the upload will fail.
-func (c *Client) PushAll() error {
+func (c *Client) PushAll() (*transfer.Report, error) {
 	if len(c.Drive.SyncIndex.FilesToUpdate) == 0 {
 		c.log.Warn("no files marked for uploading. sync index update map is empty")
-		return nil
+		return transfer.NewReport(), nil
 	}
 	q := svc.BuildQ(c.Drive.SyncIndex)
 	if q == nil {
-		return fmt.Errorf("unable to build queue: no files found for syncing")
+		return nil, fmt.Errorf("unable to build queue: no files found for syncing")
 	}
-	var wg sync.WaitGroup
-	for len(q.Queue) > 0 {
-		batch := q.Dequeue()
-		for _, file := range batch.Files {
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				c.log.Info(fmt.Sprintf("uploading %s...", file.Name))
-				if err := c.Transfer.Upload(
-					http.MethodPut,
-					file,
-					file.Endpoint,
-				); err != nil {
-					c.log.Warn(fmt.Sprintf("failed to upload file (name=%s id=%s): %v", file.Name, file.ID, err))
-				}
-			}()
-		}
-	}
-	wg.Wait()
+	report := c.executor().Run(context.Background(), q, func(ctx context.Context, file *svc.File) error {
+		c.log.Info(fmt.Sprintf("uploading %s...", file.Name))
+		return c.Transfer.UploadDelta(file, file.Endpoint)
+	})
+	c.log.Info("upload complete: " + report.String())
 	c.reset()
-	return nil
+	return report, nil
 }
 
-// gets a sync index from the server, compares with the local one,
-// and pulls any files that are out of date on the client side from the server.
-// create goroutines for each download and 'fans-in' once all are complete.
-func (c *Client) PullAll() error {
+// pull any files in the sync index's update map from the server
+// using the client's transfer workers.
+func (c *Client) PullAll() (*transfer.Report, error) {
 	if len(c.Drive.SyncIndex.FilesToUpdate) == 0 {
 		c.log.Warn("sync index update map has no contents. nothing to pull")
-		return nil
+		return transfer.NewReport(), nil
 	}
 	q := svc.BuildQ(c.Drive.SyncIndex)
 	if q == nil {
-		return fmt.Errorf("unable to build queue: no files found for syncing")
-	}
-	var wg sync.WaitGroup
-	for len(q.Queue) > 0 {
-		batch := q.Dequeue()
-		for _, file := range batch.Files {
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				if err := c.Transfer.Download(
-					file.ClientPath,
-					file.Endpoint,
-				); err != nil {
-					c.log.Warn(fmt.Sprintf("failed to download %s: %v", file.Name, err))
-					return
-				}
-				if err := file.ValidateChecksum(); err != nil {
-					c.log.Warn(fmt.Sprintf("failed to validate checksum for %s: %v", file.Name, err))
-				}
-				if err := c.Db.UpdateFile(file); err != nil {
-					c.log.Warn(fmt.Sprintf("failed to update files database: %v", err))
-				}
-			}()
-		}
+		return nil, fmt.Errorf("unable to build queue: no files found for syncing")
 	}
-	wg.Wait()
+	report := c.executor().Run(context.Background(), q, func(ctx context.Context, file *svc.File) error {
+		return c.PullFile(file)
+	})
+	c.log.Info("download complete: " + report.String())
 	c.reset()
-	return nil
+	return report, nil
 }
 
 // retrieve the current sync index for this user from the server
@@ -251,13 +341,249 @@ func (c *Client) GetServerIdx(gen bool) (*svc.SyncIndex, error) {
 	return idx, nil
 }
 
+// retrieve all file changes on the server after the given revision
+func (c *Client) GetServerChanges(since int64) (*svc.SyncIndex, error) {
+	resp, err := c.Client.Get(fmt.Sprintf("%s?since=%d", c.Endpoints["changes"], since))
+	if err != nil {
+		return nil, fmt.Errorf("failed to contact server: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		c.dump(resp)
+		return nil, fmt.Errorf("failed to get server changes: %v", resp.StatusCode)
+	}
+	defer resp.Body.Close()
+
+	var buf bytes.Buffer
+	_, err = io.Copy(&buf, resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var idx = new(svc.SyncIndex)
+	if err = json.Unmarshal(buf.Bytes(), &idx); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// ------- server-only items ---------------------------------------
+
+// retrieve metadata for all of this user's files on the server
+func (c *Client) getServerFiles() ([]*svc.File, error) {
+	req, err := c.GetAllFilesRequest(c.User)
+	if err != nil {
+		return nil, err
+	}
+	var files []*svc.File
+	if err := c.decodeItems(req, func(dec *json.Decoder) error {
+		file := new(svc.File)
+		if err := dec.Decode(file); err != nil {
+			return err
+		}
+		files = append(files, file)
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to get server files: %v", err)
+	}
+	return files, nil
+}
+
+// retrieve the server's metadata for a file. returns nil if the server doesn't have it.
+func (c *Client) getServerFile(file *svc.File) (*svc.File, error) {
+	req, err := c.GetFileInfoRequest(file)
+	if err != nil {
+		return nil, err
+	}
+	var sf *svc.File
+	if err := c.decodeItems(req, func(dec *json.Decoder) error {
+		sf = new(svc.File)
+		return dec.Decode(sf)
+	}); err != nil {
+		return nil, fmt.Errorf("failed to get server file info: %v", err)
+	}
+	return sf, nil
+}
+
+// retrieve metadata for all of this user's directories on the server
+func (c *Client) getServerDirs() ([]*svc.Directory, error) {
+	req, err := c.GetAllDirsRequest(c.User)
+	if err != nil {
+		return nil, err
+	}
+	var dirs []*svc.Directory
+	if err := c.decodeItems(req, func(dec *json.Decoder) error {
+		dir := new(svc.Directory)
+		if err := dec.Decode(dir); err != nil {
+			return err
+		}
+		dirs = append(dirs, dir)
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to get server directories: %v", err)
+	}
+	return dirs, nil
+}
+
+// execute a request whose response is a stream of JSON objects,
+// calling decode once for each object. a 404 is treated as an empty stream.
+func (c *Client) decodeItems(req *http.Request, decode func(dec *json.Decoder) error) error {
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to contact server: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		c.dump(resp)
+		return fmt.Errorf("server returned: %v", resp.StatusCode)
+	}
+	dec := json.NewDecoder(resp.Body)
+	for dec.More() {
+		if err := decode(dec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// get the path of a server directory relative to the drive root by
+// walking up its parents. returns an empty string for the root itself,
+// or for any directory whose parent isn't in dirs. returns an error if
+// any of the directories' names aren't safe to use locally.
+func relDirPath(dirID string, dirs map[string]*svc.Directory) (string, error) {
+	var parts []string
+	seen := make(map[string]bool)
+	for dir, ok := dirs[dirID]; ok && !dir.Root && !seen[dir.ID]; dir, ok = dirs[dir.ParentID] {
+		seen[dir.ID] = true
+		if !safeName(dir.Name) {
+			return "", fmt.Errorf("unsafe directory name from server: '%s'", dir.Name)
+		}
+		parts = append([]string{dir.Name}, parts...)
+	}
+	return filepath.Join(parts...), nil
+}
+
+// whether a file or directory name from the server can be used
+// as a single element of a local path. the server holds names
+// it's sent to the same rules.
+func safeName(name string) bool {
+	return svc.ValidName(name)
+}
+
+// whether a relative path from the server is made up only of safe names
+func safePath(rel string) bool {
+	if rel == "" || filepath.IsAbs(rel) {
+		return false
+	}
+	for _, name := range strings.Split(filepath.ToSlash(rel), "/") {
+		if !safeName(name) {
+			return false
+		}
+	}
+	return true
+}
+
+// join a relative path from the server onto a local directory.
+// returns an error if the result isn't inside root.
+func localPath(root string, rel string) (string, error) {
+	path := filepath.Join(root, rel)
+	r, err := filepath.Rel(root, path)
+	if err != nil || r == "." || r == ".." || strings.HasPrefix(r, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("'%s' is outside of %s", rel, root)
+	}
+	return path, nil
+}
+
+// create a local copy of a directory that only exists on the server
+func (c *Client) addServerDir(sd *svc.Directory, path string) error {
+	if err := os.MkdirAll(path, svc.PERMS); err != nil {
+		return fmt.Errorf("failed to create directory %s: %v", path, err)
+	}
+	dir := sd
+	dir.Path = path
+	dir.ClientPath = path
+	dir.Root = false
+	dir.Registered = true
+	dir.Files = make(map[string]*svc.File, 0)
+	dir.Dirs = make(map[string]*svc.Directory, 0)
+
+	parentID := c.Drive.Root.ID
+	if parent := c.Drive.GetDir(sd.ParentID); parent != nil {
+		parentID = parent.ID
+	}
+	if err := c.Drive.AddSubDir(parentID, dir); err != nil {
+		return err
+	}
+	if err := c.Db.AddDir(dir); err != nil {
+		return err
+	}
+	c.log.Info(fmt.Sprintf("directory '%s' pulled from server", dir.Name))
+	return nil
+}
+
+// where a file is downloaded from and uploaded to on the client's server
+func (c *Client) fileURL(fileID string) string {
+	return c.Endpoints["files"] + url.PathEscape(fileID)
+}
+
+// download a file that only exists on the server to the given path
+func (c *Client) addServerFile(sf *svc.File, path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("'%s' already exists locally", path)
+	}
+	// shared files may be in directories the client doesn't have
+	if err := os.MkdirAll(filepath.Dir(path), svc.PERMS); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %v", path, err)
+	}
+	// the file's endpoint came from the server (and for shared files, from
+	// another user), so it's downloaded from the client's own server instead.
+	endpoint := c.fileURL(sf.ID)
+	if err := c.Transfer.Download(path, endpoint, sf.CheckSum); err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("file was not downloaded: %v", err)
+	}
+	file := sf
+	file.Endpoint = endpoint
+	file.Path = path
+	file.ClientPath = path
+	file.Registered = true
+	file.MarkLocalBackup()
+
+	dirID := c.Drive.Root.ID
+	if dir := c.Drive.GetDir(sf.DirID); dir != nil {
+		dirID = dir.ID
+	}
+	if err := c.Drive.AddFile(dirID, file); err != nil {
+		return err
+	}
+	if err := c.Db.AddFile(file); err != nil {
+		return err
+	}
+	if err := c.WatchItem(path); err != nil {
+		return err
+	}
+	if err := c.markSynced(file); err != nil {
+		c.log.Warn(fmt.Sprintf("failed to update sync state for %s: %v", file.Name, err))
+	}
+	c.log.Info(fmt.Sprintf("file '%s' pulled from server", file.Name))
+	return nil
+}
+
 // ------- single-operation pushes and pulls from the server -------------
 
 // send a known file to the server. For new files, use PushNewFile() instead.
+//
+// only the blocks that differ from the server's copy are sent.
 func (c *Client) PushFile(file *svc.File) error {
-	if err := c.Transfer.Upload(http.MethodPut, file, file.Endpoint); err != nil {
+	if err := c.Transfer.UploadDelta(file, file.Endpoint); err != nil {
 		return err
 	}
+	if err := c.markSynced(file); err != nil {
+		c.log.Warn(fmt.Sprintf("failed to update sync state for %s: %v", file.Name, err))
+	}
 	return nil
 }
 
@@ -275,10 +601,25 @@ func (c *Client) PushNewFile(file *svc.File) error {
 //
 // not intended for new files discovered on the server -- this will be handled by a
 // separate function PullNewFiles()
+//
+// the download is verified against the checksum of the server's copy.
 func (c *Client) PullFile(file *svc.File) error {
-	if err := c.Transfer.Download(file.ClientPath, file.Endpoint); err != nil {
+	sf, err := c.getServerFile(file)
+	if err != nil {
 		return err
 	}
+	if sf == nil {
+		return fmt.Errorf("%s (id=%s) not found on the server", file.Name, file.ID)
+	}
+	if err := c.Transfer.Download(file.ClientPath, c.fileURL(file.ID), sf.CheckSum); err != nil {
+		return err
+	}
+	if err := c.markSynced(file); err != nil {
+		c.log.Warn(fmt.Sprintf("failed to update sync state for %s: %v", file.Name, err))
+	}
+	if err := c.Db.UpdateFile(file); err != nil {
+		return fmt.Errorf("failed to update files database: %v", err)
+	}
 	return nil
 }
 
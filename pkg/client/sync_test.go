@@ -1,13 +1,19 @@
 package client
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/sfs/pkg/env"
 	svr "github.com/sfs/pkg/server"
 	svc "github.com/sfs/pkg/service"
+
+	"github.com/alecthomas/assert/v2"
 )
 
 func TestGetServerSyncIndex(t *testing.T) {
@@ -114,3 +120,209 @@ func TestPull(t *testing.T) {
 
 	// retrieve index from server API and confirm non-empty fields
 }
+
+func TestConflictPath(t *testing.T) {
+	tmp := t.TempDir()
+	orig := filepath.Join(tmp, "notes.txt")
+	date := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	path := conflictPath(orig, "laptop", date)
+	assert.Equal(t, filepath.Join(tmp, "notes (conflict from laptop 2024-01-02).txt"), path)
+
+	// a second conflict on the same day shouldn't overwrite the first
+	if err := os.WriteFile(path, []byte("conflict"), svc.PERMS); err != nil {
+		t.Fatal(err)
+	}
+	path2 := conflictPath(orig, "laptop", date)
+	assert.NotEqual(t, path, path2)
+	assert.Equal(t, filepath.Join(tmp, "notes (conflict from laptop 2024-01-02 03.04.05).txt"), path2)
+}
+
+func TestSyncOp(t *testing.T) {
+	base := &svc.SyncState{FileID: "a", CheckSum: "base", Revision: 5}
+
+	// nothing changed on either side
+	assert.Equal(t, OpNone, syncOp("base", "", 0, false, base))
+	// only local changes
+	assert.Equal(t, OpPush, syncOp("local", "", 0, false, base))
+	// only server changes
+	assert.Equal(t, OpPull, syncOp("base", "server", 6, true, base))
+	// both changed
+	assert.Equal(t, OpConflict, syncOp("local", "server", 6, true, base))
+	// both changed, but to the same thing
+	assert.Equal(t, OpNone, syncOp("same", "same", 6, true, base))
+	// server revision is our own push of the base version
+	assert.Equal(t, OpPush, syncOp("local", "base", 6, true, base))
+	// server revision is older than what we last synced
+	assert.Equal(t, OpPush, syncOp("local", "server", 4, true, base))
+
+	// never synced before
+	assert.Equal(t, OpPush, syncOp("local", "", 0, false, nil))
+	assert.Equal(t, OpConflict, syncOp("local", "server", 1, true, nil))
+	assert.Equal(t, OpNone, syncOp("same", "same", 1, true, nil))
+}
+
+func TestSharedOp(t *testing.T) {
+	base := &svc.SyncState{FileID: "a", CheckSum: "base"}
+
+	// nothing changed on either side
+	assert.Equal(t, OpNone, sharedOp("base", "base", base, false))
+	// the owner changed the file
+	assert.Equal(t, OpPull, sharedOp("base", "server", base, false))
+	assert.Equal(t, OpPull, sharedOp("base", "server", base, true))
+	// local changes are only pushed with write access
+	assert.Equal(t, OpPush, sharedOp("local", "base", base, true))
+	assert.Equal(t, OpNone, sharedOp("local", "base", base, false))
+	// both changed
+	assert.Equal(t, OpConflict, sharedOp("local", "server", base, false))
+	assert.Equal(t, OpConflict, sharedOp("local", "server", base, true))
+
+	// never synced before
+	assert.Equal(t, OpNone, sharedOp("same", "same", nil, false))
+	assert.Equal(t, OpConflict, sharedOp("local", "server", nil, false))
+}
+
+func TestRelDirPath(t *testing.T) {
+	dirs := map[string]*svc.Directory{
+		"root": {ID: "root", Name: "root", Root: true},
+		"a":    {ID: "a", Name: "a", ParentID: "root"},
+		"b":    {ID: "b", Name: "b", ParentID: "a"},
+		"c":    {ID: "c", Name: "c", ParentID: "unknown"},
+		"up":   {ID: "up", Name: "..", ParentID: "a"},
+		"sep":  {ID: "sep", Name: filepath.Join("x", "y"), ParentID: "root"},
+		"in":   {ID: "in", Name: "d", ParentID: "up"},
+	}
+	for id, want := range map[string]string{
+		"root":    "",
+		"a":       "a",
+		"b":       filepath.Join("a", "b"),
+		"c":       "c",
+		"missing": "",
+	} {
+		rel, err := relDirPath(id, dirs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, want, rel)
+	}
+	// unsafe names anywhere in the path are rejected
+	for _, id := range []string{"up", "sep", "in"} {
+		_, err := relDirPath(id, dirs)
+		assert.Error(t, err)
+	}
+}
+
+func TestLocalPath(t *testing.T) {
+	for _, name := range []string{"a.txt", ".hidden", "a..b"} {
+		assert.True(t, safeName(name))
+	}
+	for _, name := range []string{"", ".", "..", filepath.Join("a", "b"), filepath.Join("..", "a"), "/etc"} {
+		assert.False(t, safeName(name))
+	}
+
+	assert.True(t, safePath(filepath.Join("docs", "a.txt")))
+	for _, rel := range []string{"", "/etc/passwd", filepath.Join("..", "a.txt"), filepath.Join("docs", "..", "..", "a.txt"), "docs/./a.txt", "docs//a.txt"} {
+		assert.False(t, safePath(rel))
+	}
+
+	root := filepath.Join("drive", "root")
+	path, err := localPath(root, filepath.Join("docs", "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, filepath.Join(root, "docs", "a.txt"), path)
+	for _, rel := range []string{"", "..", filepath.Join("..", "other"), filepath.Join("docs", "..", "..", "other")} {
+		_, err := localPath(root, rel)
+		assert.Error(t, err)
+	}
+}
+
+func TestFileURL(t *testing.T) {
+	c := &Client{Endpoints: map[string]string{"files": "http://localhost:8080/v1/files/"}}
+	assert.Equal(t, "http://localhost:8080/v1/files/some-file-id", c.fileURL("some-file-id"))
+	// ids from the server can't point the client anywhere else
+	assert.Equal(t, "http://localhost:8080/v1/files/..%2F..%2Fauth%2Flogout", c.fileURL("../../auth/logout"))
+}
+
+func TestSharedPath(t *testing.T) {
+	c := &Client{Drive: &svc.Drive{Root: &svc.Directory{ClientPath: filepath.Join("drive", "root")}}}
+
+	path, err := c.sharedPath(&svc.SharedFile{Path: filepath.Join("docs", "a.txt")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, filepath.Join("drive", "root", svc.SharedDirName, "docs", "a.txt"), path)
+
+	for _, rel := range []string{"", "/etc/passwd", filepath.Join("..", "..", "passwd"), filepath.Join("docs", "..", "..", "a.txt")} {
+		_, err := c.sharedPath(&svc.SharedFile{Path: rel})
+		assert.Error(t, err)
+	}
+}
+
+func TestSyncPlan(t *testing.T) {
+	plan := &SyncPlan{Revision: 3, Target: 7, Items: []*SyncItem{
+		{Op: OpPush, ID: "a", Path: "a.txt", Size: 10},
+		{Op: OpPush, ID: "b", Path: "b.txt", Size: 5},
+		{Op: OpPull, ID: "c", Path: "c.txt", Size: 20},
+		{Op: OpPull, ID: "d", Path: "d", Dir: true},
+		{Op: OpConflict, ID: "e", Path: "e.txt", Size: 1},
+		{Op: OpRename, ID: "f", Path: "f.txt", NewPath: "g.txt", Size: 2},
+	}}
+	assert.False(t, plan.Empty())
+	assert.Equal(t, 2, plan.Count(OpPush))
+	assert.Equal(t, int64(15), plan.Size(OpPush))
+	assert.Equal(t, 2, plan.Count(OpPull))
+	assert.Equal(t, int64(20), plan.Size(OpPull))
+	assert.Equal(t, 0, plan.Count(OpDelete))
+	assert.Equal(t, 1, plan.Count(OpRename))
+
+	data, err := plan.ToJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded SyncPlan
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, plan.Items, decoded.Items)
+	assert.Equal(t, int64(7), decoded.Target)
+
+	assert.True(t, (&SyncPlan{}).Empty())
+}
+
+func TestSyncPlanServerPath(t *testing.T) {
+	docs := &svc.Directory{
+		ID:         "docs",
+		Name:       "docs",
+		ClientPath: filepath.Join("drive", "docs"),
+		Files:      make(map[string]*svc.File),
+		Dirs:       make(map[string]*svc.Directory),
+	}
+	root := &svc.Directory{
+		ID:         "root",
+		Name:       "root",
+		ClientPath: "drive",
+		Root:       true,
+		Files:      make(map[string]*svc.File),
+		Dirs:       map[string]*svc.Directory{docs.ID: docs},
+	}
+	c := &Client{Drive: &svc.Drive{ID: "drive", RootID: root.ID, Root: root}}
+	file := &svc.File{ID: "a", Name: "a.txt", DirID: root.ID, ClientPath: filepath.Join("drive", "a.txt")}
+
+	// nothing changed
+	assert.Equal(t, file.ClientPath, c.serverPath(file, &svc.File{Name: "a.txt", DirID: root.ID}))
+	// renamed
+	assert.Equal(t, filepath.Join("drive", "b.txt"), c.serverPath(file, &svc.File{Name: "b.txt", DirID: root.ID}))
+	// moved into a directory the client knows about
+	assert.Equal(t, filepath.Join("drive", "docs", "a.txt"), c.serverPath(file, &svc.File{Name: "a.txt", DirID: docs.ID}))
+	// moved into a directory the client doesn't know about
+	assert.Equal(t, file.ClientPath, c.serverPath(file, &svc.File{Name: "a.txt", DirID: "unknown"}))
+
+	// names that aren't safe to use locally are ignored
+	assert.Equal(t, file.ClientPath, c.serverPath(file, &svc.File{Name: filepath.Join("..", "..", "a.txt"), DirID: root.ID}))
+	assert.Equal(t, file.ClientPath, c.serverPath(file, &svc.File{Name: "..", DirID: root.ID}))
+
+	// files in the server's root may just not have their directory registered
+	moved := &svc.File{ID: "b", Name: "b.txt", DirID: docs.ID, ClientPath: filepath.Join("drive", "docs", "b.txt")}
+	assert.Equal(t, moved.ClientPath, c.serverPath(moved, &svc.File{Name: "b.txt", DirID: root.ID}))
+}
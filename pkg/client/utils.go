@@ -8,6 +8,8 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strings"
+	"time"
 )
 
 // Generate a pseudo-random integer in the range [0, n)
@@ -61,3 +63,30 @@ func ShowFileInExplorer(filePath string) error {
 		return fmt.Errorf("unsupported platform: %s", runtime.GOOS)
 	}
 }
+
+// name of this device. used when labeling conflict copies.
+func deviceName() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		return "unknown device"
+	}
+	return host
+}
+
+// build a path for a conflict copy of a file, i.e.
+// "notes (conflict from laptop 2024-01-02).txt"
+//
+// if a conflict copy with that name already exists,
+// then the time is added to the name as well.
+func conflictPath(filePath string, device string, t time.Time) string {
+	var (
+		dir  = filepath.Dir(filePath)
+		ext  = filepath.Ext(filePath)
+		base = strings.TrimSuffix(filepath.Base(filePath), ext)
+	)
+	path := filepath.Join(dir, fmt.Sprintf("%s (conflict from %s %s)%s", base, device, t.Format("2006-01-02"), ext))
+	if FileExists(path) {
+		path = filepath.Join(dir, fmt.Sprintf("%s (conflict from %s %s)%s", base, device, t.Format("2006-01-02 15.04.05"), ext))
+	}
+	return path
+}
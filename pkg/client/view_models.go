@@ -100,6 +100,7 @@ func newRecycleBinItems() RecycleBinItems {
 type RecyclePage struct {
 	UserPage   string
 	ProfilePic string
+	Items      []*svc.RecycledItem
 	Dirs       []os.DirEntry
 	Files      []os.DirEntry
 	ServerHost string
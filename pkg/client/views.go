@@ -113,13 +113,25 @@ func (c *Client) RecycleBinPage(w http.ResponseWriter, r *http.Request) {
 	entries, err := os.ReadDir(c.RecycleBin)
 	if err != nil {
 		c.error(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	items, err := c.GetRecycled()
+	if err != nil {
+		c.error(w, r, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
+	// anything in the recycle bin that isn't in the manifest
+	// (i.e. recycled before the manifest existed) can't be restored.
+	tracked := make(map[string]bool, len(items))
+	for _, item := range items {
+		tracked[filepath.Base(item.Path)] = true
+	}
 	recycleBinItems := newRecycleBinItems()
 	for _, entry := range entries {
 		if entry.IsDir() {
 			recycleBinItems.Dirs = append(recycleBinItems.Dirs, entry)
-		} else {
+		} else if !tracked[entry.Name()] {
 			recycleBinItems.Files = append(recycleBinItems.Files, entry)
 		}
 	}
@@ -127,6 +139,7 @@ func (c *Client) RecycleBinPage(w http.ResponseWriter, r *http.Request) {
 	recyclePageData := RecyclePage{
 		UserPage:   userPage,
 		ProfilePic: c.Conf.ProfilePic,
+		Items:      items,
 		Files:      recycleBinItems.Files,
 		Dirs:       recycleBinItems.Dirs,
 		ServerHost: c.Conf.ServerAddr,
@@ -154,6 +154,7 @@ func newWcRouter(client *Client) *chi.Mux {
 	// recycle bin page
 	r.Route("/recycled", func(r chi.Router) {
 		r.Get("/", client.RecycleBinPage)
+		r.Post("/restore", client.RestoreRecycledHandler)
 	})
 
 	return r
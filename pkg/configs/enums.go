@@ -2,37 +2,49 @@ package configs
 
 // global config settings
 const (
-	ADMIN_MODE           string = "ADMIN_MODE"
-	BUFFERED_EVENTS      string = "BUFFERED_EVENTS"
-	CLIENT_ADDRESS       string = "CLIENT_ADDRESS"
-	CLIENT_BACKUP_DIR    string = "CLIENT_BACKUP_DIR"
-	CLIENT_EMAIL         string = "CLIENT_EMAIL"
-	CLIENT_HOST          string = "CLIENT_HOST"
-	CLIENT_ID            string = "CLIENT_ID"
-	CLIENT_LOG_DIR       string = "CLIENT_LOG_DIR"
-	CLIENT_NAME          string = "CLIENT_NAME"
-	CLIENT_NEW_SERVICE   string = "CLIENT_NEW_SERVICE"
-	CLIENT_NOTIFICATIONS string = "CLIENT_NOTIFICATIONS"
-	CLIENT_PASSWORD      string = "CLIENT_PASSWORD"
-	CLIENT_PORT          string = "CLIENT_PORT"
-	CLIENT_PROFILE_PIC   string = "CLIENT_PROFILE_PIC"
-	CLIENT_SERVER_SYNC   string = "CLIENT_SERVER_SYNC"
-	CLIENT_TESTING       string = "CLIENT_TESTING"
-	CLIENT_USERNAME      string = "CLIENT_USERNAME"
-	EVENT_BUFFER_SIZE    string = "EVENT_BUFFER_SIZE"
-	JWT_SECRET           string = "JWT_SECRET"
-	NEW_SERVICE          string = "NEW_SERVICE"
-	SERVER_ADDR          string = "SERVER_ADDR"
-	SERVER_ADMIN         string = "SERVER_ADMIN"
-	SERVER_ADMIN_KEY     string = "SERVER_ADMIN_KEY"
-	SERVER_HOST          string = "SERVER_LOCAL_HOST"
-	SERVER_LOG_DIR       string = "SERVER_LOG_DIR"
-	SERVER_PORT          string = "SERVER_PORT"
-	SERVER_TIMEOUT_IDLE  string = "SERVER_TIMEOUT_IDLE"
-	SERVER_TIMEOUT_READ  string = "SERVER_TIMEOUT_READ"
-	SERVER_TIMEOUT_WRITE string = "SERVER_TIMEOUT_WRITE"
-	SERVICE_ENV          string = "SERVICE_ENV"
-	SERVICE_LOG_DIR      string = "SERVICE_LOG_DIR"
-	SERVICE_ROOT         string = "SERVICE_ROOT"
-	SERVICE_TEST_ROOT    string = "SERVICE_TEST_ROOT"
+	ADMIN_MODE                 string = "ADMIN_MODE"
+	BUFFERED_EVENTS            string = "BUFFERED_EVENTS"
+	CLIENT_ADDRESS             string = "CLIENT_ADDRESS"
+	CLIENT_BACKUP_DIR          string = "CLIENT_BACKUP_DIR"
+	CLIENT_DOWNLOAD_LIMIT      string = "CLIENT_DOWNLOAD_LIMIT"
+	CLIENT_EMAIL               string = "CLIENT_EMAIL"
+	CLIENT_FILE_DOWNLOAD_LIMIT string = "CLIENT_FILE_DOWNLOAD_LIMIT"
+	CLIENT_FILE_UPLOAD_LIMIT   string = "CLIENT_FILE_UPLOAD_LIMIT"
+	CLIENT_HOST                string = "CLIENT_HOST"
+	CLIENT_ID                  string = "CLIENT_ID"
+	CLIENT_IGNORE              string = "CLIENT_IGNORE"
+	CLIENT_LOG_DIR             string = "CLIENT_LOG_DIR"
+	CLIENT_NAME                string = "CLIENT_NAME"
+	CLIENT_NEW_SERVICE         string = "CLIENT_NEW_SERVICE"
+	CLIENT_NOTIFICATIONS       string = "CLIENT_NOTIFICATIONS"
+	CLIENT_PASSWORD            string = "CLIENT_PASSWORD"
+	CLIENT_PORT                string = "CLIENT_PORT"
+	CLIENT_PROFILE_PIC         string = "CLIENT_PROFILE_PIC"
+	CLIENT_PUSH_NEW_ITEMS      string = "CLIENT_PUSH_NEW_ITEMS"
+	CLIENT_RECYCLE_EXPIRY      string = "CLIENT_RECYCLE_EXPIRY"
+	CLIENT_SERVER_SYNC         string = "CLIENT_SERVER_SYNC"
+	CLIENT_TESTING             string = "CLIENT_TESTING"
+	CLIENT_TRANSFER_RETRIES    string = "CLIENT_TRANSFER_RETRIES"
+	CLIENT_TRANSFER_WINDOWS    string = "CLIENT_TRANSFER_WINDOWS"
+	CLIENT_TRANSFER_WORKERS    string = "CLIENT_TRANSFER_WORKERS"
+	CLIENT_UPLOAD_LIMIT        string = "CLIENT_UPLOAD_LIMIT"
+	CLIENT_USERNAME            string = "CLIENT_USERNAME"
+	EVENT_BUFFER_SIZE          string = "EVENT_BUFFER_SIZE"
+	JWT_SECRET                 string = "JWT_SECRET"
+	MONITOR_BACKEND            string = "MONITOR_BACKEND"
+	NEW_SERVICE                string = "NEW_SERVICE"
+	SERVER_ADDR                string = "SERVER_ADDR"
+	SERVER_ADMIN               string = "SERVER_ADMIN"
+	SERVER_ADMIN_KEY           string = "SERVER_ADMIN_KEY"
+	SERVER_HOST                string = "SERVER_LOCAL_HOST"
+	SERVER_LOG_DIR             string = "SERVER_LOG_DIR"
+	SERVER_PORT                string = "SERVER_PORT"
+	SERVER_TIMEOUT_IDLE        string = "SERVER_TIMEOUT_IDLE"
+	SERVER_TIMEOUT_READ        string = "SERVER_TIMEOUT_READ"
+	SERVER_TIMEOUT_WRITE       string = "SERVER_TIMEOUT_WRITE"
+	SERVER_TOMBSTONE_RETENTION string = "SERVER_TOMBSTONE_RETENTION"
+	SERVICE_ENV                string = "SERVICE_ENV"
+	SERVICE_LOG_DIR            string = "SERVICE_LOG_DIR"
+	SERVICE_ROOT               string = "SERVICE_ROOT"
+	SERVICE_TEST_ROOT          string = "SERVICE_TEST_ROOT"
 )
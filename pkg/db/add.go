@@ -1,7 +1,9 @@
 package db
 
 import (
+	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/sfs/pkg/auth"
 
@@ -107,6 +109,7 @@ func (q *Query) AddUser(user *auth.User) error {
 		&user.TotalFiles,
 		&user.TotalDirs,
 		&user.DrvRoot,
+		string(user.GetRole()),
 	); err != nil {
 		return fmt.Errorf("failed to execute statement: %v", err)
 	}
@@ -215,3 +218,287 @@ func (q *Query) AddDrive(drv *svc.Drive) error {
 	}
 	return nil
 }
+
+// add or replace the last synced state of a file
+func (q *Query) SetSyncState(state *svc.SyncState) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.WhichDB("sync")
+	q.Connect()
+	defer q.Close()
+
+	if _, err := q.Conn.Exec(
+		SetSyncStateQuery,
+		&state.FileID,
+		&state.DriveID,
+		&state.CheckSum,
+		&state.Revision,
+		&state.LastSync,
+	); err != nil {
+		return fmt.Errorf("failed to execute statement: %v", err)
+	}
+	return nil
+}
+
+// assign the next revision for the file's drive to the file.
+// returns the new revision.
+func (q *Query) NextRevision(file *svc.File) (int64, error) {
+	return q.nextRevision(file, false, "")
+}
+
+// record a tombstone for a deleted file using the next revision
+// for the file's drive. returns the new revision.
+func (q *Query) AddTombstone(file *svc.File, device string) (int64, error) {
+	return q.nextRevision(file, true, device)
+}
+
+func (q *Query) nextRevision(file *svc.File, deleted bool, device string) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.WhichDB("revisions")
+	q.Connect()
+	defer q.Close()
+
+	if _, err := q.Conn.Exec(
+		NextRevisionQuery,
+		file.ID,
+		file.DriveID,
+		file.DriveID,
+		file.CheckSum,
+		time.Now().UTC(),
+		deleted,
+		device,
+	); err != nil {
+		return 0, fmt.Errorf("failed to execute statement: %v", err)
+	}
+	var rev int64
+	if err := q.Conn.QueryRow(FindDriveRevisionQuery, file.DriveID).Scan(&rev); err != nil {
+		return 0, fmt.Errorf("failed to get revision: %v", err)
+	}
+	return rev, nil
+}
+
+// add or replace an upload session
+func (q *Query) SetUpload(u *svc.UploadSession) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.WhichDB("uploads")
+	q.Connect()
+	defer q.Close()
+
+	received, err := json.Marshal(u.Received)
+	if err != nil {
+		return fmt.Errorf("failed to marshal received ranges: %v", err)
+	}
+	if _, err := q.Conn.Exec(
+		SetUploadQuery,
+		&u.ID,
+		&u.FileID,
+		&u.DriveID,
+		&u.OwnerID,
+		&u.Size,
+		&u.CheckSum,
+		&u.TmpPath,
+		string(received),
+		&u.Created,
+		&u.Updated,
+	); err != nil {
+		return fmt.Errorf("failed to execute statement: %v", err)
+	}
+	return nil
+}
+
+// add a file version. the version is assigned the next version number
+// for its file, which is returned.
+func (q *Query) AddVersion(v *svc.Version) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.WhichDB("versions")
+	q.Connect()
+	defer q.Close()
+
+	if _, err := q.Conn.Exec(
+		AddVersionQuery,
+		&v.FileID,
+		&v.DriveID,
+		&v.OwnerID,
+		&v.DirID,
+		&v.Name,
+		&v.FileID,
+		&v.Size,
+		&v.CheckSum,
+		&v.Path,
+		&v.Created,
+		&v.Replaced,
+	); err != nil {
+		return 0, fmt.Errorf("failed to execute statement: %v", err)
+	}
+	latest, err := scanVersion(q.Conn.QueryRow(FindLatestVersionQuery, v.FileID))
+	if err != nil {
+		return 0, fmt.Errorf("failed to get version number: %v", err)
+	}
+	v.Number = latest.Number
+	return v.Number, nil
+}
+
+// set a drive's version retention policy
+func (q *Query) SetRetention(p *svc.RetentionPolicy) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.WhichDB("retention")
+	q.Connect()
+	defer q.Close()
+
+	if _, err := q.Conn.Exec(
+		SetRetentionQuery,
+		&p.DriveID,
+		&p.KeepLast,
+		&p.Daily,
+		&p.Monthly,
+	); err != nil {
+		return fmt.Errorf("failed to execute statement: %v", err)
+	}
+	return nil
+}
+
+// add an item to the recycle bin manifest
+func (q *Query) AddRecycled(item *svc.RecycledItem) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.WhichDB("recycled")
+	q.Connect()
+	defer q.Close()
+
+	if _, err := q.Conn.Exec(
+		AddRecycledQuery,
+		&item.ID,
+		&item.FileID,
+		&item.Name,
+		&item.OrigPath,
+		&item.Path,
+		&item.Size,
+		&item.CheckSum,
+		&item.Deleted,
+	); err != nil {
+		return fmt.Errorf("failed to execute statement: %v", err)
+	}
+	return nil
+}
+
+// add or update a blob
+func (q *Query) SetBlob(b *svc.Blob) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.WhichDB("blobs")
+	q.Connect()
+	defer q.Close()
+
+	if _, err := q.Conn.Exec(
+		SetBlobQuery,
+		&b.CheckSum,
+		&b.Path,
+		&b.Size,
+		&b.Refs,
+		&b.Created,
+	); err != nil {
+		return fmt.Errorf("failed to execute statement: %v", err)
+	}
+	return nil
+}
+
+// point a logical path at a blob, replacing any blob it pointed to before.
+// the blob's reference count is not updated.
+func (q *Query) SetBlobRef(path string, checksum string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.WhichDB("blobrefs")
+	q.Connect()
+	defer q.Close()
+
+	if _, err := q.Conn.Exec(SetBlobRefQuery, path, checksum); err != nil {
+		return fmt.Errorf("failed to execute statement: %v", err)
+	}
+	return nil
+}
+
+// add or replace a user session
+func (q *Query) SetRefreshToken(r *auth.Refresh) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.WhichDB("tokens")
+	q.Connect()
+	defer q.Close()
+
+	if _, err := q.Conn.Exec(
+		SetRefreshTokenQuery,
+		&r.ID,
+		&r.UserID,
+		&r.Hash,
+		&r.Created,
+		&r.Expires,
+		&r.Revoked,
+	); err != nil {
+		return fmt.Errorf("failed to execute statement: %v", err)
+	}
+	return nil
+}
+
+// add or replace a share. sharing an item with a user
+// again replaces the user's previous share of the item.
+func (q *Query) SetShare(s *svc.Share) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.WhichDB("shares")
+	q.Connect()
+	defer q.Close()
+
+	if _, err := q.Conn.Exec(
+		SetShareQuery,
+		&s.ID,
+		&s.ItemID,
+		&s.ItemType,
+		&s.OwnerID,
+		&s.GranteeID,
+		&s.Permission,
+		&s.Created,
+		&s.Expires,
+	); err != nil {
+		return fmt.Errorf("failed to execute statement: %v", err)
+	}
+	return nil
+}
+
+// add or replace a device
+func (q *Query) SetDevice(d *auth.Device) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.WhichDB("devices")
+	q.Connect()
+	defer q.Close()
+
+	if _, err := q.Conn.Exec(
+		SetDeviceQuery,
+		&d.ID,
+		&d.Name,
+		&d.OwnerID,
+		&d.KeyHash,
+		&d.Created,
+		&d.LastSeen,
+		&d.LastRevision,
+		&d.Revoked,
+	); err != nil {
+		return fmt.Errorf("failed to execute statement: %v", err)
+	}
+	return nil
+}
@@ -5,7 +5,9 @@ import (
 	"log"
 	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/sfs/pkg/auth"
 	"github.com/sfs/pkg/env"
 	svc "github.com/sfs/pkg/service"
 
@@ -176,3 +178,545 @@ func TestAddAndFindUser(t *testing.T) {
 		t.Errorf("[ERROR] unable to remove test directories: %v", err)
 	}
 }
+
+func TestSetAndGetSyncState(t *testing.T) {
+	env.SetEnv(false)
+
+	testDir := GetTestingDir()
+
+	NewTable(filepath.Join(testDir, "sync"), CreateSyncStateTable)
+	q := NewQuery(filepath.Join(testDir, "sync"), false)
+	q.Debug = true
+
+	state := &svc.SyncState{
+		FileID:   "some-file-id",
+		DriveID:  "some-drive-id",
+		CheckSum: "some-checksum",
+	}
+	if err := q.SetSyncState(state); err != nil {
+		Fatal(t, err)
+	}
+
+	// replacing the state should update the existing entry
+	state.CheckSum = "some-other-checksum"
+	if err := q.SetSyncState(state); err != nil {
+		Fatal(t, err)
+	}
+	s, err := q.GetSyncState(state.FileID)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.NotEqual(t, nil, s)
+	assert.Equal(t, "some-other-checksum", s.CheckSum)
+
+	if err := q.RemoveSyncState(state.FileID); err != nil {
+		Fatal(t, err)
+	}
+	s, err = q.GetSyncState(state.FileID)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, nil, s)
+
+	if err := Clean(t, GetTestingDir()); err != nil {
+		t.Errorf("[ERROR] unable to remove test directories: %v", err)
+	}
+}
+
+func TestNextRevision(t *testing.T) {
+	env.SetEnv(false)
+
+	testDir := GetTestingDir()
+
+	NewTable(filepath.Join(testDir, "revisions"), CreateRevisionsTable)
+	q := NewQuery(filepath.Join(testDir, "revisions"), false)
+	q.Debug = true
+
+	f1 := &svc.File{ID: "file-1", DriveID: "drive-1", CheckSum: "cs-1"}
+	f2 := &svc.File{ID: "file-2", DriveID: "drive-1", CheckSum: "cs-2"}
+	other := &svc.File{ID: "file-3", DriveID: "drive-2", CheckSum: "cs-3"}
+
+	// revisions should increase monotonically per drive
+	for i, f := range []*svc.File{f1, f2, f1} {
+		rev, err := q.NextRevision(f)
+		if err != nil {
+			Fatal(t, err)
+		}
+		assert.Equal(t, int64(i+1), rev)
+	}
+	rev, err := q.NextRevision(other)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, int64(1), rev)
+
+	head, err := q.GetDriveRevision("drive-1")
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, int64(3), head)
+
+	// only the latest revision of each file is kept
+	revs, err := q.GetRevisionsAfter("drive-1", 1)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, 2, len(revs))
+	assert.Equal(t, "file-2", revs[0].FileID)
+	assert.Equal(t, "file-1", revs[1].FileID)
+	assert.Equal(t, int64(3), revs[1].Revision)
+
+	r, err := q.GetRevision("file-1")
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, "cs-1", r.CheckSum)
+
+	if err := Clean(t, GetTestingDir()); err != nil {
+		t.Errorf("[ERROR] unable to remove test directories: %v", err)
+	}
+}
+
+func TestTombstones(t *testing.T) {
+	env.SetEnv(false)
+
+	testDir := GetTestingDir()
+
+	NewTable(filepath.Join(testDir, "revisions"), CreateRevisionsTable)
+	q := NewQuery(filepath.Join(testDir, "revisions"), false)
+	q.Debug = true
+
+	f1 := &svc.File{ID: "file-1", DriveID: "drive-1", CheckSum: "cs-1"}
+	f2 := &svc.File{ID: "file-2", DriveID: "drive-1", CheckSum: "cs-2"}
+	for _, f := range []*svc.File{f1, f2} {
+		if _, err := q.NextRevision(f); err != nil {
+			Fatal(t, err)
+		}
+	}
+	rev, err := q.AddTombstone(f1, "laptop")
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, int64(3), rev)
+
+	revs, err := q.GetRevisionsAfter("drive-1", 2)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, 1, len(revs))
+	assert.True(t, revs[0].Deleted)
+	assert.Equal(t, "laptop", revs[0].Device)
+
+	// the tombstone holds the drive's latest revision, so it shouldn't be removed
+	n, err := q.RemoveTombstones(time.Now().UTC().Add(time.Hour))
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, int64(0), n)
+
+	// ...until something newer comes along
+	if _, err := q.NextRevision(f2); err != nil {
+		Fatal(t, err)
+	}
+	n, err = q.RemoveTombstones(time.Now().UTC().Add(time.Hour))
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, int64(1), n)
+	r, err := q.GetRevision(f1.ID)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, nil, r)
+
+	if err := Clean(t, GetTestingDir()); err != nil {
+		t.Errorf("[ERROR] unable to remove test directories: %v", err)
+	}
+}
+
+func TestSetAndGetUpload(t *testing.T) {
+	env.SetEnv(false)
+
+	testDir := GetTestingDir()
+
+	NewTable(filepath.Join(testDir, "uploads"), CreateUploadsTable)
+	q := NewQuery(filepath.Join(testDir, "uploads"), false)
+	q.Debug = true
+
+	file := &svc.File{ID: "some-file-id", DriveID: "some-drive-id", OwnerID: "me"}
+	u := svc.NewUploadSession(file, 100, "some-checksum", filepath.Join(testDir, "some-upload"))
+	if err := q.SetUpload(u); err != nil {
+		Fatal(t, err)
+	}
+
+	// received ranges should be saved with the session
+	u.AddRange(0, 50)
+	if err := q.SetUpload(u); err != nil {
+		Fatal(t, err)
+	}
+	u2, err := q.GetUploadByFileID(file.ID)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.NotEqual(t, nil, u2)
+	assert.Equal(t, u.ID, u2.ID)
+	assert.Equal(t, u.TmpPath, u2.TmpPath)
+	assert.Equal(t, int64(50), u2.Offset())
+
+	stale, err := q.GetUploadsBefore(time.Now().UTC().Add(time.Hour))
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, 1, len(stale))
+
+	if err := q.RemoveUpload(u.ID); err != nil {
+		Fatal(t, err)
+	}
+	u2, err = q.GetUpload(u.ID)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, nil, u2)
+
+	if err := Clean(t, GetTestingDir()); err != nil {
+		t.Errorf("[ERROR] unable to remove test directories: %v", err)
+	}
+}
+
+func TestVersionsAndRetention(t *testing.T) {
+	env.SetEnv(false)
+
+	testDir := GetTestingDir()
+
+	NewTable(filepath.Join(testDir, "versions"), CreateVersionsTable)
+	NewTable(filepath.Join(testDir, "retention"), CreateRetentionTable)
+	q := NewQuery(testDir, true)
+	q.Debug = true
+
+	// versions are numbered per file
+	file := &svc.File{ID: "some-file-id", DriveID: "some-drive-id", OwnerID: "me", Name: "file.txt"}
+	other := &svc.File{ID: "other-file-id", DriveID: "some-drive-id", OwnerID: "me", Name: "other.txt"}
+	for i, f := range []*svc.File{file, file, other, file} {
+		n, err := q.AddVersion(svc.NewVersion(f, filepath.Join(testDir, fmt.Sprint(i))))
+		if err != nil {
+			Fatal(t, err)
+		}
+		if f == other {
+			assert.Equal(t, int64(1), n)
+		}
+	}
+	versions, err := q.GetVersions(file.ID)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, 3, len(versions))
+	assert.Equal(t, int64(3), versions[2].Number)
+	assert.Equal(t, filepath.Join(testDir, "3"), versions[2].Path)
+
+	if err := q.RemoveVersion(file.ID, 2); err != nil {
+		Fatal(t, err)
+	}
+	v, err := q.GetVersion(file.ID, 2)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, nil, v)
+
+	// version numbers aren't reused
+	n, err := q.AddVersion(svc.NewVersion(file, ""))
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, int64(4), n)
+
+	// retention policies
+	p, err := q.GetRetention("some-drive-id")
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, nil, p)
+	if err := q.SetRetention(&svc.RetentionPolicy{DriveID: "some-drive-id", KeepLast: 5}); err != nil {
+		Fatal(t, err)
+	}
+	p, err = q.GetRetention("some-drive-id")
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, 5, p.KeepLast)
+	assert.Equal(t, 0, p.Daily)
+
+	if err := Clean(t, GetTestingDir()); err != nil {
+		t.Errorf("[ERROR] unable to remove test directories: %v", err)
+	}
+}
+
+func TestRecycled(t *testing.T) {
+	env.SetEnv(false)
+
+	testDir := GetTestingDir()
+
+	NewTable(filepath.Join(testDir, "recycled"), CreateRecycledTable)
+	q := NewQuery(testDir, true)
+	q.Debug = true
+
+	// files with the same name get their own entries
+	var items []*svc.RecycledItem
+	for _, path := range []string{"/a/file.txt", "/b/file.txt"} {
+		file := &svc.File{ID: path, Name: "file.txt", ClientPath: path}
+		item := svc.NewRecycledItem(file, testDir)
+		if err := q.AddRecycled(item); err != nil {
+			Fatal(t, err)
+		}
+		items = append(items, item)
+	}
+	assert.NotEqual(t, items[0].Path, items[1].Path)
+
+	all, err := q.GetAllRecycled()
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, 2, len(all))
+
+	item, err := q.GetRecycled(items[1].ID)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, "/b/file.txt", item.OrigPath)
+
+	if err := q.RemoveRecycled(items[1].ID); err != nil {
+		Fatal(t, err)
+	}
+	item, err = q.GetRecycled(items[1].ID)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, nil, item)
+
+	if err := Clean(t, GetTestingDir()); err != nil {
+		t.Errorf("[ERROR] unable to remove test directories: %v", err)
+	}
+}
+
+func TestRefreshTokens(t *testing.T) {
+	env.SetEnv(false)
+
+	testDir := GetTestingDir()
+
+	NewTable(filepath.Join(testDir, "tokens"), CreateRefreshTokensTable)
+	q := NewQuery(filepath.Join(testDir, "tokens"), false)
+	q.Debug = true
+
+	userID := auth.NewUUID()
+	now := time.Now().UTC()
+	r1 := &auth.Refresh{ID: auth.NewUUID(), UserID: userID, Hash: auth.HashToken("one"), Created: now, Expires: now.Add(time.Hour)}
+	r2 := &auth.Refresh{ID: auth.NewUUID(), UserID: userID, Hash: auth.HashToken("two"), Created: now, Expires: now.Add(-time.Hour)}
+	for _, r := range []*auth.Refresh{r1, r2} {
+		if err := q.SetRefreshToken(r); err != nil {
+			Fatal(t, err)
+		}
+	}
+
+	got, err := q.GetRefreshTokenByHash(auth.HashToken("one"))
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.NotEqual(t, nil, got)
+	assert.Equal(t, r1.ID, got.ID)
+	assert.True(t, got.Valid())
+
+	// rotating a token replaces its hash
+	r1.Hash = auth.HashToken("three")
+	if err := q.SetRefreshToken(r1); err != nil {
+		Fatal(t, err)
+	}
+	got, err = q.GetRefreshTokenByHash(auth.HashToken("one"))
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, nil, got)
+
+	if err := q.RevokeRefreshToken(r1.ID); err != nil {
+		Fatal(t, err)
+	}
+	got, err = q.GetRefreshToken(r1.ID)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.True(t, got.Revoked)
+	assert.False(t, got.Valid())
+
+	// revoking all of a user's sessions
+	if err := q.RevokeUserRefreshTokens(userID); err != nil {
+		Fatal(t, err)
+	}
+	active, err := q.GetUsersRefreshTokens(userID)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, 0, len(active))
+
+	// r2 expired, r1 was revoked
+	n, err := q.RemoveExpiredRefreshTokens(time.Now().UTC().Add(time.Minute))
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, int64(2), n)
+
+	if err := Clean(t, GetTestingDir()); err != nil {
+		t.Errorf("[ERROR] unable to remove test directories: %v", err)
+	}
+}
+
+func TestDevices(t *testing.T) {
+	env.SetEnv(false)
+
+	testDir := GetTestingDir()
+
+	NewTable(filepath.Join(testDir, "devices"), CreateDevicesTable)
+	q := NewQuery(filepath.Join(testDir, "devices"), false)
+	q.Debug = true
+
+	userID := auth.NewUUID()
+	laptop, err := auth.NewDevice("laptop", userID)
+	if err != nil {
+		Fatal(t, err)
+	}
+	desktop, err := auth.NewDevice("desktop", userID)
+	if err != nil {
+		Fatal(t, err)
+	}
+	other, err := auth.NewDevice("laptop", auth.NewUUID())
+	if err != nil {
+		Fatal(t, err)
+	}
+	for _, d := range []*auth.Device{laptop, desktop, other} {
+		if err := q.SetDevice(d); err != nil {
+			Fatal(t, err)
+		}
+	}
+
+	// devices are found by the hash of their key. the key itself isn't saved.
+	d, err := q.GetDeviceByKeyHash(auth.HashToken(laptop.Key))
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.NotEqual(t, nil, d)
+	assert.Equal(t, laptop.ID, d.ID)
+	assert.Equal(t, "", d.Key)
+
+	devices, err := q.GetUsersDevices(userID)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, 2, len(devices))
+
+	all, err := q.GetAllDevices()
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, 3, len(all))
+
+	d.Revoked = true
+	d.LastRevision = 5
+	if err := q.SetDevice(d); err != nil {
+		Fatal(t, err)
+	}
+	d, err = q.GetDevice(laptop.ID)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.True(t, d.Revoked)
+	assert.Equal(t, int64(5), d.LastRevision)
+
+	d, err = q.GetDevice("not-a-device")
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, nil, d)
+
+	if err := Clean(t, GetTestingDir()); err != nil {
+		t.Errorf("[ERROR] unable to remove test directories: %v", err)
+	}
+}
+
+func TestShares(t *testing.T) {
+	env.SetEnv(false)
+
+	testDir := GetTestingDir()
+
+	NewTable(filepath.Join(testDir, "shares"), CreateSharesTable)
+	q := NewQuery(filepath.Join(testDir, "shares"), false)
+	q.Debug = true
+
+	ownerID, granteeID := auth.NewUUID(), auth.NewUUID()
+	dirShare, err := svc.NewShare(auth.NewUUID(), svc.ShareDir, ownerID, granteeID, svc.PermReadWrite, time.Now().Add(time.Hour))
+	if err != nil {
+		Fatal(t, err)
+	}
+	fileShare, err := svc.NewShare(auth.NewUUID(), svc.ShareFile, ownerID, granteeID, svc.PermRead, time.Time{})
+	if err != nil {
+		Fatal(t, err)
+	}
+	for _, s := range []*svc.Share{dirShare, fileShare} {
+		if err := q.SetShare(s); err != nil {
+			Fatal(t, err)
+		}
+	}
+
+	s, err := q.GetShare(fileShare.ID)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.NotEqual(t, nil, s)
+	assert.Equal(t, svc.PermRead, s.Permission)
+	assert.False(t, s.Expired())
+
+	owned, err := q.GetSharesByOwner(ownerID)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, 2, len(owned))
+	shared, err := q.GetSharesWithUser(granteeID)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, 2, len(shared))
+
+	// sharing an item with the same user again replaces the old share
+	again, err := svc.NewShare(dirShare.ItemID, svc.ShareDir, ownerID, granteeID, svc.PermRead, time.Time{})
+	if err != nil {
+		Fatal(t, err)
+	}
+	if err := q.SetShare(again); err != nil {
+		Fatal(t, err)
+	}
+	items, err := q.GetItemShares(dirShare.ItemID)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, 1, len(items))
+	assert.Equal(t, again.ID, items[0].ID)
+	assert.Equal(t, svc.PermRead, items[0].Permission)
+
+	if err := q.RemoveShare(fileShare.ID); err != nil {
+		Fatal(t, err)
+	}
+	s, err = q.GetShare(fileShare.ID)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, nil, s)
+
+	if err := q.RemoveUsersShares(granteeID); err != nil {
+		Fatal(t, err)
+	}
+	shared, err = q.GetSharesWithUser(granteeID)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, 0, len(shared))
+
+	if err := Clean(t, GetTestingDir()); err != nil {
+		t.Errorf("[ERROR] unable to remove test directories: %v", err)
+	}
+}
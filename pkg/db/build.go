@@ -20,6 +20,28 @@ func NewDB(dbName string, pathToNewDB string) error {
 		NewTable(pathToNewDB, CreateDirectoryTable)
 	case "files":
 		NewTable(pathToNewDB, CreateFileTable)
+	case "sync":
+		NewTable(pathToNewDB, CreateSyncStateTable)
+	case "revisions":
+		NewTable(pathToNewDB, CreateRevisionsTable)
+	case "uploads":
+		NewTable(pathToNewDB, CreateUploadsTable)
+	case "versions":
+		NewTable(pathToNewDB, CreateVersionsTable)
+	case "retention":
+		NewTable(pathToNewDB, CreateRetentionTable)
+	case "recycled":
+		NewTable(pathToNewDB, CreateRecycledTable)
+	case "blobs":
+		NewTable(pathToNewDB, CreateBlobsTable)
+	case "blobrefs":
+		NewTable(pathToNewDB, CreateBlobRefsTable)
+	case "tokens":
+		NewTable(pathToNewDB, CreateRefreshTokensTable)
+	case "devices":
+		NewTable(pathToNewDB, CreateDevicesTable)
+	case "shares":
+		NewTable(pathToNewDB, CreateSharesTable)
 	default:
 		return fmt.Errorf("unsupported database: %v", dbName)
 	}
@@ -40,6 +62,12 @@ func NewTable(path string, query string) {
 	}
 }
 
+// databases used by the server and client services
+var (
+	serverDBs = []string{"files", "directories", "users", "drives", "revisions", "uploads", "versions", "retention", "blobs", "blobrefs", "tokens", "devices", "shares"}
+	clientDBs = []string{"users", "files", "drives", "directories", "sync", "recycled"}
+)
+
 // initialize server databases
 func InitServerDBs(dbPath string) error {
 	// make sure there's no databases where we want to create in
@@ -51,8 +79,7 @@ func InitServerDBs(dbPath string) error {
 		return fmt.Errorf("service database directory not empty! %v", entries)
 	}
 
-	dbs := []string{"files", "directories", "users", "drives"}
-	for _, dbName := range dbs {
+	for _, dbName := range serverDBs {
 		if err := NewDB(dbName, filepath.Join(dbPath, dbName)); err != nil {
 			return err
 		}
@@ -70,11 +97,104 @@ func InitClientDBs(dbPath string) error {
 		return fmt.Errorf("service database directory not empty! %v", entries)
 	}
 
-	dbs := []string{"users", "files", "drives", "directories"}
-	for _, dbName := range dbs {
+	for _, dbName := range clientDBs {
 		if err := NewDB(dbName, filepath.Join(dbPath, dbName)); err != nil {
 			return err
 		}
 	}
 	return nil
 }
+
+// create any databases that are missing from an existing service's
+// database directory. used when loading a service that was set up
+// before newer databases were added.
+func updateDBs(dbPath string, dbs []string) error {
+	for _, dbName := range dbs {
+		path := filepath.Join(dbPath, dbName)
+		if _, err := os.Stat(path); err == nil {
+			continue
+		} else if !os.IsNotExist(err) {
+			return fmt.Errorf("failed to get stats for %s database: %v", dbName, err)
+		}
+		if err := NewDB(dbName, path); err != nil {
+			return err
+		}
+	}
+	return migrateDBs(dbPath, dbs)
+}
+
+// update the tables of existing databases created by
+// older versions. new tables are already up to date.
+func migrateDBs(dbPath string, dbs []string) error {
+	for _, dbName := range dbs {
+		for _, m := range migrations[dbName] {
+			if err := addColumn(filepath.Join(dbPath, dbName), m.table, m.column, m.query); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// columns added to each database's table after it was first created
+var migrations = map[string][]struct {
+	table  string
+	column string
+	query  string
+}{
+	"users": {
+		{"Users", "role", AddUserRoleColumnQuery},
+	},
+	"sync": {
+		{"SyncState", "revision", AddSyncStateRevisionColumnQuery},
+	},
+	"revisions": {
+		{"Revisions", "deleted", AddRevisionDeletedColumnQuery},
+		{"Revisions", "device", AddRevisionDeviceColumnQuery},
+	},
+}
+
+// add a column to a table with the given query, if the table doesn't have it already
+func addColumn(path string, table string, column string, query string) error {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return fmt.Errorf("unable to open database: %v", err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s);", table))
+	if err != nil {
+		return fmt.Errorf("failed to get columns for %s: %v", table, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			cid     int
+			name    string
+			colType string
+			notNull int
+			dflt    sql.NullString
+			pk      int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dflt, &pk); err != nil {
+			return fmt.Errorf("failed to scan columns for %s: %v", table, err)
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to get columns for %s: %v", table, err)
+	}
+	rows.Close()
+	if _, err := db.Exec(query); err != nil {
+		return fmt.Errorf("failed to add %s column to %s: %v", column, table, err)
+	}
+	return nil
+}
+
+// create any server databases that are missing
+func UpdateServerDBs(dbPath string) error { return updateDBs(dbPath, serverDBs) }
+
+// create any client databases that are missing
+func UpdateClientDBs(dbPath string) error { return updateDBs(dbPath, clientDBs) }
@@ -1,11 +1,17 @@
 package db
 
 import (
+	"database/sql"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/sfs/pkg/auth"
+	"github.com/sfs/pkg/env"
+	svc "github.com/sfs/pkg/service"
 
 	"github.com/alecthomas/assert/v2"
 )
@@ -43,3 +49,154 @@ func TestBuildDbs(t *testing.T) {
 		log.Fatal(err)
 	}
 }
+
+func TestMigrateUsersTable(t *testing.T) {
+	env.SetEnv(false)
+
+	testDir := GetTestingDir()
+
+	// users table from before roles were added
+	NewTable(filepath.Join(testDir, "users"), `
+		CREATE TABLE IF NOT EXISTS Users (
+			id VARCHAR(50) PRIMARY KEY,
+			name VARCHAR(255),
+			username VARCHAR(50),
+			email VARCHAR(255),
+			password VARCHAR(100),
+			last_login DATETIME,
+			is_admin BIT,
+			sf_path VARCHAR(255),
+			drive_id VARCHAR(255),
+			total_files INT,
+			total_directories INT,
+			root VARCHAR(255),
+			UNIQUE(id)
+		);`)
+	db, err := sql.Open("sqlite3", filepath.Join(testDir, "users"))
+	if err != nil {
+		Fatal(t, err)
+	}
+	userID := auth.NewUUID()
+	if _, err := db.Exec(`INSERT INTO Users VALUES (?, 'bill', 'billBB', 'bill@bill.com', '', ?, 1, '', '', 0, 0, '');`, userID, time.Now().UTC()); err != nil {
+		Fatal(t, err)
+	}
+	db.Close()
+
+	// migrating twice shouldn't fail
+	for i := 0; i < 2; i++ {
+		if err := updateDBs(testDir, []string{"users"}); err != nil {
+			Fatal(t, err)
+		}
+	}
+
+	q := NewQuery(filepath.Join(testDir, "users"), false)
+	u, err := q.GetUser(userID)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.NotEqual(t, nil, u)
+	assert.Equal(t, auth.Role(""), u.Role)
+	assert.Equal(t, auth.RoleAdmin, u.GetRole())
+
+	if err := u.SetRole(auth.RoleReadOnly); err != nil {
+		Fatal(t, err)
+	}
+	if err := q.UpdateUser(u); err != nil {
+		Fatal(t, err)
+	}
+	u, err = q.GetUser(userID)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, auth.RoleReadOnly, u.GetRole())
+	assert.False(t, u.Admin)
+
+	if err := Clean(t, testDir); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func TestMigrateSyncTables(t *testing.T) {
+	env.SetEnv(false)
+
+	testDir := GetTestingDir()
+
+	// sync state and revision tables from before revisions and tombstones were added
+	NewTable(filepath.Join(testDir, "sync"), `
+		CREATE TABLE IF NOT EXISTS SyncState (
+			file_id VARCHAR(50) PRIMARY KEY,
+			drive_id VARCHAR(50),
+			checksum VARCHAR(255),
+			last_sync DATETIME,
+			UNIQUE(file_id)
+		);`)
+	NewTable(filepath.Join(testDir, "revisions"), `
+		CREATE TABLE IF NOT EXISTS Revisions (
+			file_id VARCHAR(50) PRIMARY KEY,
+			drive_id VARCHAR(50),
+			revision INTEGER,
+			checksum VARCHAR(255),
+			last_sync DATETIME,
+			UNIQUE(file_id)
+		);`)
+	fileID, driveID := auth.NewUUID(), auth.NewUUID()
+	for dbName, query := range map[string]string{
+		"sync":      `INSERT INTO SyncState VALUES (?, ?, 'abc', ?);`,
+		"revisions": `INSERT INTO Revisions VALUES (?, ?, 1, 'abc', ?);`,
+	} {
+		db, err := sql.Open("sqlite3", filepath.Join(testDir, dbName))
+		if err != nil {
+			Fatal(t, err)
+		}
+		if _, err := db.Exec(query, fileID, driveID, time.Now().UTC()); err != nil {
+			Fatal(t, err)
+		}
+		db.Close()
+	}
+
+	// migrating twice shouldn't fail
+	for i := 0; i < 2; i++ {
+		if err := updateDBs(testDir, []string{"sync", "revisions"}); err != nil {
+			Fatal(t, err)
+		}
+	}
+
+	q := NewQuery(testDir, true)
+	state, err := q.GetSyncState(fileID)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.NotEqual(t, nil, state)
+	assert.Equal(t, "abc", state.CheckSum)
+	assert.Equal(t, int64(0), state.Revision)
+
+	state.Revision = 5
+	if err := q.SetSyncState(state); err != nil {
+		Fatal(t, err)
+	}
+	state, err = q.GetSyncState(fileID)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, "abc", state.CheckSum)
+	assert.Equal(t, int64(5), state.Revision)
+
+	rev, err := q.GetRevision(fileID)
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.NotEqual(t, nil, rev)
+	assert.Equal(t, int64(1), rev.Revision)
+	assert.False(t, rev.Deleted)
+	assert.Equal(t, "", rev.Device)
+
+	next, err := q.NextRevision(&svc.File{ID: auth.NewUUID(), DriveID: driveID, CheckSum: "def"})
+	if err != nil {
+		Fatal(t, err)
+	}
+	assert.Equal(t, int64(2), next)
+
+	if err := Clean(t, testDir); err != nil {
+		log.Fatal(err)
+	}
+}
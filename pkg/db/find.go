@@ -2,6 +2,7 @@ package db
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"path/filepath"
 	"time"
@@ -58,6 +59,7 @@ func (q *Query) GetUser(userID string) (*auth.User, error) {
 		&user.TotalFiles,
 		&user.TotalDirs,
 		&user.DrvRoot,
+		&user.Role,
 	); err != nil {
 		if err == sql.ErrNoRows {
 			q.log.Log("INFO", fmt.Sprintf("no rows returned: %v", err))
@@ -68,6 +70,39 @@ func (q *Query) GetUser(userID string) (*auth.User, error) {
 	return user, nil
 }
 
+// get a user by their user name. returns nil if user is not found.
+func (q *Query) GetUserByUserName(userName string) (*auth.User, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.WhichDB("users")
+	q.Connect()
+	defer q.Close()
+
+	user := new(auth.User)
+	if err := q.Conn.QueryRow(FindUserByUserNameQuery, userName).Scan(
+		&user.ID,
+		&user.Name,
+		&user.UserName,
+		&user.Email,
+		&user.Password,
+		&user.LastLogin,
+		&user.Admin,
+		&user.SfPath,
+		&user.DriveID,
+		&user.TotalFiles,
+		&user.TotalDirs,
+		&user.DrvRoot,
+		&user.Role,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("[ERROR] unable to execute query: %v", err)
+	}
+	return user, nil
+}
+
 // get a userID from a driveID.
 // will return an empty string if no userID is found with this driveID
 func (q *Query) GetUserIDFromDriveID(driveID string) (string, error) {
@@ -125,6 +160,7 @@ func (q *Query) GetUsers() ([]*auth.User, error) {
 			&user.TotalFiles,
 			&user.TotalDirs,
 			&user.DrvRoot,
+			&user.Role,
 		); err != nil {
 			if err == sql.ErrNoRows {
 				q.log.Log(logger.INFO, "users found in database")
@@ -225,6 +261,47 @@ func (q *Query) GetFileByPath(filePath string) (*svc.File, error) {
 	return file, nil
 }
 
+// find a file in the database by its server-side path.
+// returns nil if no file is found.
+func (q *Query) GetFileByServerPath(serverPath string) (*svc.File, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.WhichDB("files")
+	q.Connect()
+	defer q.Close()
+
+	file := new(svc.File)
+	if err := q.Conn.QueryRow(FindFileByServerPathQuery, serverPath).Scan(
+		&file.ID,
+		&file.Name,
+		&file.OwnerID,
+		&file.DirID,
+		&file.DriveID,
+		&file.Mode,
+		&file.Size,
+		&file.LocalBackup,
+		&file.ServerBackup,
+		&file.Protected,
+		&file.Key,
+		&file.LastSync,
+		&file.Path,
+		&file.ServerPath,
+		&file.ClientPath,
+		&file.BackupPath,
+		&file.Registered,
+		&file.Endpoint,
+		&file.CheckSum,
+		&file.Algorithm,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get file metadata: %v", err)
+	}
+	return file, nil
+}
+
 // get a file by name. returns nil if no file is found in the db.
 func (q *Query) GetFileByName(fileName string) (*svc.File, error) {
 	q.mu.Lock()
@@ -1230,3 +1307,718 @@ func (q *Query) GetDriveIDFromUserID(userID string) (string, error) {
 	}
 	return id, nil
 }
+
+// ----- sync state ----------------------------------
+
+// get the last synced state of a file.
+// returns nil if the file has never been synced.
+func (q *Query) GetSyncState(fileID string) (*svc.SyncState, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.WhichDB("sync")
+	q.Connect()
+	defer q.Close()
+
+	state := new(svc.SyncState)
+	if err := q.Conn.QueryRow(FindSyncStateQuery, fileID).Scan(
+		&state.FileID,
+		&state.DriveID,
+		&state.CheckSum,
+		&state.Revision,
+		&state.LastSync,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get sync state: %v", err)
+	}
+	return state, nil
+}
+
+// ----- revisions ----------------------------------
+
+// get the latest revision of a file.
+// returns nil if the file has no revisions.
+func (q *Query) GetRevision(fileID string) (*svc.Revision, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.WhichDB("revisions")
+	q.Connect()
+	defer q.Close()
+
+	rev := new(svc.Revision)
+	if err := q.Conn.QueryRow(FindRevisionQuery, fileID).Scan(
+		&rev.FileID,
+		&rev.DriveID,
+		&rev.Revision,
+		&rev.CheckSum,
+		&rev.LastSync,
+		&rev.Deleted,
+		&rev.Device,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get revision: %v", err)
+	}
+	return rev, nil
+}
+
+// get the latest revision of a drive. returns 0 if
+// no changes have been recorded for the drive.
+func (q *Query) GetDriveRevision(driveID string) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.WhichDB("revisions")
+	q.Connect()
+	defer q.Close()
+
+	var rev int64
+	if err := q.Conn.QueryRow(FindDriveRevisionQuery, driveID).Scan(&rev); err != nil {
+		return 0, fmt.Errorf("failed to get drive revision: %v", err)
+	}
+	return rev, nil
+}
+
+// get all file revisions for a drive that came after the given revision,
+// in the order they were assigned.
+func (q *Query) GetRevisionsAfter(driveID string, since int64) ([]*svc.Revision, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.WhichDB("revisions")
+	q.Connect()
+	defer q.Close()
+
+	rows, err := q.Conn.Query(FindRevisionsAfterQuery, driveID, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query revisions: %v", err)
+	}
+	defer rows.Close()
+
+	revs := make([]*svc.Revision, 0)
+	for rows.Next() {
+		rev := new(svc.Revision)
+		if err := rows.Scan(
+			&rev.FileID,
+			&rev.DriveID,
+			&rev.Revision,
+			&rev.CheckSum,
+			&rev.LastSync,
+			&rev.Deleted,
+			&rev.Device,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		revs = append(revs, rev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate rows: %v", err)
+	}
+	return revs, nil
+}
+
+// ----- upload sessions ----------------------------------
+
+// scan an upload session from a row. expects all columns of the Uploads table.
+func scanUpload(row interface{ Scan(...any) error }) (*svc.UploadSession, error) {
+	u := new(svc.UploadSession)
+	var received string
+	if err := row.Scan(
+		&u.ID,
+		&u.FileID,
+		&u.DriveID,
+		&u.OwnerID,
+		&u.Size,
+		&u.CheckSum,
+		&u.TmpPath,
+		&received,
+		&u.Created,
+		&u.Updated,
+	); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(received), &u.Received); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal received ranges: %v", err)
+	}
+	return u, nil
+}
+
+func (q *Query) getUpload(query string, arg string) (*svc.UploadSession, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.WhichDB("uploads")
+	q.Connect()
+	defer q.Close()
+
+	u, err := scanUpload(q.Conn.QueryRow(query, arg))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get upload session: %v", err)
+	}
+	return u, nil
+}
+
+// get an upload session. returns nil if the session isn't found.
+func (q *Query) GetUpload(uploadID string) (*svc.UploadSession, error) {
+	return q.getUpload(FindUploadQuery, uploadID)
+}
+
+// get the most recent upload session for a file.
+// returns nil if there isn't one.
+func (q *Query) GetUploadByFileID(fileID string) (*svc.UploadSession, error) {
+	return q.getUpload(FindUploadByFileIDQuery, fileID)
+}
+
+// get all upload sessions that haven't been updated since the given time.
+func (q *Query) GetUploadsBefore(before time.Time) ([]*svc.UploadSession, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.WhichDB("uploads")
+	q.Connect()
+	defer q.Close()
+
+	rows, err := q.Conn.Query(FindUploadsBeforeQuery, before)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query upload sessions: %v", err)
+	}
+	defer rows.Close()
+
+	uploads := make([]*svc.UploadSession, 0)
+	for rows.Next() {
+		u, err := scanUpload(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		uploads = append(uploads, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate rows: %v", err)
+	}
+	return uploads, nil
+}
+
+// ----- user sessions ----------------------------------
+
+// scan a user session from a row. expects all columns of the RefreshTokens table.
+func scanRefreshToken(row interface{ Scan(...any) error }) (*auth.Refresh, error) {
+	r := new(auth.Refresh)
+	if err := row.Scan(
+		&r.ID,
+		&r.UserID,
+		&r.Hash,
+		&r.Created,
+		&r.Expires,
+		&r.Revoked,
+	); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (q *Query) getRefreshToken(query string, arg string) (*auth.Refresh, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.WhichDB("tokens")
+	q.Connect()
+	defer q.Close()
+
+	r, err := scanRefreshToken(q.Conn.QueryRow(query, arg))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get session: %v", err)
+	}
+	return r, nil
+}
+
+// get a user session by its ID. returns nil if the session isn't found.
+func (q *Query) GetRefreshToken(id string) (*auth.Refresh, error) {
+	return q.getRefreshToken(FindRefreshTokenQuery, id)
+}
+
+// get a user session using the hash of its current refresh token.
+// returns nil if the session isn't found.
+func (q *Query) GetRefreshTokenByHash(hash string) (*auth.Refresh, error) {
+	return q.getRefreshToken(FindRefreshTokenByHashQuery, hash)
+}
+
+// get all of a user's sessions that haven't been revoked
+func (q *Query) GetUsersRefreshTokens(userID string) ([]*auth.Refresh, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.WhichDB("tokens")
+	q.Connect()
+	defer q.Close()
+
+	rows, err := q.Conn.Query(FindUsersRefreshTokensQuery, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sessions: %v", err)
+	}
+	defer rows.Close()
+
+	tokens := make([]*auth.Refresh, 0)
+	for rows.Next() {
+		r, err := scanRefreshToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		tokens = append(tokens, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate rows: %v", err)
+	}
+	return tokens, nil
+}
+
+// ----- devices ----------------------------------
+
+// scan a device from a row. expects all columns of the Devices table.
+func scanDevice(row interface{ Scan(...any) error }) (*auth.Device, error) {
+	d := new(auth.Device)
+	if err := row.Scan(
+		&d.ID,
+		&d.Name,
+		&d.OwnerID,
+		&d.KeyHash,
+		&d.Created,
+		&d.LastSeen,
+		&d.LastRevision,
+		&d.Revoked,
+	); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (q *Query) getDevice(query string, arg string) (*auth.Device, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.WhichDB("devices")
+	q.Connect()
+	defer q.Close()
+
+	d, err := scanDevice(q.Conn.QueryRow(query, arg))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get device: %v", err)
+	}
+	return d, nil
+}
+
+func (q *Query) getDevices(query string, args ...any) ([]*auth.Device, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.WhichDB("devices")
+	q.Connect()
+	defer q.Close()
+
+	rows, err := q.Conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query devices: %v", err)
+	}
+	defer rows.Close()
+
+	devices := make([]*auth.Device, 0)
+	for rows.Next() {
+		d, err := scanDevice(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		devices = append(devices, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate rows: %v", err)
+	}
+	return devices, nil
+}
+
+// get a device by its ID. returns nil if the device isn't found.
+func (q *Query) GetDevice(id string) (*auth.Device, error) {
+	return q.getDevice(FindDeviceQuery, id)
+}
+
+// get a device using the hash of its API key. returns nil if the device isn't found.
+func (q *Query) GetDeviceByKeyHash(hash string) (*auth.Device, error) {
+	return q.getDevice(FindDeviceByKeyHashQuery, hash)
+}
+
+// get all devices registered by a user, including revoked ones
+func (q *Query) GetUsersDevices(userID string) ([]*auth.Device, error) {
+	return q.getDevices(FindUsersDevicesQuery, userID)
+}
+
+// get all registered devices
+func (q *Query) GetAllDevices() ([]*auth.Device, error) {
+	return q.getDevices(FindAllDevicesQuery)
+}
+
+// ----- shares ----------------------------------
+
+// scan a share from a row. expects all columns of the Shares table.
+func scanShare(row interface{ Scan(...any) error }) (*svc.Share, error) {
+	s := new(svc.Share)
+	if err := row.Scan(
+		&s.ID,
+		&s.ItemID,
+		&s.ItemType,
+		&s.OwnerID,
+		&s.GranteeID,
+		&s.Permission,
+		&s.Created,
+		&s.Expires,
+	); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (q *Query) getShares(query string, args ...any) ([]*svc.Share, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.WhichDB("shares")
+	q.Connect()
+	defer q.Close()
+
+	rows, err := q.Conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query shares: %v", err)
+	}
+	defer rows.Close()
+
+	shares := make([]*svc.Share, 0)
+	for rows.Next() {
+		s, err := scanShare(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		shares = append(shares, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate rows: %v", err)
+	}
+	return shares, nil
+}
+
+// get a share by its ID. returns nil if the share isn't found.
+func (q *Query) GetShare(id string) (*svc.Share, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.WhichDB("shares")
+	q.Connect()
+	defer q.Close()
+
+	s, err := scanShare(q.Conn.QueryRow(FindShareQuery, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get share: %v", err)
+	}
+	return s, nil
+}
+
+// get all the shares a user has created, including expired ones
+func (q *Query) GetSharesByOwner(ownerID string) ([]*svc.Share, error) {
+	return q.getShares(FindSharesByOwnerQuery, ownerID)
+}
+
+// get all the shares given to a user, including expired ones
+func (q *Query) GetSharesWithUser(granteeID string) ([]*svc.Share, error) {
+	return q.getShares(FindSharesWithUserQuery, granteeID)
+}
+
+// get all the shares of a file or directory
+func (q *Query) GetItemShares(itemID string) ([]*svc.Share, error) {
+	return q.getShares(FindItemSharesQuery, itemID)
+}
+
+// ----- file versions ----------------------------------
+
+// scan a version from a row. expects all columns of the Versions table.
+func scanVersion(row interface{ Scan(...any) error }) (*svc.Version, error) {
+	v := new(svc.Version)
+	if err := row.Scan(
+		&v.FileID,
+		&v.DriveID,
+		&v.OwnerID,
+		&v.DirID,
+		&v.Name,
+		&v.Number,
+		&v.Size,
+		&v.CheckSum,
+		&v.Path,
+		&v.Created,
+		&v.Replaced,
+	); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// get a specific version of a file. returns nil if the version isn't found.
+func (q *Query) GetVersion(fileID string, version int64) (*svc.Version, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.WhichDB("versions")
+	q.Connect()
+	defer q.Close()
+
+	v, err := scanVersion(q.Conn.QueryRow(FindVersionQuery, fileID, version))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get version: %v", err)
+	}
+	return v, nil
+}
+
+// get the version stored at a server-side path. returns nil if there isn't one.
+func (q *Query) GetVersionByPath(path string) (*svc.Version, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.WhichDB("versions")
+	q.Connect()
+	defer q.Close()
+
+	v, err := scanVersion(q.Conn.QueryRow(FindVersionByPathQuery, path))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get version: %v", err)
+	}
+	return v, nil
+}
+
+// get all versions of a file, oldest first.
+func (q *Query) GetVersions(fileID string) ([]*svc.Version, error) {
+	return q.getVersions(FindVersionsQuery, fileID)
+}
+
+// get all versions of every file in a drive, grouped by file and oldest first.
+func (q *Query) GetDriveVersions(driveID string) ([]*svc.Version, error) {
+	return q.getVersions(FindDriveVersionsQuery, driveID)
+}
+
+func (q *Query) getVersions(query string, id string) ([]*svc.Version, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.WhichDB("versions")
+	q.Connect()
+	defer q.Close()
+
+	rows, err := q.Conn.Query(query, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query versions: %v", err)
+	}
+	defer rows.Close()
+
+	versions := make([]*svc.Version, 0)
+	for rows.Next() {
+		v, err := scanVersion(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		versions = append(versions, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate rows: %v", err)
+	}
+	return versions, nil
+}
+
+// get a drive's version retention policy. returns nil if the drive hasn't set one.
+func (q *Query) GetRetention(driveID string) (*svc.RetentionPolicy, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.WhichDB("retention")
+	q.Connect()
+	defer q.Close()
+
+	p := new(svc.RetentionPolicy)
+	if err := q.Conn.QueryRow(FindRetentionQuery, driveID).Scan(
+		&p.DriveID,
+		&p.KeepLast,
+		&p.Daily,
+		&p.Monthly,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get retention policy: %v", err)
+	}
+	return p, nil
+}
+
+// ----- recycle bin --------------------------------
+
+func scanRecycled(row interface{ Scan(...any) error }) (*svc.RecycledItem, error) {
+	item := new(svc.RecycledItem)
+	if err := row.Scan(
+		&item.ID,
+		&item.FileID,
+		&item.Name,
+		&item.OrigPath,
+		&item.Path,
+		&item.Size,
+		&item.CheckSum,
+		&item.Deleted,
+	); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// get an item from the recycle bin manifest. returns nil if it isn't found.
+func (q *Query) GetRecycled(id string) (*svc.RecycledItem, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.WhichDB("recycled")
+	q.Connect()
+	defer q.Close()
+
+	item, err := scanRecycled(q.Conn.QueryRow(FindRecycledQuery, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get recycled item: %v", err)
+	}
+	return item, nil
+}
+
+// get every item in the recycle bin manifest, most recently deleted first.
+func (q *Query) GetAllRecycled() ([]*svc.RecycledItem, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.WhichDB("recycled")
+	q.Connect()
+	defer q.Close()
+
+	rows, err := q.Conn.Query(FindAllRecycledQuery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query recycled items: %v", err)
+	}
+	defer rows.Close()
+
+	items := make([]*svc.RecycledItem, 0)
+	for rows.Next() {
+		item, err := scanRecycled(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate rows: %v", err)
+	}
+	return items, nil
+}
+
+// ----- blobs --------------------------------
+
+func scanBlob(row interface{ Scan(...any) error }) (*svc.Blob, error) {
+	b := new(svc.Blob)
+	if err := row.Scan(
+		&b.CheckSum,
+		&b.Path,
+		&b.Size,
+		&b.Refs,
+		&b.Created,
+	); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// get a blob by its checksum. returns nil if it isn't found.
+func (q *Query) GetBlob(checksum string) (*svc.Blob, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.WhichDB("blobs")
+	q.Connect()
+	defer q.Close()
+
+	b, err := scanBlob(q.Conn.QueryRow(FindBlobQuery, checksum))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get blob: %v", err)
+	}
+	return b, nil
+}
+
+// get all blobs that nothing points to anymore
+func (q *Query) GetUnreferencedBlobs() ([]*svc.Blob, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.WhichDB("blobs")
+	q.Connect()
+	defer q.Close()
+
+	rows, err := q.Conn.Query(FindUnreferencedBlobsQuery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query blobs: %v", err)
+	}
+	defer rows.Close()
+
+	blobs := make([]*svc.Blob, 0)
+	for rows.Next() {
+		b, err := scanBlob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		blobs = append(blobs, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate rows: %v", err)
+	}
+	return blobs, nil
+}
+
+// get the checksum of the blob a logical path points to.
+// returns an empty string if the path doesn't point to one.
+func (q *Query) GetBlobRef(path string) (string, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.WhichDB("blobrefs")
+	q.Connect()
+	defer q.Close()
+
+	var checksum string
+	if err := q.Conn.QueryRow(FindBlobRefQuery, path).Scan(&checksum); err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to get blob reference: %v", err)
+	}
+	return checksum, nil
+}
@@ -316,3 +316,42 @@ func TestFindUsersIdWithDriveID(t *testing.T) {
 	}
 
 }
+
+func TestFindUserByUserName(t *testing.T) {
+	env.SetEnv(false)
+
+	testDir := GetTestingDir()
+
+	// make testing objects
+	_, _, tmpUser := MakeTestItems(t, testDir)
+	NewTable(filepath.Join(testDir, "tmp-db"), CreateUserTable)
+
+	// test query
+	q := NewQuery(filepath.Join(testDir, "tmp-db"), false)
+
+	if err := q.AddUser(tmpUser); err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+
+	u, err := q.GetUserByUserName(tmpUser.UserName)
+	if err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+	if u == nil || u.ID != tmpUser.ID {
+		Fail(t, GetTestingDir(), fmt.Errorf("user not found"))
+	}
+
+	// unknown user names aren't an error
+	u, err = q.GetUserByUserName("not-a-user")
+	if err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+	if u != nil {
+		Fail(t, GetTestingDir(), fmt.Errorf("found a user that doesn't exist"))
+	}
+
+	// clean up tmp db
+	if err := Clean(t, GetTestingDir()); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -89,6 +89,157 @@ const (
 			total_files INT,
 			total_directories INT,
 			root VARCHAR(255),
+			role VARCHAR(20) DEFAULT '',
+			UNIQUE(id)
+		);`
+
+	// last synced state of each file. used by the client to detect
+	// when both the client and server have modified a file since the last sync.
+	CreateSyncStateTable string = `
+		CREATE TABLE IF NOT EXISTS SyncState (
+			file_id VARCHAR(50) PRIMARY KEY,
+			drive_id VARCHAR(50),
+			checksum VARCHAR(255),
+			revision INTEGER,
+			last_sync DATETIME,
+			UNIQUE(file_id)
+		);`
+
+	// server-assigned revision of the latest change to each file.
+	// revisions are monotonically increasing per drive, so clients
+	// can ask for everything that's changed after a given revision.
+	// deleted files are kept as tombstones until they're garbage collected.
+	CreateRevisionsTable string = `
+		CREATE TABLE IF NOT EXISTS Revisions (
+			file_id VARCHAR(50) PRIMARY KEY,
+			drive_id VARCHAR(50),
+			revision INTEGER,
+			checksum VARCHAR(255),
+			last_sync DATETIME,
+			deleted BIT,
+			device VARCHAR(255),
+			UNIQUE(file_id)
+		);`
+
+	// chunked upload sessions. received is a JSON list of the
+	// byte ranges the server has received so far.
+	CreateUploadsTable string = `
+		CREATE TABLE IF NOT EXISTS Uploads (
+			id VARCHAR(50) PRIMARY KEY,
+			file_id VARCHAR(50),
+			drive_id VARCHAR(50),
+			owner_id VARCHAR(50),
+			size INTEGER,
+			checksum VARCHAR(255),
+			tmp_path VARCHAR(255),
+			received TEXT,
+			created DATETIME,
+			updated DATETIME,
+			UNIQUE(id)
+		);`
+
+	// previous versions of files. versions are numbered per file.
+	CreateVersionsTable string = `
+		CREATE TABLE IF NOT EXISTS Versions (
+			file_id VARCHAR(50),
+			drive_id VARCHAR(50),
+			owner_id VARCHAR(50),
+			dir_id VARCHAR(50),
+			name VARCHAR(255),
+			version INTEGER,
+			size INTEGER,
+			checksum VARCHAR(255),
+			path VARCHAR(255),
+			created DATETIME,
+			replaced DATETIME,
+			UNIQUE(file_id, version)
+		);`
+
+	// per-drive version retention policies
+	CreateRetentionTable string = `
+		CREATE TABLE IF NOT EXISTS Retention (
+			drive_id VARCHAR(50) PRIMARY KEY,
+			keep_last INTEGER,
+			daily INTEGER,
+			monthly INTEGER,
+			UNIQUE(drive_id)
+		);`
+
+	// server content store. each blob is a file's contents, stored once
+	// no matter how many files or versions share them.
+	CreateBlobsTable string = `
+		CREATE TABLE IF NOT EXISTS Blobs (
+			checksum VARCHAR(255) PRIMARY KEY,
+			path VARCHAR(255),
+			size INTEGER,
+			refs INTEGER,
+			created DATETIME,
+			UNIQUE(checksum)
+		);`
+
+	// logical server paths (files and versions) and the blobs they point to
+	CreateBlobRefsTable string = `
+		CREATE TABLE IF NOT EXISTS BlobRefs (
+			path VARCHAR(255) PRIMARY KEY,
+			checksum VARCHAR(255),
+			UNIQUE(path)
+		);`
+
+	// user sessions. only a hash of each session's current
+	// refresh token is kept.
+	CreateRefreshTokensTable string = `
+		CREATE TABLE IF NOT EXISTS RefreshTokens (
+			id VARCHAR(50) PRIMARY KEY,
+			user_id VARCHAR(50),
+			hash VARCHAR(255),
+			created DATETIME,
+			expires DATETIME,
+			revoked BIT,
+			UNIQUE(id),
+			UNIQUE(hash)
+		);`
+
+	// devices registered by users. only a hash of each device's API key is kept.
+	CreateDevicesTable string = `
+		CREATE TABLE IF NOT EXISTS Devices (
+			id VARCHAR(50) PRIMARY KEY,
+			name VARCHAR(255),
+			owner_id VARCHAR(50),
+			key_hash VARCHAR(255),
+			created DATETIME,
+			last_seen DATETIME,
+			last_revision INTEGER,
+			revoked BIT,
+			UNIQUE(id),
+			UNIQUE(key_hash)
+		);`
+
+	// files and directories owners have shared with other users
+	CreateSharesTable string = `
+		CREATE TABLE IF NOT EXISTS Shares (
+			id VARCHAR(50) PRIMARY KEY,
+			item_id VARCHAR(50),
+			item_type VARCHAR(20),
+			owner_id VARCHAR(50),
+			grantee_id VARCHAR(50),
+			permission VARCHAR(20),
+			created DATETIME,
+			expires DATETIME,
+			UNIQUE(id),
+			UNIQUE(item_id, grantee_id)
+		);`
+
+	// client recycle bin manifest
+	CreateRecycledTable string = `
+		CREATE TABLE IF NOT EXISTS Recycled (
+			id VARCHAR(50) PRIMARY KEY,
+			file_id VARCHAR(50),
+			name VARCHAR(255),
+			orig_path VARCHAR(255),
+			path VARCHAR(255),
+			size INTEGER,
+			checksum VARCHAR(255),
+			deleted DATETIME,
 			UNIQUE(id)
 		);`
 
@@ -175,9 +326,142 @@ const (
 			drive_id, 
 			total_files, 
 			total_directories,
-			root
+			root,
+			role
 		)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	SetSyncStateQuery string = `
+		INSERT OR REPLACE INTO SyncState (
+			file_id,
+			drive_id,
+			checksum,
+			revision,
+			last_sync
+		)
+		VALUES (?, ?, ?, ?, ?)`
+
+	// assign the next revision for the drive to a file.
+	// done in a single statement so revisions can't be handed out twice.
+	NextRevisionQuery string = `
+		INSERT OR REPLACE INTO Revisions (
+			file_id,
+			drive_id,
+			revision,
+			checksum,
+			last_sync,
+			deleted,
+			device
+		)
+		VALUES (?, ?, (SELECT IFNULL(MAX(revision), 0) + 1 FROM Revisions WHERE drive_id = ?), ?, ?, ?, ?)`
+
+	SetUploadQuery string = `
+		INSERT OR REPLACE INTO Uploads (
+			id,
+			file_id,
+			drive_id,
+			owner_id,
+			size,
+			checksum,
+			tmp_path,
+			received,
+			created,
+			updated
+		)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	// add a file version using the next version number for the file
+	AddVersionQuery string = `
+		INSERT INTO Versions (
+			file_id,
+			drive_id,
+			owner_id,
+			dir_id,
+			name,
+			version,
+			size,
+			checksum,
+			path,
+			created,
+			replaced
+		)
+		VALUES (?, ?, ?, ?, ?, (SELECT IFNULL(MAX(version), 0) + 1 FROM Versions WHERE file_id = ?), ?, ?, ?, ?, ?)`
+
+	SetRetentionQuery string = `
+		INSERT OR REPLACE INTO Retention (
+			drive_id,
+			keep_last,
+			daily,
+			monthly
+		)
+		VALUES (?, ?, ?, ?)`
+
+	SetBlobQuery string = `
+		INSERT OR REPLACE INTO Blobs (
+			checksum,
+			path,
+			size,
+			refs,
+			created
+		)
+		VALUES (?, ?, ?, ?, ?)`
+
+	SetBlobRefQuery string = `
+		INSERT OR REPLACE INTO BlobRefs (
+			path,
+			checksum
+		)
+		VALUES (?, ?)`
+
+	SetRefreshTokenQuery string = `
+		INSERT OR REPLACE INTO RefreshTokens (
+			id,
+			user_id,
+			hash,
+			created,
+			expires,
+			revoked
+		)
+		VALUES (?, ?, ?, ?, ?, ?)`
+
+	SetDeviceQuery string = `
+		INSERT OR REPLACE INTO Devices (
+			id,
+			name,
+			owner_id,
+			key_hash,
+			created,
+			last_seen,
+			last_revision,
+			revoked
+		)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+
+	SetShareQuery string = `
+		INSERT OR REPLACE INTO Shares (
+			id,
+			item_id,
+			item_type,
+			owner_id,
+			grantee_id,
+			permission,
+			created,
+			expires
+		)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+
+	AddRecycledQuery string = `
+		INSERT INTO Recycled (
+			id,
+			file_id,
+			name,
+			orig_path,
+			path,
+			size,
+			checksum,
+			deleted
+		)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
 
 	// ------- update file, user, directory, and drive entries -------
 
@@ -258,7 +542,8 @@ const (
 				drive_id = ?,
 				total_files = ?,
 				total_directories = ?,
-				root = ?
+				root = ?,
+				role = ?
 		WHERE id = ?;`
 
 	// ----------- Removal queries remove the row iff they exist
@@ -279,14 +564,83 @@ const (
 		DELETE FROM Users WHERE id = ? 
 		AND EXISTS (SELECT 1 FROM Users WHERE id=?);`
 
+	RemoveSyncStateQuery string = `
+		DELETE FROM SyncState WHERE file_id = ?;`
+
+	// remove tombstones older than a given time. the latest revision of
+	// each drive is always kept so revisions are never handed out twice.
+	RemoveTombstonesQuery string = `
+		DELETE FROM Revisions
+		WHERE deleted = 1 AND last_sync < ?
+		AND revision < (SELECT MAX(r.revision) FROM Revisions r WHERE r.drive_id = Revisions.drive_id);`
+
+	RemoveUploadQuery string = `
+		DELETE FROM Uploads WHERE id = ?;`
+
+	RemoveVersionQuery string = `
+		DELETE FROM Versions WHERE file_id = ? AND version = ?;`
+
+	RemoveRecycledQuery string = `DELETE FROM Recycled WHERE id = ?;`
+
+	RemoveBlobQuery string = `DELETE FROM Blobs WHERE checksum = ?;`
+
+	RemoveShareQuery string = `DELETE FROM Shares WHERE id = ?;`
+
+	RemoveUsersSharesQuery string = `DELETE FROM Shares WHERE owner_id = ? OR grantee_id = ?;`
+
+	RemoveBlobRefQuery string = `DELETE FROM BlobRefs WHERE path = ?;`
+
+	RevokeRefreshTokenQuery string = `UPDATE RefreshTokens SET revoked = 1 WHERE id = ?;`
+
+	RevokeUserRefreshTokensQuery string = `UPDATE RefreshTokens SET revoked = 1 WHERE user_id = ?;`
+
+	SetRevisionDeviceQuery string = `UPDATE Revisions SET device = ? WHERE file_id = ?;`
+
+	// remove sessions that have expired or were revoked before a given time
+	RemoveExpiredRefreshTokensQuery string = `
+		DELETE FROM RefreshTokens WHERE expires < ? OR (revoked = 1 AND created < ?);`
+
 	DropUserTableQuery string = `DROP TABLE IF EXISTS Users;`
 
+	// add the role column to user tables created before roles were added
+	AddUserRoleColumnQuery string = `ALTER TABLE Users ADD COLUMN role VARCHAR(20) DEFAULT '';`
+
 	DropDrivesTableQuery string = `DROP TABLE IF EXISTS Drives;`
 
 	DropDirectoriesTableQuery string = `DROP TABLE IF EXISTS Directories;`
 
 	DropFilesTableQuery string = `DROP TABLE IF EXISTS Files;`
 
+	DropSyncStateTableQuery string = `DROP TABLE IF EXISTS SyncState;`
+
+	// add the revision column to sync state tables created before revisions were added.
+	// added columns go at the end of the table, so sync state is always selected by name.
+	AddSyncStateRevisionColumnQuery string = `ALTER TABLE SyncState ADD COLUMN revision INTEGER DEFAULT 0;`
+
+	DropRevisionsTableQuery string = `DROP TABLE IF EXISTS Revisions;`
+
+	// add the tombstone columns to revision tables created before deletions were synced
+	AddRevisionDeletedColumnQuery string = `ALTER TABLE Revisions ADD COLUMN deleted BIT DEFAULT 0;`
+	AddRevisionDeviceColumnQuery  string = `ALTER TABLE Revisions ADD COLUMN device VARCHAR(255) DEFAULT '';`
+
+	DropUploadsTableQuery string = `DROP TABLE IF EXISTS Uploads;`
+
+	DropVersionsTableQuery string = `DROP TABLE IF EXISTS Versions;`
+
+	DropRetentionTableQuery string = `DROP TABLE IF EXISTS Retention;`
+
+	DropRecycledTableQuery string = `DROP TABLE IF EXISTS Recycled;`
+
+	DropBlobsTableQuery string = `DROP TABLE IF EXISTS Blobs;`
+
+	DropBlobRefsTableQuery string = `DROP TABLE IF EXISTS BlobRefs;`
+
+	DropRefreshTokensTableQuery string = `DROP TABLE IF EXISTS RefreshTokens;`
+
+	DropDevicesTableQuery string = `DROP TABLE IF EXISTS Devices;`
+
+	DropSharesTableQuery string = `DROP TABLE IF EXISTS Shares;`
+
 	// ---------- SELECT statements for searching -------------------------------
 
 	// general
@@ -307,6 +661,7 @@ const (
 	FindFileQuery                string = `SELECT * FROM Files WHERE id = ?;`
 	FindFilesByNameQuery         string = `SELECT * FROM Files WHERE name = ?;`
 	FindFileByPathQuery          string = `SELECT * FROM Files WHERE path = ?;`
+	FindFileByServerPathQuery    string = `SELECT * FROM Files WHERE server_path = ?;`
 	FindFilesByDirIDQuery        string = `SELECT * FROM Files WHERE directory_id =?;`
 	FindFilesByDriveIDQuery      string = `SELECT * FROM Files WHERE drive_id = ?;`
 	FindAllBackedUpFilesQuery    string = `SELECT * FROM Files WHERE backup = 1;`
@@ -320,12 +675,44 @@ const (
 	FindDriveQuery               string = `SELECT * FROM Drives WHERE id = ?;`
 	FindDriveByUserID            string = `SELECT * FROM Drives WHERE owner_id = ?;`
 	FindUserQuery                string = `SELECT * FROM Users WHERE id = ?;`
+	FindUserByUserNameQuery      string = `SELECT * FROM Users WHERE username = ?;`
 	FindUsersDriveIDQuery        string = `SELECT drive_id FROM Users WHERE id = ?;`
 	FindUsersIDWithDriveIDQuery  string = `SELECT owner_id FROM Drives WHERE id = ?;`
+	FindSyncStateQuery           string = `SELECT file_id, drive_id, checksum, revision, last_sync FROM SyncState WHERE file_id = ?;`
+	FindRevisionQuery            string = `SELECT * FROM Revisions WHERE file_id = ?;`
+	FindDriveRevisionQuery       string = `SELECT IFNULL(MAX(revision), 0) FROM Revisions WHERE drive_id = ?;`
+	FindUploadQuery              string = `SELECT * FROM Uploads WHERE id = ?;`
+	FindUploadByFileIDQuery      string = `SELECT * FROM Uploads WHERE file_id = ? ORDER BY updated DESC LIMIT 1;`
+	FindVersionQuery             string = `SELECT * FROM Versions WHERE file_id = ? AND version = ?;`
+	FindVersionsQuery            string = `SELECT * FROM Versions WHERE file_id = ? ORDER BY version;`
+	FindLatestVersionQuery       string = `SELECT * FROM Versions WHERE file_id = ? ORDER BY version DESC LIMIT 1;`
+	FindDriveVersionsQuery       string = `SELECT * FROM Versions WHERE drive_id = ? ORDER BY file_id, version;`
+	FindVersionByPathQuery       string = `SELECT * FROM Versions WHERE path = ?;`
+	FindRetentionQuery           string = `SELECT * FROM Retention WHERE drive_id = ?;`
+	FindRecycledQuery            string = `SELECT * FROM Recycled WHERE id = ?;`
+	FindAllRecycledQuery         string = `SELECT * FROM Recycled ORDER BY deleted DESC;`
+	FindBlobQuery                string = `SELECT * FROM Blobs WHERE checksum = ?;`
+	FindUnreferencedBlobsQuery   string = `SELECT * FROM Blobs WHERE refs <= 0;`
+	FindBlobRefQuery             string = `SELECT checksum FROM BlobRefs WHERE path = ?;`
+	FindRefreshTokenQuery        string = `SELECT * FROM RefreshTokens WHERE id = ?;`
+	FindRefreshTokenByHashQuery  string = `SELECT * FROM RefreshTokens WHERE hash = ?;`
+	FindUsersRefreshTokensQuery  string = `SELECT * FROM RefreshTokens WHERE user_id = ? AND revoked = 0;`
+	FindDeviceQuery              string = `SELECT * FROM Devices WHERE id = ?;`
+	FindDeviceByKeyHashQuery     string = `SELECT * FROM Devices WHERE key_hash = ?;`
+	FindUsersDevicesQuery        string = `SELECT * FROM Devices WHERE owner_id = ? ORDER BY created;`
+	FindAllDevicesQuery          string = `SELECT * FROM Devices ORDER BY owner_id, created;`
+	FindShareQuery               string = `SELECT * FROM Shares WHERE id = ?;`
+	FindSharesByOwnerQuery       string = `SELECT * FROM Shares WHERE owner_id = ? ORDER BY created;`
+	FindSharesWithUserQuery      string = `SELECT * FROM Shares WHERE grantee_id = ? ORDER BY created;`
+	FindItemSharesQuery          string = `SELECT * FROM Shares WHERE item_id = ? ORDER BY created;`
 
 	// find by date ranges
-	FindFilesAfterQuery string = `SELECT * FROM Files WHERE last_sync > ?;`
-	FindDirsAfterQuery  string = `SELECT * FROM Directories WHERE last_sync > ?;`
+	FindFilesAfterQuery    string = `SELECT * FROM Files WHERE last_sync > ?;`
+	FindDirsAfterQuery     string = `SELECT * FROM Directories WHERE last_sync > ?;`
+	FindUploadsBeforeQuery string = `SELECT * FROM Uploads WHERE updated < ?;`
+
+	// find by revision
+	FindRevisionsAfterQuery string = `SELECT * FROM Revisions WHERE drive_id = ? AND revision > ? ORDER BY revision;`
 
 	// ---------- SELECT statements for confirming existance and registration  -------------------
 
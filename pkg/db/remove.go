@@ -3,6 +3,7 @@ package db
 import (
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/sfs/pkg/auth"
 	svc "github.com/sfs/pkg/service"
@@ -24,6 +25,28 @@ func (q *Query) getTable(dbName string) string {
 		return "Directories"
 	case "files":
 		return "Files"
+	case "sync":
+		return "SyncState"
+	case "revisions":
+		return "Revisions"
+	case "uploads":
+		return "Uploads"
+	case "versions":
+		return "Versions"
+	case "retention":
+		return "Retention"
+	case "recycled":
+		return "Recycled"
+	case "blobs":
+		return "Blobs"
+	case "blobrefs":
+		return "BlobRefs"
+	case "tokens":
+		return "RefreshTokens"
+	case "devices":
+		return "Devices"
+	case "shares":
+		return "Shares"
 	}
 	return ""
 }
@@ -44,6 +67,39 @@ func (q *Query) getResetQueries(tableName string) (string, string) {
 	case "Files":
 		dropQuery = DropFilesTableQuery
 		createQuery = CreateFileTable
+	case "SyncState":
+		dropQuery = DropSyncStateTableQuery
+		createQuery = CreateSyncStateTable
+	case "Revisions":
+		dropQuery = DropRevisionsTableQuery
+		createQuery = CreateRevisionsTable
+	case "Uploads":
+		dropQuery = DropUploadsTableQuery
+		createQuery = CreateUploadsTable
+	case "Versions":
+		dropQuery = DropVersionsTableQuery
+		createQuery = CreateVersionsTable
+	case "Retention":
+		dropQuery = DropRetentionTableQuery
+		createQuery = CreateRetentionTable
+	case "Recycled":
+		dropQuery = DropRecycledTableQuery
+		createQuery = CreateRecycledTable
+	case "Blobs":
+		dropQuery = DropBlobsTableQuery
+		createQuery = CreateBlobsTable
+	case "BlobRefs":
+		dropQuery = DropBlobRefsTableQuery
+		createQuery = CreateBlobRefsTable
+	case "RefreshTokens":
+		dropQuery = DropRefreshTokensTableQuery
+		createQuery = CreateRefreshTokensTable
+	case "Devices":
+		dropQuery = DropDevicesTableQuery
+		createQuery = CreateDevicesTable
+	case "Shares":
+		dropQuery = DropSharesTableQuery
+		createQuery = CreateSharesTable
 	default:
 		log.Fatalf("unsupported table name: %s", tableName)
 	}
@@ -66,6 +122,28 @@ func (q *Query) DropTable(dbName string) error {
 		query = DropDirectoriesTableQuery
 	case "files":
 		query = DropFilesTableQuery
+	case "sync":
+		query = DropSyncStateTableQuery
+	case "revisions":
+		query = DropRevisionsTableQuery
+	case "uploads":
+		query = DropUploadsTableQuery
+	case "versions":
+		query = DropVersionsTableQuery
+	case "retention":
+		query = DropRetentionTableQuery
+	case "recycled":
+		query = DropRecycledTableQuery
+	case "blobs":
+		query = DropBlobsTableQuery
+	case "blobrefs":
+		query = DropBlobRefsTableQuery
+	case "tokens":
+		query = DropRefreshTokensTableQuery
+	case "devices":
+		query = DropDevicesTableQuery
+	case "shares":
+		query = DropSharesTableQuery
 	}
 	_, err := q.Conn.Exec(query)
 	if err != nil {
@@ -226,3 +304,168 @@ func (q *Query) ClearTable(dbName string) error {
 	}
 	return nil
 }
+
+func (q *Query) RemoveSyncState(fileID string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.WhichDB("sync")
+	q.Connect()
+	defer q.Close()
+
+	_, err := q.Conn.Exec(RemoveSyncStateQuery, fileID)
+	if err != nil {
+		return fmt.Errorf("failed to remove sync state (file id=%s): %v", fileID, err)
+	}
+	return nil
+}
+
+// remove all tombstones recorded before the given time.
+// returns the number of tombstones removed.
+func (q *Query) RemoveTombstones(before time.Time) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.WhichDB("revisions")
+	q.Connect()
+	defer q.Close()
+
+	res, err := q.Conn.Exec(RemoveTombstonesQuery, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to remove tombstones: %v", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (q *Query) RemoveUpload(uploadID string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.WhichDB("uploads")
+	q.Connect()
+	defer q.Close()
+
+	_, err := q.Conn.Exec(RemoveUploadQuery, uploadID)
+	if err != nil {
+		return fmt.Errorf("failed to remove upload session (id=%s): %v", uploadID, err)
+	}
+	return nil
+}
+
+func (q *Query) RemoveVersion(fileID string, version int64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.WhichDB("versions")
+	q.Connect()
+	defer q.Close()
+
+	_, err := q.Conn.Exec(RemoveVersionQuery, fileID, version)
+	if err != nil {
+		return fmt.Errorf("failed to remove version %d of file (id=%s): %v", version, fileID, err)
+	}
+	return nil
+}
+
+// remove an item from the recycle bin manifest
+func (q *Query) RemoveRecycled(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.WhichDB("recycled")
+	q.Connect()
+	defer q.Close()
+
+	_, err := q.Conn.Exec(RemoveRecycledQuery, id)
+	if err != nil {
+		return fmt.Errorf("failed to remove recycled item (id=%s): %v", id, err)
+	}
+	return nil
+}
+
+// remove a blob's record. does not remove its contents.
+func (q *Query) RemoveBlob(checksum string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.WhichDB("blobs")
+	q.Connect()
+	defer q.Close()
+
+	_, err := q.Conn.Exec(RemoveBlobQuery, checksum)
+	if err != nil {
+		return fmt.Errorf("failed to remove blob (checksum=%s): %v", checksum, err)
+	}
+	return nil
+}
+
+// remove a logical path's reference to its blob. the blob's
+// reference count is not updated.
+func (q *Query) RemoveBlobRef(path string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.WhichDB("blobrefs")
+	q.Connect()
+	defer q.Close()
+
+	_, err := q.Conn.Exec(RemoveBlobRefQuery, path)
+	if err != nil {
+		return fmt.Errorf("failed to remove blob reference for %s: %v", path, err)
+	}
+	return nil
+}
+
+// remove sessions that expired, or were revoked, before the given time.
+// returns the number of sessions removed.
+func (q *Query) RemoveExpiredRefreshTokens(before time.Time) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.WhichDB("tokens")
+	q.Connect()
+	defer q.Close()
+
+	res, err := q.Conn.Exec(RemoveExpiredRefreshTokensQuery, before, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to remove expired sessions: %v", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (q *Query) RemoveShare(shareID string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.WhichDB("shares")
+	q.Connect()
+	defer q.Close()
+
+	if _, err := q.Conn.Exec(RemoveShareQuery, shareID); err != nil {
+		return fmt.Errorf("failed to remove share: %v", err)
+	}
+	return nil
+}
+
+// remove every share a user owns or was given
+func (q *Query) RemoveUsersShares(userID string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.WhichDB("shares")
+	q.Connect()
+	defer q.Close()
+
+	if _, err := q.Conn.Exec(RemoveUsersSharesQuery, userID, userID); err != nil {
+		return fmt.Errorf("failed to remove user's shares: %v", err)
+	}
+	return nil
+}
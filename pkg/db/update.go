@@ -190,9 +190,55 @@ func (q *Query) UpdateUser(user *auth.User) error {
 		&user.TotalFiles,
 		&user.TotalDirs,
 		&user.DrvRoot,
+		string(user.GetRole()),
 		&user.ID,
 	); err != nil {
 		return fmt.Errorf("failed to execute statement: %v", err)
 	}
 	return nil
 }
+
+// revoke a user session. its refresh token can no longer be used.
+func (q *Query) RevokeRefreshToken(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.WhichDB("tokens")
+	q.Connect()
+	defer q.Close()
+
+	if _, err := q.Conn.Exec(RevokeRefreshTokenQuery, id); err != nil {
+		return fmt.Errorf("failed to revoke session (id=%s): %v", id, err)
+	}
+	return nil
+}
+
+// revoke all of a user's sessions
+func (q *Query) RevokeUserRefreshTokens(userID string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.WhichDB("tokens")
+	q.Connect()
+	defer q.Close()
+
+	if _, err := q.Conn.Exec(RevokeUserRefreshTokensQuery, userID); err != nil {
+		return fmt.Errorf("failed to revoke sessions for user (id=%s): %v", userID, err)
+	}
+	return nil
+}
+
+// record the device that made the latest change to a file
+func (q *Query) SetRevisionDevice(fileID string, device string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.WhichDB("revisions")
+	q.Connect()
+	defer q.Close()
+
+	if _, err := q.Conn.Exec(SetRevisionDeviceQuery, device, fileID); err != nil {
+		return fmt.Errorf("failed to set device for file (id=%s): %v", fileID, err)
+	}
+	return nil
+}
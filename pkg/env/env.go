@@ -23,34 +23,46 @@ var BaseEnv = map[string]string{
 	"BUFFERED_EVENTS":   "true",
 	"EVENT_BUFFER_SIZE": "2",
 	"JWT_SECRET":        "",
+	"MONITOR_BACKEND":   "fsnotify",
 	"NEW_SERVICE":       "true",
 
 	// client settings
-	"CLIENT_ADDRESS":     "localhost:9090",
-	"CLIENT_BACKUP_DIR":  "",
-	"CLIENT_EMAIL":       "",
-	"CLIENT_ID":          "",
-	"CLIENT_LOG_DIR":     "",
-	"CLIENT_NAME":        "",
-	"CLIENT_NEW_SERVICE": "true",
-	"CLIENT_PASSWORD":    "",
-	"CLIENT_PORT":        "9090",
-	"CLIENT_PROFILE_PIC": "",
-	"CLIENT_ROOT":        "",
-	"CLIENT_SERVER_SYNC": "false",
-	"CLIENT_TESTING":     "",
-	"CLIENT_USERNAME":    "",
+	"CLIENT_ADDRESS":             "localhost:9090",
+	"CLIENT_BACKUP_DIR":          "",
+	"CLIENT_DOWNLOAD_LIMIT":      "0",
+	"CLIENT_EMAIL":               "",
+	"CLIENT_FILE_DOWNLOAD_LIMIT": "0",
+	"CLIENT_FILE_UPLOAD_LIMIT":   "0",
+	"CLIENT_ID":                  "",
+	"CLIENT_IGNORE":              ".git/;node_modules/;*.swp;*~;.DS_Store",
+	"CLIENT_LOG_DIR":             "",
+	"CLIENT_NAME":                "",
+	"CLIENT_NEW_SERVICE":         "true",
+	"CLIENT_PASSWORD":            "",
+	"CLIENT_PORT":                "9090",
+	"CLIENT_PROFILE_PIC":         "",
+	"CLIENT_PUSH_NEW_ITEMS":      "false",
+	"CLIENT_RECYCLE_EXPIRY":      "30",
+	"CLIENT_ROOT":                "",
+	"CLIENT_SERVER_SYNC":         "false",
+	"CLIENT_TESTING":             "",
+	"CLIENT_TRANSFER_RETRIES":    "3",
+	"CLIENT_TRANSFER_WINDOWS":    "",
+	"CLIENT_TRANSFER_WORKERS":    "4",
+	"CLIENT_UPLOAD_LIMIT":        "0",
+	"CLIENT_USERNAME":            "",
 
 	// server settings
-	"SERVER_ADDR":          "localhost:9191",
-	"SERVER_ADMIN":         "admin",
-	"SERVER_ADMIN_KEY":     "",
-	"SERVER_HOST":          "",
-	"SERVER_LOG_DIR":       "",
-	"SERVER_PORT":          "9191",
-	"SERVER_TIMEOUT_IDLE":  "900s",
-	"SERVER_TIMEOUT_READ":  "5s",
-	"SERVER_TIMEOUT_WRITE": "10s",
+	"SERVER_ADDR":                "localhost:9191",
+	"SERVER_ADMIN":               "admin",
+	"SERVER_ADMIN_KEY":           "",
+	"SERVER_HOST":                "",
+	"SERVER_LOG_DIR":             "",
+	"SERVER_PORT":                "9191",
+	"SERVER_TIMEOUT_IDLE":        "900s",
+	"SERVER_TIMEOUT_READ":        "5s",
+	"SERVER_TIMEOUT_WRITE":       "10s",
+	"SERVER_TOMBSTONE_RETENTION": "720h",
 
 	// service settings
 	"SERVICE_ENV":       "",
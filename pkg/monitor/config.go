@@ -8,9 +8,16 @@ import (
 	"github.com/joeshaw/envdecode"
 )
 
+// monitor backends
+const (
+	FSNOTIFY = "fsnotify" // inotify/kqueue/etc. backed watchers. one OS-level watch per directory.
+	POLL     = "poll"     // one goroutine per file that periodically calls os.Stat()
+)
+
 type MonitorConfigs struct {
-	Buffered bool `env:"BUFFERED_EVENTS,required"`
-	BuffSize int  `env:"EVENT_BUFFER_SIZE,requred"`
+	Buffered bool   `env:"BUFFERED_EVENTS,required"`
+	BuffSize int    `env:"EVENT_BUFFER_SIZE,requred"`
+	Backend  string `env:"MONITOR_BACKEND,default=fsnotify"` // either "fsnotify" or "poll"
 }
 
 func MonitorCfgs() *MonitorConfigs {
@@ -3,6 +3,8 @@ package monitor
 import (
 	"io/fs"
 	"path/filepath"
+
+	svc "github.com/sfs/pkg/service"
 )
 
 // used for keeping track of current
@@ -10,18 +12,22 @@ import (
 type DirCtx struct {
 	dirpath   string
 	currItems map[string]EItem
+	entries   map[string]fs.DirEntry // last known entries, used for finding removed items
+	ignore    *svc.Ignore            // ignore rules. ignored items are never added to the context.
 }
 
 func NewDirCtx(dirPath string) *DirCtx {
 	return &DirCtx{
 		dirpath:   dirPath,
 		currItems: make(map[string]EItem),
+		entries:   make(map[string]fs.DirEntry),
 	}
 }
 
 func (ctx *DirCtx) Clear() {
 	ctx.currItems = nil
 	ctx.currItems = make(map[string]EItem)
+	ctx.entries = make(map[string]fs.DirEntry)
 }
 
 func (ctx *DirCtx) HaveItem(itemName string) bool {
@@ -46,6 +52,9 @@ func getItemType(item fs.DirEntry) string {
 func (ctx *DirCtx) AddItems(newItems []fs.DirEntry) []EItem {
 	diffs := make([]EItem, 0)
 	for _, item := range newItems {
+		if ctx.ignore.Ignored(filepath.Join(ctx.dirpath, item.Name()), item.IsDir()) {
+			continue
+		}
 		if !ctx.HaveItem(item.Name()) {
 			eitem := EItem{
 				itype: getItemType(item),
@@ -54,11 +63,31 @@ func (ctx *DirCtx) AddItems(newItems []fs.DirEntry) []EItem {
 			}
 			diffs = append(diffs, eitem)
 			ctx.currItems[eitem.Name()] = eitem
+			ctx.entries[eitem.Name()] = item
 		}
 	}
 	return diffs
 }
 
+// returns the entries in the current context that are no longer
+// in the given list of entries (i.e. were removed from the directory).
+func (ctx *DirCtx) Missing(items []fs.DirEntry) []fs.DirEntry {
+	curr := make(map[string]bool, len(items))
+	for _, item := range items {
+		// items that are ignored now are treated as removed
+		if !ctx.ignore.Ignored(filepath.Join(ctx.dirpath, item.Name()), item.IsDir()) {
+			curr[item.Name()] = true
+		}
+	}
+	missing := make([]fs.DirEntry, 0)
+	for name, entry := range ctx.entries {
+		if !curr[name] {
+			missing = append(missing, entry)
+		}
+	}
+	return missing
+}
+
 // remove items from context
 func (ctx *DirCtx) RemoveItems(remove []fs.DirEntry) []EItem {
 	diffs := make([]EItem, 0)
@@ -66,9 +95,10 @@ func (ctx *DirCtx) RemoveItems(remove []fs.DirEntry) []EItem {
 		removed := EItem{
 			itype: getItemType(item),
 			name:  item.Name(),
-			path:  filepath.Join(item.Name(), ctx.dirpath),
+			path:  filepath.Join(ctx.dirpath, item.Name()),
 		}
 		delete(ctx.currItems, item.Name())
+		delete(ctx.entries, item.Name())
 		diffs = append(diffs, removed)
 	}
 	return diffs
@@ -5,6 +5,7 @@ type EventType string
 // event enums
 const (
 	Add     EventType = "add"
+	Remove  EventType = "remove"
 	Create  EventType = "create"
 	Delete  EventType = "delete"
 	Change  EventType = "change"
@@ -26,6 +27,7 @@ type EItem struct {
 func (e *EItem) Name() string { return e.name }
 func (e *EItem) Path() string { return e.path }
 func (e *EItem) Kind() string { return e.itype }
+func (e *EItem) IsDir() bool  { return e.itype == "directory" }
 
 type Event struct {
 	ID    string    // UUID of the event
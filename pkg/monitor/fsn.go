@@ -0,0 +1,322 @@
+package monitor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/sfs/pkg/auth"
+	"github.com/sfs/pkg/logger"
+	svc "github.com/sfs/pkg/service"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+/*
+fsnotify backed watcher.
+
+rather than having one goroutine per file polling os.Stat(), a single fsnotify
+watcher is shared by the monitor, with one OS-level watch per directory. events
+for the files in those directories are then translated into the same events the
+polling watcher sends (Size, ModTime, Mode, Name, Delete), and forwarded to the
+event channel for that file, so event handlers don't need to know which backend
+is in use.
+
+watched directories get Add and Remove events whenever items are
+created in or removed from them.
+*/
+
+// size of each item's pending events queue. events are dropped if
+// the queue is full, since handlers re-check the item anyways.
+const fsnQueueSize = 16
+
+type fsnWatcher struct {
+	mu  sync.Mutex
+	log *logger.Logger
+	w   *fsnotify.Watcher
+
+	// directories registered with the OS-level watcher
+	dirs map[string]bool
+
+	// watched files and directories.
+	// key = item path, value = subscriber for that item
+	subs map[string]*fsnSub
+}
+
+// a single watched file or directory
+type fsnSub struct {
+	dir   bool          // whether this is a directory
+	stat  os.FileInfo   // last known file info
+	queue chan Event    // pending events for this item
+	done  chan struct{} // closed when the item is no longer being watched
+}
+
+func newFsnWatcher() (*fsnWatcher, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create fsnotify watcher: %v", err)
+	}
+	fw := &fsnWatcher{
+		log:  logger.NewLogger("FSN_WATCHER", auth.NewUUID()),
+		w:    w,
+		dirs: make(map[string]bool),
+		subs: make(map[string]*fsnSub),
+	}
+	go fw.run()
+	return fw, nil
+}
+
+// register a directory with the OS-level watcher.
+// expects fw.mu to be held.
+func (fw *fsnWatcher) addDir(dirPath string) error {
+	if fw.dirs[dirPath] {
+		return nil
+	}
+	if err := fw.w.Add(dirPath); err != nil {
+		return fmt.Errorf("failed to watch %s: %v", dirPath, err)
+	}
+	fw.dirs[dirPath] = true
+	return nil
+}
+
+// register a subscriber for an item, replacing any existing one.
+// dirPath is the directory to register with the OS-level watcher.
+func (fw *fsnWatcher) subscribe(itemPath, dirPath string, sub *fsnSub) error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if old, exists := fw.subs[itemPath]; exists {
+		close(old.done)
+	}
+	fw.subs[itemPath] = sub
+	if err := fw.addDir(dirPath); err != nil {
+		delete(fw.subs, itemPath)
+		return err
+	}
+	return nil
+}
+
+// start watching a file. returns a channel that will receive events
+// for the file until stop receives a value, the file is deleted, or
+// unwatch() is called for the file.
+//
+// matches the Watcher function signature.
+func (fw *fsnWatcher) watchfsn(filePath string, stop chan bool) chan Event {
+	stat, err := os.Stat(filePath)
+	if err != nil {
+		fw.log.Error(fmt.Sprintf("failed to get initial info for %s: %v - unable to monitor", filepath.Base(filePath), err))
+		return nil
+	}
+	sub := &fsnSub{
+		stat:  stat,
+		queue: make(chan Event, fsnQueueSize),
+		done:  make(chan struct{}),
+	}
+	if err := fw.subscribe(filePath, filepath.Dir(filePath), sub); err != nil {
+		fw.log.Error(err.Error())
+		return nil
+	}
+	return fw.forward(filePath, sub, stop, func(evt Event) []Event {
+		return []Event{evt}
+	})
+}
+
+// start watching a directory for new or removed items. returns a channel
+// that will receive Add and Remove events until stop receives a value,
+// the directory is deleted, or unwatch() is called for the directory.
+// items matching ig are skipped.
+func (fw *fsnWatcher) watchDir(dirPath string, stop chan bool, ig *svc.Ignore) chan Event {
+	ctx := NewDirCtx(dirPath)
+	ctx.ignore = ig
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		fw.log.Error(fmt.Sprintf("failed to read %s: %v - unable to monitor", filepath.Base(dirPath), err))
+		return nil
+	}
+	ctx.AddItems(entries)
+
+	sub := &fsnSub{
+		dir:   true,
+		queue: make(chan Event, fsnQueueSize),
+		done:  make(chan struct{}),
+	}
+	if err := fw.subscribe(dirPath, dirPath, sub); err != nil {
+		fw.log.Error(err.Error())
+		return nil
+	}
+	// change events only signal that the directory needs to be re-read,
+	// so any other pending signals can be dropped before scanning.
+	return fw.forward(dirPath, sub, stop, func(evt Event) []Event {
+		if evt.Etype == Delete {
+			return []Event{evt}
+		}
+		for len(sub.queue) > 0 {
+			if pending := <-sub.queue; pending.Etype == Delete {
+				return []Event{pending}
+			}
+		}
+		evts, err := dirEvents(ctx)
+		if errors.Is(err, os.ErrNotExist) {
+			return []Event{newDirEvent(Delete, dirPath, nil)}
+		} else if err != nil {
+			fw.log.Error(fmt.Sprintf("failed to read %s: %v", filepath.Base(dirPath), err))
+			return nil
+		}
+		return evts
+	})
+}
+
+// forward queued events to the event handler. this is done per-item so a
+// slow (or stopped) handler doesn't hold up events for other items.
+// expand turns a queued event into the events to send to the handler.
+func (fw *fsnWatcher) forward(itemPath string, sub *fsnSub, stop chan bool, expand func(Event) []Event) chan Event {
+	evtChan := make(chan Event)
+	go func() {
+		defer close(evtChan)
+		for {
+			select {
+			case <-stop:
+				fw.unwatch(itemPath, sub)
+				return
+			case <-sub.done:
+				return
+			case queued := <-sub.queue:
+				for _, evt := range expand(queued) {
+					select {
+					case evtChan <- evt:
+					case <-stop:
+						fw.unwatch(itemPath, sub)
+						return
+					case <-sub.done:
+						return
+					}
+					if evt.Etype == Delete {
+						fw.unwatch(itemPath, sub)
+						return
+					}
+				}
+			}
+		}
+	}()
+	return evtChan
+}
+
+// stop watching an item. if sub is nil, then whatever subscriber
+// is currently registered for the item is removed.
+func (fw *fsnWatcher) unwatch(itemPath string, sub *fsnSub) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if cur, exists := fw.subs[itemPath]; exists && (sub == nil || cur == sub) {
+		close(cur.done)
+		delete(fw.subs, itemPath)
+	}
+}
+
+// stop all watchers and close the underlying fsnotify watcher
+func (fw *fsnWatcher) close() error {
+	fw.mu.Lock()
+	for path, sub := range fw.subs {
+		close(sub.done)
+		delete(fw.subs, path)
+	}
+	fw.mu.Unlock()
+	return fw.w.Close()
+}
+
+// main event loop. translates fsnotify events into monitor events.
+func (fw *fsnWatcher) run() {
+	for {
+		select {
+		case event, ok := <-fw.w.Events:
+			if !ok {
+				return
+			}
+			fw.handle(event)
+		case err, ok := <-fw.w.Errors:
+			if !ok {
+				return
+			}
+			fw.log.Error("watcher error: " + err.Error())
+		}
+	}
+}
+
+func (fw *fsnWatcher) handle(event fsnotify.Event) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	removed := event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename)
+	if removed {
+		delete(fw.dirs, event.Name)
+	}
+
+	// let the parent directory know its contents changed
+	if parent, exists := fw.subs[filepath.Dir(event.Name)]; exists && parent.dir {
+		if removed || event.Has(fsnotify.Create) {
+			select {
+			case parent.queue <- newDirEvent(Change, filepath.Dir(event.Name), nil):
+			default: // a rescan is already pending
+			}
+		}
+	}
+
+	sub, exists := fw.subs[event.Name]
+	if !exists {
+		return
+	}
+	baseName := filepath.Base(event.Name)
+	if sub.dir {
+		if removed {
+			fw.log.Log(logger.INFO, fmt.Sprintf("directory '%s' removed", baseName))
+			fw.send(sub, newDirEvent(Delete, event.Name, nil))
+		}
+		return
+	}
+	switch {
+	case event.Has(fsnotify.Remove):
+		fw.log.Log(logger.INFO, fmt.Sprintf("file '%s' removed", baseName))
+		fw.send(sub, newFileEvent(Delete, event.Name))
+	case event.Has(fsnotify.Rename):
+		fw.log.Log(logger.INFO, "file name change detected for "+baseName)
+		fw.send(sub, newFileEvent(Name, event.Name))
+	case event.Has(fsnotify.Write), event.Has(fsnotify.Create), event.Has(fsnotify.Chmod):
+		stat, err := os.Stat(event.Name)
+		if err != nil {
+			return
+		}
+		switch {
+		case stat.Size() != sub.stat.Size():
+			fw.log.Log(logger.INFO, fmt.Sprintf(
+				"size change detected: %f kb -> %f kb | path: %s",
+				float32(sub.stat.Size()/1000), float32(stat.Size()/1000), event.Name),
+			)
+			fw.send(sub, newFileEvent(Size, event.Name))
+		case stat.ModTime() != sub.stat.ModTime():
+			fw.log.Log(logger.INFO, fmt.Sprintf("mod time change detected: %v -> %v", sub.stat.ModTime(), stat.ModTime()))
+			fw.send(sub, newFileEvent(ModTime, event.Name))
+		case stat.Mode() != sub.stat.Mode():
+			fw.log.Log(logger.INFO, fmt.Sprintf("mode change detected: %v -> %v", sub.stat.Mode(), stat.Mode()))
+			fw.send(sub, newFileEvent(Mode, event.Name))
+		}
+		sub.stat = stat
+	}
+}
+
+// queue an event for an item without blocking
+func (fw *fsnWatcher) send(sub *fsnSub, evt Event) {
+	select {
+	case sub.queue <- evt:
+	default:
+		fw.log.Warn(fmt.Sprintf("event queue for '%s' is full. dropping %s event", filepath.Base(evt.Path), evt.Etype))
+	}
+}
+
+func newFileEvent(etype EventType, path string) Event {
+	return Event{
+		IType: "File",
+		Etype: etype,
+		ID:    auth.NewUUID(),
+		Path:  path,
+	}
+}
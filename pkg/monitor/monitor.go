@@ -10,8 +10,7 @@ import (
 
 	"github.com/sfs/pkg/auth"
 	"github.com/sfs/pkg/logger"
-
-	"github.com/fsnotify/fsnotify"
+	svc "github.com/sfs/pkg/service"
 )
 
 /*
@@ -29,6 +28,7 @@ should also have a mechanism to interrupt a sync operation if a new event occurs
 const (
 	WAIT        = time.Millisecond * 500 // wait duration after checks with no changes
 	WAIT_LONGER = time.Second            // wait duration after checks with changes
+	DIR_WAIT    = time.Second * 5        // wait duration between directory scans when polling
 )
 
 type Watcher func(string, chan bool) chan Event
@@ -55,15 +55,66 @@ type Monitor struct {
 	//
 	// key = item path, val is chan bool
 	OffSwitches map[string]chan bool
+
+	// shared fsnotify watcher. nil if the polling backend is being used.
+	fsn *fsnWatcher
+
+	// ignore rules. ignored items aren't watched, and
+	// directories don't send events for them.
+	ignore *svc.Ignore
 }
 
 func NewMonitor(drvRoot string) *Monitor {
-	return &Monitor{
+	m := &Monitor{
 		log:         logger.NewLogger("Monitor", "None"),
 		Events:      make(map[string]chan Event),
 		Watchers:    make(map[string]Watcher),
 		OffSwitches: make(map[string]chan bool),
 	}
+	if MonCfgs.Backend != POLL {
+		fsn, err := newFsnWatcher()
+		if err != nil {
+			m.log.Warn(fmt.Sprintf("%v. falling back to polling", err))
+		} else {
+			m.fsn = fsn
+		}
+	}
+	return m
+}
+
+// the watcher function to use for new files,
+// depending on which backend is being used.
+func (m *Monitor) watcher() Watcher {
+	if m.fsn != nil {
+		return m.fsn.watchfsn
+	}
+	return watch
+}
+
+// the watcher function to use for new directories,
+// depending on which backend is being used.
+func (m *Monitor) dirWatcher() Watcher {
+	ig := m.ignore
+	if m.fsn != nil {
+		return func(dirPath string, stop chan bool) chan Event {
+			return m.fsn.watchDir(dirPath, stop, ig)
+		}
+	}
+	return func(dirPath string, stop chan bool) chan Event {
+		return watchDir(dirPath, stop, ig)
+	}
+}
+
+// set the ignore rules to use for any new watchers.
+func (m *Monitor) SetIgnore(ig *svc.Ignore) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ignore = ig
+}
+
+// whether the given item matches the monitor's ignore rules.
+func (m *Monitor) Ignored(path string, isDir bool) bool {
+	return m.ignore.Ignored(path, isDir)
 }
 
 // see if an event channel exists for a given filepath.
@@ -118,10 +169,14 @@ func (m *Monitor) StartWatcher(path string, stop chan bool) {
 	}
 }
 
-// add a file to the events map and create a new monitoring
+// add a file or directory to the events map and create a new monitoring
 // goroutine. will need a corresponding events handler on the client end.
-// will be a no-op if the given path is already being monitored, or if the
-// supplied path points to a directory.
+// will be a no-op if the given path is already being monitored.
+//
+// directories only send Add and Remove events for their immediate
+// children, and Delete events if the directory itself is removed.
+//
+// will also be a no-op if the given path is ignored.
 func (m *Monitor) Watch(path string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -134,18 +189,22 @@ func (m *Monitor) Watch(path string) error {
 		if err != nil {
 			return err
 		}
-		// NOTE: monitoring directories is too expensive for the time being.
-		// os.ReadDir() took a lot of CPU, especially when
-		// called in a frequent operation loop. for now we're sticking
-		// with monitoring files exclusively (or at least until we can find a
-		// more efficient way to monitor directories.)
-		if !isdir {
-			stop := make(chan bool)
-			m.OffSwitches[path] = stop
-			m.AddWatcher(path, watchfsn)
-			m.StartWatcher(path, stop)
-			m.log.Log(logger.INFO, fmt.Sprintf("monitoring %s...", filepath.Base(path)))
+		if m.ignore.Ignored(path, isdir) {
+			return nil
+		}
+		// NOTE: os.ReadDir() takes a lot of CPU when called in a frequent
+		// operation loop, so the polling backend scans directories less often
+		// than files. the fsnotify backend only re-reads a directory
+		// when the OS tells us something in it changed.
+		stop := make(chan bool)
+		m.OffSwitches[path] = stop
+		if isdir {
+			m.AddWatcher(path, m.dirWatcher())
+		} else {
+			m.AddWatcher(path, m.watcher())
 		}
+		m.StartWatcher(path, stop)
+		m.log.Log(logger.INFO, fmt.Sprintf("monitoring %s...", filepath.Base(path)))
 	}
 	return nil
 }
@@ -185,6 +244,9 @@ func (m *Monitor) GetOffSwitch(path string) chan bool {
 // will be a no-op if the file is not registered.
 func (m *Monitor) StopWatching(path string) {
 	if m.IsMonitored(path) {
+		if m.fsn != nil {
+			m.fsn.unwatch(path, nil)
+		}
 		m.Watchers[path] = nil
 		delete(m.OffSwitches, path)
 		delete(m.Events, path)
@@ -204,6 +266,12 @@ func (m *Monitor) ShutDown() {
 	for key := range m.Watchers {
 		m.Watchers[key] = nil
 	}
+	if m.fsn != nil {
+		if err := m.fsn.close(); err != nil {
+			m.log.Error(fmt.Sprintf("failed to close fsnotify watcher: %v", err))
+		}
+		m.fsn = nil
+	}
 }
 
 // creates a new monitor goroutine for a given file or directory.
@@ -330,87 +398,86 @@ func watch(filePath string, stop chan bool) chan Event {
 	return evtChan
 }
 
-// alternative implementation using fsnotify. still experimental.
-func watchfsn(itemPath string, stop chan bool) chan Event {
-	log := logger.NewLogger("FILE_WATCHER", auth.NewUUID())
+// creates a new monitor goroutine for a given directory. sends Add and Remove
+// events whenever items are created in or removed from the directory.
+// items matching ig are skipped.
+func watchDir(dirPath string, stop chan bool, ig *svc.Ignore) chan Event {
+	log := logger.NewLogger("DIR_WATCHER", auth.NewUUID())
 
-	// base file name for easier output reading
-	baseName := filepath.Base(itemPath)
+	// base directory name for easier output reading
+	baseName := filepath.Base(dirPath)
 
-	// event channel to pass file events to the event handler
+	// event channel to pass directory events to the event handler
 	evtChan := make(chan Event)
 
-	// setup watcher
-	w, err := fsnotify.NewBufferedWatcher(0)
+	// get initial contents of the directory
+	ctx := NewDirCtx(dirPath)
+	ctx.ignore = ig
+	entries, err := os.ReadDir(dirPath)
 	if err != nil {
-		log.Error(err.Error())
+		log.Error(fmt.Sprintf("failed to read %s: %v - unable to monitor", baseName, err))
+		return nil
 	}
+	ctx.AddItems(entries)
 
-	// event loop
 	go func() {
+		defer close(evtChan)
 		for {
 			select {
 			case <-stop:
-				log.Info("stopping watcher for " + baseName)
-				w.Close()
-				close(evtChan)
+				log.Log(logger.INFO, fmt.Sprintf("shutting down monitoring for '%s'...", baseName))
 				return
-			case event, ok := <-w.Events:
-				if !ok {
-					log.Error("failed to receive events for " + baseName)
-					w.Close()
-					close(evtChan)
-					return
+			case <-time.After(DIR_WAIT):
+				evts, err := dirEvents(ctx)
+				if errors.Is(err, os.ErrNotExist) {
+					log.Log(logger.INFO, fmt.Sprintf("'%s' was deleted. stopping monitoring.", baseName))
+					evts = []Event{newDirEvent(Delete, dirPath, nil)}
+				} else if err != nil {
+					log.Log(logger.INFO, fmt.Sprintf("%v - stopping monitoring for '%s'...", err, baseName))
+					evts = []Event{newDirEvent(Error, dirPath, nil)}
 				}
-				switch {
-				case event.Has(fsnotify.Write):
-					log.Log(logger.INFO, "writing change detected for "+baseName)
-					evtChan <- Event{
-						IType: "File",
-						Etype: Size,
-						ID:    auth.NewUUID(),
-						Path:  itemPath,
+				for _, evt := range evts {
+					select {
+					case evtChan <- evt:
+					case <-stop:
+						return
 					}
-				case event.Has(fsnotify.Rename):
-					log.Log(logger.INFO, "file name change detected for "+baseName)
-					evtChan <- Event{
-						IType: "File",
-						Etype: Name,
-						ID:    auth.NewUUID(),
-						Path:  itemPath,
-					}
-				case event.Has(fsnotify.Remove):
-					log.Log(logger.INFO, fmt.Sprintf("file '%s' removed", baseName))
-					evtChan <- Event{
-						IType: "File",
-						Etype: Delete,
-						ID:    auth.NewUUID(),
-						Path:  itemPath,
+					if evt.Etype == Delete || evt.Etype == Error {
+						return
 					}
-					w.Close()
-					close(evtChan)
-					return
-				default:
-					time.Sleep(WAIT)
-				}
-			case err, ok := <-w.Errors:
-				if !ok {
-					w.Close()
-					return
 				}
-				log.Error("error: " + err.Error())
 			}
 		}
 	}()
 
-	// Add the path to the item we want to monitor
-	if err := w.Add(itemPath); err != nil {
-		log.Error("failed to add file to watcher: " + err.Error())
-		w.Close()
-		return nil
+	return evtChan
+}
+
+// re-read a directory and build Add and Remove events for
+// any items that have been created or removed since the last read.
+func dirEvents(ctx *DirCtx) ([]Event, error) {
+	entries, err := os.ReadDir(ctx.dirpath)
+	if err != nil {
+		return nil, err
+	}
+	evts := make([]Event, 0)
+	if added := ctx.AddItems(entries); len(added) > 0 {
+		evts = append(evts, newDirEvent(Add, ctx.dirpath, added))
 	}
+	if removed := ctx.RemoveItems(ctx.Missing(entries)); len(removed) > 0 {
+		evts = append(evts, newDirEvent(Remove, ctx.dirpath, removed))
+	}
+	return evts, nil
+}
 
-	return evtChan
+func newDirEvent(etype EventType, path string, items []EItem) Event {
+	return Event{
+		IType: "Directory",
+		Etype: etype,
+		ID:    auth.NewUUID(),
+		Path:  path,
+		Items: items,
+	}
 }
 
 // add all files and directories under the given path
@@ -421,6 +488,12 @@ func watchAll(path string, m *Monitor) error {
 		if err != nil {
 			return err
 		}
+		if itemPath != path && m.ignore.Ignored(itemPath, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
 		if err := m.Watch(itemPath); err != nil {
 			return err
 		}
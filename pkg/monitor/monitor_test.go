@@ -237,6 +237,120 @@ func TestMonitorWatchAll(t *testing.T) {
 	}
 }
 
+func TestFsnWatcher(t *testing.T) {
+	env.SetEnv(false)
+
+	file, err := MakeTmpTxtFile(filepath.Join(GetTestingDir(), "tmp.txt"), RandInt(1000))
+	if err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+
+	fw, err := newFsnWatcher()
+	if err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+	defer fw.close()
+
+	stop := make(chan bool)
+	evts := fw.watchfsn(file.Path, stop)
+	assert.NotEqual(t, nil, evts)
+
+	// alter the file and wait for a size change
+	MutateFile(t, file)
+	select {
+	case evt := <-evts:
+		assert.Equal(t, Size, evt.Etype)
+		assert.Equal(t, file.Path, evt.Path)
+	case <-time.After(5 * time.Second):
+		Fail(t, GetTestingDir(), fmt.Errorf("no change event received"))
+	}
+
+	// delete the file. the event channel should close after the delete event.
+	if err := os.Remove(file.Path); err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+	gotDelete := false
+	timeout := time.After(5 * time.Second)
+	for !gotDelete {
+		select {
+		case evt, ok := <-evts:
+			if !ok {
+				Fail(t, GetTestingDir(), fmt.Errorf("event channel closed before delete event"))
+			}
+			gotDelete = evt.Etype == Delete
+		case <-timeout:
+			Fail(t, GetTestingDir(), fmt.Errorf("no delete event received"))
+		}
+	}
+	_, ok := <-evts
+	assert.False(t, ok)
+
+	if err := Clean(t, GetTestingDir()); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func TestFsnWatchDir(t *testing.T) {
+	env.SetEnv(false)
+
+	dir := filepath.Join(GetTestingDir(), "tmpdir")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+
+	fw, err := newFsnWatcher()
+	if err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+	defer fw.close()
+
+	stop := make(chan bool)
+	evts := fw.watchDir(dir, stop, nil)
+	assert.NotEqual(t, nil, evts)
+
+	// wait for the given event type, skipping any others
+	waitFor := func(etype EventType) Event {
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case evt, ok := <-evts:
+				if !ok {
+					Fail(t, GetTestingDir(), fmt.Errorf("event channel closed"))
+				}
+				if evt.Etype == etype {
+					return evt
+				}
+			case <-timeout:
+				Fail(t, GetTestingDir(), fmt.Errorf("no %s event received", etype))
+			}
+		}
+	}
+
+	// new items should be sent with an add event
+	newFile := filepath.Join(dir, "new.txt")
+	if err := os.WriteFile(newFile, []byte("some data"), 0644); err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+	evt := waitFor(Add)
+	assert.Equal(t, dir, evt.Path)
+	assert.Equal(t, 1, len(evt.Items))
+	assert.Equal(t, newFile, evt.Items[0].Path())
+	assert.False(t, evt.Items[0].IsDir())
+
+	// removed items should be sent with a remove event
+	if err := os.Remove(newFile); err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+	evt = waitFor(Remove)
+	assert.Equal(t, 1, len(evt.Items))
+	assert.Equal(t, newFile, evt.Items[0].Path())
+
+	stop <- true
+	if err := Clean(t, GetTestingDir()); err != nil {
+		log.Fatal(err)
+	}
+}
+
 // NOTE: monitoring directories is currently not supported
 // func TestMonitorDirectory(t *testing.T) {
 // 	env.SetEnv(false)
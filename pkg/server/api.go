@@ -2,19 +2,21 @@ package server
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"mime/multipart"
 	"net/http"
 	"os"
-	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/sfs/pkg/auth"
 	"github.com/sfs/pkg/logger"
 	svc "github.com/sfs/pkg/service"
-	"github.com/sfs/pkg/transfer"
 )
 
 /*
@@ -71,6 +73,39 @@ func (a *API) clientError(w http.ResponseWriter, err string) {
 	http.Error(w, err, http.StatusBadRequest)
 }
 
+// sends an unprocessable entity (422) with an error message, and logs the message.
+// used when received data doesn't match its checksum, so clients know to resend it.
+func (a *API) checksumError(w http.ResponseWriter, err string) {
+	a.log.Warn(err)
+	http.Error(w, err, http.StatusUnprocessableEntity)
+}
+
+// sends an insufficient storage (507) with an error message, and logs the message.
+// used when a change would put a drive over its quota.
+func (a *API) quotaError(w http.ResponseWriter, err string) {
+	a.log.Warn(err)
+	http.Error(w, err, http.StatusInsufficientStorage)
+}
+
+// sends a request entity too large (413) with an error message, and logs the message
+func (a *API) tooLargeError(w http.ResponseWriter, err string) {
+	a.log.Warn(err)
+	http.Error(w, err, http.StatusRequestEntityTooLarge)
+}
+
+// sends a conflict (409) with an error message, and logs the message.
+// used when a new or moved item would take the place of an existing one.
+func (a *API) conflictError(w http.ResponseWriter, err string) {
+	a.log.Warn(err)
+	http.Error(w, err, http.StatusConflict)
+}
+
+// sends an unauthorized (401) with an error message, and logs the message
+func (a *API) authError(w http.ResponseWriter, err string) {
+	a.log.Warn(err)
+	http.Error(w, err, http.StatusUnauthorized)
+}
+
 // sends an internal server error (500) with an error message, and logs the message
 func (a *API) serverError(w http.ResponseWriter, err string) {
 	a.log.Error(err)
@@ -86,6 +121,230 @@ func (a *API) GetRunTime(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(runTime))
 }
 
+// -------- auth -----------------------------------------
+
+// log in with a user ID (or user name) and password. responds with a
+// session containing a short-lived access token to send in the Authorization
+// header of other requests, and a refresh token to get new access tokens with.
+func (a *API) Login(w http.ResponseWriter, r *http.Request) {
+	var creds auth.Credentials
+	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
+		a.clientError(w, "failed to read credentials: "+err.Error())
+		return
+	}
+	var user *auth.User
+	var err error
+	// passwords aren't kept in the service state, so always check the db
+	if creds.UserID != "" {
+		user, err = a.Svc.Db.GetUser(creds.UserID)
+	} else {
+		user, err = a.Svc.Db.GetUserByUserName(creds.UserName)
+	}
+	if err != nil {
+		a.serverError(w, err.Error())
+		return
+	}
+	if user == nil || !user.CheckPassword(creds.Password) {
+		a.authError(w, "invalid user name or password")
+		return
+	}
+	session, err := a.Svc.NewSession(user.ID)
+	if err != nil {
+		a.serverError(w, err.Error())
+		return
+	}
+	user.LastLogin = time.Now().UTC()
+	if err := a.Svc.Db.UpdateUser(user); err != nil {
+		a.serverError(w, err.Error())
+		return
+	}
+	a.log.Info(fmt.Sprintf("user (name=%s id=%s) logged in", user.Name, user.ID))
+	a.writeSession(w, session)
+}
+
+// exchange a refresh token (sent as {"refresh_token": "..."}) for a new
+// session. refresh tokens can only be used once.
+func (a *API) Refresh(w http.ResponseWriter, r *http.Request) {
+	var req auth.Session
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		a.clientError(w, "failed to read refresh token: "+err.Error())
+		return
+	}
+	session, err := a.Svc.RefreshSession(req.RefreshToken)
+	if err != nil {
+		if errors.Is(err, ErrInvalidSession) {
+			a.authError(w, err.Error())
+		} else {
+			a.serverError(w, err.Error())
+		}
+		return
+	}
+	a.writeSession(w, session)
+}
+
+// end the session the request was made with
+func (a *API) Logout(w http.ResponseWriter, r *http.Request) {
+	sessionID, _ := r.Context().Value(Session).(string)
+	if err := a.Svc.RevokeSession(sessionID); err != nil {
+		a.serverError(w, err.Error())
+		return
+	}
+	a.write(w, "logged out")
+}
+
+// change the requesting user's password with {"password", "new_password"}.
+// all of the user's sessions are revoked, and a new session is returned.
+func (a *API) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	var creds auth.Credentials
+	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
+		a.clientError(w, "failed to read credentials: "+err.Error())
+		return
+	}
+	session, err := a.Svc.ChangePassword(requester(r).ID, creds.Password, creds.NewPassword)
+	if err != nil {
+		if strings.Contains(err.Error(), "invalid password") {
+			a.authError(w, err.Error())
+		} else if strings.Contains(err.Error(), "no password provided") {
+			a.clientError(w, err.Error())
+		} else {
+			a.serverError(w, err.Error())
+		}
+		return
+	}
+	a.writeSession(w, session)
+}
+
+func (a *API) writeSession(w http.ResponseWriter, session *auth.Session) {
+	data, err := session.ToJSON()
+	if err != nil {
+		a.serverError(w, "failed to convert to JSON: "+err.Error())
+		return
+	}
+	w.Write(data)
+}
+
+// -------- devices -----------------------------------------
+
+// name of the device a request came from. registered devices use their
+// registered name. otherwise the ?device=<name> query parameter is used.
+func (a *API) deviceName(r *http.Request) string {
+	if device := requestDevice(r); device != nil {
+		return device.Name
+	}
+	return r.URL.Query().Get("device")
+}
+
+// attribute a change to a file to the registered device the request came from, if any.
+// failures are only logged, since the change itself was already made.
+func (a *API) attribute(r *http.Request, file *svc.File) {
+	device := requestDevice(r)
+	if device == nil {
+		return
+	}
+	if err := a.Svc.attributeChange(device, file); err != nil {
+		a.log.Error(fmt.Sprintf("failed to attribute change to %s (id=%s) to device (id=%s): %v", file.Name, file.ID, device.ID, err))
+	}
+}
+
+func (a *API) writeDevices(w http.ResponseWriter, devices []*auth.Device) {
+	data, err := json.MarshalIndent(devices, "", "  ")
+	if err != nil {
+		a.serverError(w, "failed to convert to JSON: "+err.Error())
+		return
+	}
+	w.Write(data)
+}
+
+// register a new device for the requesting user with ?name=<name>. responds with
+// the device, including its API key. this is the only time the key is sent.
+func (a *API) RegisterDevice(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		a.clientError(w, "no device name provided")
+		return
+	}
+	device, err := a.Svc.RegisterDevice(requester(r).ID, name)
+	if err != nil {
+		if strings.Contains(err.Error(), "already exists") {
+			a.clientError(w, err.Error())
+		} else {
+			a.serverError(w, err.Error())
+		}
+		return
+	}
+	data, err := device.ToJSON()
+	if err != nil {
+		a.serverError(w, "failed to convert to JSON: "+err.Error())
+		return
+	}
+	w.Write(data)
+}
+
+// list the requesting user's devices
+func (a *API) GetDevices(w http.ResponseWriter, r *http.Request) {
+	devices, err := a.Svc.GetDevices(requester(r).ID)
+	if err != nil {
+		a.serverError(w, err.Error())
+		return
+	}
+	a.writeDevices(w, devices)
+}
+
+// list every registered device. use with AdminOnly.
+func (a *API) GetAllDevices(w http.ResponseWriter, r *http.Request) {
+	devices, err := a.Svc.GetAllDevices()
+	if err != nil {
+		a.serverError(w, err.Error())
+		return
+	}
+	a.writeDevices(w, devices)
+}
+
+// get a device from the ID supplied by DeviceCtx
+func (a *API) getDeviceFromRequest(r *http.Request) (*auth.Device, error) {
+	deviceID, _ := r.Context().Value(Device).(string)
+	if deviceID == "" {
+		return nil, fmt.Errorf("no device ID found")
+	}
+	return a.Svc.GetDevice(deviceID)
+}
+
+func (a *API) GetDevice(w http.ResponseWriter, r *http.Request) {
+	device, err := a.getDeviceFromRequest(r)
+	if err != nil {
+		if strings.Contains(err.Error(), "device") {
+			a.notFoundError(w, err.Error())
+		} else {
+			a.serverError(w, err.Error())
+		}
+		return
+	}
+	data, err := device.ToJSON()
+	if err != nil {
+		a.serverError(w, "failed to convert to JSON: "+err.Error())
+		return
+	}
+	w.Write(data)
+}
+
+// revoke a device. its API key can no longer be used.
+func (a *API) RevokeDevice(w http.ResponseWriter, r *http.Request) {
+	device, err := a.getDeviceFromRequest(r)
+	if err != nil {
+		if strings.Contains(err.Error(), "device") {
+			a.notFoundError(w, err.Error())
+		} else {
+			a.serverError(w, err.Error())
+		}
+		return
+	}
+	if err := a.Svc.RevokeDevice(device); err != nil {
+		a.serverError(w, err.Error())
+		return
+	}
+	a.write(w, fmt.Sprintf("device %s (id=%s) revoked", device.Name, device.ID))
+}
+
 // -------- users (admin only) -----------------------------------------
 
 // returns a user struct for a new or existing user, assuming it exists in the server database.
@@ -114,13 +373,26 @@ func (a *API) getUserFromRequest(r *http.Request) (*auth.User, error) {
 }
 
 // add a new user and drive to sfs instance. user existance and
-// struct pointer should be created by NewUser middleware
+// struct pointer should be created by NewUser middleware. the user's
+// password is sent in the request body, and only its hash is kept.
+//
+// NOTE: users can't register themselves as admins, or choose their own role.
 func (a *API) AddNewUser(w http.ResponseWriter, r *http.Request) {
 	user, err := a.getNewUserFromRequest(r)
 	if err != nil {
 		a.clientError(w, err.Error())
 		return
 	}
+	var creds auth.Credentials
+	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
+		a.clientError(w, "failed to read credentials: "+err.Error())
+		return
+	}
+	if err := user.SetPassword(creds.Password); err != nil {
+		a.clientError(w, err.Error())
+		return
+	}
+	user.SetRole(auth.RoleUser)
 	if err := a.Svc.AddUser(user); err != nil {
 		if strings.Contains(err.Error(), "already exists") {
 			a.write(w, "user is already registered") // user already exists
@@ -188,6 +460,44 @@ func (a *API) UpdateUser(w http.ResponseWriter, r *http.Request) {
 	a.write(w, fmt.Sprintf("user (name=%s id=%s) updated", user.Name, user.ID))
 }
 
+// set the quota for a user's drive with ?size= (in bytes).
+// responds with the drive's updated usage.
+func (a *API) SetQuota(w http.ResponseWriter, r *http.Request) {
+	user, err := a.getUserFromRequest(r)
+	if err != nil {
+		if strings.Contains(err.Error(), "user") { // non-existing user or missing ID errors
+			a.clientError(w, err.Error())
+		} else {
+			a.serverError(w, err.Error())
+		}
+		return
+	}
+	size, err := strconv.ParseInt(r.URL.Query().Get("size"), 10, 64)
+	if err != nil {
+		a.clientError(w, fmt.Sprintf("invalid quota size: '%s'", r.URL.Query().Get("size")))
+		return
+	}
+	if err := a.Svc.SetQuota(user.ID, size); err != nil {
+		if strings.Contains(err.Error(), "invalid") {
+			a.clientError(w, err.Error())
+		} else {
+			a.serverError(w, err.Error())
+		}
+		return
+	}
+	usage, err := a.Svc.GetUsage(user.DriveID)
+	if err != nil {
+		a.serverError(w, err.Error())
+		return
+	}
+	data, err := usage.ToJSON()
+	if err != nil {
+		a.serverError(w, "failed to convert to JSON: "+err.Error())
+		return
+	}
+	w.Write(data)
+}
+
 // remove a user from the server
 func (a *API) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	user, err := a.getUserFromRequest(r)
@@ -206,192 +516,866 @@ func (a *API) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	a.write(w, fmt.Sprintf("user (name=%s id=%s) removed from server", user.Name, user.ID))
 }
 
-// -------- files -----------------------------------------
+// -------- shares -----------------------------------------
 
-func (a *API) getNewFileFromRequest(r *http.Request) (*svc.File, error) {
-	file := r.Context().Value(File).(*svc.File)
-	if file == nil {
-		return nil, fmt.Errorf("file object not found in request")
+// share a file or directory with {"item_id", "grantee_id", "permission", "expires"}.
+// grantee_id can also be the grantee's user name, and expires is optional.
+// only the item's owner can share it.
+func (a *API) NewShare(w http.ResponseWriter, r *http.Request) {
+	req := new(svc.Share)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		a.clientError(w, "failed to read share: "+err.Error())
+		return
 	}
-	return file, nil
-}
-
-// gets a file from the ID provided by the request. returns nil if not found.
-func (a *API) getFileFromRequest(r *http.Request) (*svc.File, error) {
-	fileID := r.Context().Value(File).(string)
-	if fileID == "" {
-		return nil, fmt.Errorf("no file ID found in request")
+	if req.ItemID == "" || req.GranteeID == "" {
+		a.clientError(w, "item_id and grantee_id are required")
+		return
 	}
-	file, err := a.Svc.Db.GetFileByID(fileID)
+	perm, err := svc.ParsePermission(string(req.Permission))
 	if err != nil {
-		return nil, err
+		a.clientError(w, err.Error())
+		return
 	}
-	if file == nil {
-		return nil, fmt.Errorf("file (id=%s) not found", fileID)
+	_, ownerID, err := a.Svc.shareableItem(req.ItemID)
+	if err != nil {
+		if strings.Contains(err.Error(), "no file or directory") {
+			a.notFoundError(w, err.Error())
+		} else if strings.Contains(err.Error(), "can't be shared") {
+			a.clientError(w, err.Error())
+		} else {
+			a.serverError(w, err.Error())
+		}
+		return
 	}
-	return file, nil
-}
-
-// get file metadata
-func (a *API) GetFileInfo(w http.ResponseWriter, r *http.Request) {
-	f, err := a.getFileFromRequest(r)
+	if !authorized(r, ownerID) {
+		forbidden(w)
+		return
+	}
+	share, err := a.Svc.ShareItem(req.ItemID, req.GranteeID, perm, req.Expires)
 	if err != nil {
-		if strings.Contains(err.Error(), "file") { // not found or missing ID errors
+		if strings.Contains(err.Error(), "not found") {
+			a.notFoundError(w, err.Error())
+		} else if strings.Contains(err.Error(), "shared with their owner") || strings.Contains(err.Error(), "expiration") {
 			a.clientError(w, err.Error())
 		} else {
 			a.serverError(w, err.Error())
 		}
 		return
 	}
-	file, err := a.Svc.GetFile(f.DriveID, f.ID)
+	data, err := share.ToJSON()
+	if err != nil {
+		a.serverError(w, "failed to convert to JSON: "+err.Error())
+		return
+	}
+	w.Write(data)
+}
+
+// list the shares the user has created
+func (a *API) GetShares(w http.ResponseWriter, r *http.Request) {
+	shares, err := a.Svc.GetShares(requester(r).ID)
 	if err != nil {
 		a.serverError(w, err.Error())
 		return
 	}
-	data, err := file.ToJSON()
+	data, err := json.MarshalIndent(shares, "", "  ")
 	if err != nil {
 		a.serverError(w, "failed to convert to JSON: "+err.Error())
 		return
 	}
-	a.write(w, string(data))
+	w.Write(data)
 }
 
-// retrieve a file from the server
-func (a *API) ServeFile(w http.ResponseWriter, r *http.Request) {
-	f, err := a.getFileFromRequest(r)
+// list the contents of the user's "Shared with me" directory
+func (a *API) GetSharedWithMe(w http.ResponseWriter, r *http.Request) {
+	user := requester(r)
+	listing, err := a.Svc.SharedWithMe(user.ID, user.DriveID)
+	if err != nil {
+		a.serverError(w, err.Error())
+		return
+	}
+	data, err := json.MarshalIndent(listing, "", "  ")
+	if err != nil {
+		a.serverError(w, "failed to convert to JSON: "+err.Error())
+		return
+	}
+	w.Write(data)
+}
+
+// list every file shared with the user, including the contents of
+// shared directories, along with each file's permission. used by clients
+// for syncing shared items.
+func (a *API) GetSharedFiles(w http.ResponseWriter, r *http.Request) {
+	files, err := a.Svc.SharedFiles(requester(r).ID)
+	if err != nil {
+		a.serverError(w, err.Error())
+		return
+	}
+	data, err := json.MarshalIndent(files, "", "  ")
+	if err != nil {
+		a.serverError(w, "failed to convert to JSON: "+err.Error())
+		return
+	}
+	w.Write(data)
+}
+
+func (a *API) getShareFromRequest(r *http.Request) (*svc.Share, error) {
+	shareID, _ := r.Context().Value(Share).(string)
+	if shareID == "" {
+		return nil, fmt.Errorf("no share ID found")
+	}
+	return a.Svc.GetShare(shareID)
+}
+
+func (a *API) GetShare(w http.ResponseWriter, r *http.Request) {
+	share, err := a.getShareFromRequest(r)
+	if err != nil {
+		if strings.Contains(err.Error(), "share") {
+			a.notFoundError(w, err.Error())
+		} else {
+			a.serverError(w, err.Error())
+		}
+		return
+	}
+	data, err := share.ToJSON()
+	if err != nil {
+		a.serverError(w, "failed to convert to JSON: "+err.Error())
+		return
+	}
+	w.Write(data)
+}
+
+// remove a share. owners can stop sharing an item, and
+// grantees can remove items from their "Shared with me" directory.
+func (a *API) RemoveShare(w http.ResponseWriter, r *http.Request) {
+	share, err := a.getShareFromRequest(r)
+	if err != nil {
+		if strings.Contains(err.Error(), "share") {
+			a.notFoundError(w, err.Error())
+		} else {
+			a.serverError(w, err.Error())
+		}
+		return
+	}
+	if err := a.Svc.RemoveShare(share); err != nil {
+		a.serverError(w, err.Error())
+		return
+	}
+	a.write(w, fmt.Sprintf("share (id=%s) removed", share.ID))
+}
+
+// -------- admin -----------------------------------------
+
+func (a *API) readUserInfo(r *http.Request) (*auth.UserInfo, error) {
+	info := new(auth.UserInfo)
+	if err := json.NewDecoder(r.Body).Decode(info); err != nil {
+		return nil, fmt.Errorf("failed to read user info: %v", err)
+	}
+	return info, nil
+}
+
+// add a new user with {"name", "username", "email", "password", "role"}.
+// role defaults to "user".
+func (a *API) AdminAddUser(w http.ResponseWriter, r *http.Request) {
+	info, err := a.readUserInfo(r)
+	if err != nil {
+		a.clientError(w, err.Error())
+		return
+	}
+	if info.Name == "" || info.UserName == "" || info.Email == "" || info.Password == "" {
+		a.clientError(w, "name, username, email, and password are required")
+		return
+	}
+	role := auth.RoleUser
+	if info.Role != "" {
+		if role, err = auth.ParseRole(info.Role); err != nil {
+			a.clientError(w, err.Error())
+			return
+		}
+	}
+	existing, err := a.Svc.Db.GetUserByUserName(info.UserName)
+	if err != nil {
+		a.serverError(w, err.Error())
+		return
+	}
+	if existing != nil {
+		a.clientError(w, fmt.Sprintf("user name '%s' already exists", info.UserName))
+		return
+	}
+	user := auth.NewUser(info.Name, info.UserName, info.Email, a.Svc.SvcRoot, false)
+	user.SetRole(role)
+	if err := user.SetPassword(info.Password); err != nil {
+		a.clientError(w, err.Error())
+		return
+	}
+	if err := a.Svc.AddUser(user); err != nil {
+		a.serverError(w, err.Error())
+		return
+	}
+	data, err := user.ToJSON()
+	if err != nil {
+		a.serverError(w, err.Error())
+		return
+	}
+	w.Write(data)
+}
+
+// list all users as a JSON array
+func (a *API) AdminGetUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := a.Svc.Db.GetUsers()
+	if err != nil {
+		a.serverError(w, err.Error())
+		return
+	}
+	if users == nil {
+		users = make([]*auth.User, 0)
+	}
+	data, err := json.MarshalIndent(users, "", "  ")
+	if err != nil {
+		a.serverError(w, "failed to convert to JSON: "+err.Error())
+		return
+	}
+	w.Write(data)
+}
+
+// update a user with any of {"name", "username", "email", "password", "role"}.
+// changing a user's password or role ends all of their sessions.
+// admins can't change their own role, so there's always at least one admin.
+func (a *API) AdminUpdateUser(w http.ResponseWriter, r *http.Request) {
+	user, err := a.getUserFromRequest(r)
+	if err != nil {
+		if strings.Contains(err.Error(), "user") { // non-existing user or missing ID errors
+			a.notFoundError(w, err.Error())
+		} else {
+			a.serverError(w, err.Error())
+		}
+		return
+	}
+	info, err := a.readUserInfo(r)
+	if err != nil {
+		a.clientError(w, err.Error())
+		return
+	}
+	revoke := false
+	if info.Role != "" {
+		role, err := auth.ParseRole(info.Role)
+		if err != nil {
+			a.clientError(w, err.Error())
+			return
+		}
+		if role != user.GetRole() {
+			if user.ID == requester(r).ID {
+				a.clientError(w, "admins can't change their own role")
+				return
+			}
+			user.SetRole(role)
+			revoke = true
+		}
+	}
+	if info.UserName != "" && info.UserName != user.UserName {
+		existing, err := a.Svc.Db.GetUserByUserName(info.UserName)
+		if err != nil {
+			a.serverError(w, err.Error())
+			return
+		}
+		if existing != nil {
+			a.clientError(w, fmt.Sprintf("user name '%s' already exists", info.UserName))
+			return
+		}
+		user.UserName = info.UserName
+	}
+	if info.Name != "" {
+		user.Name = info.Name
+	}
+	if info.Email != "" {
+		user.Email = info.Email
+	}
+	if info.Password != "" {
+		if err := user.SetPassword(info.Password); err != nil {
+			a.clientError(w, err.Error())
+			return
+		}
+		revoke = true
+	}
+	if err := a.Svc.UpdateUser(user); err != nil {
+		a.serverError(w, err.Error())
+		return
+	}
+	if revoke {
+		if err := a.Svc.RevokeSessions(user.ID); err != nil {
+			a.serverError(w, err.Error())
+			return
+		}
+	}
+	data, err := user.ToJSON()
+	if err != nil {
+		a.serverError(w, err.Error())
+		return
+	}
+	w.Write(data)
+}
+
+// remove a user, along with their drive, sessions, and devices.
+// admins can't remove themselves.
+func (a *API) AdminDeleteUser(w http.ResponseWriter, r *http.Request) {
+	user, err := a.getUserFromRequest(r)
+	if err != nil {
+		if strings.Contains(err.Error(), "user") { // non-existing user or missing ID errors
+			a.notFoundError(w, err.Error())
+		} else {
+			a.serverError(w, err.Error())
+		}
+		return
+	}
+	if user.ID == requester(r).ID {
+		a.clientError(w, "admins can't remove themselves")
+		return
+	}
+	if err := a.Svc.RemoveUser(user.ID); err != nil {
+		a.serverError(w, err.Error())
+		return
+	}
+	a.write(w, fmt.Sprintf("user (name=%s id=%s) removed from server", user.Name, user.ID))
+}
+
+// list every drive on the server along with its owner and space usage
+func (a *API) AdminGetDrives(w http.ResponseWriter, r *http.Request) {
+	usage, err := a.Svc.GetAllUsage()
+	if err != nil {
+		a.serverError(w, err.Error())
+		return
+	}
+	data, err := json.MarshalIndent(usage, "", "  ")
+	if err != nil {
+		a.serverError(w, "failed to convert to JSON: "+err.Error())
+		return
+	}
+	w.Write(data)
+}
+
+// list all the files in a drive as a JSON array
+func (a *API) AdminGetDriveFiles(w http.ResponseWriter, r *http.Request) {
+	drive, err := a.getDriveFromRequest(r)
+	if err != nil {
+		a.notFoundError(w, err.Error())
+		return
+	}
+	files, err := a.Svc.Db.GetFilesByDriveID(drive.ID)
+	if err != nil {
+		a.serverError(w, err.Error())
+		return
+	}
+	if files == nil {
+		files = make([]*svc.File, 0)
+	}
+	data, err := json.MarshalIndent(files, "", "  ")
+	if err != nil {
+		a.serverError(w, "failed to convert to JSON: "+err.Error())
+		return
+	}
+	w.Write(data)
+}
+
+// -------- files -----------------------------------------
+
+func (a *API) getNewFileFromRequest(r *http.Request) (*svc.File, error) {
+	file := r.Context().Value(File).(*svc.File)
+	if file == nil {
+		return nil, fmt.Errorf("file object not found in request")
+	}
+	return file, nil
+}
+
+// get the file metadata the client sent in the request's token
+func (a *API) getFileFromToken(r *http.Request) (*svc.File, error) {
+	fileInfo, err := auth.NewT().Validate(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify token: %v", err)
+	}
+	return svc.UnmarshalFileStr(fileInfo)
+}
+
+// returned when a request's file doesn't exist
+var ErrFileNotFound = errors.New("not found")
+
+// gets a file from the ID provided by the request.
+// returns ErrFileNotFound if there's no such file.
+func (a *API) getFileFromRequest(r *http.Request) (*svc.File, error) {
+	fileID := r.Context().Value(File).(string)
+	if fileID == "" {
+		return nil, fmt.Errorf("no file ID found in request")
+	}
+	file, err := a.Svc.Db.GetFileByID(fileID)
+	if err != nil {
+		return nil, err
+	}
+	if file == nil {
+		return nil, fmt.Errorf("file (id=%s) %w", fileID, ErrFileNotFound)
+	}
+	return file, nil
+}
+
+// get file metadata
+func (a *API) GetFileInfo(w http.ResponseWriter, r *http.Request) {
+	f, err := a.getFileFromRequest(r)
+	if err != nil {
+		if strings.Contains(err.Error(), "file") { // not found or missing ID errors
+			a.clientError(w, err.Error())
+		} else {
+			a.serverError(w, err.Error())
+		}
+		return
+	}
+	file, err := a.Svc.GetFile(f.DriveID, f.ID)
+	if err != nil {
+		a.serverError(w, err.Error())
+		return
+	}
+	data, err := file.ToJSON()
+	if err != nil {
+		a.serverError(w, "failed to convert to JSON: "+err.Error())
+		return
+	}
+	a.write(w, string(data))
+}
+
+// retrieve a file from the server
+func (a *API) ServeFile(w http.ResponseWriter, r *http.Request) {
+	f, err := a.getFileFromRequest(r)
+	if err != nil {
+		if strings.Contains(err.Error(), "file") { // not found or missing ID errors
+			a.clientError(w, err.Error())
+		} else {
+			a.serverError(w, err.Error())
+		}
+		return
+	}
+	file, err := a.Svc.GetFile(f.DriveID, f.ID)
+	if err != nil {
+		a.serverError(w, err.Error())
+		return
+	}
+
+	path, err := a.Svc.ContentPath(file.ServerPath)
+	if err != nil {
+		a.serverError(w, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", file.Name))
+	w.Header().Set("Content-Type", "application/octet-stream")
+
+	http.ServeFile(w, r, path)
+	a.log.Info(fmt.Sprintf("served file %s: %s", file.Name, file.ServerPath))
+}
+
+// get json blobs of all files available on the server for a user.
+// only sends metadata, not the actual files.
+func (a *API) GetAllFileInfo(w http.ResponseWriter, r *http.Request) {
+	user, err := a.getUserFromRequest(r)
+	if err != nil {
+		if strings.Contains(err.Error(), "user") {
+			a.clientError(w, err.Error())
+		} else {
+			a.serverError(w, err.Error())
+		}
+		return
+	}
+	files, err := a.Svc.GetAllFiles(user.DriveID)
+	if err != nil {
+		a.serverError(w, err.Error())
+		return
+	}
+	for _, file := range files {
+		data, err := file.ToJSON()
+		if err != nil {
+			a.serverError(w, fmt.Sprintf("failed to convert %s (id=%s) to JSON: %v", file.Name, file.ID, err))
+			return
+		}
+		w.Write(data)
+	}
+}
+
+// add initial file metadata to the server. creates an empty files,
+// does not create file contents, though svc.AddFile() does attempt
+// to write out the data. This will be remidied in a future version.
+func (a *API) newFile(w http.ResponseWriter, r *http.Request, newFile *svc.File) {
+	f, err := a.Svc.Db.GetFileByID(newFile.ID)
+	if err != nil {
+		a.serverError(w, err.Error())
+		return
+	}
+	if f != nil {
+		a.clientError(w, fmt.Sprintf("file (name=%s id=%s) already exists", newFile.Name, newFile.ID))
+		return
+	}
+	if err := a.Svc.AddFile(newFile.DirID, newFile); err != nil {
+		if errors.Is(err, svc.ErrQuotaExceeded) {
+			a.quotaError(w, fmt.Sprintf("failed to add %s to service: %v", newFile.Name, err))
+		} else if errors.Is(err, ErrPathTaken) {
+			a.conflictError(w, fmt.Sprintf("failed to add %s to service: %v", newFile.Name, err))
+		} else if errors.Is(err, ErrInvalidName) {
+			a.clientError(w, fmt.Sprintf("failed to add %s to service: %v", newFile.Name, err))
+		} else {
+			a.serverError(w, fmt.Sprintf("failed to add %s to service: %v", newFile.Name, err))
+		}
+		return
+	}
+	a.attribute(r, newFile)
+	a.write(w, fmt.Sprintf("file (%s) has been added to the server", newFile.Name))
+}
+
+// update the file on the server. the file is streamed from the request
+// to a temp file rather than read into memory, and must match the checksum
+// in the request's file token. the server's copy is only replaced if it does.
+func (a *API) putFile(w http.ResponseWriter, r *http.Request, file *svc.File) {
+	sent, err := a.getFileFromToken(r)
+	if err != nil {
+		a.clientError(w, err.Error())
+		return
+	}
+	if sent.CheckSum == "" {
+		a.clientError(w, fmt.Sprintf("no checksum sent for '%s' (id=%s)", file.Name, file.ID))
+		return
+	}
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		a.clientError(w, "failed to read multipart form: "+err.Error())
+		return
+	}
+	var part *multipart.Part
+	for {
+		part, err = mr.NextPart()
+		if err != nil {
+			a.clientError(w, "failed to retrieve form file: "+err.Error())
+			return
+		}
+		if part.FormName() == "myFile" {
+			break
+		}
+	}
+	defer part.Close()
+
+	if err := a.Svc.UpdateFile(file, part, sent.CheckSum); err != nil {
+		if errors.Is(err, svc.ErrNoChecksum) {
+			a.clientError(w, err.Error())
+		} else if errors.Is(err, svc.ErrChecksumMismatch) {
+			a.checksumError(w, err.Error())
+		} else if errors.Is(err, svc.ErrQuotaExceeded) {
+			a.quotaError(w, err.Error())
+		} else {
+			a.serverError(w, fmt.Sprintf("failed to update '%s' (id=%s): %v", file.Name, file.ID, err))
+		}
+		return
+	}
+	a.attribute(r, file)
+	a.write(w, fmt.Sprintf("'%s' updated (owner id=%s)", file.Name, file.OwnerID))
+}
+
+// upload or update a file on/to the server
+func (a *API) PutFile(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut { // update the file
+		file, err := a.getFileFromRequest(r)
+		if err != nil {
+			a.serverError(w, err.Error())
+			return
+		}
+		a.putFile(w, r, file)
+	} else if r.Method == http.MethodPost { // create a new file.
+		file, err := a.getNewFileFromRequest(r)
+		if err != nil {
+			a.serverError(w, err.Error())
+			return
+		}
+		a.newFile(w, r, file)
+	} else if r.Method == http.MethodOptions {
+		a.log.Info("pre-flight CORS request received")
+		return
+	}
+}
+
+// get a block signature of the server's copy of a file.
+// used by clients to build a delta before pushing updates.
+func (a *API) GetFileSignature(w http.ResponseWriter, r *http.Request) {
+	file, err := a.getFileFromRequest(r)
+	if err != nil {
+		if strings.Contains(err.Error(), "file") {
+			a.clientError(w, err.Error())
+		} else {
+			a.serverError(w, err.Error())
+		}
+		return
+	}
+	sig, err := a.Svc.GetFileSignature(file)
+	if err != nil {
+		a.serverError(w, err.Error())
+		return
+	}
+	data, err := sig.ToJSON()
+	if err != nil {
+		a.serverError(w, "failed to convert to JSON: "+err.Error())
+		return
+	}
+	a.write(w, string(data))
+}
+
+// rough size of a single JSON encoded delta op, and of
+// everything else in a delta, not counting block data
+const (
+	deltaOpSize     = 64
+	deltaHeaderSize = 1 << 10
+)
+
+// largest delta body accepted for a file. a delta can't rebuild anything
+// bigger than the file plus its drive's free space, so the cap is that much
+// base64 encoded data plus a couple of ops per block.
+func (a *API) maxDeltaSize(file *svc.File) (int64, error) {
+	drive, err := a.Svc.updateUsage(file.DriveID)
+	if err != nil {
+		return 0, err
+	}
+	room := file.Size + max(drive.TotalSize-drive.UsedSpace, 0)
+	blocks := room/svc.BLOCK_SIZE + 1
+	return (room+2)/3*4 + 2*blocks*deltaOpSize + deltaHeaderSize, nil
+}
+
+// update a file on the server using a block-level delta
+func (a *API) PutFileDelta(w http.ResponseWriter, r *http.Request) {
+	file, err := a.getFileFromRequest(r)
+	if err != nil {
+		if strings.Contains(err.Error(), "file") {
+			a.clientError(w, err.Error())
+		} else {
+			a.serverError(w, err.Error())
+		}
+		return
+	}
+	limit, err := a.maxDeltaSize(file)
+	if err != nil {
+		a.serverError(w, err.Error())
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, r.Body); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			a.tooLargeError(w, fmt.Sprintf("delta for '%s' (id=%s) is larger than %d bytes", file.Name, file.ID, limit))
+		} else {
+			a.serverError(w, "failed to read request body: "+err.Error())
+		}
+		return
+	}
+	delta, err := svc.UnmarshalDelta(buf.Bytes())
+	if err != nil {
+		a.clientError(w, "failed to unmarshal delta: "+err.Error())
+		return
+	}
+	if delta.FileID != "" && delta.FileID != file.ID {
+		a.clientError(w, fmt.Sprintf("delta file id (%s) does not match file (id=%s)", delta.FileID, file.ID))
+		return
+	}
+	if err := a.Svc.UpdateFileDelta(file, delta); err != nil {
+		if errors.Is(err, svc.ErrChecksumMismatch) {
+			a.checksumError(w, err.Error())
+		} else if errors.Is(err, svc.ErrQuotaExceeded) {
+			a.quotaError(w, err.Error())
+		} else {
+			a.serverError(w, fmt.Sprintf("failed to update '%s' (id=%s): %v", file.Name, file.ID, err))
+		}
+		return
+	}
+	a.attribute(r, file)
+	a.write(w, fmt.Sprintf("'%s' updated (owner id=%s)", file.Name, file.OwnerID))
+}
+
+// move and/or rename a file on the server.
+// the destination directory and new name are set with ?dir= and ?name=
+func (a *API) MoveFile(w http.ResponseWriter, r *http.Request) {
+	file, err := a.getFileFromRequest(r)
+	if err != nil {
+		if errors.Is(err, ErrFileNotFound) {
+			a.notFoundError(w, err.Error())
+		} else {
+			a.serverError(w, err.Error())
+		}
+		return
+	}
+	dirID, name := r.URL.Query().Get("dir"), r.URL.Query().Get("name")
+	if dirID == "" && name == "" {
+		a.clientError(w, "no destination directory or new name specified")
+		return
+	}
+	if dirID == "" {
+		dirID = file.DirID
+	}
+	if name != "" && !svc.ValidName(name) {
+		a.clientError(w, fmt.Sprintf("invalid file name: %s", name))
+		return
+	}
+	if err := a.Svc.MoveFile(file, dirID, name); err != nil {
+		if errors.Is(err, ErrPathTaken) {
+			a.conflictError(w, err.Error())
+		} else if errors.Is(err, ErrInvalidName) {
+			a.clientError(w, err.Error())
+		} else {
+			a.serverError(w, "failed to move file: "+err.Error())
+		}
+		return
+	}
+	a.attribute(r, file)
+	a.write(w, fmt.Sprintf("'%s' (id=%s) moved", file.Name, file.ID))
+}
+
+// delete a file from the server
+func (a *API) DeleteFile(w http.ResponseWriter, r *http.Request) {
+	file, err := a.getFileFromRequest(r)
 	if err != nil {
-		if strings.Contains(err.Error(), "file") { // not found or missing ID errors
+		if errors.Is(err, ErrFileNotFound) {
 			a.clientError(w, err.Error())
 		} else {
 			a.serverError(w, err.Error())
 		}
 		return
 	}
-	file, err := a.Svc.GetFile(f.DriveID, f.ID)
-	if err != nil {
-		a.serverError(w, err.Error())
+	// remove file from SFS server service instance. the device the
+	// request came from is recorded in the file's tombstone.
+	if err := a.Svc.DeleteFile(file, a.deviceName(r)); err != nil {
+		a.serverError(w, "failed to delete file: "+err.Error())
 		return
 	}
-
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", file.Name))
-	w.Header().Set("Content-Type", "application/octet-stream")
-
-	http.ServeFile(w, r, file.ServerPath)
-	a.log.Info(fmt.Sprintf("served file %s: %s", file.Name, file.ServerPath))
+	a.write(w, fmt.Sprintf("'%s' (id=%s) deleted", file.Name, file.ID))
 }
 
-// get json blobs of all files available on the server for a user.
-// only sends metadata, not the actual files.
-func (a *API) GetAllFileInfo(w http.ResponseWriter, r *http.Request) {
-	user, err := a.getUserFromRequest(r)
+// ------- chunked uploads --------------------------------
+
+// used by functions working with existing upload sessions.
+// the session must belong to the requested file.
+func (a *API) getUploadFromRequest(r *http.Request) (*svc.File, *svc.UploadSession, error) {
+	file, err := a.getFileFromRequest(r)
 	if err != nil {
-		if strings.Contains(err.Error(), "user") {
-			a.clientError(w, err.Error())
-		} else {
-			a.serverError(w, err.Error())
-		}
-		return
+		return nil, nil, err
 	}
-	files, err := a.Svc.GetAllFiles(user.DriveID)
+	uploadID := r.Context().Value(Upload).(string)
+	if uploadID == "" {
+		return nil, nil, fmt.Errorf("no upload ID found in request")
+	}
+	u, err := a.Svc.GetUpload(uploadID)
 	if err != nil {
+		return nil, nil, err
+	}
+	if u.FileID != file.ID {
+		return nil, nil, fmt.Errorf("%w (id=%s) for file (id=%s)", ErrUploadNotFound, uploadID, file.ID)
+	}
+	return file, u, nil
+}
+
+// send the response for an error from an upload request
+func (a *API) uploadError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrFileNotFound), errors.Is(err, ErrUploadNotFound):
+		a.notFoundError(w, err.Error())
+	case errors.Is(err, ErrBadOffset), errors.Is(err, ErrUploadIncomplete), errors.Is(err, svc.ErrNoChecksum):
+		a.clientError(w, err.Error())
+	case errors.Is(err, svc.ErrChecksumMismatch):
+		a.checksumError(w, err.Error())
+	case errors.Is(err, svc.ErrQuotaExceeded):
+		a.quotaError(w, err.Error())
+	default:
 		a.serverError(w, err.Error())
-		return
 	}
-	for _, file := range files {
-		data, err := file.ToJSON()
-		if err != nil {
-			a.serverError(w, fmt.Sprintf("failed to convert %s (id=%s) to JSON: %v", file.Name, file.ID, err))
-			return
-		}
-		w.Write(data)
+}
+
+func (a *API) writeUpload(w http.ResponseWriter, u *svc.UploadSession) {
+	data, err := u.ToJSON()
+	if err != nil {
+		a.serverError(w, "failed to convert to JSON: "+err.Error())
+		return
 	}
+	w.Write(data)
 }
 
-// add initial file metadata to the server. creates an empty files,
-// does not create file contents, though svc.AddFile() does attempt
-// to write out the data. This will be remidied in a future version.
-func (a *API) newFile(w http.ResponseWriter, r *http.Request, newFile *svc.File) {
-	f, err := a.Svc.Db.GetFileByID(newFile.ID)
+// start a chunked upload for a file, or resume an existing one.
+// the total size and expected checksum of the file are set with ?size= and ?checksum=
+// and both are required.
+func (a *API) NewUpload(w http.ResponseWriter, r *http.Request) {
+	file, err := a.getFileFromRequest(r)
 	if err != nil {
-		a.serverError(w, err.Error())
+		a.uploadError(w, err)
 		return
 	}
-	if f != nil {
-		a.clientError(w, fmt.Sprintf("file (name=%s id=%s) already exists", newFile.Name, newFile.ID))
+	size, err := strconv.ParseInt(r.URL.Query().Get("size"), 10, 64)
+	if err != nil || size < 0 {
+		a.clientError(w, fmt.Sprintf("invalid upload size: '%s'", r.URL.Query().Get("size")))
 		return
 	}
-	if err := a.Svc.AddFile(newFile.DirID, newFile); err != nil {
-		a.serverError(w, fmt.Sprintf("failed to add %s to service: %v", newFile.Name, err))
+	u, err := a.Svc.NewUpload(file, size, r.URL.Query().Get("checksum"))
+	if err != nil {
+		a.uploadError(w, fmt.Errorf("failed to start upload for '%s' (id=%s): %w", file.Name, file.ID, err))
 		return
 	}
-	a.write(w, fmt.Sprintf("file (%s) has been added to the server", newFile.Name))
+	a.writeUpload(w, u)
 }
 
-// update the file on the server
-func (a *API) putFile(w http.ResponseWriter, r *http.Request, file *svc.File) {
-	f, _, err := r.FormFile("myFile")
+// get an upload session, including the byte ranges received so far
+func (a *API) GetUpload(w http.ResponseWriter, r *http.Request) {
+	_, u, err := a.getUploadFromRequest(r)
 	if err != nil {
-		a.serverError(w, "failed to retrieve form file: "+err.Error())
+		a.uploadError(w, err)
 		return
 	}
+	a.writeUpload(w, u)
+}
 
-	var buf bytes.Buffer
-	_, err = io.Copy(&buf, f)
+// receive a chunk of a file. the request body is the raw chunk data,
+// and its position in the file is set with ?offset=
+// responds with the updated upload session.
+func (a *API) PutChunk(w http.ResponseWriter, r *http.Request) {
+	_, u, err := a.getUploadFromRequest(r)
 	if err != nil {
-		a.serverError(w, "failed to copy file: "+err.Error())
+		a.uploadError(w, err)
 		return
 	}
-	if err := f.Close(); err != nil {
-		a.serverError(w, "failed to close form file: "+err.Error())
+	offset, err := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+	if err != nil {
+		a.clientError(w, fmt.Sprintf("invalid offset: '%s'", r.URL.Query().Get("offset")))
 		return
 	}
-
-	if err := a.Svc.UpdateFile(file, buf.Bytes()); err != nil {
-		a.serverError(w, fmt.Sprintf("failed to update '%s' (id=%s): %v", file.Name, file.ID, err))
+	if err := a.Svc.WriteChunk(u, offset, r.Body); err != nil {
+		a.uploadError(w, err)
 		return
 	}
-	a.write(w, fmt.Sprintf("'%s' updated (owner id=%s)", file.Name, file.OwnerID))
+	a.writeUpload(w, u)
 }
 
-// upload or update a file on/to the server
-func (a *API) PutFile(w http.ResponseWriter, r *http.Request) {
-	if r.Method == http.MethodPut { // update the file
-		file, err := a.getFileFromRequest(r)
-		if err != nil {
-			a.serverError(w, err.Error())
-			return
-		}
-		a.putFile(w, r, file)
-	} else if r.Method == http.MethodPost { // create a new file.
-		file, err := a.getNewFileFromRequest(r)
-		if err != nil {
-			a.serverError(w, err.Error())
-			return
-		}
-		a.newFile(w, r, file)
-	} else if r.Method == http.MethodOptions {
-		a.log.Info("pre-flight CORS request received")
+// finish an upload and replace the server's copy of the file.
+// the checksum of the completed file is set with ?checksum=
+func (a *API) CommitUpload(w http.ResponseWriter, r *http.Request) {
+	file, u, err := a.getUploadFromRequest(r)
+	if err != nil {
+		a.uploadError(w, err)
 		return
 	}
+	if err := a.Svc.CommitUpload(u, file, r.URL.Query().Get("checksum")); err != nil {
+		a.uploadError(w, fmt.Errorf("failed to update '%s' (id=%s): %w", file.Name, file.ID, err))
+		return
+	}
+	a.attribute(r, file)
+	a.write(w, fmt.Sprintf("'%s' updated (owner id=%s)", file.Name, file.OwnerID))
 }
 
-// delete a file from the server
-func (a *API) DeleteFile(w http.ResponseWriter, r *http.Request) {
-	file, err := a.getFileFromRequest(r)
+// cancel an upload
+func (a *API) CancelUpload(w http.ResponseWriter, r *http.Request) {
+	file, u, err := a.getUploadFromRequest(r)
 	if err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			a.clientError(w, err.Error())
-		} else {
-			a.serverError(w, err.Error())
-		}
+		a.uploadError(w, err)
 		return
 	}
-	// remove file from SFS server service instance
-	if err := a.Svc.DeleteFile(file); err != nil {
-		a.serverError(w, "failed to delete file: "+err.Error())
+	if err := a.Svc.RemoveUpload(u); err != nil {
+		a.serverError(w, "failed to cancel upload: "+err.Error())
 		return
 	}
-	a.write(w, fmt.Sprintf("'%s' (id=%s) deleted", file.Name, file.ID))
+	a.write(w, fmt.Sprintf("upload (id=%s) for '%s' cancelled", u.ID, file.Name))
 }
 
 // ------- directories --------------------------------
@@ -543,20 +1527,23 @@ func (a *API) GetDir(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// create a tmp .zip file so we can transfer the directory and its contents
-	archive := filepath.Join(dir.ServerPath, dir.Name+".zip")
-	if err := transfer.Zip(dir.ServerPath, archive); err != nil {
+	// build the archive in a temp file first so errors
+	// can still be reported before anything is sent
+	tmp, err := os.CreateTemp("", "sfs-dir-*.zip")
+	if err != nil {
+		a.serverError(w, fmt.Sprintf("failed to create archive: %v", err))
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if err := a.Svc.ArchiveDir(dir, tmp); err != nil {
 		a.serverError(w, fmt.Sprintf("failed to compress directory: %v", err))
 		return
 	}
 
-	// send archive file
-	http.ServeFile(w, r, archive)
-
-	// remove tmp archive file
-	if err := os.Remove(archive); err != nil {
-		a.log.Error(fmt.Sprintf("failed to remove temp archive %s: %v", archive, err))
-	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.zip", dir.Name))
+	w.Header().Set("Content-Type", "application/zip")
+	http.ServeContent(w, r, dir.Name+".zip", time.Now(), tmp)
 }
 
 // update the directory on the server
@@ -594,7 +1581,11 @@ func (a *API) NewDir(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if err := a.Svc.NewDir(newDir.DriveID, newDir.ParentID, newDir); err != nil {
-		a.serverError(w, fmt.Sprintf("failed to create directory: %v", err))
+		if errors.Is(err, ErrInvalidName) {
+			a.clientError(w, fmt.Sprintf("failed to create directory: %v", err))
+		} else {
+			a.serverError(w, fmt.Sprintf("failed to create directory: %v", err))
+		}
 		return
 	}
 	a.write(w, fmt.Sprintf("directory %s (id=%s) created successfully", newDir.Name, newDir.ID))
@@ -611,13 +1602,77 @@ func (a *API) DeleteDir(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
-	if err := a.Svc.RemoveDir(dir.DriveID, dir.ID); err != nil {
+	if err := a.Svc.RemoveDir(dir.DriveID, dir.ID, a.deviceName(r)); err != nil {
 		a.serverError(w, fmt.Sprintf("failed to remove directory: %v", err))
 		return
 	}
 	a.write(w, fmt.Sprintf("directory %s (id=%s) deleted", dir.Name, dir.ID))
 }
 
+// -------- file versions --------------------------------
+
+// list the previous versions of a file, oldest first
+func (a *API) GetFileVersions(w http.ResponseWriter, r *http.Request) {
+	file, err := a.getFileFromRequest(r)
+	if err != nil {
+		if errors.Is(err, ErrFileNotFound) {
+			a.notFoundError(w, err.Error())
+		} else {
+			a.serverError(w, err.Error())
+		}
+		return
+	}
+	versions, err := a.Svc.GetVersions(file)
+	if err != nil {
+		a.serverError(w, err.Error())
+		return
+	}
+	data, err := json.MarshalIndent(versions, "", "  ")
+	if err != nil {
+		a.serverError(w, "failed to convert to JSON: "+err.Error())
+		return
+	}
+	w.Write(data)
+}
+
+// download a previous version of a file
+func (a *API) ServeFileVersion(w http.ResponseWriter, r *http.Request) {
+	file, err := a.getFileFromRequest(r)
+	if err != nil {
+		if errors.Is(err, ErrFileNotFound) {
+			a.notFoundError(w, err.Error())
+		} else {
+			a.serverError(w, err.Error())
+		}
+		return
+	}
+	n, err := strconv.ParseInt(r.Context().Value(Version).(string), 10, 64)
+	if err != nil {
+		a.clientError(w, "invalid version: "+err.Error())
+		return
+	}
+	v, err := a.Svc.GetVersion(file, n)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			a.notFoundError(w, err.Error())
+		} else {
+			a.serverError(w, err.Error())
+		}
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", v.Name))
+	w.Header().Set("Content-Type", "application/octet-stream")
+
+	path, err := a.Svc.ContentPath(v.Path)
+	if err != nil {
+		a.serverError(w, err.Error())
+		return
+	}
+	http.ServeFile(w, r, path)
+	a.log.Info(fmt.Sprintf("served version %d of file %s", v.Number, v.Name))
+}
+
 // -------- drives --------------------------------
 
 func (a *API) getDriveIDFromRequest(r *http.Request) (string, error) {
@@ -668,6 +1723,135 @@ func (a *API) GetDrive(w http.ResponseWriter, r *http.Request) {
 	w.Write(data)
 }
 
+// list the top level of a drive, along with the user's "Shared with me" directory
+func (a *API) ListDrive(w http.ResponseWriter, r *http.Request) {
+	driveID, err := a.getDriveIDFromRequest(r)
+	if err != nil {
+		a.clientError(w, err.Error())
+		return
+	}
+	listing, err := a.Svc.ListDrive(driveID, requester(r).ID)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			a.notFoundError(w, err.Error())
+		} else {
+			a.serverError(w, err.Error())
+		}
+		return
+	}
+	data, err := json.MarshalIndent(listing, "", "  ")
+	if err != nil {
+		a.serverError(w, "failed to convert to JSON: "+err.Error())
+		return
+	}
+	w.Write(data)
+}
+
+// get a drive's version retention policy
+func (a *API) GetRetention(w http.ResponseWriter, r *http.Request) {
+	drive, err := a.getDriveFromRequest(r)
+	if err != nil {
+		a.notFoundError(w, err.Error())
+		return
+	}
+	p, err := a.Svc.GetRetention(drive.ID)
+	if err != nil {
+		a.serverError(w, err.Error())
+		return
+	}
+	data, err := p.ToJSON()
+	if err != nil {
+		a.serverError(w, "failed to convert to JSON: "+err.Error())
+		return
+	}
+	w.Write(data)
+}
+
+// set a drive's version retention policy with ?keep_last=, ?daily=, and ?monthly=
+// any values that aren't set are left as they are.
+func (a *API) SetRetention(w http.ResponseWriter, r *http.Request) {
+	drive, err := a.getDriveFromRequest(r)
+	if err != nil {
+		a.notFoundError(w, err.Error())
+		return
+	}
+	p, err := a.Svc.GetRetention(drive.ID)
+	if err != nil {
+		a.serverError(w, err.Error())
+		return
+	}
+	for param, val := range map[string]*int{"keep_last": &p.KeepLast, "daily": &p.Daily, "monthly": &p.Monthly} {
+		if v := r.URL.Query().Get(param); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				a.clientError(w, fmt.Sprintf("invalid %s value: %v", param, err))
+				return
+			}
+			*val = n
+		}
+	}
+	if err := a.Svc.SetRetention(p); err != nil {
+		a.clientError(w, err.Error())
+		return
+	}
+	data, err := p.ToJSON()
+	if err != nil {
+		a.serverError(w, "failed to convert to JSON: "+err.Error())
+		return
+	}
+	w.Write(data)
+}
+
+// get a drive's space usage, broken down by directory
+func (a *API) GetDriveUsage(w http.ResponseWriter, r *http.Request) {
+	drive, err := a.getDriveFromRequest(r)
+	if err != nil {
+		a.notFoundError(w, err.Error())
+		return
+	}
+	usage, err := a.Svc.GetUsage(drive.ID)
+	if err != nil {
+		a.serverError(w, err.Error())
+		return
+	}
+	data, err := usage.ToJSON()
+	if err != nil {
+		a.serverError(w, "failed to convert to JSON: "+err.Error())
+		return
+	}
+	w.Write(data)
+}
+
+// get a drive's files as they were at the time given by ?at= (RFC3339).
+// files listed with a version number can be downloaded from
+// /v1/files/{fileID}/versions/{version}, otherwise from /v1/files/{fileID}
+func (a *API) GetDriveSnapshot(w http.ResponseWriter, r *http.Request) {
+	drive, err := a.getDriveFromRequest(r)
+	if err != nil {
+		a.notFoundError(w, err.Error())
+		return
+	}
+	at := time.Now().UTC()
+	if v := r.URL.Query().Get("at"); v != "" {
+		at, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			a.clientError(w, fmt.Sprintf("invalid at value: %v", err))
+			return
+		}
+	}
+	snapshot, err := a.Svc.DriveAt(drive.ID, at)
+	if err != nil {
+		a.serverError(w, err.Error())
+		return
+	}
+	data, err := snapshot.ToJSON()
+	if err != nil {
+		a.serverError(w, "failed to convert to JSON: "+err.Error())
+		return
+	}
+	w.Write(data)
+}
+
 // add a new drive to the server. used as part of a separate registration process.
 func (a *API) NewDrive(w http.ResponseWriter, r *http.Request) {
 	newDrive, err := a.getNewDriveFromRequest(r)
@@ -746,6 +1930,45 @@ func (a *API) GetIdx(w http.ResponseWriter, r *http.Request) {
 	w.Write(data)
 }
 
+// retrieves all file changes for a drive after a given revision.
+// expects a ?since=<revision> query parameter. if not provided,
+// all changes are returned.
+func (a *API) GetChanges(w http.ResponseWriter, r *http.Request) {
+	drv, err := a.getDriveFromRequest(r)
+	if err != nil {
+		a.serverError(w, err.Error())
+		return
+	}
+	if drv == nil {
+		a.clientError(w, "drive not found")
+		return
+	}
+	var since int64
+	if sinceStr := r.URL.Query().Get("since"); sinceStr != "" {
+		since, err = strconv.ParseInt(sinceStr, 10, 64)
+		if err != nil || since < 0 {
+			a.clientError(w, fmt.Sprintf("invalid revision: %s", sinceStr))
+			return
+		}
+	}
+	changes, err := a.Svc.GetChanges(drv.ID, since)
+	if err != nil {
+		a.serverError(w, err.Error())
+		return
+	}
+	if device := requestDevice(r); device != nil {
+		if err := a.Svc.deviceSynced(device, changes.Revision); err != nil {
+			a.log.Error(fmt.Sprintf("failed to update device (id=%s): %v", device.ID, err))
+		}
+	}
+	data, err := changes.ToJSON()
+	if err != nil {
+		a.serverError(w, fmt.Sprintf("failed to encode changes: %v", err))
+		return
+	}
+	w.Write(data)
+}
+
 // refreshes the server side Update map for this drives sync index.
 // drives must already have been indexed prior to calling this endpoint.
 func (a *API) GetUpdates(w http.ResponseWriter, r *http.Request) {
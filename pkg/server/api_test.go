@@ -2,20 +2,29 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/http/httptest"
 	"net/http/httputil"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/sfs/pkg/auth"
 	"github.com/sfs/pkg/env"
+	"github.com/sfs/pkg/logger"
 	svc "github.com/sfs/pkg/service"
 	"github.com/sfs/pkg/transfer"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/go-chi/chi/v5"
 )
 
 const LocalHost = "http://localhost:8080"
@@ -506,3 +515,799 @@ func TestGetServerSyncIndex(t *testing.T) {
 		log.Fatal(err)
 	}
 }
+
+func TestLogin(t *testing.T) {
+	env.SetEnv(false)
+
+	testRoot := getTestingDir()
+	testSvc, err := SetUpService(testRoot)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	api := &API{Svc: testSvc, log: logger.NewLogger("API", "None")}
+
+	testUsr := auth.NewUser("bill buttlicker", "billBB", "bill@bill.com", svcCfg.SvcRoot, false)
+	if err := testUsr.SetPassword("hunter2"); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	if err := testSvc.AddUser(testUsr); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+
+	login := func(creds auth.Credentials) *httptest.ResponseRecorder {
+		data, err := creds.ToJSON()
+		if err != nil {
+			Fail(t, filepath.Dir(testRoot), err)
+		}
+		w := httptest.NewRecorder()
+		api.Login(w, httptest.NewRequest(http.MethodPost, "/v1/auth/login", bytes.NewReader(data)))
+		return w
+	}
+
+	// log in with the user's ID, then their user name
+	w := login(auth.Credentials{UserID: testUsr.ID, Password: "hunter2"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	session, err := auth.UnmarshalSession(w.Body.Bytes())
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	tok, err := sessionToken(testSvc.SvcRoot)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	userID, sessionID, err := tok.VerifyAccess(session.Token)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	// access tokens aren't signed with the payload secret clients have
+	_, _, err = auth.NewT().VerifyAccess(session.Token)
+	assert.Error(t, err)
+	assert.Equal(t, testUsr.ID, userID)
+	assert.Equal(t, session.ID, sessionID)
+	assert.NotEqual(t, "", session.RefreshToken)
+
+	w = login(auth.Credentials{UserName: testUsr.UserName, Password: "hunter2"})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// wrong passwords and unknown users are rejected
+	w = login(auth.Credentials{UserID: testUsr.ID, Password: "hunter3"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = login(auth.Credentials{UserName: "not-a-user", Password: "hunter2"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	if err := Clean(filepath.Dir(testRoot)); err != nil {
+		t.Errorf("[ERROR] unable to remove test directories: %v", err)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	env.SetEnv(false)
+
+	testRoot := getTestingDir()
+	testSvc, err := SetUpService(testRoot)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	api := &API{Svc: testSvc, log: logger.NewLogger("API", "None")}
+
+	// the auth middleware looks sessions and users up in the configured service's databases
+	svcRoot := svcCfg.SvcRoot
+	svcCfg.SvcRoot = testSvc.SvcRoot
+	defer func() { svcCfg.SvcRoot = svcRoot }()
+
+	testUsr := auth.NewUser("bill buttlicker", "billBB", "bill@bill.com", svcCfg.SvcRoot, false)
+	if err := testUsr.SetPassword("hunter2"); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	if err := testSvc.AddUser(testUsr); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+
+	// send a request through the auth middleware with a session's access token
+	send := func(handler http.HandlerFunc, method string, session *auth.Session, body any) *httptest.ResponseRecorder {
+		data, err := json.Marshal(body)
+		if err != nil {
+			Fail(t, filepath.Dir(testRoot), err)
+		}
+		r := httptest.NewRequest(method, "/", bytes.NewReader(data))
+		if session != nil {
+			r.Header.Set("Authorization", "Bearer "+session.Token)
+		}
+		w := httptest.NewRecorder()
+		AuthUserHandler(handler).ServeHTTP(w, r)
+		return w
+	}
+	refresh := func(token string) *httptest.ResponseRecorder {
+		data, err := json.Marshal(map[string]string{"refresh_token": token})
+		if err != nil {
+			Fail(t, filepath.Dir(testRoot), err)
+		}
+		w := httptest.NewRecorder()
+		api.Refresh(w, httptest.NewRequest(http.MethodPost, "/v1/auth/refresh", bytes.NewReader(data)))
+		return w
+	}
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+
+	session, err := testSvc.NewSession(testUsr.ID)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	assert.Equal(t, http.StatusOK, send(ok, http.MethodGet, session, nil).Code)
+
+	// refresh tokens are rotated, and can only be used once
+	w := refresh(session.RefreshToken)
+	assert.Equal(t, http.StatusOK, w.Code)
+	renewed, err := auth.UnmarshalSession(w.Body.Bytes())
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	assert.Equal(t, session.ID, renewed.ID)
+	assert.NotEqual(t, session.RefreshToken, renewed.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, refresh(session.RefreshToken).Code)
+	assert.Equal(t, http.StatusUnauthorized, refresh("not-a-token").Code)
+	assert.Equal(t, http.StatusOK, send(ok, http.MethodGet, renewed, nil).Code)
+
+	// logging out revokes both of the session's tokens
+	assert.Equal(t, http.StatusOK, send(api.Logout, http.MethodPost, renewed, nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, send(ok, http.MethodGet, renewed, nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, refresh(renewed.RefreshToken).Code)
+
+	// changing passwords revokes every session and starts a new one
+	s1, err := testSvc.NewSession(testUsr.ID)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	s2, err := testSvc.NewSession(testUsr.ID)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	w = send(api.ChangePassword, http.MethodPut, s1, auth.Credentials{Password: "wrong", NewPassword: "hunter3"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = send(api.ChangePassword, http.MethodPut, s1, auth.Credentials{Password: "hunter2", NewPassword: "hunter3"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	s3, err := auth.UnmarshalSession(w.Body.Bytes())
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	for _, s := range []*auth.Session{s1, s2} {
+		assert.Equal(t, http.StatusUnauthorized, send(ok, http.MethodGet, s, nil).Code)
+		assert.Equal(t, http.StatusUnauthorized, refresh(s.RefreshToken).Code)
+	}
+	assert.Equal(t, http.StatusOK, send(ok, http.MethodGet, s3, nil).Code)
+
+	user, err := testSvc.Db.GetUser(testUsr.ID)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	assert.True(t, user.CheckPassword("hunter3"))
+
+	if err := Clean(filepath.Dir(testRoot)); err != nil {
+		t.Errorf("[ERROR] unable to remove test directories: %v", err)
+	}
+}
+
+func TestDevices(t *testing.T) {
+	env.SetEnv(false)
+
+	testRoot := getTestingDir()
+	testSvc, err := SetUpService(testRoot)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	api := &API{Svc: testSvc, log: logger.NewLogger("API", "None")}
+
+	// the auth middleware looks devices and users up in the configured service's databases
+	svcRoot := svcCfg.SvcRoot
+	svcCfg.SvcRoot = testSvc.SvcRoot
+	defer func() { svcCfg.SvcRoot = svcRoot }()
+
+	testDrv := MakeEmptyTmpDrive(t)
+	if err := testSvc.AddDrive(testDrv); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	testUsr := auth.NewUser("bill buttlicker", "billBB", "bill@bill.com", svcCfg.SvcRoot, false)
+	testUsr.ID = testDrv.OwnerID
+	if err := testSvc.AddUser(testUsr); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	session, err := testSvc.NewSession(testUsr.ID)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+
+	// send a request through the auth middleware with either an access token or a device key
+	send := func(handler http.HandlerFunc, method string, target string, token string, key string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		if key != "" {
+			r.Header.Set(auth.DeviceKeyHeader, key)
+		}
+		w := httptest.NewRecorder()
+		AuthUserHandler(handler).ServeHTTP(w, r)
+		return w
+	}
+
+	// users can't register the same device twice
+	w := send(api.RegisterDevice, http.MethodPost, "/v1/devices/new?name=laptop", session.Token, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	device, err := auth.UnmarshalDevice(w.Body.Bytes())
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	assert.NotEqual(t, "", device.Key)
+	w = send(api.RegisterDevice, http.MethodPost, "/v1/devices/new?name=laptop", session.Token, "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// device keys can be used instead of access tokens
+	var fromDevice *auth.Device
+	w = send(func(w http.ResponseWriter, r *http.Request) {
+		fromDevice = requestDevice(r)
+		api.GetDevices(w, r)
+	}, http.MethodGet, "/v1/devices", "", device.Key)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEqual(t, nil, fromDevice)
+	assert.Equal(t, device.ID, fromDevice.ID)
+	var devices []*auth.Device
+	if err := json.Unmarshal(w.Body.Bytes(), &devices); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	assert.Equal(t, 1, len(devices))
+	assert.Equal(t, "", devices[0].Key)
+
+	// changes made from a device are attributed to it
+	srcPath := filepath.Join(t.TempDir(), "file.txt")
+	if err := os.WriteFile(srcPath, []byte("some data"), svc.PERMS); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	file := svc.NewFile("file.txt", testDrv.ID, testDrv.OwnerID, srcPath)
+	if err := testSvc.AddFile(testDrv.RootID, file); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	if err := testSvc.attributeChange(fromDevice, file); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	rev, err := testSvc.Db.GetRevision(file.ID)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	assert.Equal(t, "laptop", rev.Device)
+	saved, err := testSvc.GetDevice(device.ID)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	assert.Equal(t, rev.Revision, saved.LastRevision)
+
+	// other users can't see or revoke the device
+	otherUsr := auth.NewUser("some guy", "someGuy", "guy@guy.com", svcCfg.SvcRoot, false)
+	if err := testSvc.AddUser(otherUsr); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	other, err := testSvc.NewSession(otherUsr.ID)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	revoke := func(token string) *httptest.ResponseRecorder {
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("deviceID", device.ID)
+		r := httptest.NewRequest(http.MethodDelete, "/v1/devices/"+device.ID, nil)
+		r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		AuthUserHandler(DeviceCtx(http.HandlerFunc(api.RevokeDevice))).ServeHTTP(w, r)
+		return w
+	}
+	assert.Equal(t, http.StatusForbidden, revoke(other.Token).Code)
+
+	// revoked devices can't be used anymore
+	assert.Equal(t, http.StatusOK, revoke(session.Token).Code)
+	w = send(api.GetDevices, http.MethodGet, "/v1/devices", "", device.Key)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	if err := Clean(filepath.Dir(testRoot)); err != nil {
+		t.Errorf("[ERROR] unable to remove test directories: %v", err)
+	}
+}
+
+func TestAdminRoutes(t *testing.T) {
+	env.SetEnv(false)
+
+	testRoot := getTestingDir()
+	testSvc, err := SetUpService(testRoot)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	api := &API{Svc: testSvc, log: logger.NewLogger("API", "None")}
+
+	// the auth middleware looks users up in the configured service's databases
+	svcRoot := svcCfg.SvcRoot
+	svcCfg.SvcRoot = testSvc.SvcRoot
+	defer func() { svcCfg.SvcRoot = svcRoot }()
+
+	admin := auth.NewUser("bill buttlicker", "billBB", "bill@bill.com", svcCfg.SvcRoot, true)
+	if err := testSvc.AddUser(admin); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	adminSession, err := testSvc.NewSession(admin.ID)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	testDrv := MakeEmptyTmpDrive(t)
+	if err := testSvc.AddDrive(testDrv); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+
+	router := adminRouter(api)
+	send := func(method string, target string, token string, body any) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		if body != nil {
+			if err := json.NewEncoder(&buf).Encode(body); err != nil {
+				Fail(t, filepath.Dir(testRoot), err)
+			}
+		}
+		r := httptest.NewRequest(method, target, &buf)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	// add a read-only user
+	info := auth.UserInfo{Name: "some guy", UserName: "someGuy", Email: "guy@guy.com", Password: "hunter2", Role: "read-only"}
+	w := send(http.MethodPost, "/users", adminSession.Token, info)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var user auth.User
+	if err := json.Unmarshal(w.Body.Bytes(), &user); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	assert.Equal(t, auth.RoleReadOnly, user.Role)
+	assert.Equal(t, "", user.Password)
+
+	// user names have to be unique, and roles have to be valid
+	assert.Equal(t, http.StatusBadRequest, send(http.MethodPost, "/users", adminSession.Token, info).Code)
+	info.UserName, info.Role = "someOtherGuy", "superuser"
+	assert.Equal(t, http.StatusBadRequest, send(http.MethodPost, "/users", adminSession.Token, info).Code)
+
+	// non-admins can't use any admin routes
+	session, err := testSvc.NewSession(user.ID)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	assert.Equal(t, http.StatusForbidden, send(http.MethodGet, "/users", session.Token, nil).Code)
+	assert.Equal(t, http.StatusForbidden, send(http.MethodGet, "/drives", session.Token, nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, send(http.MethodGet, "/users", "", nil).Code)
+
+	w = send(http.MethodGet, "/users", adminSession.Token, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var users []*auth.User
+	if err := json.Unmarshal(w.Body.Bytes(), &users); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	assert.Equal(t, 2, len(users))
+
+	// changing a user's role ends their sessions
+	w = send(http.MethodPut, "/users/"+user.ID, adminSession.Token, auth.UserInfo{Role: "user", Email: "guy@guy.net"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	updated, err := testSvc.Db.GetUser(user.ID)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	assert.Equal(t, auth.RoleUser, updated.Role)
+	assert.Equal(t, "guy@guy.net", updated.Email)
+	assert.Equal(t, http.StatusUnauthorized, send(http.MethodGet, "/users", session.Token, nil).Code)
+
+	// regular users can't change quotas, including their own
+	session, err = testSvc.NewSession(user.ID)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	assert.Equal(t, http.StatusForbidden, send(http.MethodPut, "/users/"+user.ID+"/quota?size=1000000", session.Token, nil).Code)
+	assert.Equal(t, http.StatusForbidden, send(http.MethodPut, "/users/"+admin.ID+"/quota?size=1000000", session.Token, nil).Code)
+
+	// admins can't demote or remove themselves
+	assert.Equal(t, http.StatusBadRequest, send(http.MethodPut, "/users/"+admin.ID, adminSession.Token, auth.UserInfo{Role: "user"}).Code)
+	assert.Equal(t, http.StatusBadRequest, send(http.MethodDelete, "/users/"+admin.ID, adminSession.Token, nil).Code)
+
+	// drive inspection
+	w = send(http.MethodGet, "/drives", adminSession.Token, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var usage []*svc.DriveUsage
+	if err := json.Unmarshal(w.Body.Bytes(), &usage); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	assert.Equal(t, 1, len(usage))
+	assert.Equal(t, testDrv.ID, usage[0].DriveID)
+	assert.Equal(t, testDrv.OwnerID, usage[0].OwnerID)
+	assert.Equal(t, http.StatusOK, send(http.MethodGet, "/drives/"+testDrv.ID, adminSession.Token, nil).Code)
+	assert.Equal(t, http.StatusOK, send(http.MethodGet, "/drives/"+testDrv.ID+"/files", adminSession.Token, nil).Code)
+	assert.Equal(t, http.StatusOK, send(http.MethodGet, "/drives/"+testDrv.ID+"/usage", adminSession.Token, nil).Code)
+
+	// removed users can't log back in
+	assert.Equal(t, http.StatusOK, send(http.MethodDelete, "/users/"+user.ID, adminSession.Token, nil).Code)
+	removed, err := testSvc.Db.GetUser(user.ID)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	assert.Equal(t, nil, removed)
+
+	if err := Clean(filepath.Dir(testRoot)); err != nil {
+		t.Errorf("[ERROR] unable to remove test directories: %v", err)
+	}
+}
+
+func TestSetQuotaAPI(t *testing.T) {
+	env.SetEnv(false)
+
+	testRoot := getTestingDir()
+	testSvc, err := SetUpService(testRoot)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	api := &API{Svc: testSvc, log: logger.NewLogger("API", "None")}
+
+	// the auth middleware looks users up in the configured service's databases
+	svcRoot := svcCfg.SvcRoot
+	svcCfg.SvcRoot = testSvc.SvcRoot
+	defer func() { svcCfg.SvcRoot = svcRoot }()
+
+	admin := auth.NewUser("bill buttlicker", "billBB", "bill@bill.com", svcCfg.SvcRoot, true)
+	if err := testSvc.AddUser(admin); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	adminSession, err := testSvc.NewSession(admin.ID)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	testDrv := MakeEmptyTmpDrive(t)
+	if err := testSvc.AddDrive(testDrv); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	testUsr := auth.NewUser("quota user", "quotaUser", "quota@user.com", svcCfg.SvcRoot, false)
+	testUsr.DriveID = testDrv.ID
+	if err := testSvc.Db.AddUser(testUsr); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	session, err := testSvc.NewSession(testUsr.ID)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	orig, err := testSvc.Db.GetDrive(testDrv.ID)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+
+	router := adminRouter(api)
+	setQuota := func(token string, size int64) int {
+		r := httptest.NewRequest(http.MethodPut, fmt.Sprintf("/users/%s/quota?size=%d", testUsr.ID, size), nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// users can't raise their own quota
+	assert.Equal(t, http.StatusForbidden, setQuota(session.Token, orig.TotalSize*2))
+	drv, err := testSvc.Db.GetDrive(testDrv.ID)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	assert.Equal(t, orig.TotalSize, drv.TotalSize)
+
+	// admins can
+	assert.Equal(t, http.StatusOK, setQuota(adminSession.Token, orig.TotalSize*2))
+	drv, err = testSvc.Db.GetDrive(testDrv.ID)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	assert.Equal(t, orig.TotalSize*2, drv.TotalSize)
+
+	if err := Clean(filepath.Dir(testRoot)); err != nil {
+		t.Errorf("[ERROR] unable to remove test directories: %v", err)
+	}
+}
+
+func TestMoveFileNames(t *testing.T) {
+	env.SetEnv(false)
+
+	testRoot := getTestingDir()
+	testSvc, err := SetUpService(testRoot)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	api := &API{Svc: testSvc, log: logger.NewLogger("API", "None")}
+	testDrv := MakeEmptyTmpDrive(t)
+	if err := testSvc.AddDrive(testDrv); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	srcPath := filepath.Join(t.TempDir(), "file.txt")
+	if err := os.WriteFile(srcPath, []byte("some data"), svc.PERMS); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	file := svc.NewFile("file.txt", testDrv.ID, testDrv.OwnerID, srcPath)
+	if err := testSvc.AddFile(testDrv.RootID, file); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+
+	rename := func(name string) int {
+		r := httptest.NewRequest(http.MethodPut, "/v1/files/"+file.ID+"/move?name="+url.QueryEscape(name), nil)
+		r = r.WithContext(context.WithValue(r.Context(), File, file.ID))
+		w := httptest.NewRecorder()
+		api.MoveFile(w, r)
+		return w.Code
+	}
+
+	// names that aren't a single path element are rejected
+	for _, name := range []string{".", "..", "a/b", filepath.Join("..", "escaped.txt"), `a\b`} {
+		assert.Equal(t, http.StatusBadRequest, rename(name), name)
+	}
+	// so is a request without a name or a directory
+	assert.Equal(t, http.StatusBadRequest, rename(""))
+
+	assert.Equal(t, http.StatusOK, rename("renamed.txt"))
+	moved, err := testSvc.Db.GetFileByID(file.ID)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	assert.Equal(t, "renamed.txt", moved.Name)
+
+	// the service checks names too
+	assert.Error(t, testSvc.MoveFile(moved, moved.DirID, ".."))
+
+	if err := Clean(filepath.Dir(testRoot)); err != nil {
+		t.Errorf("[ERROR] unable to remove test directories: %v", err)
+	}
+}
+
+func TestUploadErrors(t *testing.T) {
+	api := &API{log: logger.NewLogger("API", "None")}
+	for _, tc := range []struct {
+		err  error
+		code int
+	}{
+		{fmt.Errorf("file (id=abc) %w", ErrFileNotFound), http.StatusNotFound},
+		{fmt.Errorf("%w (id=abc)", ErrUploadNotFound), http.StatusNotFound},
+		{fmt.Errorf("%w -1 for upload of 10 bytes", ErrBadOffset), http.StatusBadRequest},
+		{fmt.Errorf("failed to update: %w", ErrUploadIncomplete), http.StatusBadRequest},
+		{fmt.Errorf("failed to update: %w", svc.ErrNoChecksum), http.StatusBadRequest},
+		{fmt.Errorf("failed to update: %w", svc.ErrChecksumMismatch), http.StatusUnprocessableEntity},
+		{fmt.Errorf("failed to start upload: %w", svc.ErrQuotaExceeded), http.StatusInsufficientStorage},
+		// other errors that happen to mention these words aren't client errors
+		{fmt.Errorf("some file not found on disk at offset 10"), http.StatusInternalServerError},
+	} {
+		w := httptest.NewRecorder()
+		api.uploadError(w, tc.err)
+		assert.Equal(t, tc.code, w.Code, tc.err.Error())
+	}
+}
+
+func TestPutFileDeltaLimit(t *testing.T) {
+	env.SetEnv(false)
+
+	testRoot := getTestingDir()
+	testSvc, err := SetUpService(testRoot)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	api := &API{Svc: testSvc, log: logger.NewLogger("API", "None")}
+
+	testDrv := MakeEmptyTmpDrive(t)
+	testDrv.TotalSize = 1 << 10
+	if err := testSvc.AddDrive(testDrv); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	srcPath := filepath.Join(t.TempDir(), "a.txt")
+	if err := os.WriteFile(srcPath, []byte("0123456789"), svc.PERMS); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	file := svc.NewFile("a.txt", testDrv.ID, testDrv.OwnerID, srcPath)
+	if err := testSvc.AddFile(testDrv.RootID, file); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+
+	limit, err := api.maxDeltaSize(file)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	send := func(body string) int {
+		r := httptest.NewRequest(http.MethodPut, "/v1/files/i/"+file.ID+"/delta", strings.NewReader(body))
+		r = r.WithContext(context.WithValue(r.Context(), File, file.ID))
+		w := httptest.NewRecorder()
+		api.PutFileDelta(w, r)
+		return w.Code
+	}
+	// bodies past the cap are turned away before they're decoded
+	assert.Equal(t, http.StatusRequestEntityTooLarge, send(strings.Repeat(" ", int(limit)+1)))
+	assert.Equal(t, http.StatusBadRequest, send("{}"))
+
+	if err := Clean(filepath.Dir(testRoot)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShares(t *testing.T) {
+	env.SetEnv(false)
+
+	testRoot := getTestingDir()
+	testSvc, err := SetUpService(testRoot)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	api := &API{Svc: testSvc, log: logger.NewLogger("API", "None")}
+
+	// the auth middleware looks users and shares up in the configured service's databases
+	svcRoot := svcCfg.SvcRoot
+	svcCfg.SvcRoot = testSvc.SvcRoot
+	defer func() { svcCfg.SvcRoot = svcRoot }()
+
+	testDrv := MakeEmptyTmpDrive(t)
+	if err := testSvc.AddDrive(testDrv); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	owner := auth.NewUser("bill buttlicker", "billBB", "bill@bill.com", svcCfg.SvcRoot, false)
+	owner.ID = testDrv.OwnerID
+	owner.DriveID = testDrv.ID
+	grantee := auth.NewUser("some guy", "someGuy", "guy@guy.com", svcCfg.SvcRoot, false)
+	for _, u := range []*auth.User{owner, grantee} {
+		if err := testSvc.AddUser(u); err != nil {
+			Fail(t, filepath.Dir(testRoot), err)
+		}
+	}
+	ownerSession, err := testSvc.NewSession(owner.ID)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	granteeSession, err := testSvc.NewSession(grantee.ID)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+
+	// root/
+	//   c.txt
+	//   projects/
+	//     a.txt
+	//     docs/
+	//       b.txt
+	projects := svc.NewDirectory("projects", owner.ID, testDrv.ID, filepath.Join(t.TempDir(), "projects"))
+	if err := testSvc.NewDir(testDrv.ID, testDrv.RootID, projects); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	docs := svc.NewDirectory("docs", owner.ID, testDrv.ID, filepath.Join(projects.Path, "docs"))
+	docs.ParentID = projects.ID
+	if err := testSvc.GetDrive(testDrv.ID).AddSubDir(projects.ID, docs); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	if err := testSvc.Db.AddDir(docs); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	newFile := func(name string, dirID string) *svc.File {
+		path := filepath.Join(t.TempDir(), name)
+		if err := os.WriteFile(path, []byte("some data"), svc.PERMS); err != nil {
+			Fail(t, filepath.Dir(testRoot), err)
+		}
+		file := svc.NewFile(name, testDrv.ID, owner.ID, path)
+		if err := testSvc.AddFile(dirID, file); err != nil {
+			Fail(t, filepath.Dir(testRoot), err)
+		}
+		return file
+	}
+	a := newFile("a.txt", projects.ID)
+	b := newFile("b.txt", docs.ID)
+	c := newFile("c.txt", testDrv.RootID)
+
+	send := func(handler http.Handler, method string, target string, token string, params map[string]string, body any) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		if body != nil {
+			if err := json.NewEncoder(&buf).Encode(body); err != nil {
+				Fail(t, filepath.Dir(testRoot), err)
+			}
+		}
+		r := httptest.NewRequest(method, target, &buf)
+		rctx := chi.NewRouteContext()
+		for k, v := range params {
+			rctx.URLParams.Add(k, v)
+		}
+		r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		AuthUserHandler(handler).ServeHTTP(w, r)
+		return w
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	fileAccess := func(method string, fileID string) int {
+		return send(FileCtx(ok), method, "/v1/files/"+fileID, granteeSession.Token, map[string]string{"fileID": fileID}, nil).Code
+	}
+
+	// only owners can share their items
+	req := &svc.Share{ItemID: projects.ID, GranteeID: grantee.UserName, Permission: svc.PermRead}
+	w := send(http.HandlerFunc(api.NewShare), http.MethodPost, "/v1/shares/new", granteeSession.Token, nil, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, http.StatusForbidden, fileAccess(http.MethodGet, b.ID))
+
+	w = send(http.HandlerFunc(api.NewShare), http.MethodPost, "/v1/shares/new", ownerSession.Token, nil, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	share, err := svc.UnmarshalShare(w.Body.Bytes())
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	assert.Equal(t, grantee.ID, share.GranteeID)
+	assert.Equal(t, svc.ShareDir, share.ItemType)
+
+	// read-only shares allow downloads, but not changes
+	assert.Equal(t, http.StatusOK, fileAccess(http.MethodGet, b.ID))
+	assert.Equal(t, http.StatusForbidden, fileAccess(http.MethodPut, b.ID))
+	assert.Equal(t, http.StatusForbidden, fileAccess(http.MethodDelete, a.ID))
+	assert.Equal(t, http.StatusForbidden, fileAccess(http.MethodGet, c.ID))
+
+	// shared items show up under "Shared with me"
+	w = send(http.HandlerFunc(api.GetSharedWithMe), http.MethodGet, "/v1/shares/with-me", granteeSession.Token, nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var listing svc.DirListing
+	if err := json.Unmarshal(w.Body.Bytes(), &listing); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	assert.Equal(t, svc.SharedDirName, listing.Dir.Name)
+	assert.Equal(t, 1, len(listing.Dirs))
+	assert.Equal(t, projects.ID, listing.Dirs[0].ID)
+
+	w = send(http.HandlerFunc(api.GetSharedFiles), http.MethodGet, "/v1/shares/with-me/files", granteeSession.Token, nil, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var shared []*svc.SharedFile
+	if err := json.Unmarshal(w.Body.Bytes(), &shared); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	assert.Equal(t, 2, len(shared))
+	paths := make(map[string]string)
+	for _, sf := range shared {
+		paths[sf.File.ID] = sf.Path
+		assert.Equal(t, svc.PermRead, sf.Permission)
+	}
+	assert.Equal(t, filepath.Join("projects", "a.txt"), paths[a.ID])
+	assert.Equal(t, filepath.Join("projects", "docs", "b.txt"), paths[b.ID])
+
+	// the drive listing always has the "Shared with me" directory
+	w = send(DriveCtx(http.HandlerFunc(api.ListDrive)), http.MethodGet, "/v1/drive/"+testDrv.ID+"/list", ownerSession.Token, map[string]string{"driveID": testDrv.ID}, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	listing = svc.DirListing{}
+	if err := json.Unmarshal(w.Body.Bytes(), &listing); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	assert.Equal(t, 2, len(listing.Dirs))
+	assert.Equal(t, svc.SharedDirID, listing.Dirs[1].ID)
+	assert.Equal(t, 1, len(listing.Files))
+	assert.Equal(t, c.ID, listing.Files[0].ID)
+
+	// sharing again replaces the old share
+	req.Permission = svc.PermReadWrite
+	w = send(http.HandlerFunc(api.NewShare), http.MethodPost, "/v1/shares/new", ownerSession.Token, nil, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	if share, err = svc.UnmarshalShare(w.Body.Bytes()); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	assert.Equal(t, http.StatusOK, fileAccess(http.MethodPut, b.ID))
+	newDir := httptest.NewRequest(http.MethodPost, "/v1/dirs/new", nil)
+	newDir = newDir.WithContext(context.WithValue(newDir.Context(), Auth, grantee))
+	assert.True(t, authorizeNewItem(httptest.NewRecorder(), newDir, owner.ID, testDrv.ID, docs.ID))
+	assert.False(t, authorizeNewItem(httptest.NewRecorder(), newDir, owner.ID, testDrv.ID, testDrv.RootID))
+	assert.False(t, authorizeNewItem(httptest.NewRecorder(), newDir, grantee.ID, testDrv.ID, docs.ID))
+
+	// expired shares don't work anymore
+	share.Expires = time.Now().UTC().Add(-time.Minute)
+	if err := testSvc.Db.SetShare(share); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	assert.Equal(t, http.StatusForbidden, fileAccess(http.MethodGet, b.ID))
+
+	// grantees can remove shares from "Shared with me"
+	w = send(ShareCtx(http.HandlerFunc(api.RemoveShare)), http.MethodDelete, "/v1/shares/"+share.ID, granteeSession.Token, map[string]string{"shareID": share.ID}, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	shares, err := testSvc.GetShares(owner.ID)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	assert.Equal(t, 0, len(shares))
+
+	if err := Clean(filepath.Dir(testRoot)); err != nil {
+		t.Errorf("[ERROR] unable to remove test directories: %v", err)
+	}
+}
@@ -0,0 +1,370 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	svc "github.com/sfs/pkg/service"
+)
+
+/*
+server-side content store.
+
+file and version contents are stored as blobs under <svc root>/blobs, keyed by
+their checksum (see service/blobs.go). files' server paths and versions' paths
+are logical, and are resolved to blobs through the BlobRefs table. nothing is
+ever written to a logical path directly.
+
+contents stored before blobs were used are moved into the blob store the first
+time their path is resolved, as long as a file or version in the database keeps
+its contents there and the path is under its drive's root.
+*/
+
+// where blobs are kept on the server
+func (s *Service) blobDir() string { return filepath.Join(s.SvcRoot, "blobs") }
+
+// get the physical location of the contents for a logical server path
+func (s *Service) ContentPath(path string) (string, error) {
+	s.blobMu.Lock()
+	defer s.blobMu.Unlock()
+
+	b, err := s.getBlob(path)
+	if err != nil {
+		return "", err
+	}
+	if b == nil {
+		return "", fmt.Errorf("no contents found for %s", path)
+	}
+	return b.Path, nil
+}
+
+// store the file at srcPath and point path at it. srcPath is moved into the
+// blob store, or removed if a blob with the same contents is already stored.
+// srcPath should be on the same file system as the service root (i.e. created
+// with WriteTmpFile()).
+func (s *Service) storeBlob(path string, srcPath string) (*svc.Blob, error) {
+	cs, err := svc.CalculateChecksum(srcPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to calculate checksum: %v", err)
+	}
+	info, err := os.Stat(srcPath)
+	if err != nil {
+		return nil, err
+	}
+
+	s.blobMu.Lock()
+	defer s.blobMu.Unlock()
+
+	b, err := s.putBlob(srcPath, cs, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	if err := s.linkBlob(path, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// add the contents at srcPath to the blob store, unless they're already there.
+// the caller must hold blobMu.
+func (s *Service) putBlob(srcPath string, checksum string, size int64) (*svc.Blob, error) {
+	b, err := s.Db.GetBlob(checksum)
+	if err != nil {
+		return nil, err
+	}
+	if b != nil {
+		if _, err := os.Stat(b.Path); err == nil {
+			if err := os.Remove(srcPath); err != nil {
+				s.log.Warn(fmt.Sprintf("failed to remove duplicate contents %s: %v", srcPath, err))
+			}
+			return b, nil
+		}
+		// the blob's contents went missing. use these instead.
+		s.log.Warn(fmt.Sprintf("contents for blob %s are missing. restoring from %s", checksum, srcPath))
+	} else {
+		b = svc.NewBlob(checksum, size, s.blobDir())
+	}
+	if err := os.MkdirAll(filepath.Dir(b.Path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create blob directory: %v", err)
+	}
+	if err := os.Rename(srcPath, b.Path); err != nil {
+		return nil, fmt.Errorf("failed to store blob %s: %v", checksum, err)
+	}
+	if err := s.Db.SetBlob(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// point path at a blob, releasing whatever blob it pointed to before.
+// the caller must hold blobMu.
+func (s *Service) linkBlob(path string, b *svc.Blob) error {
+	prev, err := s.Db.GetBlobRef(path)
+	if err != nil {
+		return err
+	}
+	if prev == b.CheckSum {
+		return nil
+	}
+	b.Refs++
+	if err := s.Db.SetBlob(b); err != nil {
+		return err
+	}
+	if err := s.Db.SetBlobRef(path, b.CheckSum); err != nil {
+		return err
+	}
+	if prev != "" {
+		return s.releaseBlob(prev)
+	}
+	return nil
+}
+
+// drop a reference to a blob. the caller must hold blobMu.
+func (s *Service) releaseBlob(checksum string) error {
+	b, err := s.Db.GetBlob(checksum)
+	if err != nil {
+		return err
+	}
+	if b == nil {
+		return nil
+	}
+	b.Refs--
+	return s.Db.SetBlob(b)
+}
+
+// get the blob a logical path points to. returns nil if the path doesn't
+// have any contents. the caller must hold blobMu.
+func (s *Service) getBlob(path string) (*svc.Blob, error) {
+	cs, err := s.Db.GetBlobRef(path)
+	if err != nil {
+		return nil, err
+	}
+	if cs == "" {
+		return s.importBlob(path)
+	}
+	b, err := s.Db.GetBlob(cs)
+	if err != nil {
+		return nil, err
+	}
+	if b == nil {
+		return nil, fmt.Errorf("blob %s for %s not found", cs, path)
+	}
+	return b, nil
+}
+
+// move contents that were stored at their logical path before blobs were
+// used into the blob store. returns nil if there's nothing at path.
+// the caller must hold blobMu.
+func (s *Service) importBlob(path string) (*svc.Blob, error) {
+	if ok, err := s.isLegacyPath(path); err != nil || !ok {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		return nil, nil
+	}
+	cs, err := svc.CalculateChecksum(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to calculate checksum: %v", err)
+	}
+	b, err := s.putBlob(path, cs, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	if err := s.linkBlob(path, b); err != nil {
+		return nil, err
+	}
+	s.log.Info(fmt.Sprintf("moved %s into the blob store (%s)", path, cs))
+	return b, nil
+}
+
+// whether path is where a file or version in the database kept its contents
+// before blobs were used. paths outside the owning drive's root never are.
+func (s *Service) isLegacyPath(path string) (bool, error) {
+	var driveID string
+	if f, err := s.Db.GetFileByServerPath(path); err != nil {
+		return false, err
+	} else if f != nil {
+		driveID = f.DriveID
+	} else if v, err := s.Db.GetVersionByPath(path); err != nil {
+		return false, err
+	} else if v != nil {
+		driveID = v.DriveID
+	} else {
+		return false, nil
+	}
+	drive, err := s.Db.GetDrive(driveID)
+	if err != nil || drive == nil {
+		return false, err
+	}
+	rel, err := filepath.Rel(s.buildServerRootPath(drive.OwnerName, ""), path)
+	return err == nil && filepath.IsLocal(rel), nil
+}
+
+// point dest at the same contents as src. returns nil if src has no contents.
+func (s *Service) copyBlobRef(src string, dest string) (*svc.Blob, error) {
+	s.blobMu.Lock()
+	defer s.blobMu.Unlock()
+
+	b, err := s.getBlob(src)
+	if err != nil || b == nil {
+		return nil, err
+	}
+	if err := s.linkBlob(dest, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// move the contents of oldPath to newPath
+func (s *Service) moveBlobRef(oldPath string, newPath string) error {
+	s.blobMu.Lock()
+	defer s.blobMu.Unlock()
+
+	b, err := s.getBlob(oldPath)
+	if err != nil || b == nil {
+		return err
+	}
+	if err := s.linkBlob(newPath, b); err != nil {
+		return err
+	}
+	return s.unlinkBlob(oldPath)
+}
+
+// whether a logical path is already used by a file. unlike getBlob,
+// this never moves anything into the blob store.
+func (s *Service) pathTaken(path string) (bool, error) {
+	cs, err := s.Db.GetBlobRef(path)
+	if err != nil {
+		return false, err
+	}
+	if cs != "" {
+		return true, nil
+	}
+	f, err := s.Db.GetFileByServerPath(path)
+	return f != nil, err
+}
+
+// remove a logical path's contents. the blob itself is left for gcBlobs().
+func (s *Service) removeBlobRef(path string) error {
+	s.blobMu.Lock()
+	defer s.blobMu.Unlock()
+
+	return s.unlinkBlob(path)
+}
+
+// the caller must hold blobMu.
+func (s *Service) unlinkBlob(path string) error {
+	cs, err := s.Db.GetBlobRef(path)
+	if err != nil {
+		return err
+	}
+	if cs == "" {
+		// contents from before blobs were used
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %v", path, err)
+		}
+		return nil
+	}
+	if err := s.Db.RemoveBlobRef(path); err != nil {
+		return err
+	}
+	return s.releaseBlob(cs)
+}
+
+// replace a file's contents with the file at srcPath
+func (s *Service) replaceContents(file *svc.File, srcPath string) error {
+	b, err := s.storeBlob(file.ServerPath, srcPath)
+	if err != nil {
+		return fmt.Errorf("failed to replace %s: %v", file.Name, err)
+	}
+	file.CheckSum = b.CheckSum
+	file.Size = b.Size
+	file.LastSync = time.Now().UTC()
+	return nil
+}
+
+// rebuild a file's contents from its current contents and a delta.
+// the rebuilt contents must match the delta's checksum.
+func (s *Service) patchContents(file *svc.File, delta *svc.Delta) error {
+	base, err := s.ContentPath(file.ServerPath)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.uploadDir(), 0755); err != nil {
+		return fmt.Errorf("failed to create uploads directory: %v", err)
+	}
+	tmp, err := os.CreateTemp(s.uploadDir(), "patch-*")
+	if err != nil {
+		return fmt.Errorf("unable to create temp file for %s: %v", file.Name, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	// the delta's size is whatever the client says it is, so the rebuilt
+	// file is held to the space its drive actually has left as it's written.
+	drive, err := s.updateUsage(file.DriveID)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	h := svc.NewHasher()
+	qw := &quotaWriter{
+		w: io.MultiWriter(tmp, h),
+		n: file.Size + max(drive.TotalSize-drive.UsedSpace, 0),
+	}
+	if err := svc.ApplyDelta(base, delta, qw); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to apply delta to %s: %w", file.Name, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := svc.VerifyChecksum(delta.CheckSum, h.Sum()); err != nil {
+		return fmt.Errorf("failed to apply delta to %s: %w", file.Name, err)
+	}
+	return s.replaceContents(file, tmp.Name())
+}
+
+// writer that fails once more than n bytes have been written to it
+type quotaWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (q *quotaWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > q.n {
+		return 0, fmt.Errorf("%w: rebuilt file is larger than the space available", svc.ErrQuotaExceeded)
+	}
+	q.n -= int64(len(p))
+	return q.w.Write(p)
+}
+
+// remove any blobs that nothing points to anymore
+func (s *Service) gcBlobs() {
+	s.blobMu.Lock()
+	defer s.blobMu.Unlock()
+
+	blobs, err := s.Db.GetUnreferencedBlobs()
+	if err != nil {
+		s.log.Error(fmt.Sprintf("failed to get unreferenced blobs: %v", err))
+		return
+	}
+	var n int
+	for _, b := range blobs {
+		if err := os.Remove(b.Path); err != nil && !os.IsNotExist(err) {
+			s.log.Error(fmt.Sprintf("failed to remove blob %s: %v", b.CheckSum, err))
+			continue
+		}
+		if err := s.Db.RemoveBlob(b.CheckSum); err != nil {
+			s.log.Error(err.Error())
+			continue
+		}
+		n++
+	}
+	if n > 0 {
+		s.log.Info(fmt.Sprintf("removed %d unreferenced blobs", n))
+	}
+}
@@ -17,6 +17,10 @@ type SvrCnf struct {
 	TimeoutRead  time.Duration `env:"SERVER_TIMEOUT_READ,required"`
 	TimeoutWrite time.Duration `env:"SERVER_TIMEOUT_WRITE,required"`
 	TimeoutIdle  time.Duration `env:"SERVER_TIMEOUT_IDLE,required"`
+
+	// how long to keep deletion tombstones around before they're garbage collected.
+	// clients that haven't synced within this period won't see the deletions.
+	TombstoneRetention time.Duration `env:"SERVER_TOMBSTONE_RETENTION,default=720h"`
 }
 
 func ServerConfig() *SvrCnf {
@@ -14,6 +14,11 @@ func getDBConn(dbName string) *db.Query {
 	return db.NewQuery(filepath.Join(svcCfg.SvcRoot, "dbs", dbName), false)
 }
 
+// get a db connection that can switch between all of the service's databases
+func getDBs() *db.Query {
+	return db.NewQuery(filepath.Join(svcCfg.SvcRoot, "dbs"), true)
+}
+
 // get user data from db. user will be nil if not found
 func findUser(userID string, q *db.Query) (*auth.User, error) {
 	u, err := q.GetUser(userID)
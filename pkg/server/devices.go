@@ -0,0 +1,103 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sfs/pkg/auth"
+	svc "github.com/sfs/pkg/service"
+)
+
+// ---- devices --------------------------------
+
+// register a new device for a user. the returned device has its API
+// key set, which is the only time the key is available.
+func (s *Service) RegisterDevice(userID string, name string) (*auth.Device, error) {
+	devices, err := s.Db.GetUsersDevices(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range devices {
+		if d.Name == name && !d.Revoked {
+			return nil, fmt.Errorf("device '%s' already exists (id=%s). revoke it before registering it again", name, d.ID)
+		}
+	}
+	device, err := auth.NewDevice(name, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Db.SetDevice(device); err != nil {
+		return nil, err
+	}
+	s.log.Info(fmt.Sprintf("registered device %s (id=%s) for user (id=%s)", name, device.ID, userID))
+	return device, nil
+}
+
+// get a device. returns an error if it isn't found.
+func (s *Service) GetDevice(deviceID string) (*auth.Device, error) {
+	device, err := s.Db.GetDevice(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	if device == nil {
+		return nil, fmt.Errorf("device (id=%s) not found", deviceID)
+	}
+	return device, nil
+}
+
+// get all devices registered by a user, including revoked ones
+func (s *Service) GetDevices(userID string) ([]*auth.Device, error) {
+	return s.Db.GetUsersDevices(userID)
+}
+
+// get every registered device
+func (s *Service) GetAllDevices() ([]*auth.Device, error) {
+	return s.Db.GetAllDevices()
+}
+
+// revoke a device. its API key can no longer be used.
+func (s *Service) RevokeDevice(device *auth.Device) error {
+	if device.Revoked {
+		return nil
+	}
+	device.Revoked = true
+	if err := s.Db.SetDevice(device); err != nil {
+		return err
+	}
+	s.log.Info(fmt.Sprintf("revoked device %s (id=%s)", device.Name, device.ID))
+	return nil
+}
+
+// revoke all of a user's devices
+func (s *Service) revokeDevices(userID string) error {
+	devices, err := s.Db.GetUsersDevices(userID)
+	if err != nil {
+		return err
+	}
+	for _, d := range devices {
+		if err := s.RevokeDevice(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// record the device that made the latest change to a file, and
+// bring the device's last synced revision up to date.
+func (s *Service) attributeChange(device *auth.Device, file *svc.File) error {
+	if err := s.Db.SetRevisionDevice(file.ID, device.Name); err != nil {
+		return err
+	}
+	rev, err := s.Db.GetDriveRevision(file.DriveID)
+	if err != nil {
+		return err
+	}
+	return s.deviceSynced(device, rev)
+}
+
+// record the latest drive revision a device has synced
+func (s *Service) deviceSynced(device *auth.Device, rev int64) error {
+	device.LastRevision = max(device.LastRevision, rev)
+	device.LastSeen = time.Now().UTC()
+	return s.Db.SetDevice(device)
+}
@@ -19,4 +19,11 @@ const (
 	Index       Context = "index"
 	Error       Context = "error"
 	Search      Context = "search"
+	Upload      Context = "upload"
+	Version     Context = "version"
+	Auth        Context = "auth"        // the authenticated user making the request
+	Session     Context = "session"     // the ID of the session the request was made with
+	FromDevice  Context = "from_device" // the registered device the request was made from
+	Device      Context = "device"
+	Share       Context = "share"
 )
@@ -174,6 +174,8 @@ root/
 |   |---drives
 |   |---directories
 |   |---files
+|---uploads/
+|   |---(partially uploaded files)
 */
 
 // initialize a new service and corresponding databases
@@ -193,6 +195,7 @@ func SetUpService(svcRoot string) (*Service, error) {
 		filepath.Join(svcRoot, "users"),
 		filepath.Join(svcRoot, "state"),
 		filepath.Join(svcRoot, "dbs"),
+		filepath.Join(svcRoot, "uploads"),
 	}
 	for _, p := range svcPaths {
 		if err := os.Mkdir(p, 0644); err != nil {
@@ -284,6 +287,12 @@ func SvcLoad(svcPath string) (*Service, error) {
 	// state file
 	svc.Db = db.NewQuery(svc.DbDir, true)
 
+	// create any databases added since this service was set up
+	if err := db.UpdateServerDBs(svc.DbDir); err != nil {
+		initLogger.Error(fmt.Sprintf("failed to update databases: %v", err))
+		return nil, fmt.Errorf("failed to update databases: %v", err)
+	}
+
 	// load logger
 	svc.log = logger.NewLogger("Service", svc.ID)
 
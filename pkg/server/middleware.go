@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/sfs/pkg/auth"
 	svc "github.com/sfs/pkg/service"
@@ -51,6 +52,10 @@ func AllUsersFilesCtx(h http.Handler) http.Handler {
 			http.Error(w, "no user ID provided", http.StatusBadRequest)
 			return
 		}
+		if !authorized(r, userID) {
+			forbidden(w)
+			return
+		}
 		newCtx := context.WithValue(r.Context(), User, userID)
 		h.ServeHTTP(w, r.WithContext(newCtx))
 	})
@@ -89,6 +94,9 @@ func NewFileCtx(h http.Handler) http.Handler {
 			http.Error(w, fmt.Sprintf("failed to unmarshal file data: %v", err), http.StatusInternalServerError)
 			return
 		}
+		if !authorizeNewItem(w, r, newFile.OwnerID, newFile.DriveID, newFile.DirID) {
+			return
+		}
 		newCtx := context.WithValue(r.Context(), File, newFile)
 		h.ServeHTTP(w, r.WithContext(newCtx))
 	})
@@ -135,6 +143,9 @@ func NewDirectoryCtx(h http.Handler) http.Handler {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		if !authorizeNewItem(w, r, newDir.OwnerID, newDir.DriveID, newDir.ParentID) {
+			return
+		}
 		newCtx := context.WithValue(r.Context(), Directory, newDir)
 		h.ServeHTTP(w, r.WithContext(newCtx))
 	})
@@ -158,6 +169,10 @@ func NewDriveCtx(h http.Handler) http.Handler {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		if !authorized(r, newDrive.OwnerID) {
+			forbidden(w)
+			return
+		}
 		newCtx := context.WithValue(r.Context(), Drive, newDrive)
 		h.ServeHTTP(w, r.WithContext(newCtx))
 	})
@@ -165,50 +180,271 @@ func NewDriveCtx(h http.Handler) http.Handler {
 
 // ------- authentication --------------------------------
 
-// retrieve jwt token from request & verify
-func AuthenticateUser(reqToken string) (*auth.User, error) {
-	tokenValidator := auth.NewT()
-	userID, err := tokenValidator.Verify(reqToken)
+// verify an access token from an Authorization header and retrieve the
+// user it belongs to, along with the ID of the session it was issued for.
+// tokens from revoked sessions are rejected.
+func AuthenticateUser(rawToken string) (*auth.User, string, error) {
+	tokenValidator, err := sessionToken(svcCfg.SvcRoot)
 	if err != nil {
-		return nil, fmt.Errorf("failed to validate user token: %v", err)
+		return nil, "", err
+	}
+	reqToken, err := tokenValidator.Extract(rawToken)
+	if err != nil {
+		return nil, "", err
+	}
+	userID, sessionID, err := tokenValidator.VerifyAccess(reqToken)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to validate user token: %v", err)
+	}
+	// make sure the session hasn't been revoked (ie. the user logged out)
+	refresh, err := getDBConn("tokens").GetRefreshToken(sessionID)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to query database for session: %v", err)
+	} else if refresh == nil || refresh.Revoked || refresh.UserID != userID {
+		return nil, "", fmt.Errorf("session (id=%s) is no longer valid", sessionID)
 	}
 	// attempt to find data about the user from the the user db
-	user, err := findUser(userID, getDBConn("Users"))
+	user, err := findUser(userID, getDBConn("users"))
 	if err != nil {
-		return nil, fmt.Errorf("failed to query database for user: %v", err)
+		return nil, "", fmt.Errorf("failed to query database for user: %v", err)
 	} else if user == nil {
-		return nil, fmt.Errorf("user (id=%s) not found", userID)
+		return nil, "", fmt.Errorf("user (id=%s) not found", userID)
 	}
-	return user, nil
+	return user, sessionID, nil
 }
 
-// get user info
+// verify a device's API key and retrieve the device and the user it belongs to.
+// keys from revoked devices are rejected.
+func AuthenticateDevice(key string) (*auth.User, *auth.Device, error) {
+	q := getDBConn("devices")
+	device, err := q.GetDeviceByKeyHash(auth.HashToken(key))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query database for device: %v", err)
+	} else if device == nil {
+		return nil, nil, fmt.Errorf("invalid device key")
+	} else if device.Revoked {
+		return nil, nil, fmt.Errorf("device (id=%s) has been revoked", device.ID)
+	}
+	user, err := findUser(device.OwnerID, getDBConn("users"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query database for user: %v", err)
+	} else if user == nil {
+		return nil, nil, fmt.Errorf("user (id=%s) not found", device.OwnerID)
+	}
+	// don't write to the db on every request
+	if time.Since(device.LastSeen) > time.Minute {
+		device.LastSeen = time.Now().UTC()
+		if err := q.SetDevice(device); err != nil {
+			return nil, nil, fmt.Errorf("failed to query database for device: %v", err)
+		}
+	}
+	return user, device, nil
+}
+
+// authenticate the user making the request with either a registered device's
+// API key, or the access token in the Authorization header. tokens are issued
+// by /v1/auth/login and /v1/auth/refresh, and keys by /v1/devices/new.
 func AuthUserHandler(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		reqToken := r.Header.Get("Authorization")
-		if reqToken == "" {
-			http.Error(w, "header had no request token", http.StatusBadRequest)
+		var (
+			user      *auth.User
+			device    *auth.Device
+			sessionID string
+			err       error
+		)
+		if key := r.Header.Get(auth.DeviceKeyHeader); key != "" {
+			user, device, err = AuthenticateDevice(key)
+		} else if reqToken := r.Header.Get("Authorization"); reqToken != "" {
+			user, sessionID, err = AuthenticateUser(reqToken)
+		} else {
+			http.Error(w, "header had no request token", http.StatusUnauthorized)
 			return
 		}
-		user, err := AuthenticateUser(reqToken)
 		if err != nil {
-			if err.Error() == "invalid token" {
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
-			}
 			if strings.Contains(err.Error(), "failed to query database") {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			} else if strings.Contains(err.Error(), "user not found") {
-				http.Error(w, err.Error(), http.StatusBadRequest)
-				return
+			} else {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
 			}
+			return
 		}
-		newCtx := context.WithValue(r.Context(), User, user)
+		newCtx := context.WithValue(r.Context(), Auth, user)
+		newCtx = context.WithValue(newCtx, Session, sessionID)
+		newCtx = context.WithValue(newCtx, FromDevice, device)
 		h.ServeHTTP(w, r.WithContext(newCtx))
 	})
 }
 
+// ------- authorization --------------------------------
+
+// the authenticated user making the request. nil if the
+// request didn't go through AuthUserHandler.
+func requester(r *http.Request) *auth.User {
+	user, _ := r.Context().Value(Auth).(*auth.User)
+	return user
+}
+
+// the registered device the request was made from. nil if the
+// request was made with an access token instead of a device key.
+func requestDevice(r *http.Request) *auth.Device {
+	device, _ := r.Context().Value(FromDevice).(*auth.Device)
+	return device
+}
+
+// whether a request only reads things
+func readOnly(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	default:
+		return false
+	}
+}
+
+// whether the user making the request can access items owned by ownerID.
+// admins can access everything.
+func authorized(r *http.Request, ownerID string) bool {
+	user := requester(r)
+	if user == nil {
+		return false
+	}
+	return user.IsAdmin() || user.ID == ownerID
+}
+
+func forbidden(w http.ResponseWriter) {
+	http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+}
+
+// get the owner of an item from the given database, if it exists.
+// returns an empty string if the item isn't found, so handlers can
+// report it as missing themselves.
+func ownerOf(dbName string, itemID string) (string, error) {
+	q := getDBConn(dbName)
+	switch dbName {
+	case "files":
+		file, err := q.GetFileByID(itemID)
+		if err != nil || file == nil {
+			return "", err
+		}
+		return file.OwnerID, nil
+	case "directories":
+		dir, err := q.GetDirectoryByID(itemID)
+		if err != nil || dir == nil {
+			return "", err
+		}
+		return dir.OwnerID, nil
+	case "drives":
+		drive, err := q.GetDrive(itemID)
+		if err != nil || drive == nil {
+			return "", err
+		}
+		return drive.OwnerID, nil
+	case "devices":
+		device, err := q.GetDevice(itemID)
+		if err != nil || device == nil {
+			return "", err
+		}
+		return device.OwnerID, nil
+	default:
+		return "", fmt.Errorf("unsupported database: %s", dbName)
+	}
+}
+
+// get the share that lets the user making the request access a file or
+// directory they don't own. returns nil if there isn't one, or if it doesn't
+// allow the request (ie. changes to read-only shares).
+func sharedAccess(r *http.Request, dbName string, itemID string) (*svc.Share, error) {
+	user := requester(r)
+	if user == nil {
+		return nil, nil
+	}
+	var itemType string
+	switch dbName {
+	case "files":
+		itemType = svc.ShareFile
+	case "directories":
+		itemType = svc.ShareDir
+	default:
+		return nil, nil
+	}
+	share, err := findShare(getDBs(), user.ID, itemType, itemID)
+	if err != nil || share == nil {
+		return nil, err
+	}
+	if !readOnly(r) && !share.CanWrite() {
+		return nil, nil
+	}
+	return share, nil
+}
+
+// make sure the user making the request owns the item,
+// or that it was shared with them.
+func authorizeItem(w http.ResponseWriter, r *http.Request, dbName string, itemID string) bool {
+	ownerID, err := ownerOf(dbName, itemID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if ownerID == "" || authorized(r, ownerID) {
+		return true
+	}
+	share, err := sharedAccess(r, dbName, itemID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if share == nil {
+		forbidden(w)
+		return false
+	}
+	return true
+}
+
+// make sure the user making the request can add an item owned by ownerID to a
+// drive's directory. the drive and directory are looked up on the server, and the
+// requester has to own them or have a read-write share on the directory. the item's
+// owner has to be the drive's owner. if dirID isn't a known directory then the
+// item goes in the drive's root directory, so that's what's checked instead.
+func authorizeNewItem(w http.ResponseWriter, r *http.Request, ownerID string, driveID string, dirID string) bool {
+	drive, err := getDBConn("drives").GetDrive(driveID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if drive == nil {
+		http.Error(w, fmt.Sprintf("drive (id=%s) not found", driveID), http.StatusNotFound)
+		return false
+	}
+	if ownerID != drive.OwnerID {
+		forbidden(w)
+		return false
+	}
+	dir, err := getDBConn("directories").GetDirectoryByID(dirID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if dir == nil {
+		dirID = drive.RootID
+	} else if dir.DriveID != drive.ID {
+		forbidden(w)
+		return false
+	}
+	if authorized(r, drive.OwnerID) && (dir == nil || authorized(r, dir.OwnerID)) {
+		return true
+	}
+	share, err := sharedAccess(r, "directories", dirID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if share == nil || !share.CanWrite() || share.OwnerID != drive.OwnerID {
+		forbidden(w)
+		return false
+	}
+	return true
+}
+
 // ------ standard context --------------------------------
 
 // single file context
@@ -219,6 +455,9 @@ func FileCtx(h http.Handler) http.Handler {
 			http.Error(w, "fileID not set", http.StatusBadRequest)
 			return
 		}
+		if !authorizeItem(w, r, "files", fileID) {
+			return
+		}
 		ctx := context.WithValue(r.Context(), File, fileID)
 		h.ServeHTTP(w, r.WithContext(ctx))
 	})
@@ -231,11 +470,40 @@ func DirCtx(h http.Handler) http.Handler {
 			http.Error(w, "dirID not set", http.StatusBadRequest)
 			return
 		}
+		if !authorizeItem(w, r, "directories", dirID) {
+			return
+		}
 		ctx := context.WithValue(r.Context(), Directory, dirID)
 		h.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// chunked upload session context
+func UploadCtx(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uploadID := chi.URLParam(r, "uploadID")
+		if uploadID == "" {
+			http.Error(w, "uploadID not set", http.StatusBadRequest)
+			return
+		}
+		ctx := context.WithValue(r.Context(), Upload, uploadID)
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// file version context
+func VersionCtx(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version := chi.URLParam(r, "version")
+		if version == "" {
+			http.Error(w, "version not set", http.StatusBadRequest)
+			return
+		}
+		ctx := context.WithValue(r.Context(), Version, version)
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
 func DriveCtx(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		driveID := chi.URLParam(r, "driveID")
@@ -243,14 +511,55 @@ func DriveCtx(h http.Handler) http.Handler {
 			http.Error(w, "driveID not set", http.StatusBadRequest)
 			return
 		}
+		if !authorizeItem(w, r, "drives", driveID) {
+			return
+		}
 		ctx := context.WithValue(r.Context(), Drive, driveID)
 		h.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// share context. only the share's owner and grantee can access it.
+func ShareCtx(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		shareID := chi.URLParam(r, "shareID")
+		if shareID == "" {
+			http.Error(w, "shareID not set", http.StatusBadRequest)
+			return
+		}
+		share, err := getDBConn("shares").GetShare(shareID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if user := requester(r); share != nil && !authorized(r, share.OwnerID) && (user == nil || user.ID != share.GranteeID) {
+			forbidden(w)
+			return
+		}
+		ctx := context.WithValue(r.Context(), Share, shareID)
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// registered device context. users can only access their own devices.
+func DeviceCtx(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deviceID := chi.URLParam(r, "deviceID")
+		if deviceID == "" {
+			http.Error(w, "deviceID not set", http.StatusBadRequest)
+			return
+		}
+		if !authorizeItem(w, r, "devices", deviceID) {
+			return
+		}
+		ctx := context.WithValue(r.Context(), Device, deviceID)
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
 // standard user context for established users.
 // in conjunction with AuthUserHandler, which is part of the router's
-// standard middleware stack.
+// standard middleware stack. users can only access themselves.
 func UserCtx(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		userID := chi.URLParam(r, "userID")
@@ -258,6 +567,10 @@ func UserCtx(h http.Handler) http.Handler {
 			http.Error(w, "userID not set", http.StatusBadRequest)
 			return
 		}
+		if !authorized(r, userID) {
+			forbidden(w)
+			return
+		}
 		ctx := context.WithValue(r.Context(), User, userID)
 		h.ServeHTTP(w, r.WithContext(ctx))
 	})
@@ -289,14 +602,27 @@ func SearchCtx(h http.Handler) http.Handler {
 
 // ------ admin stuff --------------------------------
 
+// only allow requests from admins. use after AuthUserHandler.
 func AdminOnly(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ctx := r.Context()
-		_, ok := ctx.Value("acl.permission").(float64)
-		if !ok {
-			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		if user := requester(r); user == nil || !user.IsAdmin() {
+			forbidden(w)
 			return
 		}
 		h.ServeHTTP(w, r)
 	})
 }
+
+// only allow requests that change things from users who can make changes.
+// read-only users can still view and download their files. use after AuthUserHandler.
+func WriteAccess(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !readOnly(r) {
+			if user := requester(r); user == nil || !user.CanWrite() {
+				forbidden(w)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
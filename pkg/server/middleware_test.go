@@ -0,0 +1,166 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sfs/pkg/auth"
+	"github.com/sfs/pkg/env"
+	svc "github.com/sfs/pkg/service"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestAuthorized(t *testing.T) {
+	owner := &auth.User{ID: auth.NewUUID()}
+	other := &auth.User{ID: auth.NewUUID()}
+	admin := &auth.User{ID: auth.NewUUID(), Admin: true}
+
+	req := func(user *auth.User) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if user == nil {
+			return r
+		}
+		return r.WithContext(context.WithValue(r.Context(), Auth, user))
+	}
+
+	assert.True(t, authorized(req(owner), owner.ID))
+	assert.False(t, authorized(req(other), owner.ID))
+	assert.True(t, authorized(req(admin), owner.ID))
+	assert.False(t, authorized(req(nil), owner.ID))
+}
+
+func TestAdminOnly(t *testing.T) {
+	h := AdminOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for _, tc := range []struct {
+		user *auth.User
+		code int
+	}{
+		{nil, http.StatusForbidden},
+		{&auth.User{ID: auth.NewUUID()}, http.StatusForbidden},
+		{&auth.User{ID: auth.NewUUID(), Admin: true}, http.StatusOK},
+		{&auth.User{ID: auth.NewUUID(), Role: auth.RoleAdmin}, http.StatusOK},
+		{&auth.User{ID: auth.NewUUID(), Role: auth.RoleReadOnly}, http.StatusForbidden},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tc.user != nil {
+			r = r.WithContext(context.WithValue(r.Context(), Auth, tc.user))
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		assert.Equal(t, tc.code, w.Code)
+	}
+}
+
+func TestWriteAccess(t *testing.T) {
+	h := WriteAccess(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	readOnly := &auth.User{ID: auth.NewUUID(), Role: auth.RoleReadOnly}
+	user := &auth.User{ID: auth.NewUUID(), Role: auth.RoleUser}
+	for _, tc := range []struct {
+		user   *auth.User
+		method string
+		code   int
+	}{
+		{readOnly, http.MethodGet, http.StatusOK},
+		{readOnly, http.MethodOptions, http.StatusOK},
+		{readOnly, http.MethodPost, http.StatusForbidden},
+		{readOnly, http.MethodPut, http.StatusForbidden},
+		{readOnly, http.MethodDelete, http.StatusForbidden},
+		{user, http.MethodPut, http.StatusOK},
+		{&auth.User{ID: auth.NewUUID(), Admin: true}, http.MethodDelete, http.StatusOK},
+		{nil, http.MethodPost, http.StatusForbidden},
+	} {
+		r := httptest.NewRequest(tc.method, "/", nil)
+		if tc.user != nil {
+			r = r.WithContext(context.WithValue(r.Context(), Auth, tc.user))
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		assert.Equal(t, tc.code, w.Code, tc.method)
+	}
+}
+
+func TestNewItemAccess(t *testing.T) {
+	env.SetEnv(false)
+
+	testRoot := getTestingDir()
+	testSvc, err := SetUpService(testRoot)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	// the middleware looks drives and directories up in the configured service's databases
+	svcRoot := svcCfg.SvcRoot
+	svcCfg.SvcRoot = testSvc.SvcRoot
+	defer func() { svcCfg.SvcRoot = svcRoot }()
+
+	drvA := MakeEmptyTmpDrive(t)
+	rootB := svc.NewRootDirectory("tmp", auth.NewUUID(), auth.NewUUID(), GetTestingDir())
+	drvB := svc.NewDrive(rootB.DriveID, "some guy", rootB.OwnerID, GetTestingDir(), rootB.ID, rootB)
+	for _, drv := range []*svc.Drive{drvA, drvB} {
+		if err := testSvc.AddDrive(drv); err != nil {
+			Fail(t, filepath.Dir(testRoot), err)
+		}
+	}
+	userA := &auth.User{ID: drvA.OwnerID, DriveID: drvA.ID}
+	userB := &auth.User{ID: drvB.OwnerID, DriveID: drvB.ID}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	post := func(h http.Handler, user *auth.User, item interface{ ToJSON() ([]byte, error) }) int {
+		data, err := item.ToJSON()
+		if err != nil {
+			Fail(t, filepath.Dir(testRoot), err)
+		}
+		tok, err := auth.NewT().Create(string(data))
+		if err != nil {
+			Fail(t, filepath.Dir(testRoot), err)
+		}
+		r := httptest.NewRequest(http.MethodPost, "/v1/files/new", nil)
+		r.Header.Set(auth.PayloadHeader, tok)
+		r = r.WithContext(context.WithValue(r.Context(), Auth, user))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+	srcPath := filepath.Join(t.TempDir(), "a.txt")
+	if err := os.WriteFile(srcPath, []byte("some data"), svc.PERMS); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	newFile := func(owner *auth.User, driveID string, dirID string) *svc.File {
+		file := svc.NewFile("a.txt", driveID, owner.ID, srcPath)
+		file.DirID = dirID
+		return file
+	}
+	newDir := func(owner *auth.User, driveID string, parentID string) *svc.Directory {
+		dir := svc.NewDirectory("dir", owner.ID, driveID, filepath.Join(t.TempDir(), "dir"))
+		dir.ParentID = parentID
+		return dir
+	}
+
+	// owners can add to their own drives
+	assert.Equal(t, http.StatusOK, post(NewFileCtx(ok), userA, newFile(userA, drvA.ID, drvA.RootID)))
+	assert.Equal(t, http.StatusOK, post(NewDirectoryCtx(ok), userA, newDir(userA, drvA.ID, drvA.RootID)))
+
+	// but nobody else can, whoever the item claims to belong to
+	assert.Equal(t, http.StatusForbidden, post(NewFileCtx(ok), userB, newFile(userB, drvA.ID, drvA.RootID)))
+	assert.Equal(t, http.StatusForbidden, post(NewFileCtx(ok), userB, newFile(userA, drvA.ID, drvA.RootID)))
+	assert.Equal(t, http.StatusForbidden, post(NewFileCtx(ok), userB, newFile(userB, drvA.ID, "")))
+	assert.Equal(t, http.StatusForbidden, post(NewDirectoryCtx(ok), userB, newDir(userB, drvA.ID, drvA.RootID)))
+
+	// and directories have to be in the drive they're being added to
+	assert.Equal(t, http.StatusForbidden, post(NewFileCtx(ok), userB, newFile(userB, drvB.ID, drvA.RootID)))
+	assert.Equal(t, http.StatusNotFound, post(NewFileCtx(ok), userB, newFile(userB, auth.NewUUID(), drvB.RootID)))
+
+	if err := Clean(filepath.Dir(testRoot)); err != nil {
+		t.Errorf("[ERROR] unable to clean testing directory: %v", err)
+	}
+}
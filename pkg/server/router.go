@@ -11,16 +11,78 @@ import (
 /*
 ROUTES:
 
+// ----- auth
+
+POST    /v1/auth/login            // log in with {"user_id" or "username", "password"}. returns a session
+POST    /v1/auth/refresh          // exchange {"refresh_token"} for a new session. refresh tokens can only be used once
+POST    /v1/auth/logout           // end the current session
+PUT     /v1/auth/password         // change password with {"password", "new_password"}. revokes all of the user's sessions and returns a new one
+POST    /v1/users/new             // register a new user. the password is sent in the request body
+
+NOTE: sessions have a short-lived access token and a long-lived refresh token. every /v1 route
+other than login, refresh, and users/new needs the access token in the Authorization header
+("Bearer <token>"), or a registered device's API key in the X-Sfs-Device-Key header.
+users can only access their own files, directories, drives, and devices.
+item metadata sent with new items and updates goes in the X-Sfs-Payload header.
+
+// ----- devices
+
+POST    /v1/devices/new?name={name}  // register a device. returns the device and its API key
+GET     /v1/devices                  // list the user's devices
+GET     /v1/devices/all              // list every registered device (admin only)
+GET     /v1/devices/{deviceID}       // get info about a device
+DELETE  /v1/devices/{deviceID}       // revoke a device's API key
+
+NOTE: changes made with a device key are attributed to the device in the drive's
+revisions and tombstones, and the device's last synced revision is kept up to date.
+
 // ----- meta
 
 GET     /v1/drive/{userID}        // "home". return a root directory listing
-
-// ----- users (admin only)
+GET     /v1/drive/{driveID}/retention // get the drive's version retention policy
+PUT     /v1/drive/{driveID}/retention?keep_last={n}&daily={days}&monthly={months} // set the drive's version retention policy
+GET     /v1/drive/{driveID}/snapshot?at={RFC3339 time} // get the drive's files as they were at the given time
+GET     /v1/drive/{driveID}/usage // get the drive's quota and space usage by directory
+GET     /v1/drive/{driveID}/list  // list the drive's root directory, including the virtual "Shared with me" directory
+
+// ----- shares
+
+POST    /v1/shares/new            // share a file or directory with {"item_id", "grantee_id" (or user name), "permission", "expires"}
+GET     /v1/shares                // list the shares the user created
+GET     /v1/shares/with-me        // list the items in the user's "Shared with me" directory
+GET     /v1/shares/with-me/files  // list every file shared with the user, with its permission and path under "Shared with me"
+GET     /v1/shares/{shareID}      // get info about a share (owner or grantee)
+DELETE  /v1/shares/{shareID}      // stop sharing an item (owner), or remove it from "Shared with me" (grantee)
+
+NOTE: permissions are "read" or "read-write". sharing a directory shares everything in it.
+shared files and directories can be used through the regular /v1/files and /v1/dirs
+endpoints, and changes through read-only shares are rejected with a 403. shares can
+have an expiration date, and sharing an item with the same user again replaces the old share.
+
+// ----- admin (admin role only)
+
+GET     /admin/users                    // list all users
+POST    /admin/users                    // add a user with {"name", "username", "email", "password", "role"}
+GET     /admin/users/{userID}           // get info about a user
+PUT     /admin/users/{userID}           // update any of a user's name, username, email, password, or role
+DELETE  /admin/users/{userID}           // remove a user, their drive, sessions, and devices
+PUT     /admin/users/{userID}/quota?size={bytes} // set the quota for a user's drive
+GET     /admin/drives                   // list all drives with their owners and space usage
+GET     /admin/drives/{driveID}         // get a drive's metadata
+GET     /admin/drives/{driveID}/usage   // get a drive's quota and space usage by directory
+GET     /admin/drives/{driveID}/files   // list all files in a drive
+
+NOTE: users have one of three roles: admin, user, or read-only. read-only users can
+view and download their files, but every other request that makes changes is rejected
+with a 403 (apart from logging out, changing their password, and managing their devices).
+changing a user's password or role ends all of their sessions.
+
+// ----- users (users can only access their own account. admins can access any account)
 
 GET     /v1/users/{userID}       // get info about a user
-POST    /v1/users/new            // create a new user
 PUT     /v1/users/{userID}       // update a user
 DELETE  /v1/users/{userID}       // delete a user
+GET     /v1/users/all            // list all users (admin only)
 
 // ----- files
 
@@ -28,7 +90,27 @@ GET    /v1/files/{fileID}/i    // get info about a file
 POST   /v1/files/new           // send a new file to the server
 GET    /v1/files/{fileID}      // download a file from the server
 PUT    /v1/files/{fileID}      // update a file on the server
-DELETE /v1/files/{fileID}      // delete a file on the server
+DELETE /v1/files/{fileID}      // delete a file on the server. ?device=<name> is recorded in the tombstone
+GET    /v1/files/{fileID}/sig  // get a block signature of the server's copy of a file
+PUT    /v1/files/{fileID}/delta // update a file on the server using a block-level delta
+PUT    /v1/files/{fileID}/move?dir={dirID}&name={name} // move and/or rename a file on the server
+GET    /v1/files/{fileID}/versions     // list previous versions of a file
+GET    /v1/files/{fileID}/versions/{n} // download version n of a file
+
+// ----- chunked uploads
+
+POST   /v1/files/{fileID}/uploads?size={size}&checksum={checksum} // start (or resume) an upload session for a file
+GET    /v1/files/{fileID}/uploads/{uploadID}                      // get an upload session and the byte ranges received so far
+PUT    /v1/files/{fileID}/uploads/{uploadID}?offset={offset}      // send a chunk of the file, starting at offset
+POST   /v1/files/{fileID}/uploads/{uploadID}/commit?checksum={checksum} // finish the upload and replace the server's copy
+DELETE /v1/files/{fileID}/uploads/{uploadID}                      // cancel an upload
+
+NOTE: file updates (PUT /v1/files/{fileID}, /delta, and /commit) are verified against
+the checksum sent by the client. mismatches are rejected with a 422, and the server's
+copy of the file is left as is.
+
+NOTE: new files, file updates, and new upload sessions that would put a drive over its
+quota are rejected with a 507. changes that don't grow a drive are always allowed.
 
 // ---- directories
 
@@ -36,11 +118,12 @@ GET    /v1/i/dirs/{dirID}    // get list of files and subdirectories for this di
 POST   /v1/dirs/new          // create a directory on the server
 GET    /v1/dirs/{dirID}      // download a .zip (or other compressed format) file of this directory and its contents
 PUT    /v1/dirs/{dirID}      // update a directory on the server
-DELETE /v1/dirs/{dirID}      // delete a directory on the server
+DELETE /v1/dirs/{dirID}      // delete a directory on the server. ?device=<name> is recorded in the tombstones
 
 // ----- sync operations
 
-GET    /v1/sync/{driveID}    // fetch file last sync times from server
+GET    /v1/sync/{driveID}    // fetch file last sync times, revisions, and tombstones from server
+GET    /v1/sync/{driveID}/changes?since=N // fetch all file changes after revision N
 POST   /v1/sync/{driveID}    // send a last sync index object to the server
                              // generated from the local client directories to
 								             // initiate a client/server file sync.
@@ -66,7 +149,6 @@ func NewRouter() *chi.Mux {
 	r.Use(middleware.Timeout(time.Minute))
 
 	// custom middleware
-	// r.Use(AuthUserHandler)
 	r.Use(ContentTypeJson) // will be overridden by streaming API endpoints
 	r.Use(EnableCORS)      // used for working with the client web interface
 
@@ -78,111 +160,192 @@ func NewRouter() *chi.Mux {
 
 	//v1 routing
 	r.Route("/v1", func(r chi.Router) {
-		// Get the total runtime of the server and SFS service
-		r.Route("/runtime", func(r chi.Router) {
-			r.Get("/", api.GetRunTime)
-		})
-		r.Route("/users", func(r chi.Router) {
-			r.Route("/{userID}", func(r chi.Router) {
-				r.Use(UserCtx)
-				r.Get("/", api.GetUser)       // get info about a user
-				r.Put("/", api.UpdateUser)    // update a user
-				r.Delete("/", api.DeleteUser) // delete a user
-			})
-			r.Route("/new", func(r chi.Router) {
-				r.Use(NewUserCtx)
-				r.Post("/", api.AddNewUser) // add a new user
-			})
-			r.Route("/all", func(r chi.Router) {
-				// TODO: add admin-only context here
-				// get a list of all active users
-				r.Get("/", api.GetAllUsers)
+		// authentication. login, refresh, and users/new are the only routes that don't need an access token.
+		r.Route("/auth", func(r chi.Router) {
+			r.Post("/login", api.Login)     // log in and start a session
+			r.Post("/refresh", api.Refresh) // get a new access token
+			r.Group(func(r chi.Router) {
+				r.Use(AuthUserHandler)
+				r.Post("/logout", api.Logout)          // end the current session
+				r.Put("/password", api.ChangePassword) // change password and revoke all sessions
 			})
 		})
-
-		// files
-		r.Route("/files", func(r chi.Router) {
-			r.Route("/{fileID}", func(r chi.Router) {
-				r.Use(FileCtx)
-				r.Get("/", api.ServeFile)     // get a file from the server
-				r.Put("/", api.PutFile)       // update a file on the server
-				r.Delete("/", api.DeleteFile) // delete a file on the server
-			})
-			r.Route("/i/all/{userID}", func(r chi.Router) {
-				r.Use(AllUsersFilesCtx)
-				r.Get("/", api.GetAllFileInfo) // get info about all user-specific files
-			})
-			r.Route("/new", func(r chi.Router) { // add a new file on the server
-				r.Use(NewFileCtx)
-				r.Options("/", api.PutFile) // for fetch()'s initial "preflighted" requests. this helps with CORS.
-				r.Post("/", api.PutFile)
-			})
-			r.Route("/i/{fileID}", func(r chi.Router) {
-				r.Use(FileCtx)
-				r.Get("/", api.GetFileInfo) // get info about a file
-			})
-			// temp for testing
-			r.Route("/all", func(r chi.Router) {
-				r.Get("/", api.GetAllFileInfo) // get info
-			})
+		// register a new user
+		r.Route("/users/new", func(r chi.Router) {
+			r.Use(NewUserCtx)
+			r.Post("/", api.AddNewUser)
 		})
 
-		// directories
-		// NOTE: Directories are not supported at this time, but we'll keep these
-		// endpoints in place for future iterations.
-		r.Route("/dirs", func(r chi.Router) {
-			// specific directories
-			r.Route("/{dirID}", func(r chi.Router) {
-				r.Use(DirCtx)
-				r.Get("/", api.GetDir)       // get a directory as a zip file
-				r.Put("/", api.PutDir)       // update a directory on the server by sending a zip file and unpacking
-				r.Delete("/", api.DeleteDir) // delete a directory
-			})
-			// create a new directory
-			r.Route("/new", func(r chi.Router) {
-				r.Use(NewDirectoryCtx)
-				r.Post("/", api.NewDir)
-			})
-			// get info about a directory
-			r.Route("/i/{dirID}", func(r chi.Router) {
-				r.Use(DirCtx)
-				r.Get("/", api.GetDirInfo)
-			})
-			// get info about *all* directories
-			r.Route("/i/all/{userID}", func(r chi.Router) {
-				r.Use(UserCtx)
-				r.Get("/", api.GetUsersDirs)
-			})
-			// temp for testing
-			r.Route("/all", func(r chi.Router) {
-				r.Get("/", api.GetAllDirsInfo)
+		// everything else needs an access token in the Authorization header,
+		// and users can only access their own files, directories, and drives.
+		r.Group(func(r chi.Router) {
+			r.Use(AuthUserHandler)
+
+			// registered devices
+			r.Route("/devices", func(r chi.Router) {
+				r.Get("/", api.GetDevices)         // list the user's devices
+				r.Post("/new", api.RegisterDevice) // register a device and get its API key
+				r.Route("/all", func(r chi.Router) {
+					r.Use(AdminOnly)
+					r.Get("/", api.GetAllDevices)
+				})
+				r.Route("/{deviceID}", func(r chi.Router) {
+					r.Use(DeviceCtx)
+					r.Get("/", api.GetDevice)       // get info about a device
+					r.Delete("/", api.RevokeDevice) // revoke a device's API key
+				})
 			})
-		})
-
-		// drives
-		r.Route("/drive/{driveID}", func(r chi.Router) {
-			r.Use(DriveCtx)
-			r.Get("/", api.GetDrive) // "home" page data for all user's files, directories, etc.
-			// NOTE: new drives are created when a new user is added.
-		})
-		// add a new drive
-		r.Route("/drive/new", func(r chi.Router) {
-			r.Use(NewDriveCtx)
-			r.Post("/", api.NewDrive)
-		})
 
-		// sync operations
-		r.Route("/sync/{driveID}", func(r chi.Router) {
-			r.Use(DriveCtx)
-			// fetch file last sync times for all
-			// user files (in all directories) from server
-			r.Get("/", api.GetIdx)
-			// generate a new sync index for all files on the server
-			// for this user. Populates LastSync map in index.
-			r.Get("/index", api.GenIndex)
-			// refreshes a drives ToUpdate map (assumes LastSync is current),
-			// and returns the servers sync index for this drive/user
-			r.Get("/update", api.GetUpdates)
+			// read-only users can view and download, but can't make changes
+			r.Group(func(r chi.Router) {
+				r.Use(WriteAccess)
+
+				// Get the total runtime of the server and SFS service
+				r.Route("/runtime", func(r chi.Router) {
+					r.Get("/", api.GetRunTime)
+				})
+				r.Route("/users", func(r chi.Router) {
+					r.Route("/{userID}", func(r chi.Router) {
+						r.Use(UserCtx)
+						r.Get("/", api.GetUser)       // get info about a user
+						r.Put("/", api.UpdateUser)    // update a user
+						r.Delete("/", api.DeleteUser) // delete a user
+					})
+					r.Route("/all", func(r chi.Router) {
+						r.Use(AdminOnly)
+						// get a list of all active users
+						r.Get("/", api.GetAllUsers)
+					})
+				})
+
+				// files
+				r.Route("/files", func(r chi.Router) {
+					r.Route("/{fileID}", func(r chi.Router) {
+						r.Use(FileCtx)
+						r.Get("/", api.ServeFile)           // get a file from the server
+						r.Put("/", api.PutFile)             // update a file on the server
+						r.Delete("/", api.DeleteFile)       // delete a file on the server
+						r.Get("/sig", api.GetFileSignature) // get a block signature of the server's copy
+						r.Put("/delta", api.PutFileDelta)   // update a file using a block-level delta
+						r.Put("/move", api.MoveFile)        // move and/or rename a file
+
+						// previous versions
+						r.Get("/versions", api.GetFileVersions) // list previous versions
+						r.Route("/versions/{version}", func(r chi.Router) {
+							r.Use(VersionCtx)
+							r.Get("/", api.ServeFileVersion) // download a previous version
+						})
+
+						// chunked uploads
+						r.Post("/uploads", api.NewUpload) // start or resume an upload
+						r.Route("/uploads/{uploadID}", func(r chi.Router) {
+							r.Use(UploadCtx)
+							r.Get("/", api.GetUpload)           // get received byte ranges
+							r.Put("/", api.PutChunk)            // send a chunk
+							r.Post("/commit", api.CommitUpload) // finish the upload
+							r.Delete("/", api.CancelUpload)     // cancel the upload
+						})
+					})
+					r.Route("/i/all/{userID}", func(r chi.Router) {
+						r.Use(AllUsersFilesCtx)
+						r.Get("/", api.GetAllFileInfo) // get info about all user-specific files
+					})
+					r.Route("/new", func(r chi.Router) { // add a new file on the server
+						r.Use(NewFileCtx)
+						r.Options("/", api.PutFile) // for fetch()'s initial "preflighted" requests. this helps with CORS.
+						r.Post("/", api.PutFile)
+					})
+					r.Route("/i/{fileID}", func(r chi.Router) {
+						r.Use(FileCtx)
+						r.Get("/", api.GetFileInfo) // get info about a file
+					})
+					// temp for testing
+					r.Route("/all", func(r chi.Router) {
+						r.Use(AdminOnly)
+						r.Get("/", api.GetAllFileInfo) // get info
+					})
+				})
+
+				// directories
+				// NOTE: Directories are not supported at this time, but we'll keep these
+				// endpoints in place for future iterations.
+				r.Route("/dirs", func(r chi.Router) {
+					// specific directories
+					r.Route("/{dirID}", func(r chi.Router) {
+						r.Use(DirCtx)
+						r.Get("/", api.GetDir)       // get a directory as a zip file
+						r.Put("/", api.PutDir)       // update a directory on the server by sending a zip file and unpacking
+						r.Delete("/", api.DeleteDir) // delete a directory
+					})
+					// create a new directory
+					r.Route("/new", func(r chi.Router) {
+						r.Use(NewDirectoryCtx)
+						r.Post("/", api.NewDir)
+					})
+					// get info about a directory
+					r.Route("/i/{dirID}", func(r chi.Router) {
+						r.Use(DirCtx)
+						r.Get("/", api.GetDirInfo)
+					})
+					// get info about *all* directories
+					r.Route("/i/all/{userID}", func(r chi.Router) {
+						r.Use(UserCtx)
+						r.Get("/", api.GetUsersDirs)
+					})
+					// temp for testing
+					r.Route("/all", func(r chi.Router) {
+						r.Use(AdminOnly)
+						r.Get("/", api.GetAllDirsInfo)
+					})
+				})
+
+				// drives
+				r.Route("/drive/{driveID}", func(r chi.Router) {
+					r.Use(DriveCtx)
+					r.Get("/", api.GetDrive)                 // "home" page data for all user's files, directories, etc.
+					r.Get("/retention", api.GetRetention)    // get the drive's version retention policy
+					r.Put("/retention", api.SetRetention)    // set the drive's version retention policy
+					r.Get("/snapshot", api.GetDriveSnapshot) // get the drive's files as they were at a point in time
+					r.Get("/usage", api.GetDriveUsage)       // get the drive's quota and space usage by directory
+					r.Get("/list", api.ListDrive)            // list the drive's root along with "Shared with me"
+					// NOTE: new drives are created when a new user is added.
+				})
+				// add a new drive
+				r.Route("/drive/new", func(r chi.Router) {
+					r.Use(NewDriveCtx)
+					r.Post("/", api.NewDrive)
+				})
+
+				// shared files and directories
+				r.Route("/shares", func(r chi.Router) {
+					r.Get("/", api.GetShares)                   // list the user's shares
+					r.Post("/new", api.NewShare)                // share a file or directory
+					r.Get("/with-me", api.GetSharedWithMe)      // list the "Shared with me" directory
+					r.Get("/with-me/files", api.GetSharedFiles) // list every file shared with the user
+					r.Route("/{shareID}", func(r chi.Router) {
+						r.Use(ShareCtx)
+						r.Get("/", api.GetShare)       // get info about a share
+						r.Delete("/", api.RemoveShare) // stop sharing an item
+					})
+				})
+
+				// sync operations
+				r.Route("/sync/{driveID}", func(r chi.Router) {
+					r.Use(DriveCtx)
+					// fetch file last sync times for all
+					// user files (in all directories) from server
+					r.Get("/", api.GetIdx)
+					// generate a new sync index for all files on the server
+					// for this user. Populates LastSync map in index.
+					r.Get("/index", api.GenIndex)
+					// refreshes a drives ToUpdate map (assumes LastSync is current),
+					// and returns the servers sync index for this drive/user
+					r.Get("/update", api.GetUpdates)
+					// fetch all file changes after a given revision.
+					// ex: /v1/sync/{driveID}/changes?since=42
+					r.Get("/changes", api.GetChanges)
+				})
+			})
 		})
 	})
 
@@ -192,7 +355,7 @@ func NewRouter() *chi.Mux {
 	})
 
 	// mount the admin sub-router
-	// r.Mount("/admin", adminRouter())
+	r.Mount("/admin", adminRouter(api))
 
 	// generates a json document of our routing
 	// fmt.Println(docgen.MarkdownRoutesDoc(r, docgen.MarkdownOpts{
@@ -205,34 +368,35 @@ func NewRouter() *chi.Mux {
 
 // ------- admin router --------------------------------
 
-// A completely separate router for administrator routes
-func adminRouter() http.Handler {
+// A completely separate router for administrator routes.
+// every route needs an admin's access token or device key.
+func adminRouter(api *API) http.Handler {
 	r := chi.NewRouter()
 
-	// r.Use(AdminOnly)
-
-	// initialize API handlers
-	api := NewAPI(svcCfg.NewService, true)
+	r.Use(AuthUserHandler)
+	r.Use(AdminOnly)
 
 	r.Route("/users", func(r chi.Router) {
+		r.Get("/", api.AdminGetUsers) // list all users
+		r.Post("/", api.AdminAddUser) // add a new user
 		r.Route("/{userID}", func(r chi.Router) {
 			r.Use(UserCtx)
-			r.Get("/", api.GetUser)        // get info about a user
-			r.Put("/", api.Placeholder)    // update a user
-			r.Delete("/", api.Placeholder) // delete a user)
-
-		})
-		r.Route("/new", func(r chi.Router) {
-			r.Use(NewUserCtx)
-			r.Post("/", api.Placeholder) // add a new user
-		})
-		r.Route("/all", func(r chi.Router) {
-			// get a list of all active users
-			r.Get("/", api.GetAllUsers)
+			r.Get("/", api.GetUser)            // get info about a user
+			r.Put("/", api.AdminUpdateUser)    // update a user
+			r.Delete("/", api.AdminDeleteUser) // delete a user
+			r.Put("/quota", api.SetQuota)      // set the quota for a user's drive
 		})
 	})
 
-	// TODO: other db query routes.
+	r.Route("/drives", func(r chi.Router) {
+		r.Get("/", api.AdminGetDrives) // list all drives and their space usage
+		r.Route("/{driveID}", func(r chi.Router) {
+			r.Use(DriveCtx)
+			r.Get("/", api.GetDrive)                // get a drive's metadata
+			r.Get("/usage", api.GetDriveUsage)      // get a drive's quota and space usage by directory
+			r.Get("/files", api.AdminGetDriveFiles) // list all files in a drive
+		})
+	})
 
 	return r
 }
@@ -1,10 +1,16 @@
 package server
 
 import (
+	"archive/zip"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/sfs/pkg/auth"
@@ -59,6 +65,13 @@ type Service struct {
 	// map of populated drives.
 	// key == userID, val == *svc.Drive
 	Drives map[string]*svc.Drive `json:"drives"`
+
+	// guards upload session updates, since
+	// chunks can be received concurrently.
+	uploadMu sync.Mutex
+
+	// guards blob reference counts
+	blobMu sync.Mutex
 }
 
 // intialize a new empty service struct
@@ -166,65 +179,102 @@ func (s *Service) DriveExists(driveID string) bool {
 	return true
 }
 
-// Populate() populates a drive's root directory with all the users
-// files and subdirectories by recursively traversing the users server-side file system
-// and searching the DB with the name of each file or directory Populate() discoveres
-//
-// Note that Populate() ignores files and subdirectories it doesn't find in the
-// database as its traversing the file system.
+// Populate() populates a directory with all of its files and subdirectories
+// (recursively) from the database. file contents live in the blob store, so
+// there's no server-side file tree to traverse.
 func (s *Service) Populate(root *svc.Directory) *svc.Directory {
-	if root.Path == "" {
-		s.log.Error("can't traverse directory without a path")
-		return root
-	}
-	return s.populate(root)
+	return s.populate(root, make(map[string]bool))
 }
 
-func (s *Service) populate(dir *svc.Directory) *svc.Directory {
-	entries, err := os.ReadDir(dir.ServerPath)
+func (s *Service) populate(dir *svc.Directory, seen map[string]bool) *svc.Directory {
+	seen[dir.ID] = true
+	files, err := s.Db.GetFilesByDirID(dir.ID)
 	if err != nil {
-		s.log.Error("can't read directory: " + dir.ServerPath)
+		s.log.Error(fmt.Sprintf("could not get files for directory (%s) from db: %v", dir.Name, err))
 		return dir
 	}
-	if len(entries) == 0 {
+	for _, file := range files {
+		if dir.HasFile(file.ID) {
+			continue
+		}
+		if err := dir.AddFile(file); err != nil {
+			s.log.Error(fmt.Sprintf("could not add file (%s) to directory: %v", file.Name, err))
+		}
+	}
+	subDirs, err := s.Db.GetDirsByParentID(dir.ID)
+	if err != nil {
+		s.log.Error(fmt.Sprintf("could not get subdirectories for directory (%s) from db: %v", dir.Name, err))
 		return dir
 	}
-	for _, entry := range entries {
-		entryPath := filepath.Join(dir.ServerPath, entry.Name())
-		item, err := os.Stat(entryPath)
-		if err != nil {
-			s.log.Error(fmt.Sprintf("could not get stat for entry %s: %v", entryPath, err))
+	for _, subDir := range subDirs {
+		if seen[subDir.ID] || dir.HasDir(subDir.ID) {
 			continue
 		}
-		// add directory then recurse
-		if item.IsDir() {
-			subDir, err := s.Db.GetDirectoryByPath(entryPath)
-			if err != nil {
-				s.log.Error(fmt.Sprintf("could not get directory (%s) from db: %s", item.Name(), err))
-				continue
-			}
-			if subDir == nil {
-				continue // not found
-			}
-			subDir = s.populate(subDir)
-			dir.AddSubDir(subDir)
-		} else { // add file
-			file, err := s.Db.GetFileByPath(entryPath)
-			if err != nil {
-				s.log.Error(fmt.Sprintf("could not get file (%s) from db: %v", item.Name(), err))
-				continue
-			}
-			if file == nil {
-				continue // not found
-			}
-			if err := dir.AddFile(file); err != nil {
-				s.log.Error(fmt.Sprintf("could not add file (%s) to db: %v", file.Name, err))
-			}
-		}
+		dir.AddSubDir(s.populate(subDir, seen))
 	}
 	return dir
 }
 
+// write a .zip archive of a directory and all of its children to w.
+// entries are named by their path relative to dir.
+func (s *Service) ArchiveDir(dir *svc.Directory, w io.Writer) error {
+	zw := zip.NewWriter(w)
+	if err := s.archiveDir(zw, dir, dir.Name, make(map[string]bool)); err != nil {
+		zw.Close()
+		return err
+	}
+	return zw.Close()
+}
+
+func (s *Service) archiveDir(zw *zip.Writer, dir *svc.Directory, prefix string, seen map[string]bool) error {
+	seen[dir.ID] = true
+	if _, err := zw.Create(prefix + "/"); err != nil {
+		return err
+	}
+	files, err := s.Db.GetFilesByDirID(dir.ID)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := s.archiveFile(zw, file, prefix+"/"+file.Name); err != nil {
+			return err
+		}
+	}
+	subDirs, err := s.Db.GetDirsByParentID(dir.ID)
+	if err != nil {
+		return err
+	}
+	for _, subDir := range subDirs {
+		if seen[subDir.ID] {
+			continue
+		}
+		if err := s.archiveDir(zw, subDir, prefix+"/"+subDir.Name, seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Service) archiveFile(zw *zip.Writer, file *svc.File, name string) error {
+	path, err := s.ContentPath(file.ServerPath)
+	if err != nil {
+		return err
+	}
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dest, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dest, src); err != nil {
+		return fmt.Errorf("failed to archive %s: %v", file.Name, err)
+	}
+	return nil
+}
+
 // attempts to retrieve a drive from the drive map.
 // if Isloaded is false, service will fully load the drive
 // with users files and directories, and generate a new sync index
@@ -382,6 +432,18 @@ func (s *Service) RemoveDrive(driveID string) error {
 		if err := s.Db.RemoveFile(f.ID); err != nil {
 			return err
 		}
+		if err := s.removeBlobRef(f.ServerPath); err != nil {
+			s.log.Error(err.Error())
+		}
+	}
+	versions, err := s.Db.GetDriveVersions(driveID)
+	if err != nil {
+		return err
+	}
+	for _, v := range versions {
+		if err := s.removeVersion(v); err != nil {
+			s.log.Error(err.Error())
+		}
 	}
 	dirs := drv.GetDirsMap()
 	for _, d := range dirs {
@@ -397,6 +459,7 @@ func (s *Service) RemoveDrive(driveID string) error {
 	if err := s.Db.RemoveDrive(driveID); err != nil {
 		return err
 	}
+	s.gcBlobs()
 	// remove from drives map and save state
 	delete(s.Drives, driveID)
 	if err := s.SaveState(); err != nil {
@@ -466,6 +529,17 @@ func (s *Service) RemoveUser(userID string) error {
 		if err := s.Db.RemoveUser(usr.ID); err != nil {
 			return err
 		}
+		// anything they shared, or that was shared with them, goes with them
+		if err := s.Db.RemoveUsersShares(usr.ID); err != nil {
+			return err
+		}
+		// make sure nothing can be done with their sessions or devices
+		if err := s.RevokeSessions(usr.ID); err != nil {
+			return err
+		}
+		if err := s.revokeDevices(usr.ID); err != nil {
+			return err
+		}
 		// delete from service instance
 		delete(s.Users, usr.ID)
 		s.log.Info(fmt.Sprintf("user (id=%s) removed", userID))
@@ -499,6 +573,17 @@ func (s *Service) GetUser(userId string) (*auth.User, error) {
 }
 
 func (s *Service) updateUser(user *auth.User) error {
+	// passwords aren't saved in the state file, so users loaded
+	// from it won't have one. keep the hash that's in the db.
+	if user.Password == "" {
+		u, err := s.Db.GetUser(user.ID)
+		if err != nil {
+			return err
+		}
+		if u != nil {
+			user.Password = u.Password
+		}
+	}
 	if err := s.Db.UpdateUser(user); err != nil {
 		return fmt.Errorf("failed to update user in database: %v", err)
 	}
@@ -549,13 +634,21 @@ func (s *Service) GetFile(driveID string, fileID string) (*svc.File, error) {
 	return file, nil
 }
 
-// get all file objects for this user.
+// get all file objects for this user. files are read from the database
+// so their directory IDs reflect where they actually are in the drive.
 func (s *Service) GetAllFiles(driveID string) (map[string]*svc.File, error) {
 	drive := s.GetDrive(driveID)
 	if drive == nil {
 		return nil, fmt.Errorf("drive (id=%s) not found", driveID)
 	}
-	files := drive.GetFilesMap()
+	dbFiles, err := s.Db.GetFilesByDriveID(driveID)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]*svc.File, len(dbFiles))
+	for _, file := range dbFiles {
+		files[file.ID] = file
+	}
 	if len(files) == 0 {
 		s.log.Info(fmt.Sprintf("no files in drive (id=%s)", driveID))
 	}
@@ -573,6 +666,13 @@ func (s *Service) buildServerDirPath(parentServerPath string, itemName string) s
 	return filepath.Join(parentServerPath, itemName)
 }
 
+var (
+	// returned when a file or directory name can't be used as a single path element
+	ErrInvalidName = errors.New("invalid name")
+	// returned when another file already has the server path a file would be given
+	ErrPathTaken = errors.New("already exists")
+)
+
 // add a new file to the service. creates the physical file,
 // and updates internal service state.
 func (s *Service) AddFile(dirID string, file *svc.File) error {
@@ -580,6 +680,9 @@ func (s *Service) AddFile(dirID string, file *svc.File) error {
 	if drive == nil {
 		return fmt.Errorf("drive (id=%s) not found", file.DriveID)
 	}
+	if !svc.ValidName(file.Name) {
+		return fmt.Errorf("%w: '%s'", ErrInvalidName, file.Name)
+	}
 	// make sure the files parent directory exists on the server
 	// first. if not, add to server-side sfs root.
 	parentDir, err := s.Db.GetDirectoryByID(dirID)
@@ -592,6 +695,9 @@ func (s *Service) AddFile(dirID string, file *svc.File) error {
 	// uploaded to the server we need to set a unique server path so we
 	// can differentiate between client and server upload/download locations.
 	// NOTE: client makes an additional call to retrieve this new path
+	if err := s.checkQuota(file.DriveID, file.Size); err != nil {
+		return err
+	}
 	if parentDir == nil {
 		file.DirID = drive.Root.ID
 		file.ServerPath = s.buildServerRootPath(drive.OwnerName, file.Name)
@@ -599,11 +705,21 @@ func (s *Service) AddFile(dirID string, file *svc.File) error {
 		file.DirID = parentDir.ID
 		file.ServerPath = s.buildServerDirPath(parentDir.ServerPath, file.Name)
 	}
+	// contents are found by server path, so it can't be shared with another file
+	if taken, err := s.pathTaken(file.ServerPath); err != nil {
+		return err
+	} else if taken {
+		return fmt.Errorf("'%s' %w", file.Name, ErrPathTaken)
+	}
 
-	// create the intial (empty) physical file on the server side
-	_, err = os.Create(file.ServerPath)
+	// the file starts out empty. its contents are sent separately.
+	tmpPath, _, err := s.WriteTmpFile(strings.NewReader(""))
 	if err != nil {
-		return fmt.Errorf("failed to file on server: %v", err)
+		return err
+	}
+	if _, err := s.storeBlob(file.ServerPath, tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to create file on server: %v", err)
 	}
 
 	// mark this as a server back up so we can access it
@@ -618,41 +734,216 @@ func (s *Service) AddFile(dirID string, file *svc.File) error {
 	if err := s.Db.AddFile(file); err != nil {
 		return fmt.Errorf("failed to add file to database: %v", err)
 	}
+	if err := s.bumpRevision(file); err != nil {
+		return err
+	}
+	s.refreshUsage(file.DriveID)
 	if err := s.SaveState(); err != nil {
 		return fmt.Errorf("failed to save state: %v", err)
 	}
 	return nil
 }
 
-// update a file in the service.
-func (s *Service) UpdateFile(file *svc.File, data []byte) error {
+// update a file's contents. data is streamed to a temp file and its checksum
+// is computed as it's received. if checksum is empty then svc.ErrNoChecksum is
+// returned, and if it doesn't match then svc.ErrChecksumMismatch is returned.
+// the server's copy is left as is in either case.
+func (s *Service) UpdateFile(file *svc.File, data io.Reader, checksum string) error {
+	if checksum == "" {
+		return fmt.Errorf("%w sent for %s (id=%s)", svc.ErrNoChecksum, file.Name, file.ID)
+	}
+	tmpPath, cs, err := s.WriteTmpFile(data)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+	if err := svc.VerifyChecksum(checksum, cs); err != nil {
+		return fmt.Errorf("received data for %s (id=%s) is corrupt: %w", file.Name, file.ID, err)
+	}
+	return s.ReplaceFile(file, tmpPath)
+}
+
+// replace the server's copy of a file with the file at srcPath.
+// srcPath is moved into the blob store, so it should be on the same
+// file system as the service root (i.e. created with WriteTmpFile()).
+func (s *Service) ReplaceFile(file *svc.File, srcPath string) error {
 	drive := s.GetDrive(file.DriveID)
 	if drive == nil {
 		return fmt.Errorf("drive (id=%s) not found", file.DriveID)
 	}
-	// make sure we update this file in the correct directory
-	dir := drive.GetDir(file.DirID)
-	if dir == nil {
+	if drive.GetDir(file.DirID) == nil {
 		return fmt.Errorf("file's directory not found")
 	}
-	if err := dir.ModifyFile(file, data); err != nil {
+	info, err := os.Stat(srcPath)
+	if err != nil {
+		return fmt.Errorf("failed to get size of new contents: %v", err)
+	}
+	if err := s.checkQuota(file.DriveID, info.Size()-file.Size); err != nil {
+		return err
+	}
+	v, err := s.saveVersion(drive, file)
+	if err != nil {
+		return err
+	}
+	if err := s.replaceContents(file, srcPath); err != nil {
+		s.discardVersion(v)
 		return err
 	}
+	s.pruneVersions(file)
 	if err := s.Db.UpdateFile(file); err != nil {
 		return err
 	}
+	if err := s.bumpRevision(file); err != nil {
+		return err
+	}
+	s.refreshUsage(file.DriveID)
 	if err := s.SaveState(); err != nil {
 		return fmt.Errorf("failed to save state: %v", err)
 	}
 	return nil
 }
 
-// deletes a file and updates the database.
-func (s *Service) DeleteFile(file *svc.File) error {
+// stream data to a new temp file in the uploads directory, and return its
+// path along with the checksum of the data. the caller is responsible for
+// removing it.
+func (s *Service) WriteTmpFile(src io.Reader) (string, string, error) {
+	if err := os.MkdirAll(s.uploadDir(), 0755); err != nil {
+		return "", "", fmt.Errorf("failed to create uploads directory: %v", err)
+	}
+	tmp, err := os.CreateTemp(s.uploadDir(), "recv-*")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create temp file: %v", err)
+	}
+	h := svc.NewHasher()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), src); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", "", fmt.Errorf("failed to write temp file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", "", fmt.Errorf("failed to write temp file: %v", err)
+	}
+	return tmp.Name(), h.Sum(), nil
+}
+
+// build a block signature of the server's copy of a file.
+// clients use this to figure out which blocks they need to send.
+func (s *Service) GetFileSignature(file *svc.File) (*svc.Signature, error) {
+	path, err := s.ContentPath(file.ServerPath)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := svc.BuildSignature(path, svc.BLOCK_SIZE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build signature for %s (id=%s): %v", file.Name, file.ID, err)
+	}
+	sig.FileID = file.ID
+	return sig, nil
+}
+
+// update a file in the service using a block-level delta.
+// the file is rebuilt from the server's current copy and only
+// the blocks the client sent.
+func (s *Service) UpdateFileDelta(file *svc.File, delta *svc.Delta) error {
 	drive := s.GetDrive(file.DriveID)
 	if drive == nil {
 		return fmt.Errorf("drive (id=%s) not found", file.DriveID)
 	}
+	if drive.GetDir(file.DirID) == nil {
+		return fmt.Errorf("file's directory not found")
+	}
+	// quota is checked against the rebuilt file in patchContents
+	// rather than the size the delta claims
+	v, err := s.saveVersion(drive, file)
+	if err != nil {
+		return err
+	}
+	if err := s.patchContents(file, delta); err != nil {
+		s.discardVersion(v)
+		return err
+	}
+	s.pruneVersions(file)
+	if err := s.Db.UpdateFile(file); err != nil {
+		return err
+	}
+	if err := s.bumpRevision(file); err != nil {
+		return err
+	}
+	s.refreshUsage(file.DriveID)
+	if err := s.SaveState(); err != nil {
+		return fmt.Errorf("failed to save state: %v", err)
+	}
+	return nil
+}
+
+// move (and/or rename) a file on the server. the server's copy is relocated
+// rather than having the client upload it again. if destDirID isn't a known
+// directory then the file is moved to the drive's root directory.
+func (s *Service) MoveFile(file *svc.File, destDirID string, newName string) error {
+	drive := s.GetDrive(file.DriveID)
+	if drive == nil {
+		return fmt.Errorf("drive (id=%s) not found", file.DriveID)
+	}
+	// use the drive's copy of the file if we have one
+	if f := drive.GetFile(file.ID); f != nil {
+		file = f
+	}
+	if newName == "" {
+		newName = file.Name
+	} else if !svc.ValidName(newName) {
+		return fmt.Errorf("%w: '%s'", ErrInvalidName, newName)
+	}
+	var newPath string
+	destDir := drive.GetDir(destDirID)
+	if destDir == nil || destDir.ID == drive.Root.ID {
+		destDir = drive.Root
+		newPath = s.buildServerRootPath(drive.OwnerName, newName)
+	} else {
+		newPath = s.buildServerDirPath(destDir.ServerPath, newName)
+	}
+	if newPath == file.ServerPath && destDir.ID == file.DirID {
+		return nil
+	}
+	if taken, err := s.pathTaken(newPath); err != nil {
+		return err
+	} else if taken {
+		return fmt.Errorf("'%s' %w", newName, ErrPathTaken)
+	}
+	if err := s.moveBlobRef(file.ServerPath, newPath); err != nil {
+		return fmt.Errorf("failed to move %s (id=%s): %v", file.Name, file.ID, err)
+	}
+	file.Rename(newName)
+	file.ServerPath = newPath
+	file.Path = newPath
+	if err := drive.MoveFile(destDir.ID, file); err != nil {
+		return fmt.Errorf("failed to move %s (id=%s) in drive: %v", file.Name, file.ID, err)
+	}
+	if err := s.Db.UpdateFile(file); err != nil {
+		return fmt.Errorf("failed to update %s (id=%s) in database: %v", file.Name, file.ID, err)
+	}
+	if err := s.bumpRevision(file); err != nil {
+		return err
+	}
+	if err := s.SaveState(); err != nil {
+		return fmt.Errorf("failed to save state: %v", err)
+	}
+	return nil
+}
+
+// deletes a file and updates the database. a tombstone is recorded
+// so other clients know to remove their copies on their next sync.
+// device is the name of the device the file was deleted from.
+func (s *Service) DeleteFile(file *svc.File, device string) error {
+	drive := s.GetDrive(file.DriveID)
+	if drive == nil {
+		return fmt.Errorf("drive (id=%s) not found", file.DriveID)
+	}
+	// keep the file's last contents so the drive can be restored to a time
+	// before it was deleted. the version's replaced time is the deletion time.
+	if _, err := s.saveVersion(drive, file); err != nil {
+		s.log.Error(err.Error())
+	}
 	// remove file from the service.
 	// NOTE: client side will have the original file moved to the client's recycle bin.
 	if err := drive.RemoveFile(file.DirID, file); err != nil {
@@ -661,21 +952,18 @@ func (s *Service) DeleteFile(file *svc.File) error {
 	if err := s.Db.RemoveFile(file.ID); err != nil {
 		return fmt.Errorf("failed to remove %s (id=%s) from database: %v", file.Name, file.ID, err)
 	}
+	if _, err := s.Db.AddTombstone(file, device); err != nil {
+		return fmt.Errorf("failed to record tombstone for %s (id=%s): %v", file.Name, file.ID, err)
+	}
+	// finally, release the file's contents. they're kept in the blob store
+	// until nothing else (i.e. the version saved above) refers to them.
+	if err := s.removeBlobRef(file.ServerPath); err != nil {
+		s.log.Error(fmt.Sprintf("failed to remove contents of %s (id=%s): %v", file.Name, file.ID, err))
+	}
+	s.refreshUsage(file.DriveID)
 	if err := s.SaveState(); err != nil {
 		s.log.Error(fmt.Sprintf("failed to save state: %v", err))
 	}
-	// finally, remove the physical file on the server.
-	// dont want users files to be left on the server after removing them
-	// from the service.
-	//
-	// TODO: add checks to make sure this isn't actually something vital,
-	// or in any way a security threat! We need to verify API calls
-	// before we start deleting things willy nilly.
-	// maybe check file.ServerPath against known paths before calling
-	// os.Remove()?
-	// if err := os.Remove(file.ServerPath); err != nil {
-	// 	s.log.Error(fmt.Sprintf("failed to remove physical file on the server: %v", err))
-	// }
 	return nil
 }
 
@@ -688,6 +976,9 @@ func (s *Service) NewDir(driveID string, destDirID string, newDir *svc.Directory
 	if drive == nil {
 		return fmt.Errorf("drive (id=%s) not found", driveID)
 	}
+	if !svc.ValidName(newDir.Name) {
+		return fmt.Errorf("%w: '%s'", ErrInvalidName, newDir.Name)
+	}
 	// check if this directory already exists
 	nd := drive.GetDir(newDir.ID)
 	if nd != nil {
@@ -696,7 +987,7 @@ func (s *Service) NewDir(driveID string, destDirID string, newDir *svc.Directory
 	// NOTE: server doesn't actually create a backup directory for this object.
 	// it's only concerned about keeping records of the directories used by the files
 	// being backed up.
-	// Files are kept in a "flat" server-side directory, so maintaining the original
+	// File contents are kept in the server's blob store, so maintaining the original
 	// directory tree structure isn't necessary since the server is only about object storage
 	newDir.Parent = drive.Root
 	newDir.ParentID = drive.Root.ID
@@ -757,7 +1048,7 @@ func (s *Service) GetDirByID(dirID string) (*svc.Directory, error) {
 //
 // it's assumed dirID is a sub-directory within the drive, and not
 // the drives root directory itself.
-func (s *Service) RemoveDir(driveID string, dirID string) error {
+func (s *Service) RemoveDir(driveID string, dirID string, device string) error {
 	drive := s.GetDrive(driveID)
 	if drive == nil {
 		return fmt.Errorf("drive (id=%s) not found", driveID)
@@ -791,6 +1082,12 @@ func (s *Service) RemoveDir(driveID string, dirID string) error {
 		if err := s.Db.RemoveFile(file.ID); err != nil {
 			return err
 		}
+		if _, err := s.Db.AddTombstone(file, device); err != nil {
+			return err
+		}
+		if err := s.removeBlobRef(file.ServerPath); err != nil {
+			s.log.Error(fmt.Sprintf("failed to remove contents of %s (id=%s): %v", file.Name, file.ID, err))
+		}
 	}
 	// remove directory itself from the service
 	if err := s.Db.RemoveDirectory(dirID); err != nil {
@@ -802,6 +1099,7 @@ func (s *Service) RemoveDir(driveID string, dirID string) error {
 	if err := drive.RemoveDir(dirID); err != nil {
 		return fmt.Errorf("failed to remove dir %s: %v", dirID, err)
 	}
+	s.refreshUsage(driveID)
 	// lastly, remove the physical directory and all its subdirectories
 	// don't want users files to remain on the server after they're done.
 	if err := os.RemoveAll(dir.ServerPath); err != nil {
@@ -825,21 +1123,27 @@ func (s *Service) UpdateDir(driveID string, dir *svc.Directory) error {
 	return nil
 }
 
-// retrieves all directories available for user using the current drive state.
+// retrieves all directories available for user, not including the drive's root.
+// directories are read from the database so their parent IDs are preserved.
 // returns nil if no directories are available.
 func (s *Service) GetAllDirs(driveID string) ([]*svc.Directory, error) {
 	drive := s.GetDrive(driveID)
 	if drive == nil {
 		return nil, fmt.Errorf("drive (id=%s) not found", driveID)
 	}
-	subDirs := drive.GetDirsMap()
-	if len(subDirs) == 0 {
-		s.log.Info(fmt.Sprintf("no directories found for user (id=%s)", drive.OwnerID))
-		return nil, nil
+	subDirs, err := s.Db.GetDirsByDriveID(driveID)
+	if err != nil {
+		return nil, err
 	}
 	dirs := make([]*svc.Directory, 0, len(subDirs))
 	for _, sd := range subDirs {
-		dirs = append(dirs, sd)
+		if sd.ID != drive.RootID {
+			dirs = append(dirs, sd)
+		}
+	}
+	if len(dirs) == 0 {
+		s.log.Info(fmt.Sprintf("no directories found for user (id=%s)", drive.OwnerID))
+		return nil, nil
 	}
 	return dirs, nil
 }
@@ -856,10 +1160,617 @@ func (s *Service) GenSyncIndex(driveID string) (*svc.SyncIndex, error) {
 	if drive.Root == nil {
 		return nil, fmt.Errorf("drive (id=%s) root not found", drive.RootID)
 	}
+	s.gcTombstones()
+	s.gcBlobs()
 	drive.SyncIndex = svc.BuildRootSyncIndex(drive.Root)
+	revs, err := s.Db.GetRevisionsAfter(driveID, 0)
+	if err != nil {
+		return nil, err
+	}
+	drive.SyncIndex.AddRevisions(revs)
 	return drive.SyncIndex, nil
 }
 
+// assign the next drive revision to a file. called whenever
+// a file's contents are changed on the server.
+func (s *Service) bumpRevision(file *svc.File) error {
+	rev, err := s.Db.NextRevision(file)
+	if err != nil {
+		return fmt.Errorf("failed to assign revision to %s (id=%s): %v", file.Name, file.ID, err)
+	}
+	s.log.Info(fmt.Sprintf("%s (id=%s) is now at revision %d", file.Name, file.ID, rev))
+	return nil
+}
+
+// ---- file versions --------------------------------
+
+// where previous versions of a drive's files are kept
+func (s *Service) backupDir(drive *svc.Drive) string {
+	return s.buildServerRootPath(drive.OwnerName, "backups")
+}
+
+// keep the current contents of a file as a new version before they're replaced.
+// returns nil if the file doesn't have any contents on the server yet.
+//
+// a version's path is a logical path in the drive's backups directory that
+// points to the same blob as the file, so nothing is copied.
+func (s *Service) saveVersion(drive *svc.Drive, file *svc.File) (*svc.Version, error) {
+	path := filepath.Join(s.backupDir(drive), file.ID, auth.NewUUID())
+	b, err := s.copyBlobRef(file.ServerPath, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save version of %s (id=%s): %v", file.Name, file.ID, err)
+	}
+	if b == nil {
+		return nil, nil
+	}
+	v := svc.NewVersion(file, path)
+	v.Size, v.CheckSum = b.Size, b.CheckSum
+	// the file's last sync time changes whenever the drive is loaded, so use
+	// the time its current contents were given a revision when we have it.
+	if rev, err := s.Db.GetRevision(file.ID); err == nil && rev != nil && !rev.Deleted {
+		v.Created = rev.LastSync
+	}
+	if _, err := s.Db.AddVersion(v); err != nil {
+		if err := s.removeBlobRef(path); err != nil {
+			s.log.Error(err.Error())
+		}
+		return nil, err
+	}
+	s.log.Info(fmt.Sprintf("saved version %d of %s (id=%s)", v.Number, file.Name, file.ID))
+	return v, nil
+}
+
+// remove a version that was saved for an update that didn't go through
+func (s *Service) discardVersion(v *svc.Version) {
+	if v == nil {
+		return
+	}
+	if err := s.removeVersion(v); err != nil {
+		s.log.Error(err.Error())
+	}
+}
+
+func (s *Service) removeVersion(v *svc.Version) error {
+	if err := s.removeBlobRef(v.Path); err != nil {
+		return fmt.Errorf("failed to remove version %d of %s (id=%s): %v", v.Number, v.Name, v.FileID, err)
+	}
+	return s.Db.RemoveVersion(v.FileID, v.Number)
+}
+
+// remove any versions of a file that aren't kept by its drive's retention policy.
+// failures are only logged, since the file itself was updated successfully.
+func (s *Service) pruneVersions(file *svc.File) {
+	policy, err := s.GetRetention(file.DriveID)
+	if err != nil {
+		s.log.Error(err.Error())
+		return
+	}
+	versions, err := s.Db.GetVersions(file.ID)
+	if err != nil {
+		s.log.Error(err.Error())
+		return
+	}
+	for _, v := range policy.Prune(versions, time.Now().UTC()) {
+		if err := s.removeVersion(v); err != nil {
+			s.log.Error(err.Error())
+			continue
+		}
+		s.log.Info(fmt.Sprintf("removed version %d of %s (id=%s)", v.Number, v.Name, v.FileID))
+	}
+}
+
+// get all previous versions of a file, oldest first.
+func (s *Service) GetVersions(file *svc.File) ([]*svc.Version, error) {
+	return s.Db.GetVersions(file.ID)
+}
+
+// get a previous version of a file.
+func (s *Service) GetVersion(file *svc.File, version int64) (*svc.Version, error) {
+	v, err := s.Db.GetVersion(file.ID, version)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return nil, fmt.Errorf("version %d of %s (id=%s) not found", version, file.Name, file.ID)
+	}
+	return v, nil
+}
+
+// get a drive's version retention policy. drives that
+// haven't set one use svc.DefaultRetention().
+func (s *Service) GetRetention(driveID string) (*svc.RetentionPolicy, error) {
+	p, err := s.Db.GetRetention(driveID)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return svc.DefaultRetention(driveID), nil
+	}
+	return p, nil
+}
+
+// set a drive's version retention policy. existing versions
+// are pruned the next time each file is updated.
+func (s *Service) SetRetention(p *svc.RetentionPolicy) error {
+	if s.GetDrive(p.DriveID) == nil {
+		return fmt.Errorf("drive (id=%s) not found", p.DriveID)
+	}
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	return s.Db.SetRetention(p)
+}
+
+// ---- quotas --------------------------------
+
+// recalculate a drive's used space from its files in the database, and save it.
+// usage is always recalculated rather than adjusted so it can't drift.
+func (s *Service) updateUsage(driveID string) (*svc.Drive, error) {
+	drive, err := s.Db.GetDrive(driveID)
+	if err != nil {
+		return nil, err
+	}
+	if drive == nil {
+		return nil, fmt.Errorf("drive (id=%s) not found", driveID)
+	}
+	files, err := s.Db.GetFilesByDriveID(driveID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get files for drive (id=%s): %v", driveID, err)
+	}
+	var used int64
+	for _, file := range files {
+		used += file.Size
+	}
+	drive.SetUsage(used)
+	if err := s.Db.UpdateDrive(drive); err != nil {
+		return nil, fmt.Errorf("failed to update drive usage: %v", err)
+	}
+	if d, exists := s.Drives[driveID]; exists {
+		d.TotalSize = drive.TotalSize
+		d.SetUsage(used)
+	}
+	return drive, nil
+}
+
+// same as updateUsage(), but only logs errors. used after changes
+// have already been made, where a failure shouldn't undo them.
+func (s *Service) refreshUsage(driveID string) {
+	if _, err := s.updateUsage(driveID); err != nil {
+		s.log.Error(fmt.Sprintf("failed to update usage for drive (id=%s): %v", driveID, err))
+	}
+}
+
+// check whether a drive has room for delta more bytes.
+// returns an error wrapping svc.ErrQuotaExceeded if it doesn't.
+func (s *Service) checkQuota(driveID string, delta int64) error {
+	if delta <= 0 {
+		return nil
+	}
+	drive, err := s.updateUsage(driveID)
+	if err != nil {
+		return err
+	}
+	return drive.CheckQuota(delta)
+}
+
+// get a drive's space usage, broken down by directory
+func (s *Service) GetUsage(driveID string) (*svc.DriveUsage, error) {
+	drive, err := s.updateUsage(driveID)
+	if err != nil {
+		return nil, err
+	}
+	dirs, err := s.Db.GetDirsByDriveID(driveID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get directories for drive (id=%s): %v", driveID, err)
+	}
+	files, err := s.Db.GetFilesByDriveID(driveID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get files for drive (id=%s): %v", driveID, err)
+	}
+	return svc.NewDriveUsage(drive, dirs, files), nil
+}
+
+// get the space usage of every drive on the server, without the per-directory breakdown
+func (s *Service) GetAllUsage() ([]*svc.DriveUsage, error) {
+	drives, err := s.Db.GetDrives()
+	if err != nil {
+		return nil, err
+	}
+	usage := make([]*svc.DriveUsage, 0, len(drives))
+	for _, drive := range drives {
+		u, err := s.GetUsage(drive.ID)
+		if err != nil {
+			return nil, err
+		}
+		u.Dirs = nil
+		usage = append(usage, u)
+	}
+	return usage, nil
+}
+
+// set the quota for a user's drive, in bytes. a quota smaller than the
+// drive's current usage is allowed, but nothing can be added until
+// enough space is freed.
+func (s *Service) SetQuota(userID string, size int64) error {
+	if size <= 0 {
+		return fmt.Errorf("invalid quota: %d. must be greater than 0", size)
+	}
+	user, err := s.Db.GetUser(userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return fmt.Errorf("user (id=%s) not found", userID)
+	}
+	drive, err := s.Db.GetDrive(user.DriveID)
+	if err != nil {
+		return err
+	}
+	if drive == nil {
+		return fmt.Errorf("drive (id=%s) not found for user (id=%s)", user.DriveID, userID)
+	}
+	drive.TotalSize = size
+	if err := s.Db.UpdateDrive(drive); err != nil {
+		return fmt.Errorf("failed to update drive quota: %v", err)
+	}
+	s.log.Info(fmt.Sprintf("set quota for user (id=%s) to %d bytes", userID, size))
+	_, err = s.updateUsage(drive.ID)
+	return err
+}
+
+// reconstruct a drive's files as they were at the given time, using the
+// current files, their saved versions, and tombstones for deleted files.
+//
+// each file uses whichever of its contents were written most recently at
+// or before the given time. files that were deleted by then, or that have
+// no contents from before it, are left out. files from deleted directories
+// are placed in the drive's root directory.
+func (s *Service) DriveAt(driveID string, at time.Time) (*svc.Snapshot, error) {
+	drive := s.GetDrive(driveID)
+	if drive == nil {
+		return nil, fmt.Errorf("drive (id=%s) not found", driveID)
+	}
+	current, err := s.GetAllFiles(driveID)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := s.Db.GetDriveVersions(driveID)
+	if err != nil {
+		return nil, err
+	}
+	revs, err := s.Db.GetRevisionsAfter(driveID, 0)
+	if err != nil {
+		return nil, err
+	}
+	// when each file's current contents were written, or when it was deleted
+	written := make(map[string]time.Time)
+	deleted := make(map[string]time.Time)
+	for _, rev := range revs {
+		if rev.Deleted {
+			deleted[rev.FileID] = rev.LastSync
+		} else {
+			written[rev.FileID] = rev.LastSync
+		}
+	}
+	byFile := make(map[string][]*svc.Version)
+	for _, v := range versions {
+		byFile[v.FileID] = append(byFile[v.FileID], v)
+	}
+
+	snapshot := &svc.Snapshot{DriveID: driveID, At: at.UTC(), Files: make([]*svc.SnapshotFile, 0)}
+	dirs := drive.GetDirsMap()
+	add := func(sf *svc.SnapshotFile) {
+		sf.Path = filepath.Join(snapshotDirPath(sf.DirID, dirs), sf.Name)
+		snapshot.Files = append(snapshot.Files, sf)
+	}
+
+	// files still on the server
+	for _, file := range current {
+		var sf *svc.SnapshotFile
+		modified, ok := written[file.ID]
+		if !ok {
+			modified = file.LastSync
+		}
+		if !modified.After(at) {
+			sf = &svc.SnapshotFile{
+				FileID:   file.ID,
+				Name:     file.Name,
+				DirID:    file.DirID,
+				Size:     file.Size,
+				CheckSum: file.CheckSum,
+				Modified: modified,
+			}
+		} else if v := versionAt(byFile[file.ID], at); v != nil {
+			sf = snapshotVersion(v)
+		}
+		if sf != nil {
+			add(sf)
+		}
+		delete(byFile, file.ID)
+	}
+
+	// files that have since been deleted
+	for fileID, vs := range byFile {
+		removed, ok := deleted[fileID]
+		if !ok {
+			// no tombstone. use the last time the file's contents were replaced
+			removed = vs[len(vs)-1].Replaced
+		}
+		if !at.Before(removed) {
+			continue
+		}
+		if v := versionAt(vs, at); v != nil {
+			add(snapshotVersion(v))
+		}
+	}
+	sort.Slice(snapshot.Files, func(i, j int) bool { return snapshot.Files[i].Path < snapshot.Files[j].Path })
+	return snapshot, nil
+}
+
+// get the most recent version that was written at or before the given time.
+// versions are expected to be oldest first.
+func versionAt(versions []*svc.Version, at time.Time) *svc.Version {
+	for i := len(versions) - 1; i >= 0; i-- {
+		if !versions[i].Created.After(at) {
+			return versions[i]
+		}
+	}
+	return nil
+}
+
+func snapshotVersion(v *svc.Version) *svc.SnapshotFile {
+	return &svc.SnapshotFile{
+		FileID:   v.FileID,
+		Name:     v.Name,
+		DirID:    v.DirID,
+		Version:  v.Number,
+		Size:     v.Size,
+		CheckSum: v.CheckSum,
+		Modified: v.Created,
+	}
+}
+
+// path of a directory relative to the drive's root. directories that
+// no longer exist are treated as the root.
+func snapshotDirPath(dirID string, dirs map[string]*svc.Directory) string {
+	var parts []string
+	seen := make(map[string]bool)
+	for dir, ok := dirs[dirID]; ok && !dir.Root && !seen[dir.ID]; dir, ok = dirs[dir.ParentID] {
+		seen[dir.ID] = true
+		parts = append([]string{dir.Name}, parts...)
+	}
+	return filepath.Join(parts...)
+}
+
+// ---- chunked uploads --------------------------------
+
+// how long an upload session can go without receiving
+// any data before it's removed.
+const UPLOAD_EXPIRY = time.Hour * 24 * 7
+
+var (
+	// returned when an upload session doesn't exist, or belongs to another file
+	ErrUploadNotFound = errors.New("upload not found")
+	// returned when a chunk doesn't fit within its upload
+	ErrBadOffset = errors.New("invalid offset")
+	// returned when committing an upload that's missing data
+	ErrUploadIncomplete = errors.New("upload incomplete")
+)
+
+// where partially uploaded files are kept
+func (s *Service) uploadDir() string { return filepath.Join(s.SvcRoot, "uploads") }
+
+// start a chunked upload for a file. if the file already has an upload session
+// for the same size and checksum, then that session is returned instead so the
+// client can pick up where it left off. checksum is required, since it's
+// what the received data is verified against when the upload is committed.
+func (s *Service) NewUpload(file *svc.File, size int64, checksum string) (*svc.UploadSession, error) {
+	if size < 0 {
+		return nil, fmt.Errorf("invalid upload size: %d", size)
+	}
+	if checksum == "" {
+		return nil, fmt.Errorf("%w sent for upload of %s (id=%s)", svc.ErrNoChecksum, file.Name, file.ID)
+	}
+	// reject uploads that won't fit before any data is sent
+	if err := s.checkQuota(file.DriveID, size-file.Size); err != nil {
+		return nil, err
+	}
+	s.gcUploads()
+
+	u, err := s.Db.GetUploadByFileID(file.ID)
+	if err != nil {
+		return nil, err
+	}
+	if u != nil {
+		if u.Size == size && u.CheckSum == checksum {
+			if _, err := os.Stat(u.TmpPath); err == nil {
+				s.log.Info(fmt.Sprintf("resuming upload for %s (id=%s) at %d of %d bytes", file.Name, file.ID, u.Total(), u.Size))
+				return u, nil
+			}
+		}
+		if err := s.RemoveUpload(u); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := os.MkdirAll(s.uploadDir(), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create upload directory: %v", err)
+	}
+	u = svc.NewUploadSession(file, size, checksum, "")
+	u.TmpPath = filepath.Join(s.uploadDir(), u.ID)
+	tmp, err := os.Create(u.TmpPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upload file: %v", err)
+	}
+	tmp.Close()
+	if err := s.Db.SetUpload(u); err != nil {
+		os.Remove(u.TmpPath)
+		return nil, err
+	}
+	s.log.Info(fmt.Sprintf("started upload %s for %s (id=%s, %d bytes)", u.ID, file.Name, file.ID, size))
+	return u, nil
+}
+
+// get an upload session. returns an error if it isn't found.
+func (s *Service) GetUpload(uploadID string) (*svc.UploadSession, error) {
+	u, err := s.Db.GetUpload(uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, fmt.Errorf("%w (id=%s)", ErrUploadNotFound, uploadID)
+	}
+	return u, nil
+}
+
+// write a chunk of a file starting at the given offset. chunks can be sent in any
+// order, and chunks that were already received can be sent again.
+func (s *Service) WriteChunk(u *svc.UploadSession, offset int64, chunk io.Reader) error {
+	if offset < 0 || offset > u.Size {
+		return fmt.Errorf("%w %d for upload of %d bytes", ErrBadOffset, offset, u.Size)
+	}
+	// stage the chunk first so one that's too big doesn't
+	// overwrite data that's already been received.
+	part, err := os.CreateTemp(filepath.Dir(u.TmpPath), u.ID+"-*.part")
+	if err != nil {
+		return fmt.Errorf("failed to create chunk file: %v", err)
+	}
+	defer func() {
+		part.Close()
+		os.Remove(part.Name())
+	}()
+	// read one byte past the end of the file so chunks that are too big are caught
+	n, err := io.Copy(part, io.LimitReader(chunk, u.Size-offset+1))
+	if err != nil {
+		return fmt.Errorf("failed to read chunk: %v", err)
+	}
+	if offset+n > u.Size {
+		return fmt.Errorf("%w. chunk at offset %d exceeds upload size of %d bytes", ErrBadOffset, offset, u.Size)
+	}
+
+	tmp, err := os.OpenFile(u.TmpPath, os.O_WRONLY, svc.PERMS)
+	if err != nil {
+		return fmt.Errorf("failed to open upload file: %v", err)
+	}
+	defer tmp.Close()
+	if _, err := io.Copy(io.NewOffsetWriter(tmp, offset), io.NewSectionReader(part, 0, n)); err != nil {
+		return fmt.Errorf("failed to write chunk: %v", err)
+	}
+	// other chunks may have been received since u was loaded
+	s.uploadMu.Lock()
+	defer s.uploadMu.Unlock()
+	cur, err := s.GetUpload(u.ID)
+	if err != nil {
+		return err
+	}
+	cur.AddRange(offset, offset+n)
+	if err := s.Db.SetUpload(cur); err != nil {
+		return err
+	}
+	u.Received, u.Updated = cur.Received, cur.Updated
+	return nil
+}
+
+// finish an upload. every byte of the file must have been received, and the checksum
+// of the received data must match the given checksum, or the session's checksum if
+// none is given. the file is then replaced with the uploaded data. the session is
+// removed if the checksum doesn't match, since the received data can't be trusted.
+// svc.ErrNoChecksum is returned if there's no checksum to verify the data against.
+func (s *Service) CommitUpload(u *svc.UploadSession, file *svc.File, checksum string) error {
+	if missing := u.Missing(); len(missing) > 0 {
+		return fmt.Errorf("%w. %d of %d bytes received", ErrUploadIncomplete, u.Total(), u.Size)
+	}
+	if checksum == "" {
+		checksum = u.CheckSum
+	}
+	if checksum == "" {
+		return fmt.Errorf("%w sent for upload %s", svc.ErrNoChecksum, u.ID)
+	}
+	cs, err := svc.CalculateChecksum(u.TmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to calculate checksum: %v", err)
+	}
+	if err := svc.VerifyChecksum(checksum, cs); err != nil {
+		if err := s.RemoveUpload(u); err != nil {
+			s.log.Error(err.Error())
+		}
+		return fmt.Errorf("upload %s is corrupt: %w", u.ID, err)
+	}
+
+	if err := s.ReplaceFile(file, u.TmpPath); err != nil {
+		return err
+	}
+	if err := s.Db.RemoveUpload(u.ID); err != nil {
+		return err
+	}
+	s.log.Info(fmt.Sprintf("upload %s for %s (id=%s) complete", u.ID, file.Name, file.ID))
+	return nil
+}
+
+// cancel an upload and remove any data received so far.
+func (s *Service) RemoveUpload(u *svc.UploadSession) error {
+	if err := os.Remove(u.TmpPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove upload file: %v", err)
+	}
+	return s.Db.RemoveUpload(u.ID)
+}
+
+// remove any upload sessions that haven't received data within UPLOAD_EXPIRY.
+func (s *Service) gcUploads() {
+	stale, err := s.Db.GetUploadsBefore(time.Now().UTC().Add(-UPLOAD_EXPIRY))
+	if err != nil {
+		s.log.Error(fmt.Sprintf("failed to get expired uploads: %v", err))
+		return
+	}
+	for _, u := range stale {
+		if err := s.RemoveUpload(u); err != nil {
+			s.log.Error(fmt.Sprintf("failed to remove expired upload (id=%s): %v", u.ID, err))
+		}
+	}
+	if len(stale) > 0 {
+		s.log.Info(fmt.Sprintf("removed %d expired uploads", len(stale)))
+	}
+}
+
+// remove any tombstones older than the configured retention period.
+func (s *Service) gcTombstones() {
+	if svrCfg.TombstoneRetention <= 0 {
+		return
+	}
+	n, err := s.Db.RemoveTombstones(time.Now().UTC().Add(-svrCfg.TombstoneRetention))
+	if err != nil {
+		s.log.Error(fmt.Sprintf("failed to remove expired tombstones: %v", err))
+		return
+	}
+	if n > 0 {
+		s.log.Info(fmt.Sprintf("removed %d expired tombstones", n))
+	}
+}
+
+// get all file changes for a drive after the given revision.
+// the returned index only contains the changed files, and its
+// Revision field is set to the drive's latest revision.
+func (s *Service) GetChanges(driveID string, since int64) (*svc.SyncIndex, error) {
+	drive := s.GetDrive(driveID)
+	if drive == nil {
+		return nil, fmt.Errorf("drive (id=%s) not found", driveID)
+	}
+	s.gcTombstones()
+	s.gcBlobs()
+	revs, err := s.Db.GetRevisionsAfter(driveID, since)
+	if err != nil {
+		return nil, err
+	}
+	head, err := s.Db.GetDriveRevision(driveID)
+	if err != nil {
+		return nil, err
+	}
+	idx := svc.NewSyncIndex(drive.OwnerID)
+	idx.AddRevisions(revs)
+	idx.Revision = head
+	return idx, nil
+}
+
 // retrieve a sync index for a given drive. used by the client
 // to compare against and initiate a sync operation
 // will be used as the first step in a sync operation on the client side.
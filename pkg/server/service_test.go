@@ -1,12 +1,15 @@
 package server
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/sfs/pkg/auth"
 	"github.com/sfs/pkg/env"
@@ -17,6 +20,22 @@ import (
 
 var e = env.NewE()
 
+// read the contents of a logical server path
+func readContents(s *Service, path string) ([]byte, error) {
+	p, err := s.ContentPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(p)
+}
+
+// checksum of some test data
+func checksumOf(data string) string {
+	h := svc.NewHasher()
+	h.Write([]byte(data))
+	return h.Sum()
+}
+
 func getTestingDir() string {
 	tmpDir, err := e.Get("SERVICE_TEST_ROOT")
 	if err != nil {
@@ -223,6 +242,618 @@ func TestCreateNewService(t *testing.T) {
 // 	}
 // }
 
+func TestChunkedUpload(t *testing.T) {
+	env.SetEnv(false)
+
+	testRoot := getTestingDir()
+	testSvc, err := SetUpService(testRoot)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	testDrv := MakeEmptyTmpDrive(t)
+	if err := testSvc.AddDrive(testDrv); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+
+	// add a file to upload a new version of
+	srcPath := filepath.Join(t.TempDir(), "big.bin")
+	if err := os.WriteFile(srcPath, []byte("old contents"), svc.PERMS); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	file := svc.NewFile("big.bin", testDrv.ID, testDrv.OwnerID, srcPath)
+	if err := testSvc.AddFile(testDrv.RootID, file); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+
+	data := []byte(strings.Repeat("some chunked data. ", 50))
+	if err := os.WriteFile(srcPath, data, svc.PERMS); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	cs, err := svc.CalculateChecksum(srcPath)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+
+	// uploads can't be started without a checksum
+	_, err = testSvc.NewUpload(file, int64(len(data)), "")
+	assert.True(t, errors.Is(err, svc.ErrNoChecksum))
+
+	// send the second chunk first, then "restart" the upload
+	u, err := testSvc.NewUpload(file, int64(len(data)), cs)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	if err := testSvc.WriteChunk(u, 500, bytes.NewReader(data[500:])); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	resumed, err := testSvc.NewUpload(file, int64(len(data)), cs)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	assert.Equal(t, u.ID, resumed.ID)
+	assert.Equal(t, []svc.Range{{Start: 0, End: 500}}, resumed.Missing())
+
+	// chunks past the end of the file are rejected, and
+	// incomplete uploads can't be committed
+	assert.True(t, errors.Is(testSvc.WriteChunk(resumed, 900, bytes.NewReader(data[:500])), ErrBadOffset))
+	assert.True(t, errors.Is(testSvc.WriteChunk(resumed, -1, bytes.NewReader(data[:500])), ErrBadOffset))
+	assert.True(t, errors.Is(testSvc.CommitUpload(resumed, file, cs), ErrUploadIncomplete))
+
+	if err := testSvc.WriteChunk(resumed, 0, bytes.NewReader(data[:500])); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	// sessions without a checksum can't be committed
+	noChecksum := *resumed
+	noChecksum.CheckSum = ""
+	assert.True(t, errors.Is(testSvc.CommitUpload(&noChecksum, file, ""), svc.ErrNoChecksum))
+
+	if err := testSvc.CommitUpload(resumed, file, cs); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+
+	// server's copy should be replaced, and the session removed
+	got, err := readContents(testSvc, file.ServerPath)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	assert.Equal(t, data, got)
+	assert.Equal(t, cs, file.CheckSum)
+	u, err = testSvc.Db.GetUpload(resumed.ID)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	assert.Equal(t, nil, u)
+	_, err = testSvc.GetUpload(resumed.ID)
+	assert.True(t, errors.Is(err, ErrUploadNotFound))
+
+	if err := Clean(filepath.Dir(testRoot)); err != nil {
+		t.Errorf("[ERROR] unable to clean testing directory: %v", err)
+	}
+}
+
+func TestUpdateFileChecksum(t *testing.T) {
+	env.SetEnv(false)
+
+	testRoot := getTestingDir()
+	testSvc, err := SetUpService(testRoot)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	testDrv := MakeEmptyTmpDrive(t)
+	if err := testSvc.AddDrive(testDrv); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	srcPath := filepath.Join(t.TempDir(), "file.txt")
+	if err := os.WriteFile(srcPath, []byte("some data"), svc.PERMS); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	file := svc.NewFile("file.txt", testDrv.ID, testDrv.OwnerID, srcPath)
+	if err := testSvc.AddFile(testDrv.RootID, file); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	orig, err := readContents(testSvc, file.ServerPath)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+
+	cs := checksumOf("new data")
+
+	// data can't be sent without a checksum
+	err = testSvc.UpdateFile(file, strings.NewReader("new data"), "")
+	assert.True(t, errors.Is(err, svc.ErrNoChecksum))
+
+	// data that doesn't match the checksum is rejected,
+	// and the server's copy is left as is
+	err = testSvc.UpdateFile(file, strings.NewReader("corrupted data"), cs)
+	assert.True(t, errors.Is(err, svc.ErrChecksumMismatch))
+	got, err := readContents(testSvc, file.ServerPath)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	assert.Equal(t, orig, got)
+
+	if err := testSvc.UpdateFile(file, strings.NewReader("new data"), cs); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	got, err = readContents(testSvc, file.ServerPath)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	assert.Equal(t, []byte("new data"), got)
+	assert.Equal(t, cs, file.CheckSum)
+
+	// no temp files are left behind
+	entries, err := os.ReadDir(testSvc.uploadDir())
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	assert.Equal(t, 0, len(entries))
+
+	if err := Clean(filepath.Dir(testRoot)); err != nil {
+		t.Errorf("[ERROR] unable to clean testing directory: %v", err)
+	}
+}
+
+func TestFileVersions(t *testing.T) {
+	env.SetEnv(false)
+
+	testRoot := getTestingDir()
+	testSvc, err := SetUpService(testRoot)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	testDrv := MakeEmptyTmpDrive(t)
+	if err := testSvc.AddDrive(testDrv); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	srcPath := filepath.Join(t.TempDir(), "file.txt")
+	if err := os.WriteFile(srcPath, []byte("some data"), svc.PERMS); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	file := svc.NewFile("file.txt", testDrv.ID, testDrv.OwnerID, srcPath)
+	if err := testSvc.AddFile(testDrv.RootID, file); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	orig, err := readContents(testSvc, file.ServerPath)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+
+	// each update keeps the previous contents
+	for _, data := range []string{"version 2", "version 3", "version 4"} {
+		if err := testSvc.UpdateFile(file, strings.NewReader(data), checksumOf(data)); err != nil {
+			Fail(t, filepath.Dir(testRoot), err)
+		}
+	}
+	versions, err := testSvc.GetVersions(file)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	assert.Equal(t, 3, len(versions))
+	for i, want := range []string{string(orig), "version 2", "version 3"} {
+		got, err := readContents(testSvc, versions[i].Path)
+		if err != nil {
+			Fail(t, filepath.Dir(testRoot), err)
+		}
+		assert.Equal(t, want, string(got))
+	}
+
+	// rejected updates don't add versions
+	assert.Error(t, testSvc.UpdateFile(file, strings.NewReader("corrupted"), "bad-checksum"))
+	versions, err = testSvc.GetVersions(file)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	assert.Equal(t, 3, len(versions))
+
+	// old versions are pruned under a new policy
+	if err := testSvc.SetRetention(&svc.RetentionPolicy{DriveID: testDrv.ID, KeepLast: 2}); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	if err := testSvc.UpdateFile(file, strings.NewReader("version 5"), checksumOf("version 5")); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	versions, err = testSvc.GetVersions(file)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	assert.Equal(t, 2, len(versions))
+	assert.Equal(t, int64(3), versions[0].Number)
+	assert.Equal(t, int64(4), versions[1].Number)
+	_, err = testSvc.GetVersion(file, 1)
+	assert.Error(t, err)
+
+	if err := Clean(filepath.Dir(testRoot)); err != nil {
+		t.Errorf("[ERROR] unable to clean testing directory: %v", err)
+	}
+}
+
+func TestDriveAt(t *testing.T) {
+	env.SetEnv(false)
+
+	testRoot := getTestingDir()
+	testSvc, err := SetUpService(testRoot)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	testDrv := MakeEmptyTmpDrive(t)
+	if err := testSvc.AddDrive(testDrv); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	tick := func() time.Time {
+		time.Sleep(10 * time.Millisecond)
+		at := time.Now().UTC()
+		time.Sleep(10 * time.Millisecond)
+		return at
+	}
+	before := tick()
+
+	var files []*svc.File
+	for _, name := range []string{"a.txt", "b.txt"} {
+		srcPath := filepath.Join(t.TempDir(), name)
+		if err := os.WriteFile(srcPath, []byte(name), svc.PERMS); err != nil {
+			Fail(t, filepath.Dir(testRoot), err)
+		}
+		file := svc.NewFile(name, testDrv.ID, testDrv.OwnerID, srcPath)
+		if err := testSvc.AddFile(testDrv.RootID, file); err != nil {
+			Fail(t, filepath.Dir(testRoot), err)
+		}
+		files = append(files, file)
+	}
+	created := tick()
+	if err := testSvc.UpdateFile(files[0], strings.NewReader("a.txt, updated"), checksumOf("a.txt, updated")); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	updated := tick()
+	orig, err := readContents(testSvc, files[1].ServerPath)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	if err := testSvc.DeleteFile(files[1], "some-device"); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+
+	versions := func(at time.Time) map[string]int64 {
+		snapshot, err := testSvc.DriveAt(testDrv.ID, at)
+		if err != nil {
+			Fail(t, filepath.Dir(testRoot), err)
+		}
+		m := make(map[string]int64)
+		for _, sf := range snapshot.Files {
+			m[sf.Path] = sf.Version
+		}
+		return m
+	}
+	assert.Equal(t, map[string]int64{}, versions(before))
+	// b.txt was deleted, so its contents come from the version saved when it was
+	assert.Equal(t, map[string]int64{"a.txt": 1, "b.txt": 1}, versions(created))
+	assert.Equal(t, map[string]int64{"a.txt": 0, "b.txt": 1}, versions(updated))
+	assert.Equal(t, map[string]int64{"a.txt": 0}, versions(time.Now().UTC()))
+
+	// deleted files keep their last contents
+	v, err := testSvc.GetVersion(files[1], 1)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	got, err := readContents(testSvc, v.Path)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	assert.Equal(t, orig, got)
+
+	if err := Clean(filepath.Dir(testRoot)); err != nil {
+		t.Errorf("[ERROR] unable to clean testing directory: %v", err)
+	}
+}
+
+func TestDriveQuota(t *testing.T) {
+	env.SetEnv(false)
+
+	testRoot := getTestingDir()
+	testSvc, err := SetUpService(testRoot)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	testDrv := MakeEmptyTmpDrive(t)
+	if err := testSvc.AddDrive(testDrv); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	testUsr := auth.NewUser("quota user", "quotaUser", "quota@user.com", svcCfg.SvcRoot, false)
+	testUsr.DriveID = testDrv.ID
+	if err := testSvc.Db.AddUser(testUsr); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	assert.Error(t, testSvc.SetQuota(testUsr.ID, 0))
+	if err := testSvc.SetQuota(testUsr.ID, 20); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+
+	srcPath := filepath.Join(t.TempDir(), "a.txt")
+	if err := os.WriteFile(srcPath, []byte("0123456789"), svc.PERMS); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	file := svc.NewFile("a.txt", testDrv.ID, testDrv.OwnerID, srcPath)
+	if err := testSvc.AddFile(testDrv.RootID, file); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	usage, err := testSvc.GetUsage(testDrv.ID)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	assert.Equal(t, int64(20), usage.Total)
+	assert.Equal(t, int64(10), usage.Used)
+	assert.Equal(t, int64(10), usage.Free)
+
+	// growing within the quota is fine
+	if err := testSvc.UpdateFile(file, strings.NewReader(strings.Repeat("x", 15)), checksumOf(strings.Repeat("x", 15))); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	usage, err = testSvc.GetUsage(testDrv.ID)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	assert.Equal(t, int64(15), usage.Used)
+
+	// going over isn't, and the server's copy is left alone
+	err = testSvc.UpdateFile(file, strings.NewReader(strings.Repeat("x", 30)), checksumOf(strings.Repeat("x", 30)))
+	assert.True(t, errors.Is(err, svc.ErrQuotaExceeded))
+	_, err = testSvc.NewUpload(file, 30, checksumOf(strings.Repeat("x", 30)))
+	assert.True(t, errors.Is(err, svc.ErrQuotaExceeded))
+	data, err := readContents(testSvc, file.ServerPath)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	assert.Equal(t, 15, len(data))
+
+	// a delta is held to the size of the file it rebuilds, not the size it claims
+	big := strings.Repeat("x", 30)
+	delta := &svc.Delta{
+		FileID:    file.ID,
+		BlockSize: svc.BLOCK_SIZE,
+		Size:      15,
+		CheckSum:  checksumOf(big),
+		Ops:       []svc.DeltaOp{{Block: -1, Data: []byte(big)}},
+	}
+	err = testSvc.UpdateFileDelta(file, delta)
+	assert.True(t, errors.Is(err, svc.ErrQuotaExceeded))
+	data, err = readContents(testSvc, file.ServerPath)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	assert.Equal(t, 15, len(data))
+
+	// deleting frees up space
+	if err := testSvc.DeleteFile(file, "some-device"); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	usage, err = testSvc.GetUsage(testDrv.ID)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	assert.Equal(t, int64(0), usage.Used)
+	assert.Equal(t, int64(20), usage.Free)
+
+	if err := Clean(filepath.Dir(testRoot)); err != nil {
+		t.Errorf("[ERROR] unable to clean testing directory: %v", err)
+	}
+}
+
+func TestBlobDedup(t *testing.T) {
+	env.SetEnv(false)
+
+	testRoot := getTestingDir()
+	testSvc, err := SetUpService(testRoot)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	// the same contents in two drives
+	var files []*svc.File
+	for _, name := range []string{"a.txt", "b.txt"} {
+		testDrv := MakeEmptyTmpDriveWithPath(t, filepath.Join(testRoot, name))
+		testDrv.OwnerName = name
+		if err := testSvc.AddDrive(testDrv); err != nil {
+			Fail(t, filepath.Dir(testRoot), err)
+		}
+		srcPath := filepath.Join(t.TempDir(), name)
+		if err := os.WriteFile(srcPath, []byte("same data"), svc.PERMS); err != nil {
+			Fail(t, filepath.Dir(testRoot), err)
+		}
+		file := svc.NewFile(name, testDrv.ID, testDrv.OwnerID, srcPath)
+		if err := testSvc.AddFile(testDrv.RootID, file); err != nil {
+			Fail(t, filepath.Dir(testRoot), err)
+		}
+		if err := testSvc.UpdateFile(file, strings.NewReader("same data"), checksumOf("same data")); err != nil {
+			Fail(t, filepath.Dir(testRoot), err)
+		}
+		files = append(files, file)
+	}
+	assert.Equal(t, files[0].CheckSum, files[1].CheckSum)
+	pathA, err := testSvc.ContentPath(files[0].ServerPath)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	pathB, err := testSvc.ContentPath(files[1].ServerPath)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	assert.Equal(t, pathA, pathB)
+	b, err := testSvc.Db.GetBlob(files[0].CheckSum)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	assert.Equal(t, int64(2), b.Refs)
+
+	// updating one file leaves the other alone, and the old contents are
+	// still referenced by the new version
+	if err := testSvc.UpdateFile(files[0], strings.NewReader("new data"), checksumOf("new data")); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	got, err := readContents(testSvc, files[1].ServerPath)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	assert.Equal(t, "same data", string(got))
+	b, err = testSvc.Db.GetBlob(files[1].CheckSum)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	assert.Equal(t, int64(2), b.Refs)
+
+	// once nothing refers to a blob, it's garbage collected
+	if err := testSvc.SetRetention(&svc.RetentionPolicy{DriveID: files[0].DriveID, KeepLast: 1}); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	if err := testSvc.UpdateFile(files[0], strings.NewReader("newer data"), checksumOf("newer data")); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	if err := testSvc.DeleteFile(files[1], "some-device"); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	versions, err := testSvc.GetVersions(files[1])
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	for _, v := range versions {
+		if err := testSvc.removeVersion(v); err != nil {
+			Fail(t, filepath.Dir(testRoot), err)
+		}
+	}
+	testSvc.gcBlobs()
+	b, err = testSvc.Db.GetBlob(files[1].CheckSum)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	assert.Equal(t, nil, b)
+	_, err = os.Stat(pathA)
+	assert.True(t, os.IsNotExist(err))
+
+	if err := Clean(filepath.Dir(testRoot)); err != nil {
+		t.Errorf("[ERROR] unable to clean testing directory: %v", err)
+	}
+}
+
+func TestImportBlob(t *testing.T) {
+	env.SetEnv(false)
+
+	testRoot := getTestingDir()
+	testSvc, err := SetUpService(testRoot)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	testDrv := MakeEmptyTmpDrive(t)
+	if err := testSvc.AddDrive(testDrv); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	// contents stored at a file's server path before blobs were used
+	path := testSvc.buildServerRootPath(testDrv.OwnerName, "old-file.txt")
+	if err := os.MkdirAll(filepath.Dir(path), svc.PERMS); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	if err := os.WriteFile(path, []byte("old data"), svc.PERMS); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	file := svc.NewFile("old-file.txt", testDrv.ID, testDrv.OwnerID, path)
+	file.DirID = testDrv.RootID
+	file.ServerPath = path
+	if err := testSvc.Db.AddFile(file); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	got, err := readContents(testSvc, path)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	assert.Equal(t, "old data", string(got))
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+
+	// anything without a file record is left where it is
+	stray := testSvc.buildServerRootPath(testDrv.OwnerName, "stray.txt")
+	if err := os.WriteFile(stray, []byte("stray data"), svc.PERMS); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	_, err = testSvc.ContentPath(stray)
+	assert.Error(t, err)
+	_, err = os.Stat(stray)
+	assert.NoError(t, err)
+
+	// as is anything outside the file's drive root, even with a record
+	outside := filepath.Join(testRoot, "outside.txt")
+	if err := os.WriteFile(outside, []byte("outside data"), svc.PERMS); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	other := svc.NewFile("outside.txt", testDrv.ID, testDrv.OwnerID, outside)
+	other.DirID = testDrv.RootID
+	other.ServerPath = outside
+	if err := testSvc.Db.AddFile(other); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	_, err = testSvc.ContentPath(outside)
+	assert.Error(t, err)
+	_, err = os.Stat(outside)
+	assert.NoError(t, err)
+
+	// checking a move's destination doesn't import it either
+	taken, err := testSvc.pathTaken(stray)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	assert.False(t, taken)
+	_, err = os.Stat(stray)
+	assert.NoError(t, err)
+
+	if err := Clean(filepath.Dir(testRoot)); err != nil {
+		t.Errorf("[ERROR] unable to clean testing directory: %v", err)
+	}
+}
+
+func TestAddFilePaths(t *testing.T) {
+	env.SetEnv(false)
+
+	testRoot := getTestingDir()
+	testSvc, err := SetUpService(testRoot)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	testDrv := MakeEmptyTmpDrive(t)
+	if err := testSvc.AddDrive(testDrv); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	srcPath := filepath.Join(t.TempDir(), "a.txt")
+	if err := os.WriteFile(srcPath, []byte("a's data"), svc.PERMS); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	file := svc.NewFile("a.txt", testDrv.ID, testDrv.OwnerID, srcPath)
+	if err := testSvc.AddFile(testDrv.RootID, file); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	if err := testSvc.UpdateFile(file, strings.NewReader("a's data"), checksumOf("a's data")); err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+
+	// a new file with the same name can't take over the first one's contents
+	dupe := svc.NewFile("a.txt", testDrv.ID, testDrv.OwnerID, srcPath)
+	err = testSvc.AddFile(testDrv.RootID, dupe)
+	assert.True(t, errors.Is(err, ErrPathTaken))
+	data, err := readContents(testSvc, file.ServerPath)
+	if err != nil {
+		Fail(t, filepath.Dir(testRoot), err)
+	}
+	assert.Equal(t, "a's data", string(data))
+
+	// and names are checked the same way clients check them
+	for _, name := range []string{"", ".", "..", "../a.txt", "a/b", `a\b`} {
+		bad := svc.NewFile("a.txt", testDrv.ID, testDrv.OwnerID, srcPath)
+		bad.Name = name
+		assert.True(t, errors.Is(testSvc.AddFile(testDrv.RootID, bad), ErrInvalidName), name)
+	}
+	dir := svc.NewDirectory("..", testDrv.OwnerID, testDrv.ID, filepath.Join(t.TempDir(), "dir"))
+	assert.True(t, errors.Is(testSvc.NewDir(testDrv.ID, testDrv.RootID, dir), ErrInvalidName))
+
+	if err := Clean(filepath.Dir(testRoot)); err != nil {
+		t.Errorf("[ERROR] unable to clean testing directory: %v", err)
+	}
+}
+
 // ------- user tests --------------------------------
 
 func TestAddAndRemoveUser(t *testing.T) {
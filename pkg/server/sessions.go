@@ -0,0 +1,148 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sfs/pkg/auth"
+)
+
+// ---- user sessions --------------------------------
+
+// returned when a refresh token is unknown, expired, or was revoked
+var ErrInvalidSession = errors.New("invalid or expired session")
+
+// file in the service root with the secret access tokens are signed with
+const sessionKeyFile = "session.key"
+
+var sessionKeyMu sync.Mutex
+
+// get the token used to sign and verify access tokens for the service at svcRoot.
+// its secret is generated the first time it's needed and never leaves the server,
+// unlike JWT_SECRET, which clients also have for signing payload tokens.
+func sessionToken(svcRoot string) (*auth.Token, error) {
+	sessionKeyMu.Lock()
+	defer sessionKeyMu.Unlock()
+
+	path := filepath.Join(svcRoot, sessionKeyFile)
+	key, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		key = []byte(auth.GenSecret(64))
+		err = os.WriteFile(path, key, 0600)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load session secret: %v", err)
+	}
+	if len(key) == 0 {
+		return nil, fmt.Errorf("session secret (%s) is empty", path)
+	}
+	return auth.NewSessionT(key), nil
+}
+
+// start a new session for a user. only the hash of the
+// session's refresh token is saved.
+func (s *Service) NewSession(userID string) (*auth.Session, error) {
+	s.gcSessions()
+
+	tok, err := sessionToken(s.SvcRoot)
+	if err != nil {
+		return nil, err
+	}
+	session, refresh, err := tok.NewSession(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session: %v", err)
+	}
+	if err := s.Db.SetRefreshToken(refresh); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// exchange a refresh token for a new access token and refresh token.
+// the old refresh token can't be used again.
+func (s *Service) RefreshSession(refreshToken string) (*auth.Session, error) {
+	if refreshToken == "" {
+		return nil, ErrInvalidSession
+	}
+	refresh, err := s.Db.GetRefreshTokenByHash(auth.HashToken(refreshToken))
+	if err != nil {
+		return nil, err
+	}
+	if refresh == nil || !refresh.Valid() {
+		return nil, ErrInvalidSession
+	}
+	// make sure the user wasn't removed since the session started
+	user, err := s.Db.GetUser(refresh.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		if err := s.Db.RevokeRefreshToken(refresh.ID); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidSession
+	}
+	tok, err := sessionToken(s.SvcRoot)
+	if err != nil {
+		return nil, err
+	}
+	session, err := tok.RenewSession(refresh)
+	if err != nil {
+		return nil, fmt.Errorf("failed to renew session: %v", err)
+	}
+	if err := s.Db.SetRefreshToken(refresh); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// revoke a session. its access and refresh tokens can no longer be used.
+func (s *Service) RevokeSession(sessionID string) error {
+	return s.Db.RevokeRefreshToken(sessionID)
+}
+
+// revoke all of a user's sessions
+func (s *Service) RevokeSessions(userID string) error {
+	return s.Db.RevokeUserRefreshTokens(userID)
+}
+
+// change a user's password. all of the user's sessions are revoked,
+// and a new session is returned for the caller to use.
+func (s *Service) ChangePassword(userID string, password string, newPassword string) (*auth.Session, error) {
+	// passwords aren't kept in the service state, so always check the db
+	user, err := s.Db.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, fmt.Errorf("user (id=%s) not found", userID)
+	}
+	if !user.CheckPassword(password) {
+		return nil, fmt.Errorf("invalid password")
+	}
+	if err := user.SetPassword(newPassword); err != nil {
+		return nil, err
+	}
+	if err := s.Db.UpdateUser(user); err != nil {
+		return nil, err
+	}
+	if err := s.RevokeSessions(userID); err != nil {
+		return nil, err
+	}
+	s.log.Info(fmt.Sprintf("password changed for user (id=%s). all sessions revoked", userID))
+	return s.NewSession(userID)
+}
+
+// remove sessions that expired or were revoked more than a day ago.
+func (s *Service) gcSessions() {
+	n, err := s.Db.RemoveExpiredRefreshTokens(time.Now().UTC().Add(-time.Hour * 24))
+	if err != nil {
+		s.log.Error("failed to remove expired sessions: " + err.Error())
+	} else if n > 0 {
+		s.log.Info(fmt.Sprintf("removed %d expired sessions", n))
+	}
+}
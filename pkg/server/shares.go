@@ -0,0 +1,320 @@
+package server
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/sfs/pkg/db"
+	svc "github.com/sfs/pkg/service"
+)
+
+// ---- shared files and directories --------------------------------
+
+// find the type and owner of an item that can be shared
+func (s *Service) shareableItem(itemID string) (string, string, error) {
+	file, err := s.Db.GetFileByID(itemID)
+	if err != nil {
+		return "", "", err
+	}
+	if file != nil {
+		return svc.ShareFile, file.OwnerID, nil
+	}
+	dir, err := s.Db.GetDirectoryByID(itemID)
+	if err != nil {
+		return "", "", err
+	}
+	if dir != nil {
+		if dir.Root {
+			return "", "", fmt.Errorf("drive root directories can't be shared")
+		}
+		return svc.ShareDir, dir.OwnerID, nil
+	}
+	return "", "", fmt.Errorf("no file or directory found with id=%s", itemID)
+}
+
+// share a file or directory with another user. grantee can be a user ID or a user name.
+// sharing an item with the same user again replaces their previous share of it.
+func (s *Service) ShareItem(itemID string, grantee string, perm svc.Permission, expires time.Time) (*svc.Share, error) {
+	itemType, ownerID, err := s.shareableItem(itemID)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.Db.GetUser(grantee)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		if user, err = s.Db.GetUserByUserName(grantee); err != nil {
+			return nil, err
+		}
+	}
+	if user == nil {
+		return nil, fmt.Errorf("user '%s' not found", grantee)
+	}
+	share, err := svc.NewShare(itemID, itemType, ownerID, user.ID, perm, expires)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Db.SetShare(share); err != nil {
+		return nil, err
+	}
+	s.log.Info(fmt.Sprintf("%s (id=%s) shared with user (id=%s) as %s", itemType, itemID, user.ID, perm))
+	return share, nil
+}
+
+// get a share. returns an error if it isn't found.
+func (s *Service) GetShare(shareID string) (*svc.Share, error) {
+	share, err := s.Db.GetShare(shareID)
+	if err != nil {
+		return nil, err
+	}
+	if share == nil {
+		return nil, fmt.Errorf("share (id=%s) not found", shareID)
+	}
+	return share, nil
+}
+
+// get all the shares a user has created
+func (s *Service) GetShares(ownerID string) ([]*svc.Share, error) {
+	return s.Db.GetSharesByOwner(ownerID)
+}
+
+// remove a share. the grantee can no longer access the item.
+func (s *Service) RemoveShare(share *svc.Share) error {
+	if err := s.Db.RemoveShare(share.ID); err != nil {
+		return err
+	}
+	s.log.Info(fmt.Sprintf("share (id=%s) of %s (id=%s) removed", share.ID, share.ItemType, share.ItemID))
+	return nil
+}
+
+// get the active shares given to a user, at most one per item.
+// read-write shares take priority over read-only ones.
+func activeShares(q *db.Query, userID string) (map[string]*svc.Share, error) {
+	shares, err := q.GetSharesWithUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	active := make(map[string]*svc.Share, len(shares))
+	for _, share := range shares {
+		if share.Expired() {
+			continue
+		}
+		if cur, ok := active[share.ItemID]; !ok || (!cur.CanWrite() && share.CanWrite()) {
+			active[share.ItemID] = share
+		}
+	}
+	return active, nil
+}
+
+// find the share that gives a user access to a file or directory, either
+// directly or through one of the directories it's in. returns nil if the
+// item isn't shared with the user, or if all of its shares have expired.
+//
+// q must be able to switch between databases.
+func findShare(q *db.Query, userID string, itemType string, itemID string) (*svc.Share, error) {
+	active, err := activeShares(q, userID)
+	if err != nil || len(active) == 0 {
+		return nil, err
+	}
+	var best *svc.Share
+	pick := func(share *svc.Share) {
+		if best == nil || (!best.CanWrite() && share.CanWrite()) {
+			best = share
+		}
+	}
+
+	dirID := itemID
+	if itemType == svc.ShareFile {
+		if share, ok := active[itemID]; ok {
+			pick(share)
+		}
+		file, err := q.GetFileByID(itemID)
+		if err != nil {
+			return nil, err
+		}
+		if file == nil {
+			return best, nil
+		}
+		dirID = file.DirID
+	}
+	// walk up to the drive's root
+	seen := make(map[string]bool)
+	for dirID != "" && !seen[dirID] {
+		seen[dirID] = true
+		if share, ok := active[dirID]; ok {
+			pick(share)
+		}
+		dir, err := q.GetDirectoryByID(dirID)
+		if err != nil {
+			return nil, err
+		}
+		if dir == nil || dir.Root {
+			break
+		}
+		dirID = dir.ParentID
+	}
+	return best, nil
+}
+
+// list the items shared with a user. these make up the user's
+// "Shared with me" directory. items that no longer exist are skipped.
+func (s *Service) SharedWithMe(userID string, driveID string) (*svc.DirListing, error) {
+	active, err := activeShares(s.Db, userID)
+	if err != nil {
+		return nil, err
+	}
+	listing := &svc.DirListing{
+		Dir:   svc.NewSharedDir(userID, driveID),
+		Dirs:  make([]*svc.Directory, 0),
+		Files: make([]*svc.File, 0),
+	}
+	for _, share := range active {
+		switch share.ItemType {
+		case svc.ShareFile:
+			file, err := s.Db.GetFileByID(share.ItemID)
+			if err != nil {
+				return nil, err
+			}
+			if file != nil {
+				listing.Files = append(listing.Files, file)
+			}
+		case svc.ShareDir:
+			dir, err := s.Db.GetDirectoryByID(share.ItemID)
+			if err != nil {
+				return nil, err
+			}
+			if dir != nil {
+				listing.Dirs = append(listing.Dirs, dir)
+			}
+		}
+	}
+	return listing, nil
+}
+
+// get every file shared with a user, including the contents of shared directories,
+// along with where each one appears under the user's "Shared with me" directory.
+// files reachable through more than one share use the one that allows the most.
+func (s *Service) SharedFiles(userID string) ([]*svc.SharedFile, error) {
+	active, err := activeShares(s.Db, userID)
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string]*svc.SharedFile)
+	add := func(file *svc.File, path string, share *svc.Share) {
+		if cur, ok := found[file.ID]; ok && (cur.Permission == svc.PermReadWrite || !share.CanWrite()) {
+			return
+		}
+		found[file.ID] = &svc.SharedFile{File: file, Path: path, ShareID: share.ID, Permission: share.Permission}
+	}
+
+	for _, share := range active {
+		switch share.ItemType {
+		case svc.ShareFile:
+			file, err := s.Db.GetFileByID(share.ItemID)
+			if err != nil {
+				return nil, err
+			}
+			if file != nil {
+				add(file, file.Name, share)
+			}
+		case svc.ShareDir:
+			dir, err := s.Db.GetDirectoryByID(share.ItemID)
+			if err != nil {
+				return nil, err
+			}
+			if dir == nil {
+				continue
+			}
+			dirs, err := s.Db.GetDirsByDriveID(dir.DriveID)
+			if err != nil {
+				return nil, err
+			}
+			dirMap := make(map[string]*svc.Directory, len(dirs))
+			for _, d := range dirs {
+				dirMap[d.ID] = d
+			}
+			files, err := s.Db.GetFilesByDriveID(dir.DriveID)
+			if err != nil {
+				return nil, err
+			}
+			for _, file := range files {
+				if rel, ok := sharedPath(file.DirID, dir, dirMap); ok {
+					add(file, filepath.Join(rel, file.Name), share)
+				}
+			}
+		}
+	}
+
+	shared := make([]*svc.SharedFile, 0, len(found))
+	for _, sf := range found {
+		shared = append(shared, sf)
+	}
+	return shared, nil
+}
+
+// get the path of a directory relative to the parent of a shared directory,
+// ie. starting with the shared directory's name. returns false if the
+// directory isn't inside the shared directory.
+func sharedPath(dirID string, shared *svc.Directory, dirs map[string]*svc.Directory) (string, bool) {
+	var parts []string
+	seen := make(map[string]bool)
+	for dir, ok := dirs[dirID]; ok && !seen[dir.ID]; dir, ok = dirs[dir.ParentID] {
+		seen[dir.ID] = true
+		parts = append([]string{dir.Name}, parts...)
+		if dir.ID == shared.ID {
+			return filepath.Join(parts...), true
+		}
+		if dir.Root {
+			break
+		}
+	}
+	return "", false
+}
+
+// list the top level of a user's drive. the virtual "Shared with me"
+// directory is always listed along with the root's subdirectories.
+func (s *Service) ListDrive(driveID string, userID string) (*svc.DirListing, error) {
+	drive, err := s.Db.GetDrive(driveID)
+	if err != nil {
+		return nil, err
+	}
+	if drive == nil {
+		return nil, fmt.Errorf("drive (id=%s) not found", driveID)
+	}
+	root, err := s.Db.GetDirectoryByID(drive.RootID)
+	if err != nil {
+		return nil, err
+	}
+	if root == nil {
+		return nil, fmt.Errorf("no root directory found for drive (id=%s)", driveID)
+	}
+	listing := &svc.DirListing{
+		Dir:   root,
+		Dirs:  make([]*svc.Directory, 0),
+		Files: make([]*svc.File, 0),
+	}
+	dirs, err := s.Db.GetDirsByDriveID(driveID)
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		if dir.ParentID == root.ID && dir.ID != root.ID {
+			listing.Dirs = append(listing.Dirs, dir)
+		}
+	}
+	files, err := s.Db.GetFilesByDriveID(driveID)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if file.DirID == root.ID {
+			listing.Files = append(listing.Files, file)
+		}
+	}
+	shared := svc.NewSharedDir(userID, driveID)
+	shared.ParentID = root.ID
+	listing.Dirs = append(listing.Dirs, shared)
+	return listing, nil
+}
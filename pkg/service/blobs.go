@@ -0,0 +1,52 @@
+package service
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"time"
+)
+
+/*
+content-addressed storage.
+
+the server keeps file contents as blobs named by their checksum, so identical
+contents are only stored once, no matter how many files, drives, or versions
+share them. files and versions have logical server paths, which point to blobs
+through the server's blob references. each blob counts the paths pointing to
+it, and blobs that nothing points to are removed during garbage collection.
+
+blobs are never written to once they're stored. changing a file's contents
+stores (or reuses) a different blob and moves the file's path over to it.
+*/
+
+// a file's contents, stored once on the server
+type Blob struct {
+	CheckSum string    `json:"checksum"`
+	Path     string    `json:"path"` // where the contents are stored on the server
+	Size     int64     `json:"size"`
+	Refs     int64     `json:"refs"` // number of logical paths pointing to this blob
+	Created  time.Time `json:"created"`
+}
+
+func NewBlob(checksum string, size int64, storeRoot string) *Blob {
+	return &Blob{
+		CheckSum: checksum,
+		Path:     BlobPath(storeRoot, checksum),
+		Size:     size,
+		Created:  time.Now().UTC(),
+	}
+}
+
+// where a blob's contents are kept in a blob store. blobs are spread across
+// subdirectories by the first two characters of their checksum so no single
+// directory gets too big.
+func BlobPath(storeRoot string, checksum string) string {
+	if len(checksum) < 2 {
+		return filepath.Join(storeRoot, checksum)
+	}
+	return filepath.Join(storeRoot, checksum[:2], checksum)
+}
+
+func (b *Blob) ToJSON() ([]byte, error) {
+	return json.MarshalIndent(b, "", "  ")
+}
@@ -0,0 +1,365 @@
+package service
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+/*
+rsync-style block level delta transfers.
+
+the receiver (usually the server) builds a Signature of its copy of a file,
+which is a list of fixed-size blocks, each with a cheap rolling checksum and
+a strong hash. the sender scans its copy of the file against the signature and
+produces a Delta, which is a list of references to blocks the receiver already has,
+along with any literal data that couldn't be matched. the receiver then rebuilds
+the file from its original copy and the delta.
+*/
+
+// default delta block size (64kb)
+const BLOCK_SIZE = 1 << 16
+
+// rolling checksum modulus
+const modAdler = 1 << 16
+
+// block signature. Weak is the rolling checksum,
+// Strong is a sha256 hash of the block.
+type BlockSig struct {
+	Index  int    `json:"index"`
+	Weak   uint32 `json:"weak"`
+	Strong string `json:"strong"`
+}
+
+// block signature of a file.
+type Signature struct {
+	FileID    string     `json:"file_id"`
+	BlockSize int        `json:"block_size"`
+	Size      int64      `json:"size"`
+	Blocks    []BlockSig `json:"blocks"`
+}
+
+// a single delta operation. if Block is >= 0, then the block with
+// that index is copied from the original file, otherwise Data is
+// written out as-is.
+type DeltaOp struct {
+	Block int    `json:"block"`
+	Data  []byte `json:"data,omitempty"`
+}
+
+// set of operations needed to rebuild a file
+// from the receiver's copy of the file.
+type Delta struct {
+	FileID    string    `json:"file_id"`
+	BlockSize int       `json:"block_size"`
+	Size      int64     `json:"size"`     // size of the rebuilt file
+	CheckSum  string    `json:"checksum"` // checksum of the rebuilt file
+	Ops       []DeltaOp `json:"ops"`
+}
+
+func (s *Signature) ToJSON() ([]byte, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func UnmarshalSignature(data []byte) (*Signature, error) {
+	sig := new(Signature)
+	if err := json.Unmarshal(data, &sig); err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
+
+func (d *Delta) ToJSON() ([]byte, error) {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// unmarshal a delta and make sure it's safe to apply
+func UnmarshalDelta(data []byte) (*Delta, error) {
+	delta := new(Delta)
+	if err := json.Unmarshal(data, &delta); err != nil {
+		return nil, err
+	}
+	if err := delta.Validate(); err != nil {
+		return nil, err
+	}
+	return delta, nil
+}
+
+// make sure a delta has a usable block size and a checksum to verify the
+// rebuilt file against. block sizes are capped at BLOCK_SIZE, since a buffer
+// of that size is allocated when the delta is applied.
+func (d *Delta) Validate() error {
+	if d.BlockSize <= 0 || d.BlockSize > BLOCK_SIZE {
+		return fmt.Errorf("invalid block size: %d. must be between 1 and %d", d.BlockSize, BLOCK_SIZE)
+	}
+	if d.CheckSum == "" {
+		return fmt.Errorf("delta has no checksum")
+	}
+	return nil
+}
+
+// total amount of literal (unmatched) data in the delta
+func (d *Delta) LiteralSize() int64 {
+	var total int64
+	for _, op := range d.Ops {
+		total += int64(len(op.Data))
+	}
+	return total
+}
+
+// ----------- checksums
+
+// rsync's weak rolling checksum. returns both halves
+// of the checksum so they can be rolled forward.
+func weakSum(block []byte) (uint32, uint32) {
+	var a, b uint32
+	n := len(block)
+	for i, c := range block {
+		a += uint32(c)
+		b += uint32(n-i) * uint32(c)
+	}
+	return a % modAdler, b % modAdler
+}
+
+func digest(a, b uint32) uint32 {
+	return (a % modAdler) | (b%modAdler)<<16
+}
+
+func strongSum(block []byte) string {
+	h := sha256.Sum256(block)
+	return base32.StdEncoding.EncodeToString(h[:])
+}
+
+// ----------- signatures and deltas
+
+// build a block signature for the file at the given path.
+// uses BLOCK_SIZE if blockSize is <= 0.
+func BuildSignature(filePath string, blockSize int) (*Signature, error) {
+	if blockSize <= 0 {
+		blockSize = BLOCK_SIZE
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	sig := &Signature{
+		BlockSize: blockSize,
+		Blocks:    make([]BlockSig, 0),
+	}
+	buf := make([]byte, blockSize)
+	for i := 0; ; i++ {
+		n, err := io.ReadFull(file, buf)
+		if n > 0 {
+			a, b := weakSum(buf[:n])
+			sig.Blocks = append(sig.Blocks, BlockSig{
+				Index:  i,
+				Weak:   digest(a, b),
+				Strong: strongSum(buf[:n]),
+			})
+			sig.Size += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read file: %v", err)
+		}
+	}
+	return sig, nil
+}
+
+// find a block in the signature matching the given window.
+// returns -1 if no match was found.
+func (s *Signature) match(weak uint32, window []byte, blocks map[uint32][]int) int {
+	idxs, ok := blocks[weak]
+	if !ok {
+		return -1
+	}
+	strong := strongSum(window)
+	for _, idx := range idxs {
+		if s.Blocks[idx].Strong == strong {
+			return s.Blocks[idx].Index
+		}
+	}
+	return -1
+}
+
+// compare the file at the given path against a signature and build a delta
+// containing only the data the receiver doesn't already have.
+func BuildDelta(sig *Signature, filePath string) (*Delta, error) {
+	if sig.BlockSize <= 0 {
+		return nil, fmt.Errorf("invalid block size: %d", sig.BlockSize)
+	}
+	cs, err := CalculateChecksum(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to calculate checksum: %v", err)
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// weak checksum -> signature block indices
+	blocks := make(map[uint32][]int, len(sig.Blocks))
+	for i, blk := range sig.Blocks {
+		blocks[blk.Weak] = append(blocks[blk.Weak], i)
+	}
+
+	delta := &Delta{
+		FileID:    sig.FileID,
+		BlockSize: sig.BlockSize,
+		CheckSum:  cs,
+		Ops:       make([]DeltaOp, 0),
+	}
+	literal := make([]byte, 0, sig.BlockSize)
+	flush := func() {
+		if len(literal) > 0 {
+			delta.Ops = append(delta.Ops, DeltaOp{Block: -1, Data: literal})
+			literal = make([]byte, 0, sig.BlockSize)
+		}
+	}
+
+	// peek at the next block-sized window and roll forward one byte at
+	// a time until we find a match. bufio's internal buffer is larger than
+	// a block so we're not shuffling memory around on every byte.
+	r := bufio.NewReaderSize(file, 4*sig.BlockSize)
+	window, err := r.Peek(sig.BlockSize)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	a, b := weakSum(window)
+	for len(window) > 0 {
+		if idx := sig.match(digest(a, b), window, blocks); idx >= 0 {
+			flush()
+			delta.Ops = append(delta.Ops, DeltaOp{Block: idx})
+			delta.Size += int64(len(window))
+			if _, err := r.Discard(len(window)); err != nil {
+				return nil, err
+			}
+			window, err = r.Peek(sig.BlockSize)
+			if err != nil && err != io.EOF {
+				return nil, err
+			}
+			a, b = weakSum(window)
+			continue
+		}
+
+		// no match. move the first byte of the window to the literal
+		// buffer and roll the checksum forward.
+		out, n := uint32(window[0]), len(window)
+		literal = append(literal, window[0])
+		delta.Size++
+		if len(literal) == sig.BlockSize {
+			flush()
+		}
+		if _, err := r.Discard(1); err != nil {
+			return nil, err
+		}
+		window, err = r.Peek(sig.BlockSize)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		a = (a - out) % modAdler
+		b = (b - uint32(n)*out) % modAdler
+		if len(window) == n { // a new byte entered the window
+			a = (a + uint32(window[n-1])) % modAdler
+			b = (b + a) % modAdler
+		}
+	}
+	flush()
+	return delta, nil
+}
+
+// rebuild a file using the original file at basePath and a delta,
+// writing the result to w.
+func ApplyDelta(basePath string, delta *Delta, w io.Writer) error {
+	if err := delta.Validate(); err != nil {
+		return err
+	}
+	base, err := os.Open(basePath)
+	if err != nil {
+		return err
+	}
+	defer base.Close()
+
+	buf := make([]byte, delta.BlockSize)
+	for _, op := range delta.Ops {
+		if op.Block < 0 {
+			if _, err := w.Write(op.Data); err != nil {
+				return err
+			}
+			continue
+		}
+		n, err := base.ReadAt(buf, int64(op.Block)*int64(delta.BlockSize))
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("failed to read block %d: %v", op.Block, err)
+		}
+		if n == 0 {
+			return fmt.Errorf("block %d out of range", op.Block)
+		}
+		if _, err := w.Write(buf[:n]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// update a file using a delta against the file's current contents.
+// the file is rebuilt in a temp file alongside the original, and is
+// only swapped in once the rebuilt file's checksum has been verified.
+func (f *File) Patch(delta *Delta) error {
+	if f.Protected {
+		log.Printf("[INFO] %s is protected", f.Name)
+		return nil
+	}
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	tmpPath := f.GetPath() + ".sfs-tmp"
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("unable to create temp file for %s: %v", f.Name, err)
+	}
+	if err := ApplyDelta(f.GetPath(), delta, tmp); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to apply delta to %s: %v", f.Name, err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	cs, err := CalculateChecksum(tmpPath)
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to calculate checksum: %v", err)
+	}
+	if err := VerifyChecksum(delta.CheckSum, cs); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to apply delta to %s: %w", f.Name, err)
+	}
+	if err := os.Rename(tmpPath, f.GetPath()); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to replace %s: %v", f.Name, err)
+	}
+	f.CheckSum = cs
+	f.Size = f.GetSize()
+	f.LastSync = time.Now().UTC()
+	return nil
+}
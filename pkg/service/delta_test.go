@@ -0,0 +1,134 @@
+package service
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestDeltaRoundTrip(t *testing.T) {
+	tmp := t.TempDir()
+	basePath := filepath.Join(tmp, "base.bin")
+	newPath := filepath.Join(tmp, "new.bin")
+
+	// original file spans several blocks
+	orig := make([]byte, 4*1024*10+123)
+	rand.New(rand.NewSource(42)).Read(orig)
+	if err := os.WriteFile(basePath, orig, PERMS); err != nil {
+		t.Fatal(err)
+	}
+
+	// insert some data into the middle of the file and change the tail
+	mod := append([]byte{}, orig[:5000]...)
+	mod = append(mod, []byte("some new data that wasn't there before")...)
+	mod = append(mod, orig[5000:len(orig)-50]...)
+	mod = append(mod, []byte("a different ending")...)
+	if err := os.WriteFile(newPath, mod, PERMS); err != nil {
+		t.Fatal(err)
+	}
+
+	sig, err := BuildSignature(basePath, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(len(orig)), sig.Size)
+
+	delta, err := BuildDelta(sig, newPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(len(mod)), delta.Size)
+	assert.True(t, delta.LiteralSize() < int64(len(mod)/4))
+
+	var buf bytes.Buffer
+	if err := ApplyDelta(basePath, delta, &buf); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, mod, buf.Bytes())
+}
+
+func TestDeltaNoChanges(t *testing.T) {
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "same.txt")
+	if err := os.WriteFile(path, bytes.Repeat([]byte(testData), 100), PERMS); err != nil {
+		t.Fatal(err)
+	}
+	sig, err := BuildSignature(path, 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delta, err := BuildDelta(sig, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(0), delta.LiteralSize())
+	assert.Equal(t, len(sig.Blocks), len(delta.Ops))
+}
+
+func TestFilePatch(t *testing.T) {
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "patch.txt")
+	newPath := filepath.Join(tmp, "patch-new.txt")
+	if err := os.WriteFile(path, bytes.Repeat([]byte(testData), 100), PERMS); err != nil {
+		t.Fatal(err)
+	}
+	data := append(bytes.Repeat([]byte(testData), 50), bytes.Repeat([]byte(testData2), 60)...)
+	if err := os.WriteFile(newPath, data, PERMS); err != nil {
+		t.Fatal(err)
+	}
+	file := &File{Name: "patch.txt", ClientPath: path}
+
+	sig, err := BuildSignature(path, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delta, err := BuildDelta(sig, newPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Patch(delta); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, data, got)
+	assert.Equal(t, delta.CheckSum, file.CheckSum)
+	assert.Equal(t, int64(len(data)), file.Size)
+
+	// bad checksums should leave the original file in place
+	delta.CheckSum = "bad"
+	assert.Error(t, file.Patch(delta))
+	got, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, data, got)
+}
+
+func TestDeltaValidation(t *testing.T) {
+	delta := &Delta{BlockSize: 256, CheckSum: "abc"}
+	assert.NoError(t, delta.Validate())
+
+	for _, bad := range []*Delta{
+		{BlockSize: 0, CheckSum: "abc"},
+		{BlockSize: -1, CheckSum: "abc"},
+		{BlockSize: BLOCK_SIZE + 1, CheckSum: "abc"},
+		{BlockSize: 256},
+	} {
+		assert.Error(t, bad.Validate())
+		data, err := bad.ToJSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = UnmarshalDelta(data)
+		assert.Error(t, err)
+		assert.Error(t, ApplyDelta("does-not-matter", bad, io.Discard))
+	}
+}
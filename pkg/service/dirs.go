@@ -379,6 +379,38 @@ func (d *Directory) ModifyFile(file *File, data []byte) error {
 	return nil
 }
 
+// update a file's contents using a block-level delta.
+func (d *Directory) PatchFile(file *File, delta *Delta) error {
+	if d.Protected {
+		return fmt.Errorf("directory %s (id=%s) locked", d.Name, d.ID)
+	}
+	if !d.HasFile(file.ID) {
+		return fmt.Errorf("file (id=%s) does not belong to this directory (id=%s)", file.ID, d.ID)
+	}
+	var origSize = file.GetSize()
+	if err := file.Patch(delta); err != nil {
+		return err
+	}
+	d.Size += file.GetSize() - origSize
+	return nil
+}
+
+// replace a file's contents with the file at srcPath.
+func (d *Directory) ReplaceFile(file *File, srcPath string) error {
+	if d.Protected {
+		return fmt.Errorf("directory %s (id=%s) locked", d.Name, d.ID)
+	}
+	if !d.HasFile(file.ID) {
+		return fmt.Errorf("file (id=%s) does not belong to this directory (id=%s)", file.ID, d.ID)
+	}
+	var origSize = file.Size
+	if err := file.Replace(srcPath); err != nil {
+		return err
+	}
+	d.Size += file.Size - origSize
+	return nil
+}
+
 // update metadata for a file that's already in the directory.
 func (d *Directory) PutFile(file *File) error {
 	if !d.Protected {
@@ -392,11 +424,12 @@ func (d *Directory) PutFile(file *File) error {
 }
 
 // remove file from files map and update internal metadata.
-// does not remove the physical file.
+// does not remove the physical file, which may already be gone,
+// so the file's last known size is used.
 func (d *Directory) removeFile(fileID string) error {
 	if file, ok := d.Files[fileID]; ok {
 		delete(d.Files, file.ID)
-		d.Size -= file.GetSize()
+		d.Size -= file.Size
 		d.LastSync = time.Now().UTC()
 	} else {
 		return fmt.Errorf("file (id=%s) not found", fileID)
@@ -610,12 +643,18 @@ as it will generate new file and directory objects with their own ID's, and will
 to be treated as persistent items rather than ephemeral ones.
 */
 func (d *Directory) Walk() *Directory {
-	return walk(d)
+	return walk(d, nil)
+}
+
+// WalkIgnore() is the same as Walk(), but skips any
+// files or directories that match the given ignore rules.
+func (d *Directory) WalkIgnore(ig *Ignore) *Directory {
+	return walk(d, ig)
 }
 
 // walk recursively descends the directory tree and populates all files
-// and subdirectory maps in depth-first order.
-func walk(d *Directory) *Directory {
+// and subdirectory maps in depth-first order. items matching ig are skipped.
+func walk(d *Directory, ig *Ignore) *Directory {
 	entries, err := os.ReadDir(d.GetPath())
 	if err != nil {
 		log.Printf("could not read directory: %v", err)
@@ -631,9 +670,12 @@ func walk(d *Directory) *Directory {
 			log.Printf("could not get stat for %s - %v", entryPath, err)
 			return d
 		}
+		if ig.Ignored(entryPath, item.IsDir()) {
+			continue
+		}
 		if item.IsDir() {
 			sd := NewDirectory(item.Name(), d.OwnerID, d.DriveID, entryPath)
-			sd = walk(sd)
+			sd = walk(sd, ig)
 			if err := d.AddSubDir(sd); err != nil {
 				log.Print(err)
 			}
@@ -745,9 +787,13 @@ func walkDs(dir *Directory, dirMap map[string]*Directory) map[string]*Directory
 }
 
 func buildSync(dir *Directory, idx *SyncIndex) *SyncIndex {
+	if idx.CheckSums == nil {
+		idx.CheckSums = make(map[string]string, len(dir.Files))
+	}
 	for _, file := range dir.Files {
 		if !idx.HasItem(file.ID) {
 			idx.LastSync[file.ID] = file.LastSync
+			idx.CheckSums[file.ID] = file.CheckSum
 		}
 	}
 	// NOTE: monitoring directories is no longer supported.
@@ -34,15 +34,13 @@ users/
 |    |----state/
 |    |    |----drive-state-d-m-y-hh-mm-ss.json
 |    |    recycled/     <---- "deleted" files & directories
+|    |    backups/      <---- previous versions of files
 |----userB/
 (etc)
+blobs/              <---- file contents for every drive, keyed by checksum
 
-TODO:
-
-	possibly store clients files on the server in a "flat" directory?
-	doesn't have to match the client's local file system tree -- only be a
-	repository of back ups. would make search time linear instead of whatever the walk()
-	implementations in dirs.go are at currently.
+on the server, paths under users/ are logical. file and version contents
+are stored once in blobs/ no matter how many files share them (see blobs.go).
 */
 func AllocateDrive(name string, svcRoot string) error {
 	// new user service file paths
@@ -338,7 +336,7 @@ func (d *Drive) RemoveFile(dirID string, file *File) error {
 			if dir == nil {
 				return fmt.Errorf("dir (id=%s) not found", dirID)
 			}
-			d.UpdateDriveSize(-file.GetSize())
+			d.UpdateDriveSize(-file.Size)
 			if err := dir.RemoveFile(file.ID); err != nil {
 				return err
 			}
@@ -350,6 +348,36 @@ func (d *Drive) RemoveFile(dirID string, file *File) error {
 	return nil
 }
 
+// move a file to another directory in the drive. the file's DirID and
+// backup path are updated, but its client or server paths are not, and
+// should point to the file's new location before calling this.
+func (d *Drive) MoveFile(destDirID string, file *File) error {
+	if d.Protected {
+		d.Log.Info(fmt.Sprintf("drive (id=%s) is protected", d.ID))
+		return nil
+	}
+	if !d.HasRoot() {
+		return fmt.Errorf("drive has no root directory")
+	}
+	src := d.GetDir(file.DirID)
+	if src == nil {
+		return fmt.Errorf("dir (id=%s) not found", file.DirID)
+	}
+	dest := d.GetDir(destDirID)
+	if dest == nil {
+		return fmt.Errorf("dir (id=%s) not found", destDirID)
+	}
+	// just a rename
+	if src.ID == dest.ID {
+		file.BackupPath = filepath.Join(dest.BackupPath, file.Name)
+		return src.PutFile(file)
+	}
+	if err := src.RemoveFile(file.ID); err != nil {
+		return err
+	}
+	return dest.AddFile(file)
+}
+
 // ------ directory management --------------------------------
 
 func (d *Drive) addSubDir(dirID string, dir *Directory) error {
@@ -570,7 +598,7 @@ func (d *Drive) ClearDrive() error {
 
 func (d *Drive) BuildSyncIdx() {
 	files := d.GetFiles()
-	d.SyncIndex = BuildSyncIndex(files, nil, d.SyncIndex)
+	d.SyncIndex = BuildSyncIndex(files, nil, d.SyncIndex, nil)
 }
 
 // Builds the ToUpdate map for the sync index. If the index is not set (ie nil),
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"os"
 	"path/filepath"
 	"testing"
 
@@ -85,3 +86,40 @@ func TestPopulateDrive(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestDriveMoveFile(t *testing.T) {
+	env.SetEnv(false)
+
+	testDrive, testRoot := MakeDummySystem(t)
+	subDir := testRoot.GetSubDirs()[0]
+
+	// move a file from the root into the subdirectory
+	var file *File
+	for _, f := range testRoot.Files {
+		file = f
+		break
+	}
+	origID := file.ID
+	newPath := filepath.Join(subDir.Path, "moved.txt")
+	if err := os.Rename(file.ClientPath, newPath); err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+	file.Rename("moved.txt")
+	file.ClientPath = newPath
+	file.Path = newPath
+	if err := testDrive.MoveFile(subDir.ID, file); err != nil {
+		Fail(t, GetTestingDir(), err)
+	}
+
+	moved := testDrive.GetFile(origID)
+	assert.NotEqual(t, nil, moved)
+	assert.Equal(t, subDir.ID, moved.DirID)
+	assert.Equal(t, "moved.txt", moved.Name)
+	assert.Equal(t, "moved.txt", moved.NMap[origID])
+	assert.True(t, subDir.HasFile(origID))
+	assert.False(t, testRoot.HasFile(origID))
+
+	if err := Clean(t, GetTestingDir()); err != nil {
+		t.Fatal(err)
+	}
+}
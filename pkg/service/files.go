@@ -6,11 +6,13 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"io/fs"
 	"log"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -102,6 +104,22 @@ func NewFile(fileName string, driveID string, ownerID string, filePath string) *
 	}
 }
 
+// whether a name can be used for a file or directory. names can't be
+// empty, "." or "..", or contain a path separator.
+func ValidName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
+
+// update a file's name and name map. client and server
+// paths are left as-is, and should be set by the caller.
+func (f *File) Rename(newName string) {
+	if f.NMap == nil {
+		f.NMap = make(NameMap, 1)
+	}
+	f.NMap[f.ID] = newName
+	f.Name = newName
+}
+
 // has this file been backed up ?
 func (f *File) IsServerBackUp() bool  { return f.ServerBackup }
 func (f *File) IsLocalBackup() bool   { return f.LocalBackup }
@@ -136,10 +154,13 @@ func (f *File) ToJSON() ([]byte, error) {
 // returns file size in bytes
 //
 // uses os.Stat() - "length in bytes for regular files; system-dependent for others"
+//
+// falls back to the last known size if the file can't be found. server-side
+// files are kept in the server's blob store rather than at their server path.
 func (f *File) GetSize() int64 {
 	info, err := os.Stat(f.GetPath())
 	if err != nil {
-		log.Fatalf("unable to determine file size: %v", err)
+		return f.Size
 	}
 	return info.Size()
 }
@@ -267,6 +288,29 @@ func (f *File) Save(data []byte) error {
 	return nil
 }
 
+// replace the file's contents with the file at srcPath. srcPath is moved into
+// place, so it should be on the same file system as the file itself.
+func (f *File) Replace(srcPath string) error {
+	if f.Protected {
+		log.Printf("[INFO] %s is protected", f.Name)
+		return nil
+	}
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	cs, err := CalculateChecksum(srcPath)
+	if err != nil {
+		return fmt.Errorf("failed to calculate checksum: %v", err)
+	}
+	if err := os.Rename(srcPath, f.GetPath()); err != nil {
+		return fmt.Errorf("failed to replace %s: %v", f.Name, err)
+	}
+	f.CheckSum = cs
+	f.Size = f.GetSize()
+	f.LastSync = time.Now().UTC()
+	return nil
+}
+
 // clears the *in-memory* file contents, not the actual external file contents.
 func (f *File) Clear() error {
 	if !f.Protected {
@@ -309,6 +353,44 @@ func (f *File) Copy(destPath string) error {
 
 // ----------- File integrity
 
+// returned when received data doesn't match its expected checksum
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// returned when data is sent without a checksum to verify it against
+var ErrNoChecksum = errors.New("no checksum")
+
+// compare a checksum against an expected one. data can't be verified
+// without an expected checksum, so an empty one is ErrNoChecksum.
+// mismatches are ErrChecksumMismatch.
+func VerifyChecksum(expected, got string) error {
+	if expected == "" {
+		return fmt.Errorf("%w to verify against", ErrNoChecksum)
+	}
+	if got != expected {
+		return fmt.Errorf("%w. expected: %s, got: %s", ErrChecksumMismatch, expected, got)
+	}
+	return nil
+}
+
+// computes a checksum of everything written to it, so files
+// can be verified as they're streamed rather than read twice.
+type Hasher struct {
+	h hash.Hash
+}
+
+func NewHasher() *Hasher {
+	return &Hasher{h: sha256.New()}
+}
+
+func (h *Hasher) Write(p []byte) (int, error) {
+	return h.h.Write(p)
+}
+
+// checksum of the data written so far
+func (h *Hasher) Sum() string {
+	return base32.StdEncoding.EncodeToString(h.h.Sum(nil))
+}
+
 func CalculateChecksum(filePath string) (string, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
@@ -316,12 +398,11 @@ func CalculateChecksum(filePath string) (string, error) {
 	}
 	defer file.Close()
 
-	var h = sha256.New()
+	var h = NewHasher()
 	if _, err := io.Copy(h, file); err != nil {
 		return "", err
 	}
-	checksum := base32.StdEncoding.EncodeToString(h.Sum(nil))
-	return checksum, nil
+	return h.Sum(), nil
 }
 
 func (f *File) ValidateChecksum() error {
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/sfs/pkg/env"
@@ -142,3 +143,23 @@ func TestFileChecksum(t *testing.T) {
 		t.Fatalf("[ERROR] failed to remove test files: %v", err)
 	}
 }
+
+func TestVerifyChecksum(t *testing.T) {
+	h := NewHasher()
+	h.Write([]byte(testData))
+	cs := h.Sum()
+
+	assert.NoError(t, VerifyChecksum(cs, cs))
+	assert.True(t, errors.Is(VerifyChecksum(cs, "something else"), ErrChecksumMismatch))
+	// data can't be verified without an expected checksum
+	assert.True(t, errors.Is(VerifyChecksum("", cs), ErrNoChecksum))
+}
+
+func TestValidName(t *testing.T) {
+	for _, name := range []string{"a.txt", ".hidden", "a..b", "some file"} {
+		assert.True(t, ValidName(name), name)
+	}
+	for _, name := range []string{"", ".", "..", "a/b", "../a", `a\b`, "/"} {
+		assert.False(t, ValidName(name), name)
+	}
+}
@@ -0,0 +1,280 @@
+package service
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ignore rules.
+
+.sfsignore files use the same format as .gitignore files, and can be placed in
+any directory. patterns in a .sfsignore file are relative to the directory it's in,
+and patterns in deeper .sfsignore files take precedence over ones higher up.
+global patterns (from the client config) are relative to the root directory, and
+have the lowest precedence. directories outside of the root (i.e. ones added with
+discover) can be added as their own roots with AddRoot(). like git, if a directory is ignored, then everything
+under it is ignored too, regardless of any negated patterns.
+*/
+
+// name of the ignore file that can be placed in any directory
+const IGNORE_FILE = ".sfsignore"
+
+// suffix of temp files used for in-progress downloads. these are always ignored.
+const PART_SUFFIX = ".sfs-part"
+
+// a single ignore pattern
+type ignoreRule struct {
+	segs     []string // pattern split on "/"
+	anchored bool     // whether the pattern is matched against the full relative path or just the item's name
+	dirOnly  bool     // pattern ends with "/", so only matches directories
+	negate   bool     // pattern starts with "!", so re-includes matching items
+}
+
+// rules loaded from an ignore file
+type ignoreFile struct {
+	modTime time.Time
+	rules   []ignoreRule
+}
+
+type Ignore struct {
+	mu     sync.Mutex
+	roots  []string     // root directories. ignore files above these aren't used.
+	global []ignoreRule // global patterns
+	files  map[string]*ignoreFile
+}
+
+// create a new ignore rule set. patterns are global gitignore-style patterns
+// that apply to everything under root, in addition to any .sfsignore files.
+func NewIgnore(root string, patterns []string) *Ignore {
+	return &Ignore{
+		roots:  []string{filepath.Clean(root)},
+		global: parseIgnoreRules(patterns),
+		files:  make(map[string]*ignoreFile),
+	}
+}
+
+// add another root directory. no-op if the directory
+// is already under one of the existing roots.
+func (ig *Ignore) AddRoot(dirPath string) {
+	if ig == nil {
+		return
+	}
+	dirPath = filepath.Clean(dirPath)
+	if ig.rootFor(dirPath) != "" {
+		return
+	}
+	ig.mu.Lock()
+	defer ig.mu.Unlock()
+	for _, root := range ig.roots {
+		if root == dirPath {
+			return
+		}
+	}
+	ig.roots = append(ig.roots, dirPath)
+}
+
+func parseIgnoreRules(lines []string) []ignoreRule {
+	rules := make([]ignoreRule, 0, len(lines))
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var rule ignoreRule
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		// patterns with a slash at the beginning or middle are
+		// relative to the ignore file's directory.
+		if strings.Contains(line, "/") {
+			rule.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		rule.segs = strings.Split(line, "/")
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// see if a rule matches a path relative to the rule's base directory.
+func (r *ignoreRule) match(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	parts := strings.Split(rel, "/")
+	if !r.anchored {
+		ok, _ := path.Match(r.segs[0], parts[len(parts)-1])
+		return ok
+	}
+	return matchSegs(r.segs, parts)
+}
+
+// match path segments against pattern segments. "**" matches
+// zero or more directories.
+func matchSegs(pat, parts []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			if len(pat) == 1 {
+				return len(parts) > 0
+			}
+			for i := 0; i <= len(parts); i++ {
+				if matchSegs(pat[1:], parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pat[0], parts[0]); !ok {
+			return false
+		}
+		pat, parts = pat[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
+
+// load the rules from the ignore file in a given directory, if there is one.
+// rules are cached and reloaded whenever the file changes.
+func (ig *Ignore) rules(dir string) []ignoreRule {
+	ig.mu.Lock()
+	defer ig.mu.Unlock()
+
+	ignPath := filepath.Join(dir, IGNORE_FILE)
+	info, err := os.Stat(ignPath)
+	if err != nil {
+		delete(ig.files, dir)
+		return nil
+	}
+	if cached, ok := ig.files[dir]; ok && cached.modTime.Equal(info.ModTime()) {
+		return cached.rules
+	}
+	f, err := os.Open(ignPath)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	lines := make([]string, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	rules := parseIgnoreRules(lines)
+	ig.files[dir] = &ignoreFile{modTime: info.ModTime(), rules: rules}
+	return rules
+}
+
+// get a path relative to base, using forward slashes.
+// returns false if the path isn't under base.
+func relPath(base, itemPath string) (string, bool) {
+	rel, err := filepath.Rel(base, itemPath)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// get the outermost root directory the given path is under.
+// returns an empty string if the path isn't under any of them.
+func (ig *Ignore) rootFor(itemPath string) string {
+	ig.mu.Lock()
+	defer ig.mu.Unlock()
+	var found string
+	for _, root := range ig.roots {
+		if _, ok := relPath(root, itemPath); ok && (found == "" || len(root) < len(found)) {
+			found = root
+		}
+	}
+	return found
+}
+
+// directories whose ignore files apply to the given path, starting with the
+// root directory. if there's no root, then only the item's parent is used.
+func ruleDirs(root, itemPath string) []string {
+	parent := filepath.Dir(itemPath)
+	if root == "" {
+		return []string{parent}
+	}
+	dirs := make([]string, 0)
+	for dir := parent; ; dir = filepath.Dir(dir) {
+		dirs = append([]string{dir}, dirs...)
+		if dir == root || dir == filepath.Dir(dir) {
+			break
+		}
+	}
+	return dirs
+}
+
+// see if a path is ignored, without checking its parent directories.
+func (ig *Ignore) match(root, itemPath string, isDir bool) bool {
+	ignored := false
+	if len(ig.global) > 0 {
+		base := root
+		if base == "" {
+			base = filepath.Dir(itemPath)
+		}
+		if rel, ok := relPath(base, itemPath); ok {
+			for _, rule := range ig.global {
+				if rule.match(rel, isDir) {
+					ignored = !rule.negate
+				}
+			}
+		}
+	}
+	for _, dir := range ruleDirs(root, itemPath) {
+		rel, ok := relPath(dir, itemPath)
+		if !ok {
+			continue
+		}
+		for _, rule := range ig.rules(dir) {
+			if rule.match(rel, isDir) {
+				ignored = !rule.negate
+			}
+		}
+	}
+	return ignored
+}
+
+// see if a file or directory should be ignored. items are ignored if they
+// match an ignore pattern, or if any of their parent directories under root do.
+// a nil *Ignore doesn't ignore anything.
+func (ig *Ignore) Ignored(itemPath string, isDir bool) bool {
+	if ig == nil {
+		return false
+	}
+	if !isDir && strings.HasSuffix(itemPath, PART_SUFFIX) {
+		return true
+	}
+	itemPath = filepath.Clean(itemPath)
+	root := ig.rootFor(itemPath)
+	if root != "" {
+		parents := make([]string, 0)
+		for dir := filepath.Dir(itemPath); dir != root; dir = filepath.Dir(dir) {
+			parents = append([]string{dir}, parents...)
+		}
+		for _, dir := range parents {
+			if ig.match(root, dir, true) {
+				return true
+			}
+		}
+	}
+	return ig.match(root, itemPath, isDir)
+}
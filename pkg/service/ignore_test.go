@@ -0,0 +1,125 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func writeIgnoreFile(t *testing.T, dir string, lines ...string) {
+	if err := os.WriteFile(filepath.Join(dir, IGNORE_FILE), []byte(strings.Join(lines, "\n")), PERMS); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIgnorePatterns(t *testing.T) {
+	root := t.TempDir()
+	sub := filepath.Join(root, "src", "pkg")
+	if err := os.MkdirAll(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeIgnoreFile(t, root,
+		"# build output",
+		"*.log",
+		"!keep.log",
+		"/build/",
+		"docs/**/*.tmp",
+		`\#notes`,
+	)
+	writeIgnoreFile(t, sub, "*.gen.go", "!important.log")
+
+	ig := NewIgnore(root, []string{"node_modules/", ".DS_Store"})
+
+	// global patterns
+	assert.True(t, ig.Ignored(filepath.Join(root, "node_modules"), true))
+	assert.False(t, ig.Ignored(filepath.Join(root, "node_modules"), false))
+	assert.True(t, ig.Ignored(filepath.Join(sub, ".DS_Store"), false))
+
+	// unanchored patterns match at any depth, and can be negated
+	assert.True(t, ig.Ignored(filepath.Join(root, "out.log"), false))
+	assert.True(t, ig.Ignored(filepath.Join(sub, "out.log"), false))
+	assert.False(t, ig.Ignored(filepath.Join(root, "keep.log"), false))
+
+	// anchored patterns only match relative to the ignore file
+	assert.True(t, ig.Ignored(filepath.Join(root, "build"), true))
+	assert.False(t, ig.Ignored(filepath.Join(root, "src", "build"), true))
+	assert.True(t, ig.Ignored(filepath.Join(root, "docs", "a.tmp"), false))
+	assert.True(t, ig.Ignored(filepath.Join(root, "docs", "a", "b", "c.tmp"), false))
+	assert.False(t, ig.Ignored(filepath.Join(root, "a.tmp"), false))
+
+	// escaped characters
+	assert.True(t, ig.Ignored(filepath.Join(root, "#notes"), false))
+
+	// deeper ignore files take precedence
+	assert.True(t, ig.Ignored(filepath.Join(sub, "types.gen.go"), false))
+	assert.False(t, ig.Ignored(filepath.Join(root, "types.gen.go"), false))
+	assert.False(t, ig.Ignored(filepath.Join(sub, "important.log"), false))
+
+	// everything under an ignored directory is ignored
+	assert.True(t, ig.Ignored(filepath.Join(root, "build", "keep.log"), false))
+	assert.True(t, ig.Ignored(filepath.Join(root, "node_modules", "pkg", "index.js"), false))
+
+	// partial downloads are always ignored
+	assert.True(t, ig.Ignored(filepath.Join(root, ".out.txt-1234"+PART_SUFFIX), false))
+
+	// nil ignore rules don't ignore anything
+	var none *Ignore
+	assert.False(t, none.Ignored(filepath.Join(root, "out.log"), false))
+}
+
+func TestIgnoreAddRoot(t *testing.T) {
+	root := t.TempDir()
+	other := t.TempDir()
+	sub := filepath.Join(other, "sub")
+	if err := os.MkdirAll(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeIgnoreFile(t, other, "*.bak")
+
+	ig := NewIgnore(root, []string{"tmp/"})
+
+	// without a root, only the item's parent ignore file is used
+	assert.True(t, ig.Ignored(filepath.Join(other, "a.bak"), false))
+	assert.False(t, ig.Ignored(filepath.Join(sub, "a.bak"), false))
+
+	ig.AddRoot(other)
+	assert.True(t, ig.Ignored(filepath.Join(sub, "a.bak"), false))
+	assert.True(t, ig.Ignored(filepath.Join(sub, "tmp", "a.txt"), false))
+	assert.False(t, ig.Ignored(filepath.Join(sub, "a.txt"), false))
+
+	// roots under existing roots aren't added
+	ig.AddRoot(sub)
+	assert.Equal(t, 2, len(ig.roots))
+}
+
+func TestWalkIgnore(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"keep", "skip"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range []string{"a.txt", "b.log", "keep/c.txt", "skip/d.txt"} {
+		if err := os.WriteFile(filepath.Join(root, f), []byte("test"), PERMS); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeIgnoreFile(t, root, "*.log", "skip/")
+
+	dir := NewDirectory("root", "me", "some-rand-id", root)
+	dir.WalkIgnore(NewIgnore(root, nil))
+
+	names := make(map[string]bool)
+	for _, f := range dir.GetFiles() {
+		names[f.Name] = true
+	}
+	assert.Equal(t, map[string]bool{"a.txt": true, "c.txt": true, IGNORE_FILE: true}, names)
+	assert.Equal(t, 1, len(dir.GetSubDirs()))
+
+	// ignored files are left out of the sync index
+	idx := BuildSyncIndex(dir.GetFiles(), nil, NewSyncIndex("me"), NewIgnore(root, []string{"a.txt"}))
+	assert.Equal(t, 2, len(idx.LastSync))
+}
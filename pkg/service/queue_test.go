@@ -126,3 +126,23 @@ func TestBuildQWithFilesLargerThanMAX(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestBuildFileQ(t *testing.T) {
+	files := []*File{
+		{ID: "a", Size: 10},
+		{ID: "b", Size: 20},
+		{ID: "c", Size: MAX + 1},
+	}
+	q := BuildFileQ(files)
+	assert.NotEqual(t, nil, q)
+	assert.Equal(t, 2, len(q.Queue))
+
+	// large files go last, in their own batch
+	small := q.Dequeue()
+	assert.Equal(t, 2, len(small.Files))
+	large := q.Dequeue()
+	assert.Equal(t, 1, len(large.Files))
+	assert.True(t, large.HasFile("c"))
+
+	assert.Equal(t, (*Queue)(nil), BuildFileQ(nil))
+}
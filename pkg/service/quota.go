@@ -0,0 +1,137 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+)
+
+/*
+drive quotas.
+
+a drive's TotalSize is its quota. UsedSpace is the total size of the drive's
+files, and FreeSpace is what's left. the server recalculates usage from its
+database whenever files are added, updated, or removed, and rejects changes
+that would put a drive over its quota.
+*/
+
+var ErrQuotaExceeded = errors.New("drive quota exceeded")
+
+// set the drive's used space, and update its free space to match
+func (d *Drive) SetUsage(used int64) {
+	d.UsedSpace = used
+	d.FreeSpace = d.TotalSize - used
+}
+
+// check whether the drive has room for delta more bytes. changes that don't
+// grow the drive are always allowed, even if it's already over its quota.
+func (d *Drive) CheckQuota(delta int64) error {
+	if delta <= 0 {
+		return nil
+	}
+	if d.UsedSpace+delta > d.TotalSize {
+		return fmt.Errorf(
+			"%w: %d bytes needed, %d of %d bytes available",
+			ErrQuotaExceeded, delta, max(d.TotalSize-d.UsedSpace, 0), d.TotalSize,
+		)
+	}
+	return nil
+}
+
+// space used by a directory. Size and Files include the directory's subdirectories.
+type DirUsage struct {
+	DirID string `json:"dir_id"`
+	Path  string `json:"path"` // relative to the drive's root directory
+	Size  int64  `json:"size"`
+	Files int    `json:"files"`
+}
+
+// space used by a drive, broken down by directory
+type DriveUsage struct {
+	DriveID string      `json:"drive_id"`
+	OwnerID string      `json:"owner_id"`
+	Total   int64       `json:"total"`
+	Used    int64       `json:"used"`
+	Free    int64       `json:"free"`
+	Dirs    []*DirUsage `json:"dirs"`
+}
+
+// build a usage report for a drive from its directories and files.
+// files in directories that aren't in dirs are counted towards the root.
+func NewDriveUsage(drive *Drive, dirs []*Directory, files []*File) *DriveUsage {
+	byID := make(map[string]*Directory, len(dirs))
+	for _, dir := range dirs {
+		byID[dir.ID] = dir
+	}
+	// ancestors of a directory, including itself and the root
+	chain := func(dirID string) []string {
+		ids := []string{drive.RootID}
+		seen := map[string]bool{drive.RootID: true}
+		for dir, ok := byID[dirID]; ok && !seen[dir.ID]; dir, ok = byID[dir.ParentID] {
+			seen[dir.ID] = true
+			ids = append(ids, dir.ID)
+		}
+		return ids
+	}
+	path := func(dirID string) string {
+		var parts []string
+		seen := make(map[string]bool)
+		for dir, ok := byID[dirID]; ok && dir.ID != drive.RootID && !seen[dir.ID]; dir, ok = byID[dir.ParentID] {
+			seen[dir.ID] = true
+			parts = append([]string{dir.Name}, parts...)
+		}
+		return "/" + filepath.ToSlash(filepath.Join(parts...))
+	}
+
+	usage := make(map[string]*DirUsage)
+	get := func(dirID string) *DirUsage {
+		if u, ok := usage[dirID]; ok {
+			return u
+		}
+		u := &DirUsage{DirID: dirID, Path: path(dirID)}
+		usage[dirID] = u
+		return u
+	}
+	get(drive.RootID)
+	for _, dir := range dirs {
+		get(dir.ID)
+	}
+
+	var used int64
+	for _, file := range files {
+		used += file.Size
+		for _, id := range chain(file.DirID) {
+			u := get(id)
+			u.Size += file.Size
+			u.Files++
+		}
+	}
+
+	report := &DriveUsage{
+		DriveID: drive.ID,
+		OwnerID: drive.OwnerID,
+		Total:   drive.TotalSize,
+		Used:    used,
+		Free:    drive.TotalSize - used,
+		Dirs:    make([]*DirUsage, 0, len(usage)),
+	}
+	for _, u := range usage {
+		report.Dirs = append(report.Dirs, u)
+	}
+	sort.Slice(report.Dirs, func(i, j int) bool { return report.Dirs[i].Path < report.Dirs[j].Path })
+	return report
+}
+
+func (u *DriveUsage) ToJSON() ([]byte, error) {
+	return json.MarshalIndent(u, "", "  ")
+}
+
+func UnmarshalDriveUsage(data []byte) (*DriveUsage, error) {
+	u := new(DriveUsage)
+	if err := json.Unmarshal(data, u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
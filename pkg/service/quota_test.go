@@ -0,0 +1,44 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestDriveUsage(t *testing.T) {
+	drive := &Drive{ID: "drive", RootID: "root", TotalSize: 100}
+
+	dirs := []*Directory{
+		{ID: "root", Name: "root", ParentID: "root", Root: true},
+		{ID: "docs", Name: "docs", ParentID: "root"},
+		{ID: "work", Name: "work", ParentID: "docs"},
+		{ID: "pics", Name: "pics", ParentID: "root"},
+	}
+	files := []*File{
+		{ID: "a", DirID: "root", Size: 5},
+		{ID: "b", DirID: "docs", Size: 10},
+		{ID: "c", DirID: "work", Size: 20},
+		{ID: "d", DirID: "gone", Size: 1}, // directory no longer exists
+	}
+	usage := NewDriveUsage(drive, dirs, files)
+	assert.Equal(t, int64(36), usage.Used)
+	assert.Equal(t, int64(64), usage.Free)
+
+	byPath := make(map[string]*DirUsage)
+	for _, u := range usage.Dirs {
+		byPath[u.Path] = u
+	}
+	assert.Equal(t, 4, len(byPath))
+	assert.Equal(t, int64(36), byPath["/"].Size)
+	assert.Equal(t, 4, byPath["/"].Files)
+	assert.Equal(t, int64(30), byPath["/docs"].Size)
+	assert.Equal(t, int64(20), byPath["/docs/work"].Size)
+	assert.Equal(t, int64(0), byPath["/pics"].Size)
+
+	drive.SetUsage(usage.Used)
+	assert.NoError(t, drive.CheckQuota(64))
+	assert.True(t, errors.Is(drive.CheckQuota(65), ErrQuotaExceeded))
+	assert.NoError(t, drive.CheckQuota(-10))
+}
@@ -0,0 +1,58 @@
+package service
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"time"
+
+	"github.com/sfs/pkg/auth"
+)
+
+/*
+recycle bin items.
+
+removed files are copied into the client's recycle bin under a unique storage
+name, so files with the same name don't overwrite each other. the recycle bin's
+manifest keeps track of where each item came from so it can be put back.
+*/
+
+// a file in the recycle bin
+type RecycledItem struct {
+	ID       string    `json:"id"`        // unique id for this item. also used in its storage name
+	FileID   string    `json:"file_id"`   // id the file had before it was removed
+	Name     string    `json:"name"`      // original file name
+	OrigPath string    `json:"orig_path"` // where the file was before it was removed
+	Path     string    `json:"path"`      // where the file is kept in the recycle bin
+	Size     int64     `json:"size"`
+	CheckSum string    `json:"checksum"`
+	Deleted  time.Time `json:"deleted"`
+}
+
+// create a recycle bin entry for a file. the file is expected to be
+// copied to the returned item's Path.
+func NewRecycledItem(file *File, recycleBin string) *RecycledItem {
+	id := auth.NewUUID()
+	return &RecycledItem{
+		ID:       id,
+		FileID:   file.ID,
+		Name:     file.Name,
+		OrigPath: file.ClientPath,
+		Path:     filepath.Join(recycleBin, id+"-"+file.Name),
+		Size:     file.Size,
+		CheckSum: file.CheckSum,
+		Deleted:  time.Now().UTC(),
+	}
+}
+
+// whether the item has been in the recycle bin for more than the given
+// number of days. items never expire if days is 0 or less.
+func (r *RecycledItem) Expired(days int, now time.Time) bool {
+	if days <= 0 {
+		return false
+	}
+	return now.Sub(r.Deleted) > time.Duration(days)*24*time.Hour
+}
+
+func (r *RecycledItem) ToJSON() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
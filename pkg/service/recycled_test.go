@@ -0,0 +1,19 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestRecycledItemExpired(t *testing.T) {
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+	item := &RecycledItem{Deleted: now.AddDate(0, 0, -10)}
+
+	assert.True(t, item.Expired(7, now))
+	assert.False(t, item.Expired(30, now))
+
+	// items never expire with no expiry set
+	assert.False(t, item.Expired(0, now))
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/sfs/pkg/auth"
+)
+
+/*
+shared files and directories.
+
+owners can give other users read or read-write access to a file or directory
+in their drive. sharing a directory shares everything in it. items shared
+with a user show up in their drive listing under a "Shared with me" virtual
+directory, which doesn't exist anywhere on the server.
+
+shares can have an expiration date, after which they stop working.
+*/
+
+// id and name of the virtual directory shared items are listed under
+const (
+	SharedDirID   = "shared-with-me"
+	SharedDirName = "Shared with me"
+)
+
+// types of items that can be shared
+const (
+	ShareFile = "file"
+	ShareDir  = "directory"
+)
+
+type Permission string
+
+const (
+	PermRead      Permission = "read"
+	PermReadWrite Permission = "read-write"
+)
+
+func ParsePermission(p string) (Permission, error) {
+	switch Permission(p) {
+	case PermRead, PermReadWrite:
+		return Permission(p), nil
+	default:
+		return "", fmt.Errorf("invalid permission '%s'. must be one of: %s, %s", p, PermRead, PermReadWrite)
+	}
+}
+
+type Share struct {
+	ID         string     `json:"id"`
+	ItemID     string     `json:"item_id"`
+	ItemType   string     `json:"item_type"` // "file" or "directory"
+	OwnerID    string     `json:"owner_id"`
+	GranteeID  string     `json:"grantee_id"` // user the item is shared with
+	Permission Permission `json:"permission"`
+	Created    time.Time  `json:"created"`
+	Expires    time.Time  `json:"expires,omitempty"` // zero if the share never expires
+}
+
+// create a new share. expires can be zero for shares that never expire.
+func NewShare(itemID string, itemType string, ownerID string, granteeID string, perm Permission, expires time.Time) (*Share, error) {
+	if itemID == "" || ownerID == "" || granteeID == "" {
+		return nil, fmt.Errorf("item, owner, and grantee IDs are required")
+	}
+	if itemType != ShareFile && itemType != ShareDir {
+		return nil, fmt.Errorf("invalid item type '%s'", itemType)
+	}
+	if ownerID == granteeID {
+		return nil, fmt.Errorf("items can't be shared with their owner")
+	}
+	if _, err := ParsePermission(string(perm)); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	if !expires.IsZero() && !expires.After(now) {
+		return nil, fmt.Errorf("share expiration must be in the future")
+	}
+	return &Share{
+		ID:         auth.NewUUID(),
+		ItemID:     itemID,
+		ItemType:   itemType,
+		OwnerID:    ownerID,
+		GranteeID:  granteeID,
+		Permission: perm,
+		Created:    now,
+		Expires:    expires.UTC(),
+	}, nil
+}
+
+func (s *Share) Expired() bool {
+	return !s.Expires.IsZero() && time.Now().UTC().After(s.Expires)
+}
+
+// whether the grantee can change the shared item
+func (s *Share) CanWrite() bool {
+	return s.Permission == PermReadWrite
+}
+
+func (s *Share) ToJSON() ([]byte, error) {
+	return json.MarshalIndent(s, "", "  ")
+}
+
+func UnmarshalShare(data []byte) (*Share, error) {
+	s := new(Share)
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal share: %v", err)
+	}
+	return s, nil
+}
+
+// a file shared with a user. Path is where the file appears under
+// the user's "Shared with me" directory.
+type SharedFile struct {
+	File       *File      `json:"file"`
+	Path       string     `json:"path"`
+	ShareID    string     `json:"share_id"`
+	Permission Permission `json:"permission"`
+}
+
+// a directory and its immediate contents
+type DirListing struct {
+	Dir   *Directory   `json:"dir"`
+	Dirs  []*Directory `json:"dirs"`
+	Files []*File      `json:"files"`
+}
+
+// create the virtual directory shared items are listed under
+func NewSharedDir(ownerID string, driveID string) *Directory {
+	return &Directory{
+		ID:      SharedDirID,
+		Name:    SharedDirName,
+		OwnerID: ownerID,
+		DriveID: driveID,
+		Files:   make(map[string]*File, 0),
+		Dirs:    make(map[string]*Directory, 0),
+	}
+}
@@ -0,0 +1,37 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestNewShare(t *testing.T) {
+	share, err := NewShare("item", ShareDir, "owner", "grantee", PermRead, time.Time{})
+	assert.NoError(t, err)
+	assert.False(t, share.Expired())
+	assert.False(t, share.CanWrite())
+
+	share.Expires = time.Now().UTC().Add(-time.Second)
+	assert.True(t, share.Expired())
+
+	share, err = NewShare("item", ShareFile, "owner", "grantee", PermReadWrite, time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	assert.True(t, share.CanWrite())
+
+	for _, tc := range []struct {
+		itemType string
+		grantee  string
+		perm     Permission
+		expires  time.Time
+	}{
+		{"thing", "grantee", PermRead, time.Time{}},
+		{ShareFile, "owner", PermRead, time.Time{}},
+		{ShareFile, "grantee", Permission("admin"), time.Time{}},
+		{ShareFile, "grantee", PermRead, time.Now().Add(-time.Hour)},
+	} {
+		_, err := NewShare("item", tc.itemType, "owner", tc.grantee, tc.perm, tc.expires)
+		assert.Error(t, err)
+	}
+}
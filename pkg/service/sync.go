@@ -18,12 +18,30 @@ type SyncIndex struct {
 	// flag to indicate whether a sync operation should be executed
 	Sync bool `json:"sync"`
 
+	// latest revision of the drive this index was built from.
+	// assigned by the server.
+	Revision int64 `json:"revision"`
+
 	// We will use the file path for each file to retrieve the pointer for the
 	// file object if it is to be queued for uploading or downloading
 	//
 	// key = file or directory UUID, value = last modified date
 	LastSync map[string]time.Time `json:"last_sync"`
 
+	// checksums of each file at the time the index was built.
+	// key = file UUID, value = file checksum
+	CheckSums map[string]string `json:"checksums"`
+
+	// server assigned revision of each file's latest change.
+	// revisions are monotonically increasing within a drive, so unlike
+	// last sync times, they aren't affected by clock skew between devices.
+	// key = file UUID, value = revision
+	Revisions map[string]int64 `json:"revisions"`
+
+	// files that have been deleted on the server.
+	// key = file UUID, value = tombstone
+	Tombstones map[string]*Tombstone `json:"tombstones"`
+
 	// map of files to be queued for uploading or downloading.
 	// key = file UUID, value = file pointer
 	FilesToUpdate map[string]*File `json:"files_to_update"`
@@ -40,17 +58,104 @@ func NewSyncIndex(userID string) *SyncIndex {
 		UserID:        userID,
 		Sync:          false,
 		LastSync:      make(map[string]time.Time, 0),
+		CheckSums:     make(map[string]string, 0),
+		Revisions:     make(map[string]int64, 0),
+		Tombstones:    make(map[string]*Tombstone, 0),
 		FilesToUpdate: make(map[string]*File, 0),
 		// DirsToUpdate:  make(map[string]*Directory, 0),
 	}
 }
 
+// last synced state of a file. used as the common base
+// when comparing the client and server versions of a file.
+type SyncState struct {
+	FileID   string    `json:"file_id"`
+	DriveID  string    `json:"drive_id"`
+	CheckSum string    `json:"checksum"`
+	Revision int64     `json:"revision"` // server revision at the time of the sync
+	LastSync time.Time `json:"last_sync"`
+}
+
+func NewSyncState(file *File, revision int64) *SyncState {
+	return &SyncState{
+		FileID:   file.ID,
+		DriveID:  file.DriveID,
+		CheckSum: file.CheckSum,
+		Revision: revision,
+		LastSync: time.Now().UTC(),
+	}
+}
+
+// a server-assigned revision for the latest change to a file.
+// LastSync is informational only. if Deleted is true, then the
+// latest change was the file being deleted by Device.
+type Revision struct {
+	FileID   string    `json:"file_id"`
+	DriveID  string    `json:"drive_id"`
+	Revision int64     `json:"revision"`
+	CheckSum string    `json:"checksum"`
+	LastSync time.Time `json:"last_sync"`
+	Deleted  bool      `json:"deleted"`
+	Device   string    `json:"device"`
+}
+
+// record of a file that was deleted on the server, so other
+// clients know to remove their copies rather than push them back.
+type Tombstone struct {
+	FileID   string    `json:"file_id"`
+	Revision int64     `json:"revision"`
+	Device   string    `json:"device"` // device the file was deleted from
+	Deleted  time.Time `json:"deleted"`
+}
+
+// add a set of revisions to the index, updating
+// the index's revision to the latest one found.
+// deletions are added as tombstones.
+func (s *SyncIndex) AddRevisions(revs []*Revision) {
+	if s.Revisions == nil {
+		s.Revisions = make(map[string]int64, len(revs))
+	}
+	if s.CheckSums == nil {
+		s.CheckSums = make(map[string]string, len(revs))
+	}
+	if s.Tombstones == nil {
+		s.Tombstones = make(map[string]*Tombstone, 0)
+	}
+	for _, rev := range revs {
+		if rev.Deleted {
+			delete(s.Revisions, rev.FileID)
+			delete(s.CheckSums, rev.FileID)
+			delete(s.LastSync, rev.FileID)
+			s.Tombstones[rev.FileID] = &Tombstone{
+				FileID:   rev.FileID,
+				Revision: rev.Revision,
+				Device:   rev.Device,
+				Deleted:  rev.LastSync,
+			}
+		} else {
+			delete(s.Tombstones, rev.FileID)
+			s.Revisions[rev.FileID] = rev.Revision
+			s.CheckSums[rev.FileID] = rev.CheckSum
+			s.LastSync[rev.FileID] = rev.LastSync
+		}
+		if rev.Revision > s.Revision {
+			s.Revision = rev.Revision
+		}
+	}
+}
+
 // resets both LastSync and ToUpdate maps
 func (s *SyncIndex) Reset() {
 	s.LastSync = nil
+	s.CheckSums = nil
+	s.Revisions = nil
+	s.Tombstones = nil
 	s.FilesToUpdate = nil
 	// s.DirsToUpdate = nil
 	s.LastSync = make(map[string]time.Time, 0)
+	s.CheckSums = make(map[string]string, 0)
+	s.Revisions = make(map[string]int64, 0)
+	s.Tombstones = make(map[string]*Tombstone, 0)
 	s.FilesToUpdate = make(map[string]*File, 0)
 	// s.DirsToUpdate = make(map[string]*Directory, 0)
 }
@@ -128,6 +233,9 @@ func BuildRootToUpdate(root *Directory, idx *SyncIndex) *SyncIndex {
 compares a given syncindex against a newly generated one and returns the differnece
 between the two, favoring the newer one for any lastest sync times.
 
+if both indicies have a server-assigned revision for an item, then revisions are
+compared instead of last sync times, since last sync times are subject to clock skew.
+
 the map this returns will only contain the items that were matched and found to have a
 more recent time -- items that weren't matched will be ignored.
 
@@ -136,10 +244,17 @@ TODO: indicate whether the latest sync time is from a server item or a client it
 func Compare(orig *SyncIndex, new *SyncIndex) *SyncIndex {
 	newest := NewSyncIndex(orig.UserID) // index containing most recent items
 
-	// compare last sync times
+	// compare revisions, or last sync times if there are no revisions to compare
 	for itemId, lastSync := range new.LastSync {
 		if origTime, exists := orig.LastSync[itemId]; exists {
-			if lastSync.After(origTime) {
+			newRev, newOk := new.Revisions[itemId]
+			origRev, origOk := orig.Revisions[itemId]
+			if newOk && origOk {
+				if newRev > origRev {
+					newest.LastSync[itemId] = lastSync
+					newest.Revisions[itemId] = newRev
+				}
+			} else if lastSync.After(origTime) {
 				newest.LastSync[itemId] = lastSync
 			}
 		}
@@ -171,9 +286,20 @@ Build a sync index of all files being monitored by the system.
 NOTE: the directories argument is for future implementations.
 probably won't be used during this first iteration.
 dirs can be set to nil for the time being.
+
+files matching ig are left out of the index (and removed from it
+if they were already indexed). ig can be nil.
 */
-func BuildSyncIndex(files []*File, dirs []*Directory, idx *SyncIndex) *SyncIndex {
+func BuildSyncIndex(files []*File, dirs []*Directory, idx *SyncIndex, ig *Ignore) *SyncIndex {
+	if idx.CheckSums == nil {
+		idx.CheckSums = make(map[string]string, len(files))
+	}
 	for _, file := range files {
+		if ig.Ignored(file.ClientPath, false) {
+			delete(idx.LastSync, file.ID)
+			delete(idx.CheckSums, file.ID)
+			continue
+		}
 		if !idx.HasItem(file.ID) {
 			idx.LastSync[file.ID] = file.LastSync
 		} else {
@@ -181,6 +307,7 @@ func BuildSyncIndex(files []*File, dirs []*Directory, idx *SyncIndex) *SyncIndex
 				idx.LastSync[file.ID] = file.LastSync
 			}
 		}
+		idx.CheckSums[file.ID] = file.CheckSum
 	}
 	// NOTE: for future implementation iterations
 	// for _, dir := range dirs {
@@ -310,6 +437,22 @@ func BuildQ(idx *SyncIndex) *Queue {
 	return buildQ(files, NewBatch(), NewQ())
 }
 
+// build a queue for transferring the given files. files larger than
+// MAX are put in their own batch at the end of the queue.
+//
+// returns nil if no files are given
+func BuildFileQ(files []*File) *Queue {
+	if len(files) == 0 {
+		return nil
+	}
+	large := GetLargeFiles(files)
+	q := buildQ(DiffFiles(files, large), NewBatch(), NewQ())
+	if len(large) > 0 {
+		q.Enqueue(LargeFileQ(large).Dequeue())
+	}
+	return q
+}
+
 // create a custom file queue for files that exceed batch.MAX
 func LargeFileQ(files []*File) *Queue {
 	b := NewBatch()
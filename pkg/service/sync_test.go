@@ -2,6 +2,7 @@ package service
 
 import (
 	"testing"
+	"time"
 
 	"github.com/sfs/pkg/env"
 
@@ -73,3 +74,42 @@ func TestCompare(t *testing.T) {
 	// compare
 	assert.NotEqual(t, 0, len(diffs.LastSync))
 }
+
+func TestCompareRevisions(t *testing.T) {
+	now := time.Now().UTC()
+	orig := NewSyncIndex("user")
+	orig.AddRevisions([]*Revision{
+		{FileID: "a", Revision: 1, LastSync: now},
+		{FileID: "b", Revision: 2, LastSync: now},
+	})
+	assert.Equal(t, int64(2), orig.Revision)
+
+	// "a" has a newer revision but an older timestamp (clock skew),
+	// "b" has a newer timestamp but the same revision.
+	new := NewSyncIndex("user")
+	new.AddRevisions([]*Revision{
+		{FileID: "a", Revision: 3, LastSync: now.Add(-time.Hour)},
+		{FileID: "b", Revision: 2, LastSync: now.Add(time.Hour)},
+	})
+	diffs := Compare(orig, new)
+	assert.Equal(t, 1, len(diffs.LastSync))
+	assert.Equal(t, int64(3), diffs.Revisions["a"])
+}
+
+func TestAddRevisionsTombstones(t *testing.T) {
+	now := time.Now().UTC()
+	idx := NewSyncIndex("user")
+	idx.AddRevisions([]*Revision{
+		{FileID: "a", Revision: 1, CheckSum: "cs-a", LastSync: now},
+		{FileID: "b", Revision: 2, CheckSum: "cs-b", LastSync: now},
+	})
+	idx.AddRevisions([]*Revision{
+		{FileID: "a", Revision: 3, LastSync: now, Deleted: true, Device: "laptop"},
+	})
+	assert.Equal(t, int64(3), idx.Revision)
+	assert.Equal(t, 1, len(idx.Revisions))
+	assert.Equal(t, 1, len(idx.Tombstones))
+	assert.Equal(t, "laptop", idx.Tombstones["a"].Device)
+	_, ok := idx.LastSync["a"]
+	assert.False(t, ok)
+}
@@ -0,0 +1,134 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/sfs/pkg/auth"
+)
+
+/*
+upload sessions are used for sending large files to the server in chunks.
+
+a session is created for a file with the total size and expected checksum of
+the file. chunks are then sent with their offsets, and the server keeps track
+of which byte ranges it has received. if a transfer is interrupted, the client
+can ask for the session again and only send what's missing. once everything
+has been received, the session is committed with the checksum, and the server
+replaces its copy of the file.
+*/
+
+// size of each chunk sent during a chunked upload
+const CHUNK_SIZE int64 = 8 << 20 // 8 MB
+
+// a byte range [Start, End)
+type Range struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+func (r Range) Len() int64 { return r.End - r.Start }
+
+type UploadSession struct {
+	ID       string    `json:"id"`
+	FileID   string    `json:"file_id"`
+	DriveID  string    `json:"drive_id"`
+	OwnerID  string    `json:"owner_id"`
+	Size     int64     `json:"size"`     // total size of the file being uploaded
+	CheckSum string    `json:"checksum"` // expected checksum of the completed file
+	TmpPath  string    `json:"-"`        // where the received data is written to on the server
+	Received []Range   `json:"received"` // byte ranges received so far, sorted and merged
+	Created  time.Time `json:"created"`
+	Updated  time.Time `json:"updated"`
+}
+
+func NewUploadSession(file *File, size int64, checksum string, tmpPath string) *UploadSession {
+	now := time.Now().UTC()
+	return &UploadSession{
+		ID:       auth.NewUUID(),
+		FileID:   file.ID,
+		DriveID:  file.DriveID,
+		OwnerID:  file.OwnerID,
+		Size:     size,
+		CheckSum: checksum,
+		TmpPath:  tmpPath,
+		Received: make([]Range, 0),
+		Created:  now,
+		Updated:  now,
+	}
+}
+
+// record that the range [start, end) was received.
+// overlapping and adjacent ranges are merged.
+func (u *UploadSession) AddRange(start, end int64) {
+	if end <= start {
+		return
+	}
+	ranges := append(u.Received, Range{Start: start, End: end})
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Start < ranges[j].Start })
+
+	merged := make([]Range, 0, len(ranges))
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && r.Start <= merged[n-1].End {
+			if r.End > merged[n-1].End {
+				merged[n-1].End = r.End
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	u.Received = merged
+	u.Updated = time.Now().UTC()
+}
+
+// byte ranges that haven't been received yet
+func (u *UploadSession) Missing() []Range {
+	missing := make([]Range, 0)
+	var pos int64
+	for _, r := range u.Received {
+		if r.Start > pos {
+			missing = append(missing, Range{Start: pos, End: r.Start})
+		}
+		pos = r.End
+	}
+	if pos < u.Size {
+		missing = append(missing, Range{Start: pos, End: u.Size})
+	}
+	return missing
+}
+
+// offset of the first byte that hasn't been received
+func (u *UploadSession) Offset() int64 {
+	if len(u.Received) > 0 && u.Received[0].Start == 0 {
+		return u.Received[0].End
+	}
+	return 0
+}
+
+// total number of bytes received so far
+func (u *UploadSession) Total() int64 {
+	var total int64
+	for _, r := range u.Received {
+		total += r.Len()
+	}
+	return total
+}
+
+// whether every byte of the file has been received
+func (u *UploadSession) Complete() bool {
+	return len(u.Missing()) == 0
+}
+
+func (u *UploadSession) ToJSON() ([]byte, error) {
+	return json.MarshalIndent(u, "", "  ")
+}
+
+func UnmarshalUploadSession(data []byte) (*UploadSession, error) {
+	u := new(UploadSession)
+	if err := json.Unmarshal(data, u); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal upload session: %v", err)
+	}
+	return u, nil
+}
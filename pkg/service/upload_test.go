@@ -0,0 +1,45 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestUploadSessionRanges(t *testing.T) {
+	file := &File{ID: "some-file-id", DriveID: "some-rand-id", OwnerID: "me"}
+	u := NewUploadSession(file, 100, "", "")
+	assert.Equal(t, int64(0), u.Offset())
+	assert.Equal(t, []Range{{Start: 0, End: 100}}, u.Missing())
+	assert.False(t, u.Complete())
+
+	// out of order and overlapping chunks
+	u.AddRange(50, 75)
+	u.AddRange(0, 20)
+	u.AddRange(10, 30)
+	assert.Equal(t, []Range{{Start: 0, End: 30}, {Start: 50, End: 75}}, u.Received)
+	assert.Equal(t, int64(30), u.Offset())
+	assert.Equal(t, int64(55), u.Total())
+	assert.Equal(t, []Range{{Start: 30, End: 50}, {Start: 75, End: 100}}, u.Missing())
+
+	// adjacent chunks are merged
+	u.AddRange(30, 50)
+	u.AddRange(75, 100)
+	assert.Equal(t, []Range{{Start: 0, End: 100}}, u.Received)
+	assert.True(t, u.Complete())
+
+	// empty ranges are ignored
+	u.AddRange(10, 10)
+	assert.Equal(t, 1, len(u.Received))
+
+	data, err := u.ToJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u2, err := UnmarshalUploadSession(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, u.Received, u2.Received)
+	assert.Equal(t, u.FileID, u2.FileID)
+}
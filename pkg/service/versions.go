@@ -0,0 +1,158 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+/*
+file versions.
+
+whenever the server's copy of a file is replaced, the previous contents are kept
+in the drive's backups/ directory as a numbered version. versions are numbered
+per file starting at 1, with higher numbers being more recent. the file's current
+contents are never a version.
+
+old versions are pruned according to the drive's retention policy.
+*/
+
+// a previous version of a file
+type Version struct {
+	FileID   string    `json:"file_id"`
+	DriveID  string    `json:"drive_id"`
+	OwnerID  string    `json:"owner_id"`
+	DirID    string    `json:"dir_id"`
+	Name     string    `json:"name"`
+	Number   int64     `json:"version"`
+	Size     int64     `json:"size"`
+	CheckSum string    `json:"checksum"`
+	Path     string    `json:"-"`        // location of the version's contents on the server
+	Created  time.Time `json:"created"`  // when these contents were written
+	Replaced time.Time `json:"replaced"` // when these contents were replaced by a newer version
+}
+
+// create a version from a file's current metadata. the version's number is
+// assigned when it's added to the database.
+func NewVersion(file *File, path string) *Version {
+	return &Version{
+		FileID:   file.ID,
+		DriveID:  file.DriveID,
+		OwnerID:  file.OwnerID,
+		DirID:    file.DirID,
+		Name:     file.Name,
+		Size:     file.Size,
+		CheckSum: file.CheckSum,
+		Path:     path,
+		Created:  file.LastSync,
+		Replaced: time.Now().UTC(),
+	}
+}
+
+func (v *Version) ToJSON() ([]byte, error) {
+	return json.MarshalIndent(v, "", "  ")
+}
+
+// how many old versions of a file to keep. versions kept by any of
+// the rules are kept. a policy with every rule set to 0 keeps everything.
+type RetentionPolicy struct {
+	DriveID  string `json:"drive_id"`
+	KeepLast int    `json:"keep_last"` // keep the N most recent versions
+	Daily    int    `json:"daily"`     // keep the most recent version from each of the last N days
+	Monthly  int    `json:"monthly"`   // keep the most recent version from each of the last N months
+}
+
+// default retention policy for drives that haven't set one
+func DefaultRetention(driveID string) *RetentionPolicy {
+	return &RetentionPolicy{
+		DriveID:  driveID,
+		KeepLast: 10,
+		Daily:    30,
+		Monthly:  12,
+	}
+}
+
+func (p *RetentionPolicy) Validate() error {
+	if p.KeepLast < 0 || p.Daily < 0 || p.Monthly < 0 {
+		return fmt.Errorf("retention values can't be negative")
+	}
+	return nil
+}
+
+func (p *RetentionPolicy) keepAll() bool {
+	return p.KeepLast == 0 && p.Daily == 0 && p.Monthly == 0
+}
+
+func (p *RetentionPolicy) ToJSON() ([]byte, error) {
+	return json.MarshalIndent(p, "", "  ")
+}
+
+// get the versions that should be removed under this policy.
+// days and months are in UTC, and counted back from now.
+func (p *RetentionPolicy) Prune(versions []*Version, now time.Time) []*Version {
+	if p.keepAll() {
+		return nil
+	}
+	sorted := make([]*Version, len(versions))
+	copy(sorted, versions)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Number > sorted[j].Number })
+
+	now = now.UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	dailyCutoff := today.AddDate(0, 0, -(p.Daily - 1))
+	monthlyCutoff := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -(p.Monthly - 1), 0)
+
+	days := make(map[string]bool)
+	months := make(map[string]bool)
+	prune := make([]*Version, 0)
+	for i, v := range sorted {
+		keep := i < p.KeepLast
+		created := v.Created.UTC()
+		if day := created.Format("2006-01-02"); p.Daily > 0 && !created.Before(dailyCutoff) && !days[day] {
+			days[day] = true
+			keep = true
+		}
+		if month := created.Format("2006-01"); p.Monthly > 0 && !created.Before(monthlyCutoff) && !months[month] {
+			months[month] = true
+			keep = true
+		}
+		if !keep {
+			prune = append(prune, v)
+		}
+	}
+	return prune
+}
+
+// a file as it was at some point in time. Version is 0 if the file's
+// current contents are used, otherwise it's the number of the version
+// with the file's contents at that time.
+type SnapshotFile struct {
+	FileID   string    `json:"file_id"`
+	Name     string    `json:"name"`
+	DirID    string    `json:"dir_id"`
+	Path     string    `json:"path"` // relative to the drive's root directory
+	Version  int64     `json:"version"`
+	Size     int64     `json:"size"`
+	CheckSum string    `json:"checksum"`
+	Modified time.Time `json:"modified"`
+}
+
+// the files in a drive as they were at a point in time
+type Snapshot struct {
+	DriveID string          `json:"drive_id"`
+	At      time.Time       `json:"at"`
+	Files   []*SnapshotFile `json:"files"`
+}
+
+func (s *Snapshot) ToJSON() ([]byte, error) {
+	return json.MarshalIndent(s, "", "  ")
+}
+
+func UnmarshalSnapshot(data []byte) (*Snapshot, error) {
+	s := new(Snapshot)
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
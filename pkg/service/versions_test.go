@@ -0,0 +1,65 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestRetentionPrune(t *testing.T) {
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+
+	// two versions a day for the last 5 days, then one on the
+	// 1st of each month going back two years
+	var versions []*Version
+	var n int64
+	for m := 24; m > 0; m-- {
+		n++
+		versions = append(versions, &Version{Number: n, Created: time.Date(2024, 6, 1, 8, 0, 0, 0, time.UTC).AddDate(0, -m, 0)})
+	}
+	for d := 4; d >= 0; d-- {
+		for _, h := range []int{9, 17} {
+			n++
+			versions = append(versions, &Version{Number: n, Created: time.Date(2024, 6, 15-d, h, 0, 0, 0, time.UTC)})
+		}
+	}
+
+	numbers := func(vs []*Version) map[int64]bool {
+		m := make(map[int64]bool)
+		for _, v := range vs {
+			m[v.Number] = true
+		}
+		return m
+	}
+
+	// keep last N
+	p := &RetentionPolicy{KeepLast: 3}
+	assert.Equal(t, len(versions)-3, len(p.Prune(versions, now)))
+
+	// daily keeps the latest version from each day
+	p = &RetentionPolicy{Daily: 3}
+	kept := len(versions) - len(p.Prune(versions, now))
+	assert.Equal(t, 3, kept)
+	pruned := numbers(p.Prune(versions, now))
+	assert.False(t, pruned[n])   // today, 17:00
+	assert.True(t, pruned[n-1])  // today, 09:00
+	assert.False(t, pruned[n-2]) // yesterday, 17:00
+
+	// monthly keeps the latest version from each month, including this one
+	p = &RetentionPolicy{Monthly: 12}
+	pruned = numbers(p.Prune(versions, now))
+	assert.Equal(t, 12, len(versions)-len(pruned))
+	assert.False(t, pruned[n])
+	assert.True(t, pruned[1])
+
+	// rules are combined. the last 4, plus the latest from each
+	// of the other 3 days, plus the 11 earlier months.
+	p = &RetentionPolicy{KeepLast: 4, Daily: 30, Monthly: 12}
+	assert.Equal(t, 7+11, len(versions)-len(p.Prune(versions, now)))
+
+	// an empty policy keeps everything
+	p = &RetentionPolicy{}
+	assert.Equal(t, 0, len(p.Prune(versions, now)))
+	assert.Error(t, (&RetentionPolicy{KeepLast: -1}).Validate())
+}
@@ -0,0 +1,203 @@
+package transfer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sfs/pkg/logger"
+	svc "github.com/sfs/pkg/service"
+)
+
+/*
+worker pool for running a queue of transfers.
+
+batches are taken from the queue in order, and their files are handed out to
+a fixed number of workers. failed transfers are retried with exponential
+backoff. cancelling the context stops any waiting retries, and any files that
+haven't been started yet are skipped. transfers that are already running are
+left to finish.
+*/
+
+// return this (or wrap it) from a TransferFunc to skip a file
+// without counting it as a failure
+var ErrSkipped = errors.New("transfer skipped")
+
+// transfers a single file
+type TransferFunc func(ctx context.Context, file *svc.File) error
+
+type Executor struct {
+	Workers    int           // number of files transferred at once
+	Retries    int           // number of times a failed transfer is retried
+	Backoff    time.Duration // delay before the first retry. doubles after each attempt.
+	MaxBackoff time.Duration // longest delay between retries
+	log        *logger.Logger
+}
+
+func NewExecutor(workers int, retries int) *Executor {
+	return &Executor{
+		Workers:    max(workers, 1),
+		Retries:    max(retries, 0),
+		Backoff:    time.Second,
+		MaxBackoff: time.Second * 30,
+		log:        logger.NewLogger("Executor", "None"),
+	}
+}
+
+// a file that couldn't be transferred
+type Failure struct {
+	FileID   string `json:"file_id"`
+	Name     string `json:"name"`
+	Attempts int    `json:"attempts"`
+	Error    string `json:"error"`
+}
+
+// results of running a transfer queue
+type Report struct {
+	Succeeded []string      `json:"succeeded"` // ids of files that were transferred
+	Failed    []*Failure    `json:"failed"`
+	Skipped   []string      `json:"skipped"` // ids of files that were skipped or cancelled
+	Bytes     int64         `json:"bytes"`   // total size of the files that were transferred
+	Duration  time.Duration `json:"duration"`
+}
+
+func NewReport() *Report {
+	return &Report{
+		Succeeded: make([]string, 0),
+		Failed:    make([]*Failure, 0),
+		Skipped:   make([]string, 0),
+	}
+}
+
+// whether every file was either transferred or skipped
+func (r *Report) OK() bool { return len(r.Failed) == 0 }
+
+// add another report's results to this one
+func (r *Report) Merge(other *Report) {
+	if other == nil {
+		return
+	}
+	r.Succeeded = append(r.Succeeded, other.Succeeded...)
+	r.Failed = append(r.Failed, other.Failed...)
+	r.Skipped = append(r.Skipped, other.Skipped...)
+	r.Bytes += other.Bytes
+	r.Duration += other.Duration
+}
+
+func (r *Report) String() string {
+	return fmt.Sprintf(
+		"%d succeeded | %d failed | %d skipped | %d bytes in %s",
+		len(r.Succeeded), len(r.Failed), len(r.Skipped), r.Bytes, r.Duration.Round(time.Millisecond),
+	)
+}
+
+func (r *Report) ToJSON() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
+
+// transfer every file in the queue with fn. the queue is emptied.
+func (e *Executor) Run(ctx context.Context, q *svc.Queue, fn TransferFunc) *Report {
+	var (
+		start  = time.Now()
+		report = NewReport()
+		files  = make(chan *svc.File)
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+	)
+	if q == nil {
+		return report
+	}
+
+	for i := 0; i < max(e.Workers, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range files {
+				attempts, err := e.run(ctx, file, fn)
+				mu.Lock()
+				switch {
+				case err == nil:
+					report.Succeeded = append(report.Succeeded, file.ID)
+					report.Bytes += file.GetSize()
+				case errors.Is(err, ErrSkipped) || (ctx.Err() != nil && errors.Is(err, ctx.Err())):
+					report.Skipped = append(report.Skipped, file.ID)
+				default:
+					e.log.Error(fmt.Sprintf("failed to transfer %s after %d attempt(s): %v", file.Name, attempts, err))
+					report.Failed = append(report.Failed, &Failure{
+						FileID:   file.ID,
+						Name:     file.Name,
+						Attempts: attempts,
+						Error:    err.Error(),
+					})
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	// hand out files one batch at a time. once cancelled,
+	// everything left in the queue is skipped.
+	for batch := q.Dequeue(); batch != nil; batch = q.Dequeue() {
+		for _, file := range batch.Files {
+			select {
+			case files <- file:
+			case <-ctx.Done():
+				mu.Lock()
+				report.Skipped = append(report.Skipped, file.ID)
+				mu.Unlock()
+			}
+		}
+	}
+	close(files)
+	wg.Wait()
+
+	report.Duration = time.Since(start)
+	return report
+}
+
+// transfer a single file, retrying on failure. returns the number of attempts made.
+func (e *Executor) run(ctx context.Context, file *svc.File, fn TransferFunc) (int, error) {
+	var err error
+	for attempt := 1; ; attempt++ {
+		if ctx.Err() != nil {
+			return attempt - 1, ctx.Err()
+		}
+		if err = fn(ctx, file); err == nil || !retryable(err) || attempt > e.Retries {
+			return attempt, err
+		}
+		delay := e.backoff(attempt)
+		e.log.Warn(fmt.Sprintf("failed to transfer %s (attempt %d of %d): %v. retrying in %s", file.Name, attempt, e.Retries+1, err, delay))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return attempt, ctx.Err()
+		}
+	}
+}
+
+// delay before the given retry
+func (e *Executor) backoff(attempt int) time.Duration {
+	delay := e.Backoff
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if e.MaxBackoff > 0 && delay >= e.MaxBackoff {
+			return e.MaxBackoff
+		}
+	}
+	return delay
+}
+
+// whether a failed transfer is worth trying again
+func retryable(err error) bool {
+	switch {
+	case errors.Is(err, ErrSkipped),
+		errors.Is(err, context.Canceled),
+		errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, svc.ErrQuotaExceeded):
+		return false
+	}
+	return true
+}
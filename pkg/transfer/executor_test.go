@@ -0,0 +1,99 @@
+package transfer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+	svc "github.com/sfs/pkg/service"
+)
+
+func testQ(n int) *svc.Queue {
+	files := make([]*svc.File, 0, n)
+	for i := 0; i < n; i++ {
+		files = append(files, &svc.File{ID: fmt.Sprintf("file-%d", i), Name: fmt.Sprintf("file-%d.txt", i), Size: 10})
+	}
+	return svc.BuildFileQ(files)
+}
+
+func TestExecutor(t *testing.T) {
+	e := NewExecutor(3, 2)
+	e.Backoff = time.Millisecond
+
+	var mu sync.Mutex
+	attempts := make(map[string]int)
+	report := e.Run(context.Background(), testQ(10), func(ctx context.Context, file *svc.File) error {
+		mu.Lock()
+		attempts[file.ID]++
+		n := attempts[file.ID]
+		mu.Unlock()
+		switch file.ID {
+		case "file-0": // fails once, then succeeds
+			if n == 1 {
+				return fmt.Errorf("connection reset")
+			}
+		case "file-1": // always fails
+			return fmt.Errorf("connection reset")
+		case "file-2":
+			return fmt.Errorf("not registered: %w", ErrSkipped)
+		case "file-3": // not worth retrying
+			return fmt.Errorf("upload failed: %w", svc.ErrQuotaExceeded)
+		}
+		return nil
+	})
+
+	assert.Equal(t, 7, len(report.Succeeded))
+	assert.Equal(t, int64(70), report.Bytes)
+	assert.Equal(t, []string{"file-2"}, report.Skipped)
+	assert.Equal(t, 2, len(report.Failed))
+	assert.False(t, report.OK())
+	for _, f := range report.Failed {
+		switch f.FileID {
+		case "file-1":
+			assert.Equal(t, 3, f.Attempts)
+		case "file-3":
+			assert.Equal(t, 1, f.Attempts)
+		default:
+			t.Fatalf("unexpected failure: %s", f.FileID)
+		}
+	}
+	assert.Equal(t, 2, attempts["file-0"])
+}
+
+func TestExecutorCancel(t *testing.T) {
+	e := NewExecutor(2, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var started atomic.Int32
+	report := e.Run(ctx, testQ(20), func(ctx context.Context, file *svc.File) error {
+		if started.Add(1) == 2 {
+			cancel()
+		}
+		return nil
+	})
+	// everything is accounted for, and nothing runs after cancelling
+	assert.Equal(t, 20, len(report.Succeeded)+len(report.Skipped)+len(report.Failed))
+	assert.True(t, len(report.Skipped) > 0)
+	assert.True(t, int(started.Load()) < 20)
+	assert.True(t, report.OK())
+}
+
+func TestExecutorBackoff(t *testing.T) {
+	e := NewExecutor(1, 10)
+	e.Backoff = time.Second
+	e.MaxBackoff = time.Second * 5
+	assert.Equal(t, time.Second, e.backoff(1))
+	assert.Equal(t, time.Second*2, e.backoff(2))
+	assert.Equal(t, time.Second*4, e.backoff(3))
+	assert.Equal(t, time.Second*5, e.backoff(4))
+
+	assert.False(t, retryable(context.Canceled))
+	assert.True(t, retryable(errors.New("timeout")))
+}
@@ -0,0 +1,263 @@
+package transfer
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+bandwidth limits and transfer windows.
+
+uploads and downloads can be limited across every transfer made with the same
+Transfer, and for each individual transfer. if any transfer windows are set,
+limits only apply outside of them, so transfers run at full speed during a
+window (ie. overnight) and are throttled the rest of the day.
+*/
+
+// largest read made before waiting on a limiter, so limited
+// transfers send data in a steady stream rather than in bursts.
+const limitReadSize = 32 * 1024
+
+// rate limits, in bytes per second. 0 means no limit.
+type Limits struct {
+	Upload       int64 `json:"upload"`        // shared by all uploads
+	Download     int64 `json:"download"`      // shared by all downloads
+	FileUpload   int64 `json:"file_upload"`   // for each upload
+	FileDownload int64 `json:"file_download"` // for each download
+}
+
+// token bucket rate limiter. callers are allowed to overdraw the bucket,
+// then wait for it to refill, so concurrent transfers share the rate evenly.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   int64 // bytes per second. 0 for no limit
+	tokens float64
+	last   time.Time
+}
+
+func NewLimiter(rate int64) *Limiter {
+	return &Limiter{rate: rate, tokens: float64(rate), last: time.Now()}
+}
+
+func (l *Limiter) Rate() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+func (l *Limiter) SetRate(rate int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = rate
+	l.tokens = min(l.tokens, float64(rate))
+}
+
+// take n bytes from the bucket, and return how long to wait before sending them
+func (l *Limiter) reserve(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate <= 0 {
+		return 0
+	}
+	now := time.Now()
+	// the bucket holds up to a second's worth of bytes
+	l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*float64(l.rate), float64(l.rate))
+	l.last = now
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / float64(l.rate) * float64(time.Second))
+}
+
+// block until n bytes can be sent
+func (l *Limiter) Wait(n int) {
+	if d := l.reserve(n); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// reader that waits on a set of limiters for everything it reads
+type limitedReader struct {
+	r        io.Reader
+	limiters []*Limiter
+	active   func() bool // whether limits currently apply
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > limitReadSize {
+		p = p[:limitReadSize]
+	}
+	n, err := lr.r.Read(p)
+	if n > 0 && lr.active() {
+		for _, l := range lr.limiters {
+			l.Wait(n)
+		}
+	}
+	return n, err
+}
+
+// a period of time during the day, ie. 01:00-06:00.
+// windows that end before they start wrap around midnight.
+type Window struct {
+	Start time.Duration // time since midnight
+	End   time.Duration
+}
+
+// parse a window from "HH:MM-HH:MM"
+func ParseWindow(s string) (Window, error) {
+	start, end, ok := strings.Cut(strings.TrimSpace(s), "-")
+	if !ok {
+		return Window{}, fmt.Errorf("invalid transfer window '%s'. expected HH:MM-HH:MM", s)
+	}
+	var w Window
+	var err error
+	if w.Start, err = parseTimeOfDay(start); err != nil {
+		return Window{}, fmt.Errorf("invalid transfer window '%s': %v", s, err)
+	}
+	if w.End, err = parseTimeOfDay(end); err != nil {
+		return Window{}, fmt.Errorf("invalid transfer window '%s': %v", s, err)
+	}
+	return w, nil
+}
+
+// parse a list of windows separated by ';', ie. "01:00-06:00;22:00-23:30"
+func ParseWindows(s string) ([]Window, error) {
+	windows := make([]Window, 0)
+	for _, part := range strings.Split(s, ";") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		w, err := ParseWindow(part)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// time since midnight, in t's location
+func sinceMidnight(t time.Time) time.Duration {
+	y, m, d := t.Date()
+	return t.Sub(time.Date(y, m, d, 0, 0, 0, 0, t.Location()))
+}
+
+// whether t (in local time) falls within the window.
+// a window that starts and ends at the same time covers the whole day.
+func (w Window) Contains(t time.Time) bool {
+	now := sinceMidnight(t)
+	switch {
+	case w.Start == w.End:
+		return true
+	case w.Start < w.End:
+		return now >= w.Start && now < w.End
+	default:
+		return now >= w.Start || now < w.End
+	}
+}
+
+// the next time the window opens after t, or t if it's already open
+func (w Window) Next(t time.Time) time.Time {
+	if w.Contains(t) {
+		return t
+	}
+	y, m, d := t.Date()
+	start := time.Date(y, m, d, 0, 0, 0, 0, t.Location()).Add(w.Start)
+	if !start.After(t) {
+		start = start.AddDate(0, 0, 1)
+	}
+	return start
+}
+
+func (w Window) String() string {
+	format := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return format(w.Start) + "-" + format(w.End)
+}
+
+// whether t falls within any of the windows
+func InWindow(windows []Window, t time.Time) bool {
+	for _, w := range windows {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// the next time any of the windows open after t, or t if one is already open.
+// returns the zero time if there are no windows.
+func NextWindow(windows []Window, t time.Time) time.Time {
+	var next time.Time
+	for _, w := range windows {
+		if n := w.Next(t); next.IsZero() || n.Before(next) {
+			next = n
+		}
+	}
+	return next
+}
+
+// ------- transfer limits ---------------------------------------
+
+// set upload and download rate limits for this transfer component
+func (t *Transfer) SetLimits(limits Limits) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.limits = limits
+	t.up.SetRate(limits.Upload)
+	t.down.SetRate(limits.Download)
+}
+
+func (t *Transfer) Limits() Limits {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.limits
+}
+
+// set the windows during which transfers aren't limited.
+// if no windows are set, then limits always apply.
+func (t *Transfer) SetWindows(windows []Window) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.windows = windows
+}
+
+func (t *Transfer) Windows() []Window {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.windows
+}
+
+// whether rate limits apply right now
+func (t *Transfer) limited() bool {
+	windows := t.Windows()
+	return len(windows) == 0 || !InWindow(windows, time.Now())
+}
+
+// limiters for a single upload or download: the shared limiter,
+// plus a new one for this transfer if there's a per transfer limit.
+func (t *Transfer) limiters(upload bool) []*Limiter {
+	limits := t.Limits()
+	if upload {
+		return []*Limiter{t.up, NewLimiter(limits.FileUpload)}
+	}
+	return []*Limiter{t.down, NewLimiter(limits.FileDownload)}
+}
+
+// limit how quickly r can be read
+func (t *Transfer) limit(r io.Reader, limiters []*Limiter) io.Reader {
+	return &limitedReader{r: r, limiters: limiters, active: t.limited}
+}
@@ -0,0 +1,74 @@
+package transfer
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestParseWindows(t *testing.T) {
+	windows, err := ParseWindows("01:00-06:00; 22:30-00:15")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, len(windows))
+	assert.Equal(t, "01:00-06:00", windows[0].String())
+	assert.Equal(t, "22:30-00:15", windows[1].String())
+
+	windows, err = ParseWindows("")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(windows))
+
+	_, err = ParseWindows("01:00")
+	assert.Error(t, err)
+	_, err = ParseWindows("1am-6am")
+	assert.Error(t, err)
+}
+
+func TestWindowContains(t *testing.T) {
+	at := func(h, m int) time.Time { return time.Date(2024, 1, 2, h, m, 0, 0, time.Local) }
+
+	night := Window{Start: time.Hour, End: 6 * time.Hour}
+	assert.True(t, night.Contains(at(1, 0)))
+	assert.True(t, night.Contains(at(5, 59)))
+	assert.False(t, night.Contains(at(6, 0)))
+	assert.False(t, night.Contains(at(12, 0)))
+
+	// wraps around midnight
+	late := Window{Start: 22 * time.Hour, End: 2 * time.Hour}
+	assert.True(t, late.Contains(at(23, 0)))
+	assert.True(t, late.Contains(at(1, 0)))
+	assert.False(t, late.Contains(at(3, 0)))
+
+	// next opening
+	assert.Equal(t, at(1, 0), night.Next(at(0, 30)))
+	assert.Equal(t, at(1, 0).AddDate(0, 0, 1), night.Next(at(12, 0)))
+	assert.Equal(t, at(3, 0), night.Next(at(3, 0)))
+	assert.Equal(t, at(22, 0), NextWindow([]Window{night, late}, at(12, 0)))
+	assert.True(t, NextWindow(nil, at(12, 0)).IsZero())
+}
+
+func TestLimitedReader(t *testing.T) {
+	const rate = 64 * 1024
+	data := bytes.Repeat([]byte("a"), rate*2)
+
+	tr := NewTransfer()
+	tr.SetLimits(Limits{Download: rate})
+
+	// two seconds worth of data shouldn't take much less than that
+	start := time.Now()
+	n, err := io.Copy(io.Discard, tr.limit(bytes.NewReader(data), tr.limiters(false)))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(data)), n)
+	assert.True(t, time.Since(start) >= 900*time.Millisecond)
+
+	// no limits during a transfer window
+	tr.SetWindows([]Window{{Start: 0, End: 0}})
+	start = time.Now()
+	_, err = io.Copy(io.Discard, tr.limit(bytes.NewReader(data), tr.limiters(false)))
+	assert.NoError(t, err)
+	assert.True(t, time.Since(start) < 500*time.Millisecond)
+}
@@ -3,13 +3,17 @@ package transfer
 import (
 	"bytes"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
 	"net/http/httputil"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/sfs/pkg/auth"
@@ -17,6 +21,10 @@ import (
 	svc "github.com/sfs/pkg/service"
 )
 
+// number of times a transfer is attempted when the
+// receiving end reports a checksum mismatch
+const MAX_ATTEMPTS = 3
+
 // transfer handles the uploading and downloading of individual files
 // during synchronization events as well as one off file transfer
 // API calls.
@@ -24,16 +32,29 @@ type Transfer struct {
 	Tok    *auth.Token
 	log    *logger.Logger
 	Client *http.Client
+
+	// bandwidth limits (see limit.go)
+	mu      sync.RWMutex
+	limits  Limits
+	windows []Window
+	up      *Limiter // shared by all uploads
+	down    *Limiter // shared by all downloads
 }
 
 func NewTransfer() *Transfer {
 	return &Transfer{
-		Tok: auth.NewT(),
-		log: logger.NewLogger("Transfer", "None"),
+		Tok:  auth.NewT(),
+		log:  logger.NewLogger("Transfer", "None"),
+		up:   NewLimiter(0),
+		down: NewLimiter(0),
 		Client: &http.Client{
-			Timeout: 30 * time.Second,
+			// no overall timeout since file bodies are streamed and
+			// large files can take a while. only wait so long for the
+			// server to respond.
 			Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+				TLSClientConfig:       &tls.Config{InsecureSkipVerify: true},
+				TLSHandshakeTimeout:   10 * time.Second,
+				ResponseHeaderTimeout: 30 * time.Second,
 			},
 		},
 	}
@@ -52,6 +73,33 @@ func (t *Transfer) dump(resp *http.Response, body bool) {
 	}
 }
 
+// build an error for a failed response. checksum mismatches (422)
+// are wrapped with svc.ErrChecksumMismatch so they can be retried, and
+// rejections for being over quota (507) with svc.ErrQuotaExceeded.
+func (t *Transfer) respError(resp *http.Response, action string) error {
+	t.dump(resp, true)
+	switch resp.StatusCode {
+	case http.StatusUnprocessableEntity:
+		return fmt.Errorf("%s: %w", action, svc.ErrChecksumMismatch)
+	case http.StatusInsufficientStorage:
+		return fmt.Errorf("%s: %w", action, svc.ErrQuotaExceeded)
+	}
+	return fmt.Errorf("%s: %v", action, resp.Status)
+}
+
+// run a transfer, retrying it if the data that was received
+// didn't match its checksum.
+func (t *Transfer) retry(name string, transfer func() error) error {
+	var err error
+	for attempt := 1; attempt <= MAX_ATTEMPTS; attempt++ {
+		if err = transfer(); err == nil || !errors.Is(err, svc.ErrChecksumMismatch) {
+			return err
+		}
+		t.log.Warn(fmt.Sprintf("checksum mismatch while transferring %s (attempt %d of %d): %v", name, attempt, MAX_ATTEMPTS, err))
+	}
+	return err
+}
+
 // create a zip file of a directory so it can be transferred
 func (t *Transfer) CreateArchive(path string) error {
 	return Zip(path, path+".zip")
@@ -63,8 +111,8 @@ func (t *Transfer) ExtractArchive(path string) error {
 }
 
 // prepare file transfer request header.
-func (t *Transfer) PrepareFileReq(method string, destURL string, contentType string, file *svc.File, buf *bytes.Buffer) (*http.Request, error) {
-	req, err := http.NewRequest(method, destURL, buf)
+func (t *Transfer) PrepareFileReq(method string, destURL string, contentType string, file *svc.File, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, destURL, body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create HTTP request: %v", err)
 	}
@@ -78,7 +126,7 @@ func (t *Transfer) PrepareFileReq(method string, destURL string, contentType str
 	if err != nil {
 		return nil, fmt.Errorf("failed to create file token: %v", err)
 	}
-	req.Header.Set("Authorization", "Bearer "+fileToken)
+	req.Header.Set(auth.PayloadHeader, fileToken)
 	req.Header.Set("Content-Type", contentType)
 
 	return req, nil
@@ -87,81 +135,318 @@ func (t *Transfer) PrepareFileReq(method string, destURL string, contentType str
 // prepare and transfer a file for upload or download to the server.
 // server will handle whether this is a new file or an update to an existing file,
 // usually determined by the method.
+//
+// the file is streamed through a multipart writer as it's read, so it's
+// never held in memory all at once. the file's token carries the checksum
+// of what's being sent so the server can verify it, and the upload is
+// retried if the server reports a mismatch.
 func (t *Transfer) Upload(method string, file *svc.File, destURL string) error {
-	var (
-		buf = new(bytes.Buffer)
-		w   = multipart.NewWriter(buf)
-	)
+	return t.retry(file.Name, func() error { return t.upload(method, file, destURL) })
+}
 
-	// create form file writer and prepare request
-	fw, err := w.CreateFormFile("myFile", filepath.Base(file.Path))
+func (t *Transfer) upload(method string, file *svc.File, destURL string) error {
+	sent, err := withChecksum(file)
 	if err != nil {
 		return err
 	}
+	f, err := os.Open(file.ClientPath)
+	if err != nil {
+		return err
+	}
+
+	pr, pw := io.Pipe()
+	w := multipart.NewWriter(pw)
+	go func() {
+		defer f.Close()
+		pw.CloseWithError(writeFormFile(w, t.limit(f, t.limiters(true)), filepath.Base(file.Path)))
+	}()
+
+	// prepare request
+	req, err := t.PrepareFileReq(method, destURL, w.FormDataContentType(), sent, pr)
+	if err != nil {
+		pr.CloseWithError(err)
+		return err
+	}
+
+	// send request
+	t.log.Log("INFO", fmt.Sprintf("uploading %s to %s...", file.Name, file.Endpoint))
+	resp, err := t.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send HTTP request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return t.respError(resp, "failed to upload "+file.Name)
+	}
+	t.log.Log("INFO", fmt.Sprintf("%s uploaded to %s", file.Name, destURL))
+	return nil
+}
+
+// copy of a file's metadata with the checksum of its current contents.
+// the file's own checksum is from the last time it was synced, so it
+// can't be used to verify what's being sent.
+func withChecksum(file *svc.File) (*svc.File, error) {
+	cs, err := svc.CalculateChecksum(file.ClientPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to calculate checksum: %v", err)
+	}
+	data, err := file.ToJSON()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file json string: %v", err)
+	}
+	sent, err := svc.UnmarshalFileStr(string(data))
+	if err != nil {
+		return nil, err
+	}
+	sent.CheckSum = cs
+	return sent, nil
+}
 
-	// read in file data
-	data, err := os.ReadFile(file.ClientPath)
+// write a file's contents to a multipart form, then close the form.
+func writeFormFile(w *multipart.Writer, src io.Reader, name string) error {
+	fw, err := w.CreateFormFile("myFile", name)
 	if err != nil {
 		return err
 	}
-	if _, err = fw.Write(data); err != nil {
+	if _, err := io.Copy(fw, src); err != nil {
 		return fmt.Errorf("failed to retrieve file data: %v", err)
 	}
-	if err := w.Close(); err != nil {
-		t.log.Error("failed to close writer: " + err.Error())
+	return w.Close()
+}
+
+// retrieve a block signature of the server's copy of a file.
+func (t *Transfer) GetSignature(file *svc.File, srcURL string) (*svc.Signature, error) {
+	req, err := t.PrepareFileReq(http.MethodGet, srcURL, "application/json", file, new(bytes.Buffer))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send HTTP request: %v", err)
 	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.dump(resp, true)
+		return nil, fmt.Errorf("failed to get file signature: %v", resp.Status)
+	}
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, resp.Body); err != nil {
+		return nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+	return svc.UnmarshalSignature(buf.Bytes())
+}
 
-	// prepare request
-	req, err := t.PrepareFileReq(method, destURL, w.FormDataContentType(), file, buf)
+// send only the blocks of a file that differ from the server's copy.
+// destURL is the file's server API endpoint.
+//
+// falls back to a full upload if the server's signature isn't available,
+// or if the delta wouldn't be any smaller than the file itself.
+// retried if the server reports a checksum mismatch.
+func (t *Transfer) UploadDelta(file *svc.File, destURL string) error {
+	return t.retry(file.Name, func() error { return t.uploadDelta(file, destURL) })
+}
+
+func (t *Transfer) uploadDelta(file *svc.File, destURL string) error {
+	sig, err := t.GetSignature(file, destURL+"/sig")
+	if err != nil {
+		t.log.Warn(fmt.Sprintf("failed to get signature for %s: %v. sending entire file...", file.Name, err))
+		return t.uploadFile(file, destURL)
+	}
+	delta, err := svc.BuildDelta(sig, file.ClientPath)
+	if err != nil {
+		return fmt.Errorf("failed to build delta: %v", err)
+	}
+	if delta.LiteralSize() >= delta.Size {
+		return t.uploadFile(file, destURL)
+	}
+	data, err := delta.ToJSON()
+	if err != nil {
+		return fmt.Errorf("failed to encode delta: %v", err)
+	}
+	req, err := t.PrepareFileReq(http.MethodPut, destURL+"/delta", "application/json", file, t.limit(bytes.NewReader(data), t.limiters(true)))
 	if err != nil {
 		return err
 	}
+	req.ContentLength = int64(len(data))
 
-	// send request
-	t.log.Log("INFO", fmt.Sprintf("uploading %s to %s...", file.Name, file.Endpoint))
+	t.log.Log("INFO", fmt.Sprintf(
+		"uploading delta for %s to %s (%d of %d bytes changed)...",
+		file.Name, file.Endpoint, delta.LiteralSize(), delta.Size,
+	))
 	resp, err := t.Client.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to send HTTP request: %v", err)
 	}
-	t.dump(resp, true)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return t.respError(resp, "failed to upload delta")
+	}
 	return nil
 }
 
-// download a known file from the given URL (associated server API endpoint).
+// send an entire file to the server. files larger than a single
+// chunk are sent with a chunked upload, everything else is sent
+// in a single request.
+func (t *Transfer) uploadFile(file *svc.File, destURL string) error {
+	info, err := os.Stat(file.ClientPath)
+	if err != nil {
+		return err
+	}
+	if info.Size() > svc.CHUNK_SIZE {
+		return t.uploadChunked(file, destURL)
+	}
+	return t.upload(http.MethodPut, file, destURL)
+}
+
+// send a request for an upload session and decode the session from the response.
+func (t *Transfer) uploadReq(method string, destURL string, file *svc.File, body io.Reader, size int64) (*svc.UploadSession, error) {
+	req, err := t.PrepareFileReq(method, destURL, "application/octet-stream", file, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	resp, err := t.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send HTTP request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, t.respError(resp, "upload request failed")
+	}
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, resp.Body); err != nil {
+		return nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+	return svc.UnmarshalUploadSession(buf.Bytes())
+}
+
+// send a file to the server in chunks. destURL is the file's server API endpoint.
+//
+// if the server already has an upload session for this version of the file
+// (i.e. a previous upload was interrupted), then only the chunks the server
+// hasn't acknowledged are sent. if the completed upload doesn't match its
+// checksum, then the server discards it and the whole upload is retried.
+func (t *Transfer) UploadChunked(file *svc.File, destURL string) error {
+	return t.retry(file.Name, func() error { return t.uploadChunked(file, destURL) })
+}
+
+func (t *Transfer) uploadChunked(file *svc.File, destURL string) error {
+	f, err := os.Open(file.ClientPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	cs, err := svc.CalculateChecksum(file.ClientPath)
+	if err != nil {
+		return fmt.Errorf("failed to calculate checksum: %v", err)
+	}
+
+	// start or resume the upload
+	params := url.Values{}
+	params.Set("size", strconv.FormatInt(info.Size(), 10))
+	params.Set("checksum", cs)
+	u, err := t.uploadReq(http.MethodPost, destURL+"/uploads?"+params.Encode(), file, nil, 0)
+	if err != nil {
+		return err
+	}
+	if total := u.Total(); total > 0 {
+		t.log.Info(fmt.Sprintf("resuming upload of %s (%d of %d bytes already sent)", file.Name, total, u.Size))
+	}
+
+	// send whatever the server is missing, one chunk at a time
+	sessionURL := destURL + "/uploads/" + u.ID
+	limiters := t.limiters(true)
+	for _, missing := range u.Missing() {
+		for off := missing.Start; off < missing.End; off += svc.CHUNK_SIZE {
+			n := min(svc.CHUNK_SIZE, missing.End-off)
+			chunk := t.limit(io.NewSectionReader(f, off, n), limiters)
+			if _, err := t.uploadReq(http.MethodPut, fmt.Sprintf("%s?offset=%d", sessionURL, off), file, chunk, n); err != nil {
+				return fmt.Errorf("failed to send chunk at offset %d: %v", off, err)
+			}
+		}
+	}
+
+	// commit the upload
+	req, err := t.PrepareFileReq(http.MethodPost, sessionURL+"/commit?checksum="+url.QueryEscape(cs), "application/json", file, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := t.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send HTTP request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return t.respError(resp, "failed to commit upload")
+	}
+	t.log.Info(fmt.Sprintf("%s uploaded in chunks to %s", file.Name, destURL))
+	return nil
+}
+
+// download a file from the given URL (associated server API endpoint) to destPath.
+//
+// the file is streamed to a temp file next to destPath, and is only moved into
+// place once it's been fully received. checksum is required, and the data must
+// match it, otherwise destPath is left untouched and the download is retried.
+// downloads without a checksum fail with svc.ErrNoChecksum before anything is sent.
 //
 // intended to run in its own goroutine.
-// download a known file that is only on the server, and is new to the client
-func (t *Transfer) Download(destPath string, srcURL string) error {
+func (t *Transfer) Download(destPath string, srcURL string, checksum string) error {
+	if checksum == "" {
+		return fmt.Errorf("failed to download %s: %w", filepath.Base(destPath), svc.ErrNoChecksum)
+	}
+	return t.retry(filepath.Base(destPath), func() error { return t.download(destPath, srcURL, checksum) })
+}
+
+func (t *Transfer) download(destPath string, srcURL string, checksum string) error {
 	resp, err := t.Client.Get(srcURL)
 	if err != nil {
 		return fmt.Errorf("failed to execute http request: %v", err)
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		t.dump(resp, true)
-		// server may be having issues.
-		// does necessarily not mean a client error occurred.
-		return nil
+		return t.respError(resp, "failed to download "+filepath.Base(destPath))
 	}
-	defer resp.Body.Close()
 
-	// create (or truncate) file
-	file, err := os.Create(destPath)
+	// hidden, and ignored by the monitor until it's renamed
+	tmp, err := os.CreateTemp(filepath.Dir(destPath), "."+filepath.Base(destPath)+"-*"+svc.PART_SUFFIX)
 	if err != nil {
-		return fmt.Errorf("failed to create destination file: %v", err)
+		return fmt.Errorf("failed to create temp file: %v", err)
 	}
-	defer file.Close()
+	defer os.Remove(tmp.Name())
 
-	// write out data
-	var buf bytes.Buffer
-	_, err = io.Copy(&buf, resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to copy file data to buffer: %v", err)
+	// keep the permissions of the file being replaced
+	mode := os.FileMode(svc.PERMS)
+	if info, err := os.Stat(destPath); err == nil {
+		mode = info.Mode().Perm()
 	}
-	_, err = file.Write(buf.Bytes())
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to set file permissions: %v", err)
+	}
+
+	h := svc.NewHasher()
+	n, err := io.Copy(io.MultiWriter(tmp, h), t.limit(resp.Body, t.limiters(false)))
 	if err != nil {
+		tmp.Close()
 		return fmt.Errorf("failed to write out file data: %v", err)
 	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write out file data: %v", err)
+	}
+	if err := svc.VerifyChecksum(checksum, h.Sum()); err != nil {
+		return fmt.Errorf("failed to download %s: %w", filepath.Base(destPath), err)
+	}
+	if err := os.Rename(tmp.Name(), destPath); err != nil {
+		return fmt.Errorf("failed to move file into place: %v", err)
+	}
 
-	t.log.Log("INFO", fmt.Sprintf("%s downloaded to %s", file.Name(), destPath))
+	t.log.Log("INFO", fmt.Sprintf("%s downloaded to %s (%d bytes)", filepath.Base(destPath), destPath, n))
 	return nil
 }
@@ -1 +1,157 @@
 package transfer
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+	"github.com/sfs/pkg/auth"
+	"github.com/sfs/pkg/env"
+	svc "github.com/sfs/pkg/service"
+)
+
+func TestUpload(t *testing.T) {
+	env.SetEnv(false)
+
+	data := []byte(strings.Repeat("streamed file data. ", 1000))
+	srcPath := filepath.Join(t.TempDir(), "upload.txt")
+	if err := os.WriteFile(srcPath, data, svc.PERMS); err != nil {
+		t.Fatal(err)
+	}
+
+	var received []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, _, err := r.FormFile("myFile")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer f.Close()
+		received, _ = io.ReadAll(f)
+	}))
+	defer srv.Close()
+
+	file := &svc.File{ID: "some-file-id", Name: "upload.txt", Path: srcPath, ClientPath: srcPath}
+	tr := NewTransfer()
+	if err := tr.Upload(http.MethodPut, file, srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, data, received)
+
+	// missing files aren't sent
+	file.ClientPath = filepath.Join(t.TempDir(), "nope.txt")
+	assert.Error(t, tr.Upload(http.MethodPut, file, srv.URL))
+}
+
+func TestUploadRetriesOnMismatch(t *testing.T) {
+	env.SetEnv(false)
+
+	data := []byte(strings.Repeat("streamed file data. ", 1000))
+	srcPath := filepath.Join(t.TempDir(), "upload.txt")
+	if err := os.WriteFile(srcPath, data, svc.PERMS); err != nil {
+		t.Fatal(err)
+	}
+	cs, err := svc.CalculateChecksum(srcPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// reject the first attempt, and make sure the token
+	// carries the checksum of what's actually being sent
+	var attempts int
+	var rejectAll bool
+	var sentChecksum string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		fileInfo, err := auth.NewT().Validate(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sent, err := svc.UnmarshalFileStr(fileInfo)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sentChecksum = sent.CheckSum
+		if attempts == 1 || rejectAll {
+			http.Error(w, "checksum mismatch", http.StatusUnprocessableEntity)
+		}
+	}))
+	defer srv.Close()
+
+	file := &svc.File{ID: "some-file-id", Name: "upload.txt", Path: srcPath, ClientPath: srcPath, CheckSum: "stale"}
+	tr := NewTransfer()
+	if err := tr.Upload(http.MethodPut, file, srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, attempts)
+	assert.Equal(t, cs, sentChecksum)
+	assert.Equal(t, "stale", file.CheckSum)
+
+	// give up after MAX_ATTEMPTS
+	attempts, rejectAll = 0, true
+	err = tr.Upload(http.MethodPut, file, srv.URL)
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, svc.ErrChecksumMismatch))
+	assert.Equal(t, MAX_ATTEMPTS, attempts)
+}
+
+func TestDownload(t *testing.T) {
+	env.SetEnv(false)
+
+	data := []byte(strings.Repeat("streamed file data. ", 1000))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.Error(w, "file not found", http.StatusNotFound)
+			return
+		}
+		io.Copy(w, bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	h := svc.NewHasher()
+	h.Write(data)
+	cs := h.Sum()
+
+	dir := t.TempDir()
+	destPath := filepath.Join(dir, "download.txt")
+	if err := os.WriteFile(destPath, []byte("old contents"), svc.PERMS); err != nil {
+		t.Fatal(err)
+	}
+
+	tr := NewTransfer()
+
+	// a bad or missing checksum, or a server error, leaves the original file alone
+	assert.Error(t, tr.Download(destPath, srv.URL, "not-the-checksum"))
+	assert.True(t, errors.Is(tr.Download(destPath, srv.URL, ""), svc.ErrNoChecksum))
+	assert.Error(t, tr.Download(destPath, srv.URL+"/missing", cs))
+	got, err := os.ReadFile(destPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []byte("old contents"), got)
+
+	if err := tr.Download(destPath, srv.URL, cs); err != nil {
+		t.Fatal(err)
+	}
+	got, err = os.ReadFile(destPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, data, got)
+
+	// no temp files are left behind
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(entries))
+}
@@ -294,6 +294,24 @@ const emptyBin = () => {
   }
 }
 
+const restoreItem = (itemID) => {
+  fetch("/recycled/restore", {
+    method: "POST",
+    body: itemID
+  })
+  .then((response) => {
+    if (response.ok) {
+      redirectToPage("/recycled");
+    } else {
+      response.text().then((msg) => alert(msg));
+    }
+  })
+  .catch((error) => {
+    console.error("Error:", error);
+    alert(error.message);
+  });
+}
+
 // -------- search page ---------------------------------------
 
 const submitSearch = (event) => {